@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"signal-proxy/internal/version"
+)
+
+// cliOptions holds the parsed command-line flags.
+type cliOptions struct {
+	configPath string
+	configSet  bool // --config was given, so the file must exist
+	selftest   bool
+	json       bool          // Plain output for log collectors: no banner, spinners or boxes
+	pacURLUser string        // Print a signed PAC link for this user and exit
+	pacURLTTL  time.Duration // How long that link stays valid
+}
+
+// parseFlags parses args. When exit is true the caller should exit with
+// code straight away (--version, --help or a bad flag).
+func parseFlags(args []string, stdout, stderr io.Writer) (opts cliOptions, code int, exit bool) {
+	fs := flag.NewFlagSet("signal-proxy", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.configPath, "config", "config.json", "Path to the JSON config file")
+	fs.BoolVar(&opts.selftest, "selftest", false, "Check config, certificates, users and upstreams, then exit")
+	fs.BoolVar(&opts.json, "json", false, "Plain output for log collectors: no banner, spinners or boxed notes")
+	fs.StringVar(&opts.pacURLUser, "pac-url", "", "Print a signed, expiring PAC link for this user and exit (needs PAC_SIGNING_KEY)")
+	fs.DurationVar(&opts.pacURLTTL, "pac-url-ttl", 7*24*time.Hour, "How long a --pac-url link stays valid")
+	showVersion := fs.Bool("version", false, "Print the version and exit")
+	help := fs.Bool("help", false, "Show this help and exit")
+	fs.BoolVar(showVersion, "v", false, "Shorthand for --version")
+	fs.BoolVar(help, "h", false, "Shorthand for --help")
+	fs.Usage = func() { printUsage(fs.Output(), fs) }
+
+	if err := fs.Parse(args); err != nil {
+		return opts, 2, true
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			opts.configSet = true
+		}
+	})
+	switch {
+	case *showVersion:
+		fmt.Fprintln(stdout, versionString())
+		return opts, 0, true
+	case *help:
+		printUsage(stdout, fs)
+		return opts, 0, true
+	}
+	return opts, 0, false
+}
+
+// versionString is what --version prints.
+func versionString() string {
+	return "signal-proxy " + version.Version + " (commit " + version.Commit + ")"
+}
+
+// printUsage writes the flags, proxy modes and main environment variables.
+func printUsage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, versionString())
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Usage: signal-proxy [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Flags:")
+	out := fs.Output()
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	fs.SetOutput(out)
+	fmt.Fprint(w, `
+Modes (PROXY_MODE):
+  signal    TLS-in-TLS relay for Signal clients (default)
+  https     Authenticated HTTP/HTTPS and SOCKS5 proxy (aliases: http, general)
+
+Environment:
+  APP_ENV            development (default) or production
+  DOMAIN             Public domain of the proxy
+  CERT_FILE          TLS certificate (overrides cert_file)
+  KEY_FILE           TLS private key (overrides key_file)
+  METRICS_LISTEN     Metrics and stats API address (default 127.0.0.1:9090)
+  METRICS_TOKEN      Bearer token required on /metrics
+  USERS_FILE         Users for https mode (default users.json)
+  HTTP_PROXY_PORT    HTTP proxy address (default :8080)
+  SOCKS5_PORT        SOCKS5 address (default :1080)
+  PAC_ENABLED        Serve /proxy.pac in https mode (default true)
+
+A .env file in the working directory is loaded first.
+See docs/configuration/CONFIG.md for the full list.
+`)
+}
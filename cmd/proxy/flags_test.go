@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"signal-proxy/internal/version"
+)
+
+func TestParseFlagsVersion(t *testing.T) {
+	for _, arg := range []string{"--version", "-v"} {
+		var stdout, stderr bytes.Buffer
+		_, code, exit := parseFlags([]string{arg}, &stdout, &stderr)
+		if !exit || code != 0 {
+			t.Fatalf("%s: exit=%v code=%d, want exit with 0", arg, exit, code)
+		}
+		if got, want := stdout.String(), "signal-proxy "+version.Version+" (commit "+version.Commit+")\n"; got != want {
+			t.Errorf("%s printed %q, want %q", arg, got, want)
+		}
+	}
+}
+
+func TestParseFlagsHelp(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	_, code, exit := parseFlags([]string{"--help"}, &stdout, &stderr)
+	if !exit || code != 0 {
+		t.Fatalf("exit=%v code=%d, want exit with 0", exit, code)
+	}
+	for _, want := range []string{"-config", "PROXY_MODE", "USERS_FILE"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("help output missing %q", want)
+		}
+	}
+}
+
+func TestParseFlagsConfigAndUnknown(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	opts, _, exit := parseFlags([]string{"--config", "/etc/proxy/config.json"}, &stdout, &stderr)
+	if exit || opts.configPath != "/etc/proxy/config.json" || !opts.configSet {
+		t.Fatalf("opts=%+v exit=%v, want config path set and no exit", opts, exit)
+	}
+	if opts, _, _ := parseFlags(nil, &stdout, &stderr); opts.configSet || opts.configPath != "config.json" {
+		t.Errorf("no --config: opts=%+v, want the implicit config.json", opts)
+	}
+
+	if opts, _, exit := parseFlags([]string{"--json"}, &stdout, &stderr); exit || !opts.json {
+		t.Fatalf("--json: opts=%+v exit=%v, want json set and no exit", opts, exit)
+	}
+
+	if _, code, exit := parseFlags([]string{"--bogus"}, &stdout, &stderr); !exit || code != 2 {
+		t.Fatalf("unknown flag: exit=%v code=%d, want exit with 2", exit, code)
+	}
+}
+
+func TestParseFlagsPACURL(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	opts, _, exit := parseFlags([]string{"--pac-url", "alice", "--pac-url-ttl", "2h"}, &stdout, &stderr)
+	if exit || opts.pacURLUser != "alice" || opts.pacURLTTL != 2*time.Hour {
+		t.Fatalf("opts=%+v exit=%v, want alice for 2h", opts, exit)
+	}
+	if opts, _, _ := parseFlags(nil, &stdout, &stderr); opts.pacURLTTL != 7*24*time.Hour {
+		t.Errorf("default --pac-url-ttl = %v, want 7 days", opts.pacURLTTL)
+	}
+}
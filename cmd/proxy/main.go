@@ -2,33 +2,66 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"syscall"
+	"time"
 
+	"signal-proxy/internal/accesslog"
 	"signal-proxy/internal/auth"
 	"signal-proxy/internal/bandwidth"
+	"signal-proxy/internal/bufpool"
 	"signal-proxy/internal/config"
 	"signal-proxy/internal/httpproxy"
+	"signal-proxy/internal/pac"
 	"signal-proxy/internal/proxy"
+	"signal-proxy/internal/selftest"
 	"signal-proxy/internal/socks5"
 	"signal-proxy/internal/ui"
+	"signal-proxy/internal/version"
 
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func main() {
+	opts, code, exit := parseFlags(os.Args[1:], os.Stdout, os.Stderr)
+	if exit {
+		os.Exit(code)
+	}
+	ui.SetJSONMode(opts.json)
+
+	// A server that fails to start is reported here, after the deferred
+	// cleanup below has run
+	var startErr *startError
+	defer func() {
+		if startErr != nil {
+			exitStartFailed(startErr)
+		}
+	}()
+
 	// Load .env file if it exists
 	// We ignore the error because in production/docker we might relying on system env vars
 	_ = godotenv.Load()
 
-	// Display banner with version and tagline
-	ui.PrintBanner()
+	// Load and validate configuration. Only the implicit config.json may be
+	// missing; a file named with --config must exist
+	if opts.configSet {
+		if _, err := os.Stat(opts.configPath); err != nil {
+			ui.LogStatus("error", "Cannot read --config file: "+err.Error())
+			os.Exit(1)
+		}
+	}
+	cfg := config.LoadFrom(opts.configPath)
 
-	// Load and validate configuration
-	cfg := config.Load()
+	// Display banner with version and tagline
+	ui.ConfigureBanner(cfg.Env.BannerEnabled, cfg.Env.Tagline)
+	ui.Banner(version.Version, "")
 
 	// Display environment info
 	if cfg.Env.IsDevelopment() {
@@ -39,23 +72,64 @@ func main() {
 		ui.LogStatus("info", "Domain: "+cfg.Env.Domain)
 	}
 
+	// Show the effective environment and stop on clearly invalid values
+	issues := cfg.Env.Validate()
+	printEnvSummary(cfg.Env, issues)
+	if err := config.EnvError(issues); err != nil {
+		ui.LogStatus("error", err.Error())
+		os.Exit(1)
+	}
+
+	if opts.selftest {
+		os.Exit(runSelftest(cfg))
+	}
+	if opts.pacURLUser != "" {
+		os.Exit(runPACURL(cfg, opts.pacURLUser, opts.pacURLTTL))
+	}
+
+	// Size the shared relay buffer pool before any server starts
+	bufpool.SetRelaySize(cfg.Env.RelayBufferSize)
+
+	// Forwarding headers are only believed from TRUSTED_PROXIES
+	auth.SetTrustedProxies(cfg.Env.TrustedProxies)
+
+	// Hash usernames in metric labels before any are recorded
+	proxy.SetUserLabelAnonymization(cfg.Env.MetricsAnonymizeUsers, cfg.Env.MetricsUserSalt)
+
+	// Optional machine-readable access log, shared by every proxy
+	if cfg.Env.AccessLog != "" {
+		accessLog, err := accesslog.Open(cfg.Env.AccessLog, accesslog.Format(cfg.Env.AccessLogFormat), cfg.Env.AccessLogMaxBytes())
+		if err != nil {
+			ui.LogStatus("error", "Failed to open access log: "+err.Error())
+			os.Exit(1)
+		}
+		defer accessLog.Close()
+		accesslog.SetDefault(accessLog)
+		ui.LogStatus("info", "Access log → "+cfg.Env.AccessLog+" ("+cfg.Env.AccessLogFormat+")")
+	}
+
+	// CORS origin for the stats API in both modes (validated above)
+	apiOrigin, _ := cfg.Env.APIOrigin()
+	proxy.Stats.SetAllowedOrigin(apiOrigin)
+
 	// Create shutdown context
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
 	// Switch behavior based on proxy mode
-	switch cfg.Env.ProxyMode {
-	case "https", "http", "general":
-		// HTTP/HTTPS/SOCKS5 proxy mode
-		runHTTPSProxyMode(ctx, cfg)
-	default:
+	if cfg.Env.IsSignalMode() {
 		// Signal proxy mode (default)
-		runSignalProxyMode(ctx, cfg)
+		startErr = runSignalProxyMode(ctx, cfg)
+	} else {
+		// HTTP/HTTPS/SOCKS5 proxy mode
+		startErr = runHTTPSProxyMode(ctx, cfg)
 	}
 }
 
-// runSignalProxyMode starts the Signal TLS proxy (original behavior)
-func runSignalProxyMode(ctx context.Context, cfg *config.Config) {
+// runSignalProxyMode starts the Signal TLS proxy (original behavior). It
+// returns once the proxy has shut down, or with the error it failed to
+// start with.
+func runSignalProxyMode(ctx context.Context, cfg *config.Config) *startError {
 	ui.LogStatus("info", "Proxy Mode: "+ui.Success("SIGNAL"))
 
 	if err := cfg.Validate(); err != nil {
@@ -63,17 +137,40 @@ func runSignalProxyMode(ctx context.Context, cfg *config.Config) {
 		os.Exit(1)
 	}
 
-	// Start metrics server (no bandwidth usage endpoint in Signal mode)
-	metrics := proxy.NewMetricsServer(cfg.MetricsListen, nil)
+	// Configure history sampling, then restore the chart from the previous run
+	proxy.Stats.SetSampling(
+		time.Duration(cfg.Env.StatsSampleIntervalSec)*time.Second,
+		time.Duration(cfg.Env.StatsRetentionHours)*time.Hour,
+	)
+	if err := proxy.Stats.LoadHistory(cfg.Env.StatsHistoryFile); err != nil {
+		ui.LogStatus("warn", "Failed to load stats history: "+err.Error())
+	}
+
+	// Optional per-SNI usage tracking, exposed on /api/usage
+	var sniTracker *bandwidth.Tracker
+	var usageHandler http.HandlerFunc
+	if cfg.Env.SNIUsageEnabled {
+		usageFile := filepath.Join(filepath.Dir(filepath.Clean(cfg.Env.UsersFile)), "sni_usage.json")
+		sniTracker = bandwidth.NewTracker(usageFile)
+		defer sniTracker.Stop()
+		usageHandler = bandwidth.UsageHandler(sniTracker, proxy.Stats.AllowedOrigin(), nil)
+		ui.LogStatus("info", "SNI usage tracker active → "+usageFile)
+	}
+
+	// Start metrics server
+	metrics := proxy.NewMetricsServer(cfg.MetricsListen, usageHandler, cfg.Env.MetricsToken)
 	metrics.Start()
 	go func() {
 		<-ctx.Done()
 		ui.LogGracefulShutdown()
 		metrics.Shutdown(context.Background())
+		if sniTracker != nil {
+			sniTracker.Stop()
+		}
 	}()
 
 	// Start the proxy server
-	srv := proxy.NewServer(cfg)
+	srv := proxy.NewServer(cfg, sniTracker)
 
 	// Listen for SIGHUP to reload certificates
 	sighup := make(chan os.Signal, 1)
@@ -93,68 +190,260 @@ func runSignalProxyMode(ctx context.Context, cfg *config.Config) {
 	}()
 
 	if err := srv.Start(ctx); err != nil {
-		ui.LogStatus("error", "Server failed: "+err.Error())
-		log.Fatal(err)
+		return &startError{"Server", err}
+	}
+
+	// Persist history, with the traffic since the last sample, so the chart
+	// survives the restart
+	if err := proxy.Stats.FlushHistory(); err != nil {
+		ui.LogStatus("warn", "Failed to save stats history: "+err.Error())
 	}
+	return nil
 }
 
-// runHTTPSProxyMode starts the HTTP/HTTPS/SOCKS5 proxy
-func runHTTPSProxyMode(ctx context.Context, cfg *config.Config) {
+// runHTTPSProxyMode starts the HTTP/HTTPS/SOCKS5 proxy. It returns once
+// both proxies have shut down, or with the error one of them failed to
+// start with after stopping the other.
+func runHTTPSProxyMode(ctx context.Context, cfg *config.Config) *startError {
+	// Either proxy failing to start stops the other
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
 	ui.LogStatus("info", "Proxy Mode: "+ui.Success("HTTPS/SOCKS5"))
 
-	// Load user store
-	userStore, err := auth.NewUserStore(cfg.Env.UsersFile)
+	// PROXY_USER adds an account without a users file, e.g. for docker run
+	var envUsers []auth.User
+	if cfg.Env.ProxyUser != "" {
+		user, err := auth.EnvUser(cfg.Env.ProxyUser, cfg.Env.ProxyPass, cfg.Env.ProxyPassHash)
+		if err != nil {
+			ui.LogStatus("error", err.Error())
+			os.Exit(1)
+		}
+		envUsers = append(envUsers, user)
+	}
+
+	// Load user store. With AUTH_WEBHOOK_URL the file is optional and only
+	// supplies ip_whitelist and super_admin_ips
+	userStore, err := auth.NewUserStoreWithOptions(cfg.Env.UsersFile, auth.UserStoreOptions{
+		ExpiryFailClosed:     cfg.Env.ExpiryFailClosed,
+		StrictPasswordHashes: cfg.Env.StrictPasswordHashes,
+		ExtraUsers:           envUsers,
+		OptionalFile:         cfg.Env.AuthWebhookURL != "",
+	})
 	if err != nil {
 		ui.LogStatus("error", "Failed to load users: "+err.Error())
 		os.Exit(1)
 	}
-	ui.LogStatus("info", "Loaded "+itoa(userStore.GetUserCount())+" users from "+cfg.Env.UsersFile)
+	defer userStore.Close()
+	ui.LogStatus("info", "Loaded "+strconv.Itoa(userStore.GetUserCount())+" users from "+cfg.Env.UsersFile)
+	if len(envUsers) > 0 {
+		ui.LogStatus("info", "User "+cfg.Env.ProxyUser+" defined by PROXY_USER")
+	}
 
-	// Create bandwidth tracker (persists alongside users.json)
-	usageFile := filepath.Join(filepath.Dir(cfg.Env.UsersFile), "bandwidth_usage.json")
+	var authenticator auth.Authenticator = userStore
+	if cfg.Env.AuthWebhookURL != "" {
+		// PROXY_USER keeps working alongside the webhook
+		var localUsers []string
+		for _, user := range envUsers {
+			localUsers = append(localUsers, user.Username)
+		}
+		webhook, err := auth.NewWebhookAuthenticator(auth.WebhookOptions{
+			URL:        cfg.Env.AuthWebhookURL,
+			Timeout:    cfg.Env.AuthWebhookTimeout(),
+			CacheTTL:   cfg.Env.AuthWebhookCacheTTL(),
+			Local:      userStore,
+			LocalUsers: localUsers,
+		})
+		if err != nil {
+			ui.LogStatus("error", err.Error())
+			os.Exit(1)
+		}
+		authenticator = webhook
+		ui.LogStatus("info", "Credentials checked by "+config.RedactURL(cfg.Env.AuthWebhookURL))
+	}
+
+	// Listen for SIGHUP to reload users; a file that fails to load leaves
+	// the current users in place
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-sighup:
+				ui.LogStatus("info", "SIGHUP received, reloading users...")
+				if err := userStore.LoadFromFile(cfg.Env.UsersFile); err != nil {
+					ui.LogStatus("error", "Reload failed: "+err.Error())
+					continue
+				}
+				ui.LogStatus("success", "Loaded "+strconv.Itoa(userStore.GetUserCount())+" users from "+cfg.Env.UsersFile)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Create bandwidth tracker (persists alongside users.json unless overridden)
+	usageFile := cfg.Env.BandwidthUsageFile
+	if usageFile == "" {
+		usageFile = filepath.Join(filepath.Dir(filepath.Clean(cfg.Env.UsersFile)), "bandwidth_usage.json")
+	}
 	bwTracker := bandwidth.NewTracker(usageFile)
 	defer bwTracker.Stop()
 	ui.LogStatus("info", "Bandwidth tracker active → "+usageFile)
 
 	// Start metrics server with /api/usage endpoint
-	usageHandler := bandwidth.UsageHandler(bwTracker, cfg.Env.AllowedOrigin)
-	metrics := proxy.NewMetricsServer(cfg.MetricsListen, usageHandler)
+	usageHandler := bandwidth.UsageHandler(bwTracker, proxy.Stats.AllowedOrigin(), authenticator.ExpiryTime)
+	metrics := proxy.NewMetricsServer(cfg.MetricsListen, usageHandler, cfg.Env.MetricsToken)
+	metrics.HandleAdmin("/api/usage/reset", userStore.RequireSuperAdminIP(bandwidth.ResetHandler(bwTracker)))
+	metrics.HandleAdmin("/api/connections", userStore.RequireSuperAdminIP(bandwidth.ConnectionsHandler(bwTracker)))
+	metrics.HandleAdmin("/api/connections/kick", userStore.RequireSuperAdminIP(bandwidth.KickHandler(bwTracker)))
+	if cfg.Env.MetricsUserBandwidth {
+		limit := func(username string) int {
+			if user := authenticator.GetUser(username); user != nil {
+				return user.BandwidthLimitGB
+			}
+			return 0
+		}
+		prometheus.MustRegister(bandwidth.NewUsageCollector(bwTracker, limit, proxy.UserLabel))
+	}
 	metrics.Start()
 	go func() {
 		<-ctx.Done()
 		ui.LogGracefulShutdown()
 		metrics.Shutdown(context.Background())
+		bwTracker.Stop()
 	}()
 
 	// Create HTTP proxy server
-	httpSrv := httpproxy.NewServer(cfg, userStore, bwTracker)
+	httpSrv := httpproxy.NewServer(cfg, authenticator, bwTracker)
+	httpSrv.OnUser = proxy.Stats.RecordUser
+	httpSrv.OnCertificate = proxy.ObserveCertificate
 
 	// Create SOCKS5 proxy server
-	socks5Srv := socks5.NewServer(cfg, userStore, bwTracker)
+	socks5Srv := socks5.NewServer(cfg, authenticator, bwTracker)
+	socks5Srv.OnUser = proxy.Stats.RecordUser
 
-	// Start SOCKS5 in background
+	// Start SOCKS5 in background; only a taken address is fatal, and it is
+	// handed back here rather than exiting from this goroutine
+	socks5Err := make(chan error, 1)
 	go func() {
-		if err := socks5Srv.Start(ctx); err != nil {
+		err := socks5Srv.Start(ctx)
+		if err != nil && !errors.Is(err, config.ErrAddrInUse) {
 			ui.LogStatus("error", "SOCKS5 server failed: "+err.Error())
+			err = nil
+		}
+		if err != nil {
+			stop()
 		}
+		socks5Err <- err
 	}()
 
 	// Start HTTP proxy (blocking)
-	if err := httpSrv.Start(ctx); err != nil {
-		ui.LogStatus("error", "HTTP proxy failed: "+err.Error())
-		log.Fatal(err)
+	httpErr := httpSrv.Start(ctx)
+	if httpErr != nil {
+		stop()
+	}
+
+	// Wait for SOCKS5 relays to drain before exiting
+	if err := <-socks5Err; err != nil {
+		return &startError{"SOCKS5 server", err}
+	}
+	if httpErr != nil {
+		return &startError{"HTTP proxy", httpErr}
 	}
+	return nil
 }
 
-// itoa is a simple int to string helper
-func itoa(i int) string {
-	if i == 0 {
-		return "0"
+// exitAddrInUse is the exit status when a listen address is already taken,
+// so supervisors can tell a port clash from other startup failures.
+const exitAddrInUse = 3
+
+// startError is the error a server's Start failed with.
+type startError struct {
+	server string
+	err    error
+}
+
+// exitStartFailed logs why e's server failed to start and exits: with
+// exitAddrInUse and a hint when its address is taken, otherwise with 1.
+func exitStartFailed(e *startError) {
+	var inUse *config.AddrInUseError
+	if errors.As(e.err, &inUse) {
+		ui.LogStatus("error", fmt.Sprintf("%s failed: %s already in use — is another instance running?", e.server, inUse.Addr))
+		os.Exit(exitAddrInUse)
+	}
+	ui.LogStatus("error", e.server+" failed: "+e.err.Error())
+	os.Exit(1)
+}
+
+// printEnvSummary prints the effective environment as a table, flagging the
+// variables with issues, and logs each issue.
+func printEnvSummary(env *config.EnvConfig, issues []config.EnvIssue) {
+	flagged := make(map[string]config.EnvIssue, len(issues))
+	for _, is := range issues {
+		flagged[is.Var] = is
 	}
-	result := ""
-	for i > 0 {
-		result = string(rune('0'+i%10)) + result
-		i /= 10
+
+	settings := env.Summary()
+	shown := make(map[string]bool, len(settings))
+	rows := make([]map[string]string, 0, len(settings)+len(issues))
+	addRow := func(name, value string) {
+		status := ui.Success("ok")
+		if is, ok := flagged[name]; ok && is.Fatal {
+			status = ui.Error("%s", is.Message)
+		} else if ok {
+			status = ui.Warn("%s", is.Message)
+		}
+		rows = append(rows, map[string]string{"var": name, "value": value, "status": status})
+		shown[name] = true
+	}
+	for _, s := range settings {
+		addRow(s.Var, s.Value)
+	}
+	for _, is := range issues {
+		if !shown[is.Var] {
+			addRow(is.Var, is.Value)
+		}
+	}
+
+	ui.LogSection("Configuration")
+	fmt.Println(ui.RenderTable(ui.RenderTableOptions{
+		Columns: []ui.TableColumn{
+			{Key: "var", Header: "Variable"},
+			{Key: "value", Header: "Value"},
+			{Key: "status", Header: "Status"},
+		},
+		Rows: rows,
+	}))
+	for _, is := range issues {
+		if !is.Fatal {
+			ui.LogStatus("warn", is.Var+"="+is.Value+": "+is.Message)
+		}
+	}
+}
+
+// runSelftest prints a pass/fail table for the deployment without binding
+// any listeners and returns the process exit code.
+func runSelftest(cfg *config.Config) int {
+	ui.LogSection("Self-test")
+	results := selftest.Run(cfg)
+	fmt.Println(selftest.Render(results))
+	if selftest.Failed(results) {
+		ui.LogStatus("error", "Self-test failed")
+		return 1
+	}
+	ui.LogStatus("success", "All checks passed")
+	return 0
+}
+
+// runPACURL prints a signed PAC link for username, for --pac-url.
+func runPACURL(cfg *config.Config, username string, ttl time.Duration) int {
+	link, err := pac.SignedURL(cfg.Env.BaseURL, cfg.Env.PACSigningKey, username, ttl)
+	if err != nil {
+		ui.LogStatus("error", "Cannot sign a PAC link: "+err.Error()+" (set PAC_SIGNING_KEY)")
+		return 1
 	}
-	return result
+	ui.LogStatus("info", "PAC link for "+username+" valid until "+time.Now().Add(ttl).UTC().Format(time.RFC3339))
+	fmt.Println(link)
+	return 0
 }
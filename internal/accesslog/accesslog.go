@@ -0,0 +1,227 @@
+// Package accesslog writes one machine-readable record per completed proxy
+// connection or HTTP request to a buffered, size-rotated file.
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Format selects the record layout.
+type Format string
+
+const (
+	// Combined is Apache's combined log format with bytes up, the duration
+	// in milliseconds and the request ID appended
+	Combined Format = "combined"
+	// JSON writes one JSON object per line
+	JSON Format = "json"
+)
+
+// maxBackups is how many rotated files (path.1 … path.N) are kept.
+const maxBackups = 3
+
+// flushInterval bounds how long a record can sit in the buffer.
+const flushInterval = time.Second
+
+// Entry is one completed connection or HTTP request.
+type Entry struct {
+	ID        string        `json:"id"`         // Request ID (NewID), as in log lines and X-Proxy-Request-Id
+	Time      time.Time     `json:"time"`       // When it started
+	Proxy     string        `json:"proxy"`      // "http", "socks5" or "signal"
+	Client    string        `json:"client"`     // Client IP
+	User      string        `json:"user"`       // Username, or the SNI in Signal mode
+	Method    string        `json:"method"`     // HTTP method, "CONNECT" or "BIND"; empty for Signal
+	Target    string        `json:"target"`     // URL or host:port requested
+	Status    int           `json:"status"`     // HTTP status, or the SOCKS5 reply code (0 = succeeded); for Signal, the HTTP equivalent of the outcome
+	BytesUp   int64         `json:"bytes_up"`   // Client to target
+	BytesDown int64         `json:"bytes_down"` // Target to client
+	Duration  time.Duration `json:"-"`
+}
+
+// Logger appends entries to a file, rotating it once it passes maxSize.
+// It is safe for concurrent use.
+type Logger struct {
+	path    string
+	format  Format
+	maxSize int64
+
+	mu   sync.Mutex
+	file *os.File
+	buf  *bufio.Writer
+	size int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open appends to the log at path, creating it if needed. maxSize <= 0
+// disables rotation. Unknown formats fall back to Combined.
+func Open(path string, format Format, maxSize int64) (*Logger, error) {
+	if format != JSON {
+		format = Combined
+	}
+	l := &Logger{
+		path:    path,
+		format:  format,
+		maxSize: maxSize,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	go l.flushLoop()
+	return l, nil
+}
+
+// openFile opens l.path for appending. Callers hold l.mu (or own l).
+func (l *Logger) openFile() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.buf, l.size = f, bufio.NewWriter(f), info.Size()
+	return nil
+}
+
+// flushLoop writes buffered records out every flushInterval until Close.
+func (l *Logger) flushLoop() {
+	defer close(l.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.Flush()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Log appends e. Write errors are dropped: the access log must never stall
+// or fail a relay.
+func (l *Logger) Log(e Entry) {
+	line := l.encode(e)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		l.rotate()
+		if l.file == nil {
+			return
+		}
+	}
+	n, _ := l.buf.Write(line)
+	l.size += int64(n)
+}
+
+// encode renders e as one line in l's format.
+func (l *Logger) encode(e Entry) []byte {
+	if l.format == JSON {
+		line, _ := json.Marshal(struct {
+			Entry
+			DurationMs int64 `json:"duration_ms"`
+		}{e, e.Duration.Milliseconds()})
+		return append(line, '\n')
+	}
+	return []byte(fmt.Sprintf("%s - %s [%s] %s %d %d %d %d %s\n",
+		orDash(e.Client),
+		orDash(e.User),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(e.Method+" "+e.Target+" "+e.Proxy),
+		e.Status,
+		e.BytesDown,
+		e.BytesUp,
+		e.Duration.Milliseconds(),
+		orDash(e.ID)))
+}
+
+// ClientHost strips the port from a client address such as
+// "203.0.113.7:51234". Addresses without one are returned unchanged.
+func ClientHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// orDash returns s, or "-" for an empty field as in Apache logs.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// rotate shifts path.N-1 … path.1 up one, moves the current file to path.1
+// and starts a new one. Callers hold l.mu.
+func (l *Logger) rotate() {
+	l.buf.Flush()
+	l.file.Close()
+	l.file = nil
+	for i := maxBackups - 1; i > 0; i-- {
+		os.Rename(l.path+"."+strconv.Itoa(i), l.path+"."+strconv.Itoa(i+1))
+	}
+	os.Rename(l.path, l.path+".1")
+	l.openFile()
+}
+
+// Flush writes buffered records to the file.
+func (l *Logger) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.buf.Flush()
+}
+
+// Close flushes and closes the file. Later calls to Log are ignored.
+func (l *Logger) Close() error {
+	close(l.stop)
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.buf.Flush()
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file = nil
+	return err
+}
+
+// std receives entries from the package-level Log.
+var std atomic.Pointer[Logger]
+
+// SetDefault makes l receive entries passed to Log; nil turns logging off.
+func SetDefault(l *Logger) {
+	std.Store(l)
+}
+
+// Log appends e to the default logger, if one is set.
+func Log(e Entry) {
+	if l := std.Load(); l != nil {
+		l.Log(e)
+	}
+}
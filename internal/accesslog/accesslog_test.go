@@ -0,0 +1,188 @@
+package accesslog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testEntry = Entry{
+	ID:        "3f9a0c1e",
+	Time:      time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC),
+	Proxy:     "http",
+	Client:    "203.0.113.7",
+	User:      "alice",
+	Method:    "GET",
+	Target:    "http://example.com/",
+	Status:    200,
+	BytesUp:   12,
+	BytesDown: 345,
+	Duration:  1500 * time.Millisecond,
+}
+
+// readLines closes l and returns the lines of the file at path.
+func readLines(t *testing.T, l *Logger, path string) []string {
+	t.Helper()
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestJSONRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := Open(path, JSON, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Log(testEntry)
+
+	lines := readLines(t, l, path)
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1", len(lines))
+	}
+	var got struct {
+		Entry
+		DurationMs int64 `json:"duration_ms"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatalf("record is not JSON: %v\n%s", err, lines[0])
+	}
+	got.Duration = testEntry.Duration
+	if got.Entry != testEntry || got.DurationMs != 1500 {
+		t.Errorf("decoded %+v, %dms; want %+v, 1500ms", got.Entry, got.DurationMs, testEntry)
+	}
+}
+
+func TestCombinedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := Open(path, Combined, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Log(testEntry)
+	l.Log(Entry{Time: testEntry.Time, Proxy: "signal", Target: "chat.signal.org:443"})
+
+	lines := readLines(t, l, path)
+	want := []string{
+		`203.0.113.7 - alice [04/Mar/2025:05:06:07 +0000] "GET http://example.com/ http" 200 345 12 1500 3f9a0c1e`,
+		`- - - [04/Mar/2025:05:06:07 +0000] " chat.signal.org:443 signal" 0 0 0 0 -`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+	combined := regexp.MustCompile(`^\S+ - \S+ \[[^\]]+\] "[^"]*" \d+ \d+ \d+ \d+ \S+$`)
+	for _, line := range lines {
+		if !combined.MatchString(line) {
+			t.Errorf("not in combined format: %s", line)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := Open(path, JSON, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		l.Log(testEntry)
+	}
+	lines := readLines(t, l, path)
+
+	for _, name := range []string{path + ".1", path + ".2", path + ".3"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("backup %s missing: %v", filepath.Base(name), err)
+		}
+	}
+	if _, err := os.Stat(path + ".4"); !os.IsNotExist(err) {
+		t.Errorf("more than %d backups kept", maxBackups)
+	}
+
+	// Every file holds whole records and stays near the limit
+	for _, name := range []string{path, path + ".1"} {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, _ := f.Stat()
+		if info.Size() > 400 {
+			t.Errorf("%s is %d bytes, over the 400 byte limit", filepath.Base(name), info.Size())
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			if !json.Valid(sc.Bytes()) {
+				t.Errorf("%s has a partial record: %s", filepath.Base(name), sc.Text())
+			}
+		}
+		f.Close()
+	}
+	if len(lines) == 0 || lines[0] == "" {
+		t.Error("current file is empty after rotation")
+	}
+}
+
+func TestDefaultLogger(t *testing.T) {
+	Log(testEntry) // no default set: dropped
+
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := Open(path, JSON, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDefault(l)
+	defer SetDefault(nil)
+	Log(testEntry)
+
+	if lines := readLines(t, l, path); len(lines) != 1 {
+		t.Errorf("got %d lines, want 1", len(lines))
+	}
+}
+
+func TestClientHost(t *testing.T) {
+	for in, want := range map[string]string{
+		"203.0.113.7:51234": "203.0.113.7",
+		"[2001:db8::1]:443": "2001:db8::1",
+		"@":                 "@",
+	} {
+		if got := ClientHost(in); got != want {
+			t.Errorf("ClientHost(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNewID(t *testing.T) {
+	id := regexp.MustCompile(`^[0-9a-f]{8}$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		got := NewID()
+		if !id.MatchString(got) {
+			t.Fatalf("NewID() = %q, want 8 hex characters", got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 100 {
+		t.Errorf("100 IDs had only %d distinct values", len(seen))
+	}
+
+	ctx := WithID(context.Background(), "3f9a0c1e")
+	if got := IDFrom(ctx); got != "3f9a0c1e" {
+		t.Errorf("IDFrom = %q, want the stored ID", got)
+	}
+	if got := IDFrom(context.Background()); !id.MatchString(got) {
+		t.Errorf("IDFrom without an ID = %q, want a fresh one", got)
+	}
+	if got := Tag("3f9a0c1e", "Auth failed"); got != "[3f9a0c1e] Auth failed" {
+		t.Errorf("Tag = %q", got)
+	}
+}
@@ -0,0 +1,46 @@
+package accesslog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// HeaderRequestID carries a request's ID back to HTTP proxy clients, so a
+// user reporting a failure can quote it.
+const HeaderRequestID = "X-Proxy-Request-Id"
+
+// NewID returns a random 8 character hex ID for one client connection. It
+// ties together the connection's log lines, its access log record and, for
+// HTTP, the HeaderRequestID response header.
+func NewID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Tag prefixes a log message with id, as in "[3f9a0c1e] Auth failed ...".
+func Tag(id, message string) string {
+	if id == "" {
+		return message
+	}
+	return "[" + id + "] " + message
+}
+
+// idKey is the context key for a connection's ID.
+type idKey struct{}
+
+// WithID returns ctx carrying id, for accept loops to hand a connection's ID
+// to its handler.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// IDFrom returns the ID stored in ctx by WithID, or a new one if there is
+// none (e.g. a handler driven directly rather than by an accept loop).
+func IDFrom(ctx context.Context) string {
+	if id, ok := ctx.Value(idKey{}).(string); ok && id != "" {
+		return id
+	}
+	return NewID()
+}
@@ -0,0 +1,71 @@
+// Package acmecert provisions and renews TLS certificates from an ACME CA
+// (Let's Encrypt by default) as an alternative to CERT_FILE/KEY_FILE.
+package acmecert
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"signal-proxy/internal/config"
+)
+
+// ALPNProto must be offered by a listener for it to answer tls-alpn-01
+// challenges.
+const ALPNProto = acme.ALPNProto
+
+// NewManager builds an autocert manager from ACME_DOMAINS, ACME_CACHE_DIR,
+// ACME_EMAIL and ACME_DIRECTORY_URL. Certificates are only issued for the
+// listed domains.
+func NewManager(env *config.EnvConfig) (*autocert.Manager, error) {
+	if len(env.ACMEDomains) == 0 {
+		return nil, errors.New("ACME_ENABLED requires at least one domain in ACME_DOMAINS")
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(env.ACMEDomains...),
+		Cache:      autocert.DirCache(env.ACMECacheDir),
+		Email:      env.ACMEEmail,
+	}
+	if env.ACMEDirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: env.ACMEDirectoryURL}
+	}
+	return m, nil
+}
+
+// GetCertificate returns m's certificate callback, answering handshakes that
+// carry no SNI with the certificate for defaultName. observe, if not nil, is
+// called with every certificate served, except tls-alpn-01 challenge
+// certificates, so callers can follow renewals.
+func GetCertificate(m *autocert.Manager, defaultName string, observe func(*tls.Certificate)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return withObserver(withDefaultServerName(m.GetCertificate, defaultName), observe)
+}
+
+// withObserver passes the certificates get returns to observe, skipping the
+// ones answering ACME challenges.
+func withObserver(get func(*tls.ClientHelloInfo) (*tls.Certificate, error), observe func(*tls.Certificate)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if observe == nil {
+		return get
+	}
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := get(hello)
+		challenge := len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == ALPNProto
+		if err == nil && !challenge {
+			observe(cert)
+		}
+		return cert, err
+	}
+}
+
+// withDefaultServerName fills in ServerName before calling get, since
+// autocert cannot pick a certificate without one.
+func withDefaultServerName(get func(*tls.ClientHelloInfo) (*tls.Certificate, error), name string) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if hello.ServerName == "" {
+			hello.ServerName = name
+		}
+		return get(hello)
+	}
+}
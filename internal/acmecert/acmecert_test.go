@@ -0,0 +1,98 @@
+package acmecert
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"signal-proxy/internal/config"
+)
+
+func TestNewManagerRequiresDomains(t *testing.T) {
+	if _, err := NewManager(&config.EnvConfig{ACMEEnabled: true}); err == nil {
+		t.Fatal("manager built without ACME_DOMAINS")
+	}
+}
+
+func TestNewManagerWiring(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(&config.EnvConfig{
+		ACMEEnabled:      true,
+		ACMEDomains:      []string{"proxy.example.com", "private.example.com"},
+		ACMECacheDir:     dir,
+		ACMEEmail:        "ops@example.com",
+		ACMEDirectoryURL: "https://acme.test/directory",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for host, allowed := range map[string]bool{
+		"proxy.example.com":   true,
+		"private.example.com": true,
+		"evil.example.com":    false,
+	} {
+		if err := m.HostPolicy(context.Background(), host); (err == nil) != allowed {
+			t.Errorf("HostPolicy(%q) = %v, want allowed=%v", host, err, allowed)
+		}
+	}
+	if cache, ok := m.Cache.(autocert.DirCache); !ok || string(cache) != dir {
+		t.Errorf("Cache = %#v, want DirCache(%q)", m.Cache, dir)
+	}
+	if m.Email != "ops@example.com" {
+		t.Errorf("Email = %q, want ops@example.com", m.Email)
+	}
+	if m.Client == nil || m.Client.DirectoryURL != "https://acme.test/directory" {
+		t.Errorf("Client = %+v, want the configured directory URL", m.Client)
+	}
+
+	// Without ACME_DIRECTORY_URL autocert falls back to Let's Encrypt
+	m, err = NewManager(&config.EnvConfig{ACMEDomains: []string{"proxy.example.com"}, ACMECacheDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Client != nil {
+		t.Errorf("Client = %+v, want nil (Let's Encrypt default)", m.Client)
+	}
+}
+
+func TestDefaultServerNameForHelloWithoutSNI(t *testing.T) {
+	var seen string
+	get := withDefaultServerName(func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		seen = hello.ServerName
+		return &tls.Certificate{}, nil
+	}, "proxy.example.com")
+
+	get(&tls.ClientHelloInfo{})
+	if seen != "proxy.example.com" {
+		t.Errorf("ServerName = %q for a hello without SNI, want the default domain", seen)
+	}
+	get(&tls.ClientHelloInfo{ServerName: "private.example.com"})
+	if seen != "private.example.com" {
+		t.Errorf("ServerName = %q, want the client's SNI kept", seen)
+	}
+}
+
+func TestObserverSkipsChallengeCertificates(t *testing.T) {
+	served := &tls.Certificate{}
+	var observed int
+	get := withObserver(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return served, nil
+	}, func(cert *tls.Certificate) {
+		if cert != served {
+			t.Errorf("observed %p, want the served certificate", cert)
+		}
+		observed++
+	})
+
+	get(&tls.ClientHelloInfo{ServerName: "proxy.example.com", SupportedProtos: []string{"http/1.1"}})
+	if observed != 1 {
+		t.Errorf("observed %d certificates after a normal handshake, want 1", observed)
+	}
+	get(&tls.ClientHelloInfo{ServerName: "proxy.example.com", SupportedProtos: []string{ALPNProto}})
+	if observed != 1 {
+		t.Error("observed the tls-alpn-01 challenge certificate")
+	}
+}
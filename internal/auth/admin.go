@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"net/http"
+
+	"signal-proxy/internal/ui"
+)
+
+// RequireSuperAdminIP only lets requests from a super_admin CIDR reach next.
+// Everyone else gets 403, including every client when no super_admin IPs
+// are configured.
+func (s *UserStore) RequireSuperAdminIP(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientIP := RealClientIP(r, r.RemoteAddr)
+		if _, ok := s.IsSuperAdminIP(clientIP); !ok {
+			ui.LogStatus("warn", "Admin API denied for "+clientIP+": "+r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
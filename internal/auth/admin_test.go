@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireSuperAdminIP(t *testing.T) {
+	admin := User{Username: "root", Role: "super_admin", PasswordHash: mustHash(t, "pw"), Enabled: true}
+	store, err := NewUserStore(writeUsersFile(t, UsersConfig{Users: []User{admin}, SuperAdminIPs: []string{"10.0.0.0/8"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	called := false
+	handler := store.RequireSuperAdminIP(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	tests := []struct {
+		remote string
+		want   int
+	}{
+		{"10.1.2.3:5000", http.StatusOK},
+		{"192.168.1.5:5000", http.StatusForbidden},
+		{"garbage", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		called = false
+		req := httptest.NewRequest(http.MethodPost, "/api/usage/reset?user=alice", nil)
+		req.RemoteAddr = tt.remote
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.remote, rec.Code, tt.want)
+		}
+		if called != (tt.want == http.StatusOK) {
+			t.Errorf("%s: handler called = %v", tt.remote, called)
+		}
+	}
+}
+
+func TestRequireSuperAdminIPBehindTrustedProxy(t *testing.T) {
+	admin := User{Username: "root", Role: "super_admin", PasswordHash: mustHash(t, "pw"), Enabled: true}
+	store, err := NewUserStore(writeUsersFile(t, UsersConfig{Users: []User{admin}, SuperAdminIPs: []string{"10.0.0.0/8"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	SetTrustedProxies([]string{"127.0.0.1"})
+	t.Cleanup(func() { SetTrustedProxies(nil) })
+
+	handler := store.RequireSuperAdminIP(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		remote, forwardedFor string
+		want                 int
+	}{
+		{"127.0.0.1:5000", "10.1.2.3", http.StatusOK},
+		{"127.0.0.1:5000", "192.168.1.5", http.StatusForbidden}, // nginx on loopback no longer admits everyone
+		{"192.168.1.5:5000", "10.1.2.3", http.StatusForbidden},  // spoofed
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/api/usage/reset?user=alice", nil)
+		req.RemoteAddr = tt.remote
+		req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s via %s: status = %d, want %d", tt.forwardedFor, tt.remote, rec.Code, tt.want)
+		}
+	}
+}
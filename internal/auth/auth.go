@@ -1,19 +1,28 @@
 package auth
 
 import (
+	"container/list"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
+
+	"signal-proxy/internal/ui"
 )
 
+// HeaderAccountExpires is the response header carrying a user's account
+// expiry as RFC3339.
+const HeaderAccountExpires = "X-Proxy-Account-Expires"
+
 // User represents a proxy user with credentials and settings
 type User struct {
 	Username     string `json:"username"`
@@ -30,11 +39,23 @@ type User struct {
 	ExpiresAt          string `json:"expires_at,omitempty"`          // Account expiration (RFC3339), empty = no expiry
 }
 
+// Plan is a named set of default limits in the plans section of
+// users.json. A user naming it in plan inherits each limit they leave at 0;
+// -1 on the user makes that limit unlimited whatever the plan says.
+type Plan struct {
+	RateLimitRPM       int `json:"rate_limit_rpm,omitempty"`
+	BandwidthLimitGB   int `json:"bandwidth_limit_gb,omitempty"`
+	BandwidthSpeedMbps int `json:"bandwidth_speed_mbps,omitempty"`
+	MaxConnections     int `json:"max_connections,omitempty"`
+}
+
 // UsersConfig holds all user configuration
 type UsersConfig struct {
 	Users         []User   `json:"users"`
 	IPWhitelist   []string `json:"ip_whitelist"`    // CIDR notation, empty = allow all
 	SuperAdminIPs []string `json:"super_admin_ips"` // CIDR notation for super_admin bypass
+
+	Plans map[string]Plan `json:"plans,omitempty"` // Default limits by plan name
 }
 
 // UserStore manages user authentication and authorization
@@ -48,60 +69,210 @@ type UserStore struct {
 
 	// Credential cache: avoids repeated bcrypt (~100ms) on every HTTP proxy request.
 	// Keys are "username:sha256(password)", values expire after credCacheTTL.
-	credCacheMu sync.RWMutex
-	credCache   map[string]credCacheEntry
+	// credLRU orders entries most recently used first for size-capped eviction.
+	credCacheMu   sync.Mutex
+	credCache     map[string]*list.Element
+	credLRU       *list.List
+	credCacheSize int
+	credCacheTTL  time.Duration
+	stopJanitor   chan struct{}
+	closeOnce     sync.Once
+
+	expiryFailClosed     bool
+	strictPasswordHashes bool
+	extraUsers           []User
+	optionalFile         bool
 }
 
-// credCacheTTL is how long a successful credential validation is cached.
-const credCacheTTL = 5 * time.Minute
+// Credential cache defaults.
+const (
+	credCacheTTL             = 5 * time.Minute
+	defaultCredCacheSize     = 10000
+	defaultCredCacheInterval = time.Minute
+)
 
 // credCacheEntry stores a cached credential validation result.
 type credCacheEntry struct {
+	key        string
 	user       *User
 	validUntil time.Time
 }
 
+// UserStoreOptions tunes a UserStore. Zero values select the defaults.
+type UserStoreOptions struct {
+	CredCacheSize   int           // Max cached credentials (default 10000)
+	CredCacheTTL    time.Duration // Lifetime of a cached validation (default 5m)
+	JanitorInterval time.Duration // How often expired entries are purged (default 1m)
+
+	// ExpiryFailClosed treats a malformed expires_at as already expired
+	// instead of as no expiry.
+	ExpiryFailClosed bool
+
+	// StrictPasswordHashes fails a load on an enabled user whose
+	// password_hash is not a bcrypt hash, instead of only warning.
+	StrictPasswordHashes bool
+
+	// ExtraUsers are defined outside the users file (PROXY_USER, see
+	// EnvUser). They are merged in on every load, replacing file users of
+	// the same name, and let the store start without a users file.
+	ExtraUsers []User
+
+	// OptionalFile treats a missing users file as empty, for when another
+	// Authenticator (see WebhookAuthenticator) checks credentials and the
+	// file only adds ip_whitelist and super_admin_ips.
+	OptionalFile bool
+}
+
 // NewUserStore creates a new user store from a config file
 func NewUserStore(configPath string) (*UserStore, error) {
+	return NewUserStoreWithOptions(configPath, UserStoreOptions{})
+}
+
+// NewUserStoreWithOptions creates a user store with custom cache settings.
+// Call Close to stop the cache janitor when the store is no longer used.
+func NewUserStoreWithOptions(configPath string, opts UserStoreOptions) (*UserStore, error) {
+	if opts.CredCacheSize <= 0 {
+		opts.CredCacheSize = defaultCredCacheSize
+	}
+	if opts.CredCacheTTL <= 0 {
+		opts.CredCacheTTL = credCacheTTL
+	}
+	if opts.JanitorInterval <= 0 {
+		opts.JanitorInterval = defaultCredCacheInterval
+	}
+
 	store := &UserStore{
 		users:         make(map[string]*User),
 		ipWhitelist:   make([]*net.IPNet, 0),
 		superAdminIPs: make([]*net.IPNet, 0),
 		rateLimiter:   NewRateLimiter(),
-		credCache:     make(map[string]credCacheEntry),
+		credCache:     make(map[string]*list.Element),
+		credLRU:       list.New(),
+		credCacheSize: opts.CredCacheSize,
+		credCacheTTL:  opts.CredCacheTTL,
+		stopJanitor:   make(chan struct{}),
+
+		expiryFailClosed:     opts.ExpiryFailClosed,
+		strictPasswordHashes: opts.StrictPasswordHashes,
+		extraUsers:           opts.ExtraUsers,
+		optionalFile:         opts.OptionalFile,
 	}
 
 	if err := store.LoadFromFile(configPath); err != nil {
 		return nil, err
 	}
 
+	go store.credCacheJanitor(opts.JanitorInterval)
+
 	return store, nil
 }
 
-// LoadFromFile loads user configuration from a JSON file
+// Close stops the credential cache janitor. Safe to call more than once.
+func (s *UserStore) Close() {
+	s.closeOnce.Do(func() { close(s.stopJanitor) })
+}
+
+// credCacheJanitor periodically purges expired credential cache entries.
+func (s *UserStore) credCacheJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.purgeExpiredCredentials()
+		case <-s.stopJanitor:
+			return
+		}
+	}
+}
+
+// purgeExpiredCredentials removes every expired entry from the cache.
+func (s *UserStore) purgeExpiredCredentials() {
+	now := time.Now()
+	s.credCacheMu.Lock()
+	defer s.credCacheMu.Unlock()
+
+	for key, elem := range s.credCache {
+		if !now.Before(elem.Value.(*credCacheEntry).validUntil) {
+			s.credLRU.Remove(elem)
+			delete(s.credCache, key)
+		}
+	}
+}
+
+// LoadFromFile loads user configuration from a JSON file, or from every
+// *.json file in path if it is a directory (see readUsersDir). The store's
+// extra users are merged in, and with any of them (or OptionalFile) a
+// missing file is treated as empty. It can be called again to reload the
+// users; on error the store is left unchanged.
 func (s *UserStore) LoadFromFile(path string) error {
-	data, err := os.ReadFile(path)
-	if err != nil {
+	var cfg UsersConfig
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		if cfg, err = readUsersDir(path); err != nil {
+			return err
+		}
+	} else if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("failed to parse users file: %w", err)
+		}
+	} else if !os.IsNotExist(err) || (len(s.extraUsers) == 0 && !s.optionalFile) {
 		return fmt.Errorf("failed to read users file: %w", err)
 	}
+	cfg.Users = mergeUsers(cfg.Users, s.extraUsers)
+	applyPlans(cfg.Users, cfg.Plans)
+	if err := s.checkPasswordHashes(cfg.Users); err != nil {
+		return err
+	}
 
-	var cfg UsersConfig
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return fmt.Errorf("failed to parse users file: %w", err)
+	// Parse IP whitelist and super_admin IPs before touching the store
+	ipWhitelist := make([]*net.IPNet, 0, len(cfg.IPWhitelist))
+	for _, cidr := range cfg.IPWhitelist {
+		ipNet, err := parseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid IP whitelist entry '%s': %w", cidr, err)
+		}
+		ipWhitelist = append(ipWhitelist, ipNet)
+	}
+	superAdminIPs := make([]*net.IPNet, 0, len(cfg.SuperAdminIPs))
+	for _, cidr := range cfg.SuperAdminIPs {
+		ipNet, err := parseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid super_admin_ips entry '%s': %w", cidr, err)
+		}
+		superAdminIPs = append(superAdminIPs, ipNet)
 	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// Load users
+	prevSuperAdmin := s.superAdminUser
 	s.users = make(map[string]*User)
 	for i := range cfg.Users {
 		user := &cfg.Users[i]
+		if !user.Enabled && strings.ToLower(user.Role) == "super_admin" {
+			// Disabled super_admins lose the trusted-IP bypass too
+			if prevSuperAdmin != nil && strings.EqualFold(prevSuperAdmin.Username, user.Username) {
+				ui.LogStatus("warn", "super_admin "+user.Username+" was disabled; trusted IPs no longer bypass authentication")
+			} else {
+				ui.LogStatus("warn", "super_admin "+user.Username+" is disabled; trusted IP bypass inactive")
+			}
+		}
 		if user.Enabled {
-			s.users[strings.ToLower(user.Username)] = user
+			s.users[NormalizeUsername(user.Username)] = user
 			// Initialize rate limiter for user
 			if user.RateLimitRPM > 0 {
-				s.rateLimiter.SetLimit(user.Username, user.RateLimitRPM)
+				s.rateLimiter.SetLimit(NormalizeUsername(user.Username), user.RateLimitRPM)
+			}
+			if user.ExpiresAt != "" {
+				if _, err := time.Parse(time.RFC3339, user.ExpiresAt); err != nil {
+					treated := "no expiry"
+					if s.expiryFailClosed {
+						treated = "expired"
+					}
+					ui.LogStatus("warn", "Malformed expires_at for "+user.Username+" ("+user.ExpiresAt+"), treating as "+treated)
+				}
 			}
 		}
 	}
@@ -115,31 +286,163 @@ func (s *UserStore) LoadFromFile(path string) error {
 		}
 	}
 
-	// Parse IP whitelist
-	s.ipWhitelist = make([]*net.IPNet, 0, len(cfg.IPWhitelist))
-	for _, cidr := range cfg.IPWhitelist {
-		ipNet, err := parseCIDR(cidr)
-		if err != nil {
-			return fmt.Errorf("invalid IP whitelist entry '%s': %w", cidr, err)
+	s.ipWhitelist = ipWhitelist
+	s.superAdminIPs = superAdminIPs
+
+	// Invalidate all cached credentials on config reload — users may have
+	// changed passwords, been disabled, or had roles updated.
+	s.InvalidateAllCredentials()
+
+	return nil
+}
+
+// applyPlans fills in each user's limits from their plan, if plans defines
+// it (names compared case-insensitively). A limit set on the user wins over
+// the plan's, and -1 (explicitly unlimited) becomes 0 once it has kept the
+// plan's limit out. A plan missing from a non-empty plans section is only a
+// warning: the user keeps their own limits.
+func applyPlans(users []User, plans map[string]Plan) {
+	byName := make(map[string]Plan, len(plans))
+	for name, plan := range plans {
+		byName[strings.ToLower(name)] = plan
+	}
+	for i := range users {
+		user := &users[i]
+		var plan Plan
+		if user.Plan != "" && len(plans) > 0 {
+			var ok bool
+			if plan, ok = byName[strings.ToLower(user.Plan)]; !ok {
+				ui.LogStatus("warn", "User "+user.Username+" is on unknown plan '"+user.Plan+"'; only their own limits apply")
+			}
 		}
-		s.ipWhitelist = append(s.ipWhitelist, ipNet)
+		inheritLimit(&user.RateLimitRPM, plan.RateLimitRPM)
+		inheritLimit(&user.BandwidthLimitGB, plan.BandwidthLimitGB)
+		inheritLimit(&user.BandwidthSpeedMbps, plan.BandwidthSpeedMbps)
+		inheritLimit(&user.MaxConnections, plan.MaxConnections)
 	}
+}
 
-	// Parse super_admin IPs
-	s.superAdminIPs = make([]*net.IPNet, 0, len(cfg.SuperAdminIPs))
-	for _, cidr := range cfg.SuperAdminIPs {
-		ipNet, err := parseCIDR(cidr)
-		if err != nil {
-			return fmt.Errorf("invalid super_admin_ips entry '%s': %w", cidr, err)
+// inheritLimit resolves one of a user's limits against their plan's: 0
+// takes the plan's, a negative value is unlimited (0) and any other value
+// is kept.
+func inheritLimit(own *int, plan int) {
+	switch {
+	case *own == 0:
+		*own = plan
+	case *own < 0:
+		*own = 0
+	}
+}
+
+// checkPasswordHashes warns about enabled users whose password_hash is not
+// a bcrypt hash (see badPasswordHashes). With strictPasswordHashes the
+// first one is an error instead.
+func (s *UserStore) checkPasswordHashes(users []User) error {
+	for _, username := range badPasswordHashes(users) {
+		if s.strictPasswordHashes {
+			return fmt.Errorf("password_hash of user '%s' is not a bcrypt hash", username)
 		}
-		s.superAdminIPs = append(s.superAdminIPs, ipNet)
+		ui.LogStatus("warn", "password_hash of "+username+" is not a bcrypt hash (a plaintext password?); they cannot log in")
 	}
+	return nil
+}
 
-	// Invalidate all cached credentials on config reload — users may have
-	// changed passwords, been disabled, or had roles updated.
-	s.InvalidateAllCredentials()
+// badPasswordHashes names the enabled users whose password_hash is not a
+// bcrypt hash, typically a plaintext password pasted in by hand. They can
+// never log in, and the auth failures alone do not say why.
+func badPasswordHashes(users []User) []string {
+	var bad []string
+	for _, user := range users {
+		if !user.Enabled {
+			continue
+		}
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			bad = append(bad, user.Username)
+		}
+	}
+	return bad
+}
 
-	return nil
+// mergeUsers returns users with each of extra added, replacing any user of
+// the same name (compared case-insensitively).
+func mergeUsers(users, extra []User) []User {
+	if len(extra) == 0 {
+		return users
+	}
+	merged := make([]User, 0, len(users)+len(extra))
+	for _, user := range users {
+		overridden := false
+		for _, e := range extra {
+			if strings.EqualFold(e.Username, user.Username) {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			merged = append(merged, user)
+		}
+	}
+	return append(merged, extra...)
+}
+
+// EnvUser builds the enabled, unrestricted account defined by PROXY_USER.
+// passwordHash is a bcrypt hash; if it is empty, password is hashed instead.
+func EnvUser(username, password, passwordHash string) (User, error) {
+	if passwordHash == "" {
+		hash, err := HashPassword(password)
+		if err != nil {
+			return User{}, fmt.Errorf("failed to hash PROXY_PASS: %w", err)
+		}
+		passwordHash = hash
+	}
+	return User{Username: username, Role: "user", PasswordHash: passwordHash, Enabled: true}, nil
+}
+
+// readUsersDir merges the UsersConfig fragments in every *.json file in dir,
+// in name order, so teams can manage their users in separate files. Users,
+// ip_whitelist and super_admin_ips are concatenated and plans are combined;
+// a username or plan name (compared case-insensitively) defined in two
+// files is an error.
+func readUsersDir(dir string) (UsersConfig, error) {
+	var merged UsersConfig
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return merged, fmt.Errorf("failed to read users directory: %w", err)
+	}
+	definedIn := make(map[string]string)
+	planIn := make(map[string]string)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return merged, fmt.Errorf("failed to read users file: %w", err)
+		}
+		var cfg UsersConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return merged, fmt.Errorf("failed to parse users file %s: %w", filepath.Base(file), err)
+		}
+		for _, user := range cfg.Users {
+			key := NormalizeUsername(user.Username)
+			if prev, ok := definedIn[key]; ok && prev != file {
+				return merged, fmt.Errorf("user '%s' is defined in both %s and %s", user.Username, filepath.Base(prev), filepath.Base(file))
+			}
+			definedIn[key] = file
+		}
+		for name, plan := range cfg.Plans {
+			key := strings.ToLower(name)
+			if prev, ok := planIn[key]; ok && prev != file {
+				return merged, fmt.Errorf("plan '%s' is defined in both %s and %s", name, filepath.Base(prev), filepath.Base(file))
+			}
+			planIn[key] = file
+			if merged.Plans == nil {
+				merged.Plans = make(map[string]Plan)
+			}
+			merged.Plans[name] = plan
+		}
+		merged.Users = append(merged.Users, cfg.Users...)
+		merged.IPWhitelist = append(merged.IPWhitelist, cfg.IPWhitelist...)
+		merged.SuperAdminIPs = append(merged.SuperAdminIPs, cfg.SuperAdminIPs...)
+	}
+	return merged, nil
 }
 
 // ValidateCredentials checks if username and password are valid.
@@ -147,19 +450,16 @@ func (s *UserStore) LoadFromFile(path string) error {
 func (s *UserStore) ValidateCredentials(username, password string) (*User, bool) {
 	// Build cache key from username + SHA-256 of password (never cache plaintext)
 	passHash := sha256.Sum256([]byte(password))
-	cacheKey := strings.ToLower(username) + ":" + hex.EncodeToString(passHash[:])
+	cacheKey := NormalizeUsername(username) + ":" + hex.EncodeToString(passHash[:])
 
 	// Check cache first (fast path)
-	s.credCacheMu.RLock()
-	if entry, ok := s.credCache[cacheKey]; ok && time.Now().Before(entry.validUntil) {
-		s.credCacheMu.RUnlock()
-		return entry.user, true
+	if user, ok := s.cachedCredential(cacheKey); ok {
+		return user, true
 	}
-	s.credCacheMu.RUnlock()
 
 	// Cache miss — fall through to bcrypt (slow path, ~100ms)
 	s.mu.RLock()
-	user, exists := s.users[strings.ToLower(username)]
+	user, exists := s.users[NormalizeUsername(username)]
 	s.mu.RUnlock()
 
 	if !exists {
@@ -172,14 +472,63 @@ func (s *UserStore) ValidateCredentials(username, password string) (*User, bool)
 	}
 
 	// Cache successful validation
+	s.cacheCredential(cacheKey, user)
+
+	return user, true
+}
+
+// cachedCredential returns the cached user for key if present and unexpired,
+// marking it most recently used.
+func (s *UserStore) cachedCredential(key string) (*User, bool) {
 	s.credCacheMu.Lock()
-	s.credCache[cacheKey] = credCacheEntry{
-		user:       user,
-		validUntil: time.Now().Add(credCacheTTL),
+	defer s.credCacheMu.Unlock()
+
+	elem, ok := s.credCache[key]
+	if !ok {
+		return nil, false
 	}
-	s.credCacheMu.Unlock()
+	entry := elem.Value.(*credCacheEntry)
+	if !time.Now().Before(entry.validUntil) {
+		s.credLRU.Remove(elem)
+		delete(s.credCache, key)
+		return nil, false
+	}
+	s.credLRU.MoveToFront(elem)
+	return entry.user, true
+}
 
-	return user, true
+// cacheCredential stores a validation result, evicting the least recently
+// used entries once the cache is at its size cap.
+func (s *UserStore) cacheCredential(key string, user *User) {
+	s.credCacheMu.Lock()
+	defer s.credCacheMu.Unlock()
+
+	validUntil := time.Now().Add(s.credCacheTTL)
+	if elem, ok := s.credCache[key]; ok {
+		entry := elem.Value.(*credCacheEntry)
+		entry.user = user
+		entry.validUntil = validUntil
+		s.credLRU.MoveToFront(elem)
+		return
+	}
+
+	for s.credLRU.Len() >= s.credCacheSize {
+		oldest := s.credLRU.Back()
+		s.credLRU.Remove(oldest)
+		delete(s.credCache, oldest.Value.(*credCacheEntry).key)
+	}
+	s.credCache[key] = s.credLRU.PushFront(&credCacheEntry{
+		key:        key,
+		user:       user,
+		validUntil: validUntil,
+	})
+}
+
+// credCacheLen returns the number of cached credentials.
+func (s *UserStore) credCacheLen() int {
+	s.credCacheMu.Lock()
+	defer s.credCacheMu.Unlock()
+	return len(s.credCache)
 }
 
 // InvalidateUser removes all cached credentials for a specific user.
@@ -188,9 +537,10 @@ func (s *UserStore) InvalidateUser(username string) {
 	s.credCacheMu.Lock()
 	defer s.credCacheMu.Unlock()
 
-	prefix := strings.ToLower(username) + ":"
-	for key := range s.credCache {
+	prefix := NormalizeUsername(username) + ":"
+	for key, elem := range s.credCache {
 		if strings.HasPrefix(key, prefix) {
+			s.credLRU.Remove(elem)
 			delete(s.credCache, key)
 		}
 	}
@@ -202,7 +552,8 @@ func (s *UserStore) InvalidateAllCredentials() {
 	s.credCacheMu.Lock()
 	defer s.credCacheMu.Unlock()
 
-	s.credCache = make(map[string]credCacheEntry)
+	s.credCache = make(map[string]*list.Element)
+	s.credLRU.Init()
 }
 
 // CheckIPAllowed verifies if an IP address is in the whitelist
@@ -216,18 +567,7 @@ func (s *UserStore) CheckIPAllowed(ipStr string) bool {
 		return true
 	}
 
-	// Parse the IP address (handle host:port format)
-	host := ipStr
-	if strings.Contains(ipStr, ":") {
-		var err error
-		host, _, err = net.SplitHostPort(ipStr)
-		if err != nil {
-			// Might be IPv6 without port
-			host = ipStr
-		}
-	}
-
-	ip := net.ParseIP(host)
+	ip := parseIP(ipStr)
 	if ip == nil {
 		return false
 	}
@@ -242,11 +582,19 @@ func (s *UserStore) CheckIPAllowed(ipStr string) bool {
 	return false
 }
 
+// HasIPWhitelist reports whether an ip_whitelist is configured, i.e.
+// whether CheckIPAllowed restricts anyone.
+func (s *UserStore) HasIPWhitelist() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.ipWhitelist) > 0
+}
+
 // CheckRateLimit checks if request is within rate limit for user
 // Returns true if allowed, false if rate limited
 func (s *UserStore) CheckRateLimit(username string) bool {
 	s.mu.RLock()
-	user, exists := s.users[strings.ToLower(username)]
+	user, exists := s.users[NormalizeUsername(username)]
 	s.mu.RUnlock()
 
 	if !exists {
@@ -258,7 +606,26 @@ func (s *UserStore) CheckRateLimit(username string) bool {
 		return true
 	}
 
-	return s.rateLimiter.Allow(username)
+	return s.rateLimiter.Allow(NormalizeUsername(username))
+}
+
+// RateLimitState reports the user's requests-per-minute limit, the whole
+// requests left in their bucket and, when none are left, how long until the
+// next one is allowed. ok is false for unknown or unlimited users.
+func (s *UserStore) RateLimitState(username string) (limit, remaining int, retryAfter time.Duration, ok bool) {
+	s.mu.RLock()
+	user, exists := s.users[NormalizeUsername(username)]
+	s.mu.RUnlock()
+
+	if !exists || user.RateLimitRPM <= 0 {
+		return 0, 0, 0, false
+	}
+
+	tokens := s.rateLimiter.GetRemainingTokens(NormalizeUsername(username))
+	if tokens < 0 {
+		return 0, 0, 0, false
+	}
+	return user.RateLimitRPM, int(tokens), s.rateLimiter.RetryAfter(NormalizeUsername(username)), true
 }
 
 // IsSuperAdminIP checks if the given IP matches any super_admin CIDR.
@@ -292,18 +659,20 @@ func (s *UserStore) GetUserCount() int {
 	return len(s.users)
 }
 
-// GetUser returns a user by username, or nil if not found.
+// GetUser returns a user by username, compared case-insensitively, or nil
+// if there is none. Disabled users are never loaded, so they are nil too.
 func (s *UserStore) GetUser(username string) *User {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.users[strings.ToLower(username)]
+	return s.users[NormalizeUsername(username)]
 }
 
 // CheckExpiry returns true if the user's account has NOT expired.
-// Returns false if the account has expired.
+// Returns false if the account has expired. A malformed expires_at counts
+// as no expiry unless the store was opened with ExpiryFailClosed.
 func (s *UserStore) CheckExpiry(username string) bool {
 	s.mu.RLock()
-	user, exists := s.users[strings.ToLower(username)]
+	user, exists := s.users[NormalizeUsername(username)]
 	s.mu.RUnlock()
 
 	if !exists {
@@ -316,12 +685,52 @@ func (s *UserStore) CheckExpiry(username string) bool {
 
 	expiryTime, err := time.Parse(time.RFC3339, user.ExpiresAt)
 	if err != nil {
-		return true // unparseable expiry treated as no expiry
+		return !s.expiryFailClosed
 	}
 
 	return time.Now().Before(expiryTime)
 }
 
+// ExpiryTime returns when the user's account expires. ok is false for
+// unknown users and accounts with no (or an unparseable) expiry.
+func (s *UserStore) ExpiryTime(username string) (time.Time, bool) {
+	s.mu.RLock()
+	user, exists := s.users[NormalizeUsername(username)]
+	s.mu.RUnlock()
+
+	if !exists || user.ExpiresAt == "" {
+		return time.Time{}, false
+	}
+	expiryTime, err := time.Parse(time.RFC3339, user.ExpiresAt)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expiryTime, true
+}
+
+// DaysUntilExpiry returns the whole days left before the user's account
+// expires, rounded up, so an account expiring later today reports 1. Expired
+// accounts report 0 or less. ok is false when there is no expiry.
+func (s *UserStore) DaysUntilExpiry(username string) (int, bool) {
+	expiryTime, ok := s.ExpiryTime(username)
+	if !ok {
+		return 0, false
+	}
+	return daysUntil(expiryTime, time.Now()), true
+}
+
+// daysUntil returns the days from now to t, rounded up.
+func daysUntil(t, now time.Time) int {
+	return int(math.Ceil(t.Sub(now).Hours() / 24))
+}
+
+// NormalizeUsername returns the key an account's rate limit, usage and
+// metrics are kept under. Usernames are case-insensitive, so "Alice" and
+// "alice" share them.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(username)
+}
+
 // HashPassword generates a bcrypt hash for a password
 // This is a utility function for generating hashes for users.json
 func HashPassword(password string) (string, error) {
@@ -332,8 +741,16 @@ func HashPassword(password string) (string, error) {
 	return string(hash), nil
 }
 
-// parseCIDR parses a CIDR string, handling bare IPs without mask notation.
+// parseCIDR parses a CIDR string, handling bare IPs without mask notation
+// and bracketed IPv6 such as "[2001:db8::1]" or "[2001:db8::]/32".
 func parseCIDR(cidr string) (*net.IPNet, error) {
+	if strings.HasPrefix(cidr, "[") {
+		addr, mask, _ := strings.Cut(cidr, "/")
+		cidr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+		if mask != "" {
+			cidr += "/" + mask
+		}
+	}
 	if !strings.Contains(cidr, "/") {
 		if strings.Contains(cidr, ":") {
 			cidr = cidr + "/128" // IPv6
@@ -345,7 +762,9 @@ func parseCIDR(cidr string) (*net.IPNet, error) {
 	return ipNet, err
 }
 
-// parseIP extracts and parses an IP from a string that may include a port.
+// parseIP extracts and parses an IP from a string that may include a port:
+// "10.0.0.1", "10.0.0.1:1234", "2001:db8::1", "[2001:db8::1]" or
+// "[fe80::1%eth0]:1234". A zone is dropped.
 func parseIP(ipStr string) net.IP {
 	host := ipStr
 	if strings.Contains(ipStr, ":") {
@@ -355,5 +774,7 @@ func parseIP(ipStr string) net.IP {
 			host = ipStr // Might be IPv6 without port
 		}
 	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	host, _, _ = strings.Cut(host, "%")
 	return net.ParseIP(host)
 }
@@ -0,0 +1,644 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeUsersFile writes cfg as users.json in a temp dir and returns its path.
+func writeUsersFile(t *testing.T, cfg UsersConfig) string {
+	t.Helper()
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// mustHash bcrypt-hashes password at minimum cost for fast tests.
+func mustHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+// writeUsersFragment writes cfg as name in dir.
+func writeUsersFragment(t *testing.T, dir, name string, cfg UsersConfig) {
+	t.Helper()
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCredCacheJanitorRemovesExpired(t *testing.T) {
+	path := writeUsersFile(t, UsersConfig{Users: []User{
+		{Username: "alice", Role: "user", PasswordHash: mustHash(t, "secret"), Enabled: true},
+	}})
+	store, err := NewUserStoreWithOptions(path, UserStoreOptions{
+		CredCacheTTL:    50 * time.Millisecond,
+		JanitorInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, ok := store.ValidateCredentials("alice", "secret"); !ok {
+		t.Fatal("valid credentials rejected")
+	}
+	if got := store.credCacheLen(); got != 1 {
+		t.Fatalf("cache len = %d, want 1", got)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for store.credCacheLen() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("janitor did not purge expired entry")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCredCacheSizeCapEvictsLRU(t *testing.T) {
+	const users = 5
+	cfg := UsersConfig{}
+	for i := 0; i < users; i++ {
+		cfg.Users = append(cfg.Users, User{
+			Username:     "user" + strconv.Itoa(i),
+			Role:         "user",
+			PasswordHash: mustHash(t, "pw"),
+			Enabled:      true,
+		})
+	}
+	store, err := NewUserStoreWithOptions(writeUsersFile(t, cfg), UserStoreOptions{CredCacheSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 3; i++ {
+		store.ValidateCredentials("user"+strconv.Itoa(i), "pw")
+	}
+	// Touch user0 so user1 becomes least recently used
+	store.ValidateCredentials("user0", "pw")
+	for i := 3; i < users; i++ {
+		store.ValidateCredentials("user"+strconv.Itoa(i), "pw")
+	}
+
+	if got := store.credCacheLen(); got != 3 {
+		t.Fatalf("cache len = %d, want cap of 3", got)
+	}
+	cached := func(name string) bool {
+		store.credCacheMu.Lock()
+		defer store.credCacheMu.Unlock()
+		for key := range store.credCache {
+			if strings.HasPrefix(key, name+":") {
+				return true
+			}
+		}
+		return false
+	}
+	for name, want := range map[string]bool{"user0": true, "user1": false, "user2": false, "user3": true, "user4": true} {
+		if got := cached(name); got != want {
+			t.Errorf("%s cached = %v, want %v", name, got, want)
+		}
+	}
+
+	store.InvalidateUser("user0")
+	if got := store.credCacheLen(); got != 2 {
+		t.Errorf("cache len after InvalidateUser = %d, want 2", got)
+	}
+}
+
+func TestDaysUntilExpiry(t *testing.T) {
+	now := time.Now()
+	path := writeUsersFile(t, UsersConfig{Users: []User{
+		{Username: "never", PasswordHash: mustHash(t, "pw"), Enabled: true},
+		{Username: "expired", PasswordHash: mustHash(t, "pw"), Enabled: true, ExpiresAt: now.Add(-71 * time.Hour).Format(time.RFC3339)},
+		{Username: "today", PasswordHash: mustHash(t, "pw"), Enabled: true, ExpiresAt: now.Add(2 * time.Hour).Format(time.RFC3339)},
+		{Username: "soon", PasswordHash: mustHash(t, "pw"), Enabled: true, ExpiresAt: now.Add(6*24*time.Hour + time.Hour).Format(time.RFC3339)},
+		{Username: "garbled", PasswordHash: mustHash(t, "pw"), Enabled: true, ExpiresAt: "next tuesday"},
+	}})
+	store, err := NewUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	cases := []struct {
+		username string
+		days     int
+		ok       bool
+	}{
+		{"never", 0, false},
+		{"garbled", 0, false},
+		{"missing", 0, false},
+		{"expired", -2, true},
+		{"today", 1, true},
+		{"soon", 7, true},
+		{"SOON", 7, true},
+	}
+	for _, tc := range cases {
+		days, ok := store.DaysUntilExpiry(tc.username)
+		if ok != tc.ok || days != tc.days {
+			t.Errorf("DaysUntilExpiry(%q) = %d, %v; want %d, %v", tc.username, days, ok, tc.days, tc.ok)
+		}
+	}
+}
+
+func TestDaysUntilRoundsUp(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		until time.Duration
+		want  int
+	}{
+		{24 * time.Hour, 1},
+		{24*time.Hour + time.Second, 2},
+		{time.Second, 1},
+		{0, 0},
+		{-time.Hour, 0},
+		{-25 * time.Hour, -1},
+	}
+	for _, tc := range cases {
+		if got := daysUntil(now.Add(tc.until), now); got != tc.want {
+			t.Errorf("daysUntil(now%+v) = %d, want %d", tc.until, got, tc.want)
+		}
+	}
+}
+
+func TestUserRecordRoundTrips(t *testing.T) {
+	// A record as written by scripts/manage-users.go, every field set
+	hash := mustHash(t, "pw")
+	raw := `{"users": [{
+		"username": "alice",
+		"role": "user",
+		"password_hash": "` + hash + `",
+		"rate_limit_rpm": 120,
+		"enabled": true,
+		"plan": "pro",
+		"bandwidth_limit_gb": 50,
+		"bandwidth_speed_mbps": 20,
+		"max_connections": 5,
+		"expires_at": "2030-01-31T00:00:00Z"
+	}], "ip_whitelist": []}`
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(path, []byte(raw), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	want := User{
+		Username:           "alice",
+		Role:               "user",
+		PasswordHash:       hash,
+		RateLimitRPM:       120,
+		Enabled:            true,
+		Plan:               "pro",
+		BandwidthLimitGB:   50,
+		BandwidthSpeedMbps: 20,
+		MaxConnections:     5,
+		ExpiresAt:          "2030-01-31T00:00:00Z",
+	}
+	got := store.GetUser("alice")
+	if got == nil {
+		t.Fatal("user not loaded")
+	}
+	if *got != want {
+		t.Errorf("loaded user = %+v\nwant %+v", *got, want)
+	}
+
+	// Guard against fields added to User without a JSON key to load them
+	v := reflect.ValueOf(want)
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).IsZero() {
+			t.Errorf("User.%s is not covered by this record", v.Type().Field(i).Name)
+		}
+	}
+
+	// Round-trip back through JSON without losing anything
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again User
+	if err := json.Unmarshal(data, &again); err != nil {
+		t.Fatal(err)
+	}
+	if again != want {
+		t.Errorf("re-encoded user = %+v\nwant %+v", again, want)
+	}
+
+	if !store.CheckExpiry("alice") {
+		t.Error("CheckExpiry rejected an account expiring in 2030")
+	}
+}
+
+func TestCheckExpiry(t *testing.T) {
+	now := time.Now()
+	users := []User{
+		{Username: "expired", PasswordHash: mustHash(t, "pw"), Enabled: true, ExpiresAt: now.Add(-time.Minute).Format(time.RFC3339)},
+		{Username: "future", PasswordHash: mustHash(t, "pw"), Enabled: true, ExpiresAt: now.Add(time.Hour).Format(time.RFC3339)},
+		{Username: "empty", PasswordHash: mustHash(t, "pw"), Enabled: true},
+		{Username: "malformed", PasswordHash: mustHash(t, "pw"), Enabled: true, ExpiresAt: "2030-13-45"},
+	}
+	path := writeUsersFile(t, UsersConfig{Users: users})
+
+	cases := []struct {
+		username   string
+		failOpen   bool
+		failClosed bool
+	}{
+		{"expired", false, false},
+		{"future", true, true},
+		{"empty", true, true},
+		{"malformed", true, false},
+		{"missing", false, false},
+	}
+	for _, failClosed := range []bool{false, true} {
+		store, err := NewUserStoreWithOptions(path, UserStoreOptions{ExpiryFailClosed: failClosed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(store.Close)
+		for _, tc := range cases {
+			want := tc.failOpen
+			if failClosed {
+				want = tc.failClosed
+			}
+			if got := store.CheckExpiry(tc.username); got != want {
+				t.Errorf("failClosed=%v: CheckExpiry(%q) = %v, want %v", failClosed, tc.username, got, want)
+			}
+		}
+		store.Close()
+	}
+}
+
+func TestDisabledSuperAdminLosesAccessOnReload(t *testing.T) {
+	admin := User{Username: "root", Role: "super_admin", PasswordHash: mustHash(t, "pw"), Enabled: true}
+	cfg := UsersConfig{Users: []User{admin}, SuperAdminIPs: []string{"10.0.0.0/8"}}
+	path := writeUsersFile(t, cfg)
+	store, err := NewUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, ok := store.IsSuperAdminIP("10.1.2.3:5000"); !ok {
+		t.Fatal("trusted IP not recognised while super_admin is enabled")
+	}
+	if _, ok := store.ValidateCredentials("root", "pw"); !ok {
+		t.Fatal("valid credentials rejected")
+	}
+
+	// Disable the account and hot-reload, with its credentials still cached
+	cfg.Users[0].Enabled = false
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := store.IsSuperAdminIP("10.1.2.3:5000"); ok {
+		t.Error("disabled super_admin still bypasses auth from a trusted IP")
+	}
+	if _, ok := store.ValidateCredentials("root", "pw"); ok {
+		t.Error("disabled super_admin still authenticates from the credential cache")
+	}
+	if store.GetUser("root") != nil {
+		t.Error("GetUser returned a disabled user")
+	}
+}
+
+func TestCheckIPAllowedIPv6(t *testing.T) {
+	store, err := NewUserStore(writeUsersFile(t, UsersConfig{
+		IPWhitelist: []string{"10.0.0.0/8", "2001:db8::/32", "[2001:db9::1]", "[fd00::]/8"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for addr, want := range map[string]bool{
+		"10.1.2.3":              true,
+		"10.1.2.3:1234":         true,
+		"::ffff:10.1.2.3":       true, // IPv4-mapped
+		"[::ffff:10.1.2.3]:443": true,
+		"2001:db8::5":           true,
+		"[2001:db8::5]":         true,
+		"[2001:db8::5]:51234":   true,
+		"[2001:db9::1]:80":      true,
+		"[2001:db9::2]:80":      false,
+		"[fd12::1]:80":          true,
+		"[fe80::1%eth0]:80":     false,
+		"[::1]:80":              false,
+		"[::1":                  false,
+	} {
+		if got := store.CheckIPAllowed(addr); got != want {
+			t.Errorf("CheckIPAllowed(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestLoadUsersDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeUsersFragment(t, dir, "team-a.json", UsersConfig{
+		Users:       []User{{Username: "alice", PasswordHash: mustHash(t, "a"), Enabled: true}},
+		IPWhitelist: []string{"10.0.0.0/8"},
+	})
+	writeUsersFragment(t, dir, "team-b.json", UsersConfig{
+		Users:       []User{{Username: "bob", PasswordHash: mustHash(t, "b"), Enabled: true}},
+		IPWhitelist: []string{"192.168.0.0/16"},
+	})
+	// Only *.json files are fragments
+	os.WriteFile(filepath.Join(dir, "README"), []byte("not json"), 0600)
+
+	store, err := NewUserStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if got := store.GetUserCount(); got != 2 {
+		t.Errorf("GetUserCount = %d, want 2", got)
+	}
+	for user, pw := range map[string]string{"alice": "a", "bob": "b"} {
+		if _, ok := store.ValidateCredentials(user, pw); !ok {
+			t.Errorf("%s rejected", user)
+		}
+	}
+	for ip, want := range map[string]bool{"10.1.2.3": true, "192.168.1.1": true, "172.16.0.1": false} {
+		if got := store.CheckIPAllowed(ip); got != want {
+			t.Errorf("CheckIPAllowed(%s) = %v, want %v", ip, got, want)
+		}
+	}
+
+	// A reload re-reads the whole directory
+	writeUsersFragment(t, dir, "team-c.json", UsersConfig{
+		Users: []User{{Username: "carol", PasswordHash: mustHash(t, "c"), Enabled: true}},
+	})
+	if err := store.LoadFromFile(dir); err != nil {
+		t.Fatal(err)
+	}
+	if store.GetUser("carol") == nil || store.GetUserCount() != 3 {
+		t.Errorf("after reload: %d users, carol = %v", store.GetUserCount(), store.GetUser("carol"))
+	}
+
+	// A reload that fails keeps the users and whitelist already loaded
+	writeUsersFragment(t, dir, "team-d.json", UsersConfig{IPWhitelist: []string{"not-a-cidr"}})
+	if err := store.LoadFromFile(dir); err == nil {
+		t.Fatal("reload with an invalid ip_whitelist entry succeeded")
+	}
+	if store.GetUserCount() != 3 || !store.CheckIPAllowed("10.1.2.3") {
+		t.Errorf("after a failed reload: %d users, 10.1.2.3 allowed = %v", store.GetUserCount(), store.CheckIPAllowed("10.1.2.3"))
+	}
+}
+
+func TestLoadUsersDirectoryRejectsDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	writeUsersFragment(t, dir, "a.json", UsersConfig{Users: []User{{Username: "alice", Enabled: true}}})
+	writeUsersFragment(t, dir, "b.json", UsersConfig{Users: []User{{Username: "Alice", Enabled: true}}})
+
+	_, err := NewUserStore(dir)
+	if err == nil {
+		t.Fatal("duplicate username across files loaded without error")
+	}
+	if !strings.Contains(err.Error(), "a.json") || !strings.Contains(err.Error(), "b.json") {
+		t.Errorf("error %q does not name both files", err)
+	}
+}
+
+func TestPlanLimitsInherited(t *testing.T) {
+	path := writeUsersFile(t, UsersConfig{
+		Plans: map[string]Plan{
+			"starter": {RateLimitRPM: 60, BandwidthLimitGB: 10, BandwidthSpeedMbps: 5, MaxConnections: 2},
+			"Pro":     {RateLimitRPM: 300, BandwidthLimitGB: 100},
+		},
+		Users: []User{
+			{Username: "alice", Enabled: true, Plan: "starter"},
+			{Username: "bob", Enabled: true, Plan: "pro", BandwidthLimitGB: 500, MaxConnections: 8},
+			{Username: "carol", Enabled: true, Plan: "gold", RateLimitRPM: 30},
+			{Username: "dave", Enabled: true, BandwidthLimitGB: 1},
+			{Username: "erin", Enabled: true, Plan: "starter", BandwidthLimitGB: -1, MaxConnections: -1},
+			{Username: "frank", Enabled: true, RateLimitRPM: -1},
+		},
+	})
+	store, err := NewUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for name, want := range map[string]Plan{
+		// Every limit from the plan
+		"alice": {RateLimitRPM: 60, BandwidthLimitGB: 10, BandwidthSpeedMbps: 5, MaxConnections: 2},
+		// Per-user limits win; plan names are case-insensitive
+		"bob": {RateLimitRPM: 300, BandwidthLimitGB: 500, MaxConnections: 8},
+		// An unknown plan, or none, leaves the user's own limits
+		"carol": {RateLimitRPM: 30},
+		"dave":  {BandwidthLimitGB: 1},
+		// -1 is unlimited, overriding the plan, and is stored as 0
+		"erin":  {RateLimitRPM: 60, BandwidthSpeedMbps: 5},
+		"frank": {},
+	} {
+		u := store.GetUser(name)
+		got := Plan{u.RateLimitRPM, u.BandwidthLimitGB, u.BandwidthSpeedMbps, u.MaxConnections}
+		if got != want {
+			t.Errorf("%s: limits = %+v, want %+v", name, got, want)
+		}
+	}
+	if limit, _, _, ok := store.RateLimitState("alice"); !ok || limit != 60 {
+		t.Errorf("RateLimitState(alice) = %d, %v; want the plan's 60 RPM", limit, ok)
+	}
+}
+
+func TestLoadUsersDirectoryPlans(t *testing.T) {
+	dir := t.TempDir()
+	writeUsersFragment(t, dir, "plans.json", UsersConfig{Plans: map[string]Plan{"pro": {BandwidthLimitGB: 100}}})
+	writeUsersFragment(t, dir, "team-a.json", UsersConfig{Users: []User{{Username: "alice", Enabled: true, Plan: "pro"}}})
+
+	store, err := NewUserStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if got := store.GetUser("alice").BandwidthLimitGB; got != 100 {
+		t.Errorf("alice BandwidthLimitGB = %d, want 100 from a plan in another file", got)
+	}
+
+	writeUsersFragment(t, dir, "team-b.json", UsersConfig{Plans: map[string]Plan{"PRO": {BandwidthLimitGB: 1}}})
+	if err := store.LoadFromFile(dir); err == nil || !strings.Contains(err.Error(), "team-b.json") {
+		t.Errorf("plan defined twice: error = %v, want one naming both files", err)
+	}
+}
+
+func TestEnvUserWithoutUsersFile(t *testing.T) {
+	user, err := EnvUser("alice", "secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(t.TempDir(), "users.json")
+	store, err := NewUserStoreWithOptions(missing, UserStoreOptions{ExtraUsers: []User{user}})
+	if err != nil {
+		t.Fatalf("store with PROXY_USER and no users file: %v", err)
+	}
+	defer store.Close()
+
+	if _, ok := store.ValidateCredentials("alice", "secret"); !ok {
+		t.Error("env-defined user rejected")
+	}
+	if _, ok := store.ValidateCredentials("alice", "wrong"); ok {
+		t.Error("env-defined user accepted a wrong password")
+	}
+
+	// Without an env user a missing file is still an error
+	if _, err := NewUserStore(missing); err == nil {
+		t.Error("missing users file accepted without PROXY_USER")
+	}
+}
+
+func TestEnvUserOverridesFileUser(t *testing.T) {
+	path := writeUsersFile(t, UsersConfig{Users: []User{
+		{Username: "Alice", PasswordHash: mustHash(t, "from-file"), Enabled: true, RateLimitRPM: 1},
+		{Username: "bob", PasswordHash: mustHash(t, "b"), Enabled: true},
+	}})
+	user, err := EnvUser("alice", "", mustHash(t, "from-env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewUserStoreWithOptions(path, UserStoreOptions{ExtraUsers: []User{user}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// The override survives a reload of the file
+	if err := store.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.ValidateCredentials("alice", "from-file"); ok {
+		t.Error("file password still accepted for an env-defined user")
+	}
+	if _, ok := store.ValidateCredentials("alice", "from-env"); !ok {
+		t.Error("env password rejected")
+	}
+	if got := store.GetUser("alice").RateLimitRPM; got != 0 {
+		t.Errorf("rate_limit_rpm = %d, want the file entry replaced entirely", got)
+	}
+	if store.GetUserCount() != 2 {
+		t.Errorf("GetUserCount = %d, want 2", store.GetUserCount())
+	}
+}
+
+func TestPlaintextPasswordHash(t *testing.T) {
+	users := []User{
+		{Username: "alice", PasswordHash: "hunter2", Enabled: true},
+		{Username: "bob", PasswordHash: mustHash(t, "b"), Enabled: true},
+		{Username: "carol", PasswordHash: "", Enabled: true},
+		{Username: "dave", PasswordHash: "old-plaintext", Enabled: false},
+	}
+	if got := badPasswordHashes(users); !reflect.DeepEqual(got, []string{"alice", "carol"}) {
+		t.Errorf("badPasswordHashes = %v, want [alice carol]", got)
+	}
+
+	// By default the load goes ahead with a warning
+	path := writeUsersFile(t, UsersConfig{Users: users})
+	store, err := NewUserStore(path)
+	if err != nil {
+		t.Fatalf("load with a plaintext hash: %v", err)
+	}
+	defer store.Close()
+	if _, ok := store.ValidateCredentials("bob", "b"); !ok {
+		t.Error("valid user rejected alongside a bad hash")
+	}
+	if _, ok := store.ValidateCredentials("alice", "hunter2"); ok {
+		t.Error("plaintext password_hash accepted as a password")
+	}
+
+	// Strictly it fails, naming the user
+	_, err = NewUserStoreWithOptions(path, UserStoreOptions{StrictPasswordHashes: true})
+	if err == nil || !strings.Contains(err.Error(), "alice") {
+		t.Errorf("strict load error = %v, want one naming alice", err)
+	}
+}
+
+func TestUsernameCaseSharesRateLimit(t *testing.T) {
+	store, err := NewUserStore(writeUsersFile(t, UsersConfig{Users: []User{
+		{Username: "Alice", PasswordHash: mustHash(t, "a"), Enabled: true, RateLimitRPM: 2},
+	}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	user, ok := store.ValidateCredentials("alice", "a")
+	if !ok || user.Username != "Alice" {
+		t.Fatalf("ValidateCredentials(alice) = %v, %v; want the Alice account", user, ok)
+	}
+	// The burst is 10 requests, spent here under two spellings
+	for i := 0; i < 10; i++ {
+		name := "alice"
+		if i%2 == 1 {
+			name = "ALICE"
+		}
+		if !store.CheckRateLimit(name) {
+			t.Fatalf("request %d as %s rate limited within the burst", i+1, name)
+		}
+	}
+	if store.CheckRateLimit("Alice") {
+		t.Error("11th request allowed: spellings have separate buckets")
+	}
+	if _, remaining, _, ok := store.RateLimitState("aLiCe"); !ok || remaining != 0 {
+		t.Errorf("RateLimitState(aLiCe) remaining = %d, %v; want 0, true", remaining, ok)
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	store, err := NewUserStore(writeUsersFile(t, UsersConfig{Users: []User{
+		{Username: "Alice", PasswordHash: mustHash(t, "a"), Role: "super_admin", Enabled: true},
+		{Username: "bob", PasswordHash: mustHash(t, "b"), Enabled: false},
+	}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, name := range []string{"Alice", "alice", "ALICE"} {
+		if u := store.GetUser(name); u == nil || u.Username != "Alice" || u.Role != "super_admin" {
+			t.Errorf("GetUser(%q) = %+v, want the Alice account", name, u)
+		}
+	}
+	if u := store.GetUser("bob"); u != nil {
+		t.Errorf("GetUser(bob) = %+v for a disabled user, want nil", u)
+	}
+	if u := store.GetUser("carol"); u != nil {
+		t.Errorf("GetUser(carol) = %+v for an unknown user, want nil", u)
+	}
+}
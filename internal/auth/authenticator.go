@@ -0,0 +1,38 @@
+package auth
+
+import "time"
+
+// Authenticator is the user backend the HTTP proxy, SOCKS5 proxy and PAC
+// endpoint check clients against. *UserStore, loaded from USERS_FILE, is the
+// default; integrators can plug in their own (a database, LDAP, an external
+// HTTP service) without forking the proxies.
+//
+// Usernames are case-insensitive (see NormalizeUsername). Methods are called
+// concurrently from every connection and should answer quickly: the proxies
+// call them while the client waits.
+type Authenticator interface {
+	// ValidateCredentials returns the enabled user matching username and
+	// password, or false.
+	ValidateCredentials(username, password string) (*User, bool)
+	// GetUser returns an enabled user, or nil.
+	GetUser(username string) *User
+	// CheckIPAllowed reports whether a client IP may connect at all.
+	CheckIPAllowed(ip string) bool
+	// IsSuperAdminIP returns the super_admin a trusted IP is let in as
+	// without credentials, if any.
+	IsSuperAdminIP(ip string) (*User, bool)
+	// CheckRateLimit charges one request to username and reports whether it
+	// is within the user's limit.
+	CheckRateLimit(username string) bool
+	// RateLimitState reports the user's limit, the requests left and the
+	// wait before the next is allowed. ok is false for unlimited users.
+	RateLimitState(username string) (limit, remaining int, retryAfter time.Duration, ok bool)
+	// CheckExpiry reports whether the user's account has not expired.
+	CheckExpiry(username string) bool
+	// ExpiryTime returns when the user's account expires, if it does.
+	ExpiryTime(username string) (time.Time, bool)
+	// DaysUntilExpiry returns the whole days left before ExpiryTime.
+	DaysUntilExpiry(username string) (int, bool)
+}
+
+var _ Authenticator = (*UserStore)(nil)
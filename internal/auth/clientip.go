@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"signal-proxy/internal/ui"
+)
+
+// TrustedProxies are the reverse proxies and load balancers whose
+// X-Forwarded-For and X-Real-IP headers are believed. A nil set trusts
+// nobody, so every request is attributed to its direct peer.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses CIDRs or bare IPs as in ip_whitelist (e.g.
+// TRUSTED_PROXIES). Invalid entries are logged and dropped, which only
+// narrows what is trusted.
+func ParseTrustedProxies(cidrs []string) TrustedProxies {
+	var t TrustedProxies
+	for _, cidr := range cidrs {
+		ipNet, err := parseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			ui.LogStatus("warn", "Trusted proxy ignored: invalid CIDR '"+cidr+"'")
+			continue
+		}
+		t = append(t, ipNet)
+	}
+	return t
+}
+
+// Contains reports whether addr ("ip" or "ip:port") is a trusted proxy.
+func (t TrustedProxies) Contains(addr string) bool {
+	ip := parseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range t {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP of the client behind r, which reached us from
+// directAddr. Forwarding headers are only consulted when directAddr is
+// trusted: X-Forwarded-For is walked from the right, skipping trusted hops,
+// so a client cannot prepend its own entries; X-Real-IP is used when there
+// is no X-Forwarded-For. r may be nil for connections without headers.
+func (t TrustedProxies) ClientIP(r *http.Request, directAddr string) string {
+	peer := directAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	if r == nil || !t.Contains(peer) {
+		return peer
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := parseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				// Garbage ends the part of the chain we can vouch for
+				return peer
+			}
+			if i == 0 || !t.Contains(ip.String()) {
+				return ip.String()
+			}
+		}
+	}
+	if ip := parseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return peer
+}
+
+// trusted is the process-wide TRUSTED_PROXIES set read by RealClientIP.
+var trusted atomic.Pointer[TrustedProxies]
+
+// SetTrustedProxies installs the TRUSTED_PROXIES list for RealClientIP.
+// Called once at startup; until then nobody is trusted.
+func SetTrustedProxies(cidrs []string) {
+	t := ParseTrustedProxies(cidrs)
+	trusted.Store(&t)
+}
+
+// RealClientIP returns the client IP for a request that reached us from
+// directAddr, peeling forwarding headers only when directAddr is one of
+// TRUSTED_PROXIES. Every component identifying clients uses it, so PAC rate
+// limits, admin checks and logs agree on who the client is.
+func RealClientIP(r *http.Request, directAddr string) string {
+	var t TrustedProxies
+	if p := trusted.Load(); p != nil {
+		t = *p
+	}
+	return t.ClientIP(r, directAddr)
+}
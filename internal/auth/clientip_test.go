@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := ParseTrustedProxies([]string{"10.0.0.0/8", "[fd00::1]", "not-a-cidr"})
+	if len(trusted) != 2 {
+		t.Fatalf("parsed %d trusted proxies, want 2 (invalid entry dropped)", len(trusted))
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		xff     []string
+		realIP  string
+		want    string
+		trusted TrustedProxies
+	}{
+		{"direct client", "203.0.113.7:1234", nil, "", "203.0.113.7", trusted},
+		{"IPv6 direct client", "[2001:db8::1]:1234", nil, "", "2001:db8::1", trusted},
+		{"spoofed XFF from untrusted peer", "203.0.113.7:1234", []string{"198.51.100.1"}, "", "203.0.113.7", trusted},
+		{"spoofed X-Real-IP from untrusted peer", "203.0.113.7:1234", nil, "198.51.100.1", "203.0.113.7", trusted},
+		{"nobody trusted by default", "10.0.0.2:1234", []string{"198.51.100.1"}, "", "10.0.0.2", nil},
+		{"XFF from trusted proxy", "10.0.0.2:1234", []string{"198.51.100.1"}, "", "198.51.100.1", trusted},
+		{"IPv6 trusted proxy", "[fd00::1]:443", []string{"2001:db8::9"}, "", "2001:db8::9", trusted},
+		{"client-prepended entry ignored", "10.0.0.2:1234", []string{"1.2.3.4, 198.51.100.1"}, "", "198.51.100.1", trusted},
+		{"chain of trusted proxies", "10.0.0.2:1234", []string{"198.51.100.1, 10.0.0.3", "10.0.0.4"}, "", "198.51.100.1", trusted},
+		{"all hops trusted", "10.0.0.2:1234", []string{"10.0.0.5, 10.0.0.3"}, "", "10.0.0.5", trusted},
+		{"garbage hop", "10.0.0.2:1234", []string{"<script>"}, "", "10.0.0.2", trusted},
+		{"X-Real-IP from trusted proxy", "10.0.0.2:1234", nil, "198.51.100.1", "198.51.100.1", trusted},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		for _, v := range tt.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := tt.trusted.ClientIP(r, tt.remote); got != tt.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClientIPWithoutRequest(t *testing.T) {
+	trusted := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if got := trusted.ClientIP(nil, "10.0.0.2:1080"); got != "10.0.0.2" {
+		t.Errorf("ClientIP(nil) = %q, want the direct peer", got)
+	}
+}
+
+func TestRealClientIPMultiHop(t *testing.T) {
+	SetTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8", "2001:db8:ffff::/48"})
+	t.Cleanup(func() { SetTrustedProxies(nil) })
+
+	// client → CDN → load balancer → nginx → us; each proxy appends the
+	// address it received the request from
+	tests := []struct {
+		name   string
+		direct string
+		xff    string
+		want   string
+	}{
+		{"one trusted hop", "127.0.0.1:5000", "198.51.100.1", "198.51.100.1"},
+		{"three trusted hops", "127.0.0.1:5000", "198.51.100.1, 10.1.0.1, 10.2.0.1", "198.51.100.1"},
+		{"spoofed prefix before trusted hops", "127.0.0.1:5000", "6.6.6.6, 198.51.100.1, 10.1.0.1", "198.51.100.1"},
+		{"untrusted hop in the middle stops the walk", "127.0.0.1:5000", "198.51.100.1, 203.0.113.9, 10.1.0.1", "203.0.113.9"},
+		{"IPv6 hops", "[2001:db8:ffff::1]:443", "2001:db8::42, 2001:db8:ffff::2", "2001:db8::42"},
+		{"mixed families", "127.0.0.1:5000", "2001:db8::42, 10.1.0.1", "2001:db8::42"},
+		{"hop with a port", "127.0.0.1:5000", "198.51.100.1:4711, 10.1.0.1", "198.51.100.1"},
+		{"bracketed IPv6 hop with a port", "127.0.0.1:5000", "[2001:db8::42]:4711", "2001:db8::42"},
+		{"empty hop", "127.0.0.1:5000", "198.51.100.1, , 10.1.0.1", "127.0.0.1"},
+		{"only trusted hops", "127.0.0.1:5000", "10.1.0.1, 10.2.0.1", "10.1.0.1"},
+		{"untrusted peer keeps its own address", "203.0.113.9:5000", "198.51.100.1, 10.1.0.1", "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Forwarded-For", tt.xff)
+		if got := RealClientIP(r, tt.direct); got != tt.want {
+			t.Errorf("%s: RealClientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Repeated X-Forwarded-For headers form one chain, in order
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Add("X-Forwarded-For", "6.6.6.6, 198.51.100.1")
+	r.Header.Add("X-Forwarded-For", "10.1.0.1")
+	if got := RealClientIP(r, "127.0.0.1:5000"); got != "198.51.100.1" {
+		t.Errorf("split headers: RealClientIP = %q, want 198.51.100.1", got)
+	}
+}
+
+func TestRealClientIPTrustsNobodyByDefault(t *testing.T) {
+	SetTrustedProxies(nil)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.Header.Set("X-Real-IP", "198.51.100.1")
+	if got := RealClientIP(r, "127.0.0.1:5000"); got != "127.0.0.1" {
+		t.Errorf("RealClientIP = %q, want the direct peer", got)
+	}
+}
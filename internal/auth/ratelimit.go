@@ -5,9 +5,18 @@ import (
 	"time"
 )
 
+// rateLimiterShards is the number of independently locked bucket maps.
+// Users hash to a shard so concurrent requests rarely share a mutex.
+const rateLimiterShards = 32
+
 // RateLimiter implements a token bucket rate limiter per user
 type RateLimiter struct {
-	mu      sync.RWMutex
+	shards [rateLimiterShards]rateLimiterShard
+}
+
+// rateLimiterShard holds the buckets for a subset of users.
+type rateLimiterShard struct {
+	mu      sync.Mutex
 	buckets map[string]*tokenBucket
 	limits  map[string]int // RPM limit per user
 }
@@ -21,18 +30,31 @@ type tokenBucket struct {
 
 // NewRateLimiter creates a new rate limiter
 func NewRateLimiter() *RateLimiter {
-	return &RateLimiter{
-		buckets: make(map[string]*tokenBucket),
-		limits:  make(map[string]int),
+	r := &RateLimiter{}
+	for i := range r.shards {
+		r.shards[i].buckets = make(map[string]*tokenBucket)
+		r.shards[i].limits = make(map[string]int)
+	}
+	return r
+}
+
+// shard returns the shard owning username (FNV-1a, allocation free).
+func (r *RateLimiter) shard(username string) *rateLimiterShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(username); i++ {
+		h ^= uint32(username[i])
+		h *= 16777619
 	}
+	return &r.shards[h%rateLimiterShards]
 }
 
 // SetLimit sets the rate limit for a user in requests per minute
 func (r *RateLimiter) SetLimit(username string, rpm int) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	s := r.shard(username)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	r.limits[username] = rpm
+	s.limits[username] = rpm
 
 	// Initialize or update bucket
 	// Allow burst up to 10% of RPM or minimum 10 requests
@@ -41,7 +63,7 @@ func (r *RateLimiter) SetLimit(username string, rpm int) {
 		maxTokens = 10
 	}
 
-	r.buckets[username] = &tokenBucket{
+	s.buckets[username] = &tokenBucket{
 		tokens:     maxTokens,
 		maxTokens:  maxTokens,
 		refillRate: float64(rpm) / 60.0, // tokens per second
@@ -52,10 +74,11 @@ func (r *RateLimiter) SetLimit(username string, rpm int) {
 // Allow checks if a request is allowed for the user
 // Returns true if allowed, false if rate limited
 func (r *RateLimiter) Allow(username string) bool {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	s := r.shard(username)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	bucket, exists := r.buckets[username]
+	bucket, exists := s.buckets[username]
 	if !exists {
 		// No limit configured, allow
 		return true
@@ -81,13 +104,33 @@ func (r *RateLimiter) Allow(username string) bool {
 
 // GetRemainingTokens returns the current token count for a user (for metrics)
 func (r *RateLimiter) GetRemainingTokens(username string) float64 {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+	s := r.shard(username)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	bucket, exists := r.buckets[username]
+	bucket, exists := s.buckets[username]
 	if !exists {
 		return -1 // No limit
 	}
 
 	return bucket.tokens
 }
+
+// RetryAfter returns how long until the user's bucket holds a whole token
+// again, or 0 if it already does or no limit is set.
+func (r *RateLimiter) RetryAfter(username string) time.Duration {
+	s := r.shard(username)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, exists := s.buckets[username]
+	if !exists || bucket.refillRate <= 0 {
+		return 0
+	}
+
+	tokens := bucket.tokens + time.Since(bucket.lastRefill).Seconds()*bucket.refillRate
+	if tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tokens) / bucket.refillRate * float64(time.Second))
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBurstAndIsolation(t *testing.T) {
+	r := NewRateLimiter()
+	r.SetLimit("alice", 60) // burst floor of 10 tokens
+
+	for i := 0; i < 10; i++ {
+		if !r.Allow("alice") {
+			t.Fatalf("request %d within burst was limited", i+1)
+		}
+	}
+	if r.Allow("alice") {
+		t.Fatal("request beyond burst was allowed")
+	}
+
+	// Other users are unaffected, and users without a limit always pass
+	r.SetLimit("bob", 60)
+	if !r.Allow("bob") {
+		t.Error("bob limited by alice's usage")
+	}
+	if !r.Allow("carol") {
+		t.Error("user without a limit was limited")
+	}
+	if got := r.GetRemainingTokens("carol"); got != -1 {
+		t.Errorf("GetRemainingTokens(unlimited) = %v, want -1", got)
+	}
+	if got := r.GetRemainingTokens("bob"); got < 8.9 || got > 9.1 {
+		t.Errorf("GetRemainingTokens(bob) = %v, want ~9", got)
+	}
+}
+
+func TestRateLimiterRetryAfter(t *testing.T) {
+	r := NewRateLimiter()
+	r.SetLimit("alice", 60) // one token per second
+	if got := r.RetryAfter("alice"); got != 0 {
+		t.Errorf("RetryAfter with a full bucket = %v, want 0", got)
+	}
+	for r.Allow("alice") {
+	}
+	if got := r.RetryAfter("alice"); got <= 0 || got > time.Second {
+		t.Errorf("RetryAfter with an empty bucket = %v, want (0, 1s]", got)
+	}
+	if got := r.RetryAfter("carol"); got != 0 {
+		t.Errorf("RetryAfter(unlimited) = %v, want 0", got)
+	}
+}
+
+func TestRateLimiterConcurrentUsers(t *testing.T) {
+	r := NewRateLimiter()
+	const users = 200
+	for i := 0; i < users; i++ {
+		r.SetLimit("user"+strconv.Itoa(i), 60)
+	}
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if r.Allow(name) {
+					allowed.Add(1)
+				}
+			}
+		}("user" + strconv.Itoa(i))
+	}
+	wg.Wait()
+
+	// Each user gets exactly its 10-token burst (refill over the test is negligible)
+	if got := allowed.Load(); got < users*10 || got > users*11 {
+		t.Fatalf("allowed = %d, want about %d", got, users*10)
+	}
+}
+
+// globalLockLimiter reproduces the previous single-mutex design so the
+// benchmarks below can compare contention.
+type globalLockLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func (g *globalLockLimiter) Allow(username string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	bucket, ok := g.buckets[username]
+	if !ok {
+		return true
+	}
+	now := time.Now()
+	bucket.tokens += now.Sub(bucket.lastRefill).Seconds() * bucket.refillRate
+	if bucket.tokens > bucket.maxTokens {
+		bucket.tokens = bucket.maxTokens
+	}
+	bucket.lastRefill = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true
+	}
+	return false
+}
+
+const benchUsers = 1024
+
+func benchUsernames() []string {
+	names := make([]string, benchUsers)
+	for i := range names {
+		names[i] = "user" + strconv.Itoa(i)
+	}
+	return names
+}
+
+// BenchmarkRateLimiterGlobalLock is the pre-sharding baseline.
+func BenchmarkRateLimiterGlobalLock(b *testing.B) {
+	names := benchUsernames()
+	g := &globalLockLimiter{buckets: make(map[string]*tokenBucket)}
+	for _, n := range names {
+		g.buckets[n] = &tokenBucket{tokens: 1e12, maxTokens: 1e12, refillRate: 1, lastRefill: time.Now()}
+	}
+	var next atomic.Uint32
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(next.Add(1)) * 7919
+		for pb.Next() {
+			g.Allow(names[i%benchUsers])
+			i++
+		}
+	})
+}
+
+// BenchmarkRateLimiterSharded runs the same workload against RateLimiter.
+func BenchmarkRateLimiterSharded(b *testing.B) {
+	names := benchUsernames()
+	r := NewRateLimiter()
+	for _, n := range names {
+		r.SetLimit(n, 1<<40)
+	}
+	var next atomic.Uint32
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(next.Add(1)) * 7919
+		for pb.Next() {
+			r.Allow(names[i%benchUsers])
+			i++
+		}
+	})
+}
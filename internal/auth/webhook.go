@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"signal-proxy/internal/ui"
+)
+
+// Webhook defaults.
+const (
+	defaultWebhookTimeout  = 5 * time.Second
+	defaultWebhookCacheTTL = time.Minute
+	webhookCacheSize       = 10000
+)
+
+// WebhookOptions configures a WebhookAuthenticator.
+type WebhookOptions struct {
+	URL      string        // AUTH_WEBHOOK_URL: https, or http to a loopback address
+	Timeout  time.Duration // Per call, including reading the answer (default 5s)
+	CacheTTL time.Duration // How long an answer is reused (default 1m)
+
+	// Local supplies ip_whitelist and super_admin_ips from USERS_FILE. nil
+	// allows every IP and has no super_admin.
+	Local *UserStore
+
+	// LocalUsers are checked against Local instead of the webhook, such as
+	// the PROXY_USER account.
+	LocalUsers []string
+
+	// Client replaces the HTTP client, e.g. to trust a private CA. Timeout
+	// still applies.
+	Client *http.Client
+}
+
+// webhookRequest is the JSON body POSTed to the webhook.
+type webhookRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// webhookResponse is the webhook's JSON answer: allow, plus the user's
+// limits in the users.json field names (rate_limit_rpm, max_connections,
+// bandwidth_limit_gb, expires_at, ...). username, password_hash and enabled
+// are ignored.
+type webhookResponse struct {
+	Allow bool `json:"allow"`
+	User
+}
+
+// webhookAnswer is a cached webhook decision. user is nil for a denial.
+type webhookAnswer struct {
+	user    *User
+	expires time.Time
+}
+
+// WebhookAuthenticator is an Authenticator that asks an external HTTP
+// service (AUTH_WEBHOOK_URL) to check each username and password, so an
+// existing user database can be used without exporting hashes into
+// users.json. Answers are cached for CacheTTL. Any failure to get an answer
+// (a timeout, a non-200 status, bad JSON) denies the login.
+type WebhookAuthenticator struct {
+	url      string
+	client   *http.Client
+	cacheTTL time.Duration
+	local    *UserStore
+	localSet map[string]bool // NormalizeUsername of LocalUsers
+
+	mu          sync.RWMutex
+	answers     map[string]webhookAnswer // "username:sha256(password)"
+	users       map[string]*User         // Last allowed answer per user
+	rateLimiter *RateLimiter
+}
+
+// NewWebhookAuthenticator checks opts and returns the authenticator. The
+// webhook receives passwords, so a plain http URL is only accepted for a
+// loopback address, such as a sidecar.
+func NewWebhookAuthenticator(opts WebhookOptions) (*WebhookAuthenticator, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("AUTH_WEBHOOK_URL %q is not a valid URL", opts.URL)
+	}
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && isLoopbackHost(u.Hostname()):
+	default:
+		return nil, fmt.Errorf("AUTH_WEBHOOK_URL must use https (http is only allowed to a loopback address)")
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultWebhookTimeout
+	}
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = defaultWebhookCacheTTL
+	}
+	localSet := make(map[string]bool, len(opts.LocalUsers))
+	if opts.Local != nil {
+		for _, name := range opts.LocalUsers {
+			localSet[NormalizeUsername(name)] = true
+		}
+	}
+	client := &http.Client{}
+	if opts.Client != nil {
+		c := *opts.Client
+		client = &c
+	}
+	client.Timeout = opts.Timeout
+
+	return &WebhookAuthenticator{
+		url:         opts.URL,
+		client:      client,
+		cacheTTL:    opts.CacheTTL,
+		local:       opts.Local,
+		localSet:    localSet,
+		answers:     make(map[string]webhookAnswer),
+		users:       make(map[string]*User),
+		rateLimiter: NewRateLimiter(),
+	}, nil
+}
+
+// isLoopbackHost reports whether host is localhost or a loopback IP.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isLocal reports whether username is one of LocalUsers.
+func (a *WebhookAuthenticator) isLocal(username string) bool {
+	return a.localSet[NormalizeUsername(username)]
+}
+
+// ValidateCredentials returns the user the webhook allows, from the cache
+// when it answered within CacheTTL. LocalUsers are checked against the
+// local store and never sent to the webhook.
+func (a *WebhookAuthenticator) ValidateCredentials(username, password string) (*User, bool) {
+	if a.isLocal(username) {
+		return a.local.ValidateCredentials(username, password)
+	}
+	name := NormalizeUsername(username)
+	passHash := sha256.Sum256([]byte(password))
+	key := name + ":" + hex.EncodeToString(passHash[:])
+
+	a.mu.RLock()
+	answer, ok := a.answers[key]
+	a.mu.RUnlock()
+	if ok && time.Now().Before(answer.expires) {
+		return answer.user, answer.user != nil
+	}
+
+	user, err := a.ask(username, password)
+	if err != nil {
+		// Fail closed, and ask again next time
+		ui.LogStatus("warn", "Auth webhook failed for user "+username+": "+err.Error())
+		return nil, false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.answers) >= webhookCacheSize {
+		a.purgeExpiredLocked()
+	}
+	a.answers[key] = webhookAnswer{user: user, expires: time.Now().Add(a.cacheTTL)}
+	if user != nil {
+		// Only a changed limit resets the bucket, or every fresh answer
+		// would refill it
+		if prev := a.users[name]; user.RateLimitRPM > 0 && (prev == nil || prev.RateLimitRPM != user.RateLimitRPM) {
+			a.rateLimiter.SetLimit(name, user.RateLimitRPM)
+		}
+		a.users[name] = user
+	}
+	return user, user != nil
+}
+
+// ask POSTs the credentials to the webhook. It returns a nil user when the
+// webhook denies them.
+func (a *WebhookAuthenticator) ask(username, password string) (*User, error) {
+	body, _ := json.Marshal(webhookRequest{Username: username, Password: password})
+	resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var answer webhookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	if !answer.Allow {
+		return nil, nil
+	}
+	if answer.ExpiresAt != "" {
+		if _, err := time.Parse(time.RFC3339, answer.ExpiresAt); err != nil {
+			return nil, errors.New("invalid expires_at " + answer.ExpiresAt)
+		}
+	}
+
+	user := answer.User
+	user.Username = username
+	user.PasswordHash = ""
+	user.Enabled = true
+	if user.Role == "" {
+		user.Role = "user"
+	}
+	return &user, nil
+}
+
+// purgeExpiredLocked drops expired answers, or every answer if none had
+// expired, bounding the cache. Callers hold a.mu.
+func (a *WebhookAuthenticator) purgeExpiredLocked() {
+	now := time.Now()
+	for key, answer := range a.answers {
+		if now.After(answer.expires) {
+			delete(a.answers, key)
+		}
+	}
+	if len(a.answers) >= webhookCacheSize {
+		a.answers = make(map[string]webhookAnswer)
+	}
+}
+
+// GetUser returns the user as of the webhook's last allowing answer. Users
+// it has not allowed since startup, such as a super_admin let in by IP, are
+// looked up in the local store.
+func (a *WebhookAuthenticator) GetUser(username string) *User {
+	if a.isLocal(username) {
+		return a.local.GetUser(username)
+	}
+	a.mu.RLock()
+	user := a.users[NormalizeUsername(username)]
+	a.mu.RUnlock()
+	if user == nil && a.local != nil {
+		return a.local.GetUser(username)
+	}
+	return user
+}
+
+// CheckIPAllowed applies the local ip_whitelist, if any.
+func (a *WebhookAuthenticator) CheckIPAllowed(ip string) bool {
+	if a.local == nil {
+		return true
+	}
+	return a.local.CheckIPAllowed(ip)
+}
+
+// HasIPWhitelist reports whether the local store has an ip_whitelist.
+func (a *WebhookAuthenticator) HasIPWhitelist() bool {
+	return a.local != nil && a.local.HasIPWhitelist()
+}
+
+// IsSuperAdminIP applies the local super_admin_ips, if any.
+func (a *WebhookAuthenticator) IsSuperAdminIP(ip string) (*User, bool) {
+	if a.local == nil {
+		return nil, false
+	}
+	return a.local.IsSuperAdminIP(ip)
+}
+
+// CheckRateLimit enforces the rate_limit_rpm of the webhook's answer.
+func (a *WebhookAuthenticator) CheckRateLimit(username string) bool {
+	if a.isLocal(username) {
+		return a.local.CheckRateLimit(username)
+	}
+	user := a.GetUser(username)
+	if user == nil || user.RateLimitRPM <= 0 {
+		return true
+	}
+	return a.rateLimiter.Allow(NormalizeUsername(username))
+}
+
+// RateLimitState is UserStore.RateLimitState for the webhook's limits.
+func (a *WebhookAuthenticator) RateLimitState(username string) (limit, remaining int, retryAfter time.Duration, ok bool) {
+	if a.isLocal(username) {
+		return a.local.RateLimitState(username)
+	}
+	user := a.GetUser(username)
+	if user == nil || user.RateLimitRPM <= 0 {
+		return 0, 0, 0, false
+	}
+	name := NormalizeUsername(username)
+	tokens := a.rateLimiter.GetRemainingTokens(name)
+	if tokens < 0 {
+		return 0, 0, 0, false
+	}
+	return user.RateLimitRPM, int(tokens), a.rateLimiter.RetryAfter(name), true
+}
+
+// CheckExpiry reports whether the account has not expired per the webhook's
+// expires_at.
+func (a *WebhookAuthenticator) CheckExpiry(username string) bool {
+	expiresAt, ok := a.ExpiryTime(username)
+	return !ok || time.Now().Before(expiresAt)
+}
+
+// ExpiryTime returns the webhook's expires_at for the user, if any.
+func (a *WebhookAuthenticator) ExpiryTime(username string) (time.Time, bool) {
+	if a.isLocal(username) {
+		return a.local.ExpiryTime(username)
+	}
+	user := a.GetUser(username)
+	if user == nil || user.ExpiresAt == "" {
+		return time.Time{}, false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, user.ExpiresAt)
+	return expiresAt, err == nil
+}
+
+// DaysUntilExpiry is UserStore.DaysUntilExpiry for the webhook's expires_at.
+func (a *WebhookAuthenticator) DaysUntilExpiry(username string) (int, bool) {
+	expiresAt, ok := a.ExpiryTime(username)
+	if !ok {
+		return 0, false
+	}
+	return daysUntil(expiresAt, time.Now()), true
+}
+
+var _ Authenticator = (*WebhookAuthenticator)(nil)
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startAuthWebhook serves a stub AUTH_WEBHOOK_URL over TLS that allows
+// alice with password "secret" on a 60 RPM plan, and counts its calls.
+func startAuthWebhook(t *testing.T, status int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		var req webhookRequest
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if req.Username != "alice" || req.Password != "secret" {
+			json.NewEncoder(w).Encode(map[string]any{"allow": false})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"allow":              true,
+			"rate_limit_rpm":     60,
+			"bandwidth_limit_gb": 5,
+			"expires_at":         "2099-01-01T00:00:00Z",
+		})
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &calls
+}
+
+func newTestWebhook(t *testing.T, ts *httptest.Server, timeout time.Duration) *WebhookAuthenticator {
+	t.Helper()
+	a, err := NewWebhookAuthenticator(WebhookOptions{URL: ts.URL, Timeout: timeout, Client: ts.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestWebhookAllowAndDeny(t *testing.T) {
+	ts, calls := startAuthWebhook(t, http.StatusOK)
+	a := newTestWebhook(t, ts, 0)
+
+	user, ok := a.ValidateCredentials("alice", "secret")
+	if !ok || user.Username != "alice" || user.BandwidthLimitGB != 5 || !user.Enabled {
+		t.Fatalf("ValidateCredentials(alice) = %+v, %v; want alice on the webhook's plan", user, ok)
+	}
+	if _, ok := a.ValidateCredentials("alice", "wrong"); ok {
+		t.Error("denied password accepted")
+	}
+	if a.GetUser("ALICE") == nil || !a.CheckExpiry("alice") {
+		t.Error("allowed user unknown or expired afterwards")
+	}
+	if limit, _, _, ok := a.RateLimitState("alice"); !ok || limit != 60 {
+		t.Errorf("RateLimitState = %d, %v; want the webhook's 60 RPM", limit, ok)
+	}
+
+	// Answers, allow and deny alike, are reused within the cache TTL
+	a.ValidateCredentials("alice", "secret")
+	a.ValidateCredentials("alice", "wrong")
+	if got := calls.Load(); got != 2 {
+		t.Errorf("webhook called %d times, want 2 with caching", got)
+	}
+}
+
+func TestWebhookLocalUsers(t *testing.T) {
+	ts, calls := startAuthWebhook(t, http.StatusOK)
+	admin, err := EnvUser("Admin", "letmein", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := NewUserStoreWithOptions(filepath.Join(t.TempDir(), "users.json"), UserStoreOptions{
+		ExtraUsers:   []User{admin},
+		OptionalFile: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	a, err := NewWebhookAuthenticator(WebhookOptions{URL: ts.URL, Client: ts.Client(), Local: local, LocalUsers: []string{"Admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// PROXY_USER logs in against the local store, never the webhook
+	if _, ok := a.ValidateCredentials("admin", "letmein"); !ok {
+		t.Error("PROXY_USER rejected with the webhook enabled")
+	}
+	if _, ok := a.ValidateCredentials("admin", "wrong"); ok {
+		t.Error("PROXY_USER accepted with a wrong password")
+	}
+	if a.GetUser("admin") == nil || !a.CheckExpiry("admin") {
+		t.Error("PROXY_USER unknown or expired")
+	}
+	if got := calls.Load(); got != 0 {
+		t.Errorf("webhook called %d times for a local user, want 0", got)
+	}
+
+	// Everyone else is still asked of the webhook
+	if _, ok := a.ValidateCredentials("alice", "secret"); !ok || calls.Load() != 1 {
+		t.Errorf("webhook user: ok = %v after %d calls", ok, calls.Load())
+	}
+}
+
+func TestWebhookFailsClosed(t *testing.T) {
+	ts, calls := startAuthWebhook(t, http.StatusInternalServerError)
+	a := newTestWebhook(t, ts, 0)
+	if _, ok := a.ValidateCredentials("alice", "secret"); ok {
+		t.Error("login allowed while the webhook answers 500")
+	}
+	// Failures are not cached
+	a.ValidateCredentials("alice", "secret")
+	if got := calls.Load(); got != 2 {
+		t.Errorf("webhook called %d times, want 2", got)
+	}
+
+	release := make(chan struct{})
+	slow := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	start := time.Now()
+	if _, ok := newTestWebhook(t, slow, 100*time.Millisecond).ValidateCredentials("alice", "secret"); ok {
+		t.Error("login allowed when the webhook timed out")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("timed out after %v, want about 100ms", elapsed)
+	}
+}
+
+func TestWebhookURLMustUseTLS(t *testing.T) {
+	for url, ok := range map[string]bool{
+		"https://auth.example.com/check": true,
+		"http://127.0.0.1:9000/check":    true,
+		"http://localhost:9000/check":    true,
+		"http://auth.example.com/check":  false,
+		"auth.example.com":               false,
+	} {
+		if _, err := NewWebhookAuthenticator(WebhookOptions{URL: url}); (err == nil) != ok {
+			t.Errorf("NewWebhookAuthenticator(%q) error = %v, want ok=%v", url, err, ok)
+		}
+	}
+}
@@ -3,6 +3,11 @@ package bandwidth
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
+
+	"signal-proxy/internal/auth"
+	"signal-proxy/internal/ui"
 )
 
 // UsageEntry represents a single user's bandwidth usage for the API
@@ -13,6 +18,7 @@ type UsageEntry struct {
 	LimitGB        int     `json:"limit_gb"`
 	PercentUsed    float64 `json:"percent_used"`
 	ActiveConns    int     `json:"active_conns"`
+	ExpiresAt      string  `json:"expires_at,omitempty"`
 }
 
 // UsageResponse is the JSON response for /api/usage
@@ -21,13 +27,20 @@ type UsageResponse struct {
 	Users map[string]UsageEntry `json:"users"`
 }
 
+// ExpiryLookup returns when a user's account expires, if it does.
+type ExpiryLookup func(username string) (time.Time, bool)
+
 // UsageHandler returns an http.HandlerFunc for the /api/usage endpoint.
-// It needs a reference to the tracker and an allowed origin for CORS.
-func UsageHandler(tracker *Tracker, allowedOrigin string) http.HandlerFunc {
+// It needs a reference to the tracker and an allowed origin for CORS
+// (empty = no CORS headers). expiry may be nil; when set, entries include
+// the account expiry.
+func UsageHandler(tracker *Tracker, allowedOrigin string, expiry ExpiryLookup) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
-		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		if allowedOrigin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		}
 		w.Header().Set("Content-Type", "application/json")
 
 		if r.Method == "OPTIONS" {
@@ -44,7 +57,7 @@ func UsageHandler(tracker *Tracker, allowedOrigin string) http.HandlerFunc {
 
 		for username, usage := range allUsage {
 			totalGB := float64(usage.TotalBytes) / (1024 * 1024 * 1024)
-			resp.Users[username] = UsageEntry{
+			entry := UsageEntry{
 				BytesUp:     usage.BytesUp,
 				BytesDown:   usage.BytesDown,
 				TotalGB:     totalGB,
@@ -52,8 +65,91 @@ func UsageHandler(tracker *Tracker, allowedOrigin string) http.HandlerFunc {
 				PercentUsed: 0,
 				ActiveConns: usage.ActiveConns,
 			}
+			if expiry != nil {
+				if expiresAt, ok := expiry(username); ok {
+					entry.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+				}
+			}
+			resp.Users[username] = entry
+		}
+
+		// A Content-Length lets the Signal port's API keep the connection
+		// alive between dashboard polls
+		body, err := json.Marshal(resp)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
 		}
+		body = append(body, '\n')
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+	}
+}
 
+// ResetHandler returns an http.HandlerFunc for POST /api/usage/reset?user=X,
+// which zeroes that user's monthly counters. Callers must restrict access.
+func ResetHandler(tracker *Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		username := r.URL.Query().Get("user")
+		if username == "" {
+			http.Error(w, "Bad Request: user is required", http.StatusBadRequest)
+			return
+		}
+		if !tracker.ResetUser(username) {
+			http.Error(w, "Not Found: no usage for "+username, http.StatusNotFound)
+			return
+		}
+		ui.LogStatus("info", "Bandwidth usage reset for "+username+" by "+auth.RealClientIP(r, r.RemoteAddr))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tracker.GetUsage(username))
+	}
+}
+
+// ConnectionsResponse is the JSON response for /api/connections
+type ConnectionsResponse struct {
+	Users map[string]int `json:"users"` // username → active connections
+}
+
+// ConnectionsHandler returns an http.HandlerFunc for GET /api/connections,
+// listing users with open connections. Callers must restrict access.
+func ConnectionsHandler(tracker *Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := ConnectionsResponse{Users: make(map[string]int)}
+		for username, usage := range tracker.GetAllUsage() {
+			if usage.ActiveConns > 0 {
+				resp.Users[username] = usage.ActiveConns
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(resp)
 	}
 }
+
+// KickHandler returns an http.HandlerFunc for POST /api/connections/kick?user=X,
+// which closes every open connection of that user. Callers must restrict
+// access.
+func KickHandler(tracker *Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		username := r.URL.Query().Get("user")
+		if username == "" {
+			http.Error(w, "Bad Request: user is required", http.StatusBadRequest)
+			return
+		}
+		kicked := tracker.Kick(username)
+		ui.LogStatus("info", "Kicked "+strconv.Itoa(kicked)+" connections of "+username+" by "+auth.RealClientIP(r, r.RemoteAddr))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"user": username, "kicked": kicked})
+	}
+}
@@ -0,0 +1,97 @@
+package bandwidth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResetHandler(t *testing.T) {
+	tracker := NewTracker(filepath.Join(t.TempDir(), "bandwidth_usage.json"))
+	defer tracker.Stop()
+	tracker.RecordBytes("alice", 100, 200)
+	handler := ResetHandler(tracker)
+
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		want   int
+	}{
+		{"get not allowed", http.MethodGet, "?user=alice", http.StatusMethodNotAllowed},
+		{"missing user", http.MethodPost, "", http.StatusBadRequest},
+		{"unknown user", http.MethodPost, "?user=carol", http.StatusNotFound},
+		{"reset", http.MethodPost, "?user=alice", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(tt.method, "/api/usage/reset"+tt.query, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	if got := tracker.GetUsage("alice").TotalBytes; got != 0 {
+		t.Errorf("alice TotalBytes = %d after reset, want 0", got)
+	}
+}
+
+func TestConnectionsAndKickHandlers(t *testing.T) {
+	tracker := NewTracker(filepath.Join(t.TempDir(), "bandwidth_usage.json"))
+	defer tracker.Stop()
+	tracker.IncrementConns("alice")
+	tracker.IncrementConns("alice")
+	tracker.IncrementConns("bob")
+	tracker.RecordBytes("carol", 1, 1) // known but idle
+
+	rec := httptest.NewRecorder()
+	ConnectionsHandler(tracker)(rec, httptest.NewRequest(http.MethodGet, "/api/connections", nil))
+	var list ConnectionsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"alice": 2, "bob": 1}
+	if !reflect.DeepEqual(list.Users, want) {
+		t.Fatalf("connections = %v, want %v", list.Users, want)
+	}
+
+	ctx1, release1 := tracker.Kickable(context.Background(), "alice")
+	defer release1()
+	ctx2, release2 := tracker.Kickable(context.Background(), "Alice")
+	defer release2()
+	bobCtx, releaseBob := tracker.Kickable(context.Background(), "bob")
+	defer releaseBob()
+
+	rec = httptest.NewRecorder()
+	KickHandler(tracker)(rec, httptest.NewRequest(http.MethodPost, "/api/connections/kick?user=alice", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("kick status = %d, want 200", rec.Code)
+	}
+	var kick struct {
+		Kicked int `json:"kicked"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &kick); err != nil {
+		t.Fatal(err)
+	}
+	if kick.Kicked != 2 {
+		t.Errorf("kicked = %d, want 2", kick.Kicked)
+	}
+	if ctx1.Err() == nil || ctx2.Err() == nil {
+		t.Error("alice's connection contexts not cancelled by kick")
+	}
+	if bobCtx.Err() != nil {
+		t.Error("kicking alice cancelled bob's connection")
+	}
+
+	rec = httptest.NewRecorder()
+	KickHandler(tracker)(rec, httptest.NewRequest(http.MethodGet, "/api/connections/kick?user=alice", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET kick status = %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,61 @@
+package bandwidth
+
+import (
+	"context"
+	"sync"
+
+	"signal-proxy/internal/auth"
+)
+
+// kickRegistry holds the cancel funcs of each user's open connections.
+type kickRegistry struct {
+	mu      sync.Mutex
+	nextID  uint64
+	cancels map[string]map[uint64]context.CancelFunc
+}
+
+// Kickable returns a child of ctx that Kick(username) cancels. Call release
+// once the connection has closed.
+func (t *Tracker) Kickable(ctx context.Context, username string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	username = auth.NormalizeUsername(username)
+
+	k := &t.kicks
+	k.mu.Lock()
+	if k.cancels == nil {
+		k.cancels = make(map[string]map[uint64]context.CancelFunc)
+	}
+	if k.cancels[username] == nil {
+		k.cancels[username] = make(map[uint64]context.CancelFunc)
+	}
+	k.nextID++
+	id := k.nextID
+	k.cancels[username][id] = cancel
+	k.mu.Unlock()
+
+	return ctx, func() {
+		k.mu.Lock()
+		delete(k.cancels[username], id)
+		if len(k.cancels[username]) == 0 {
+			delete(k.cancels, username)
+		}
+		k.mu.Unlock()
+		cancel()
+	}
+}
+
+// Kick cancels every open connection of username and returns how many it
+// signalled.
+func (t *Tracker) Kick(username string) int {
+	username = auth.NormalizeUsername(username)
+	k := &t.kicks
+	k.mu.Lock()
+	cancels := k.cancels[username]
+	delete(k.cancels, username)
+	k.mu.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+	return len(cancels)
+}
@@ -0,0 +1,62 @@
+package bandwidth
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	usageBytesDesc = prometheus.NewDesc(
+		"signalproxy_user_bandwidth_bytes",
+		"Bytes transferred by user this month",
+		[]string{"user"}, nil)
+
+	usagePercentDesc = prometheus.NewDesc(
+		"signalproxy_user_bandwidth_percent",
+		"Percent of the user's monthly bandwidth cap used",
+		[]string{"user"}, nil)
+)
+
+// LimitLookup returns a user's bandwidth_limit_gb (0 = unlimited).
+type LimitLookup func(username string) int
+
+// UsageCollector exports the tracker's monthly usage as one gauge per user,
+// plus the percent of the cap used for users that have one. Values are read
+// from the tracker on each scrape, so they follow resets and the monthly
+// rollover. It adds two series per user; register it only where that
+// cardinality is acceptable (METRICS_USER_BANDWIDTH).
+type UsageCollector struct {
+	tracker *Tracker
+	limit   LimitLookup
+	label   func(username string) string
+}
+
+// NewUsageCollector returns a collector for t. limit may be nil, which
+// leaves out the percent gauge. label maps usernames to label values, such
+// as proxy.UserLabel; nil uses them as they are.
+func NewUsageCollector(t *Tracker, limit LimitLookup, label func(username string) string) *UsageCollector {
+	if label == nil {
+		label = func(username string) string { return username }
+	}
+	return &UsageCollector{tracker: t, limit: limit, label: label}
+}
+
+// Describe implements prometheus.Collector.
+func (c *UsageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- usageBytesDesc
+	ch <- usagePercentDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *UsageCollector) Collect(ch chan<- prometheus.Metric) {
+	for username, usage := range c.tracker.GetAllUsage() {
+		user := c.label(username)
+		ch <- prometheus.MustNewConstMetric(usageBytesDesc, prometheus.GaugeValue, float64(usage.TotalBytes), user)
+		if c.limit == nil {
+			continue
+		}
+		if limitGB := c.limit(username); limitGB > 0 {
+			limitBytes := float64(limitGB) * 1024 * 1024 * 1024
+			ch <- prometheus.MustNewConstMetric(usagePercentDesc, prometheus.GaugeValue, float64(usage.TotalBytes)/limitBytes*100, user)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package bandwidth
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestUsageCollector(t *testing.T) {
+	tracker := NewTracker(filepath.Join(t.TempDir(), "bandwidth_usage.json"))
+	defer tracker.Stop()
+	const gb = 1024 * 1024 * 1024
+	tracker.RecordBytes("alice", gb/4, gb/4)
+	tracker.RecordBytes("Bob", 1000, 0)
+
+	limits := map[string]int{"alice": 2}
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewUsageCollector(tracker, func(username string) int { return limits[username] }, strings.ToUpper))
+
+	gather := func() map[string]float64 {
+		t.Helper()
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]float64)
+		for _, mf := range families {
+			for _, m := range mf.GetMetric() {
+				got[mf.GetName()+"{"+m.GetLabel()[0].GetValue()+"}"] = m.GetGauge().GetValue()
+			}
+		}
+		return got
+	}
+
+	got := gather()
+	want := map[string]float64{
+		"signalproxy_user_bandwidth_bytes{ALICE}":   gb / 2,
+		"signalproxy_user_bandwidth_percent{ALICE}": 25,
+		"signalproxy_user_bandwidth_bytes{BOB}":     1000,
+	}
+	if len(got) != len(want) {
+		t.Errorf("gathered %v, want %v (no percent for unlimited bob)", got, want)
+	}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("%s = %v, want %v", name, got[name], v)
+		}
+	}
+
+	// Gauges follow the tracker between scrapes
+	tracker.RecordBytes("alice", gb/2, 0)
+	tracker.ResetUser("bob")
+	got = gather()
+	if got["signalproxy_user_bandwidth_percent{ALICE}"] != 50 || got["signalproxy_user_bandwidth_bytes{BOB}"] != 0 {
+		t.Errorf("after more traffic and a reset: %v", got)
+	}
+}
@@ -0,0 +1,75 @@
+package bandwidth
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// quotaCheckBytes is how much traffic a QuotaConn buffers before recording
+// it and re-checking the cap. It bounds how far a tunnel can overshoot.
+const quotaCheckBytes = 256 * 1024
+
+// QuotaConn wraps a client connection and records its traffic in the tracker
+// while it flows, closing the connection once the user's monthly cap is
+// crossed. Reads from the client count as upstream, writes as downstream.
+type QuotaConn struct {
+	net.Conn
+	tracker  *Tracker
+	username string
+	limitGB  int
+
+	pendingUp   atomic.Int64
+	pendingDown atomic.Int64
+	flushMu     sync.Mutex
+	exceeded    atomic.Bool
+}
+
+// NewQuotaConn wraps conn for username. limitGB 0 records usage live but
+// never cuts the connection off.
+func NewQuotaConn(conn net.Conn, t *Tracker, username string, limitGB int) *QuotaConn {
+	return &QuotaConn{Conn: conn, tracker: t, username: username, limitGB: limitGB}
+}
+
+// Read implements io.Reader, counting upstream bytes
+func (qc *QuotaConn) Read(b []byte) (int, error) {
+	n, err := qc.Conn.Read(b)
+	if n > 0 && qc.pendingUp.Add(int64(n))+qc.pendingDown.Load() >= quotaCheckBytes {
+		qc.check()
+	}
+	return n, err
+}
+
+// Write implements io.Writer, counting downstream bytes
+func (qc *QuotaConn) Write(b []byte) (int, error) {
+	n, err := qc.Conn.Write(b)
+	if n > 0 && qc.pendingDown.Add(int64(n))+qc.pendingUp.Load() >= quotaCheckBytes {
+		qc.check()
+	}
+	return n, err
+}
+
+// Flush records any traffic not yet added to the tracker. Call it once the
+// relay has finished.
+func (qc *QuotaConn) Flush() {
+	qc.flushMu.Lock()
+	defer qc.flushMu.Unlock()
+	up, down := qc.pendingUp.Swap(0), qc.pendingDown.Swap(0)
+	if up > 0 || down > 0 {
+		qc.tracker.RecordBytes(qc.username, up, down)
+	}
+}
+
+// Exceeded reports whether the connection was closed for crossing the cap.
+func (qc *QuotaConn) Exceeded() bool {
+	return qc.exceeded.Load()
+}
+
+// check records pending traffic and closes the connection if the user is
+// now over their cap.
+func (qc *QuotaConn) check() {
+	qc.Flush()
+	if !qc.tracker.CheckAllowance(qc.username, qc.limitGB) && qc.exceeded.CompareAndSwap(false, true) {
+		qc.Conn.Close()
+	}
+}
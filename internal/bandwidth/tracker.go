@@ -7,6 +7,8 @@ import (
 	"sync"
 	"time"
 
+	"signal-proxy/internal/auth"
+	"signal-proxy/internal/fsutil"
 	"signal-proxy/internal/ui"
 )
 
@@ -33,6 +35,8 @@ type Tracker struct {
 	month    string // current month "YYYY-MM"
 	filePath string
 	stopCh   chan struct{}
+	stopOnce sync.Once
+	kicks    kickRegistry
 }
 
 // NewTracker creates a bandwidth tracker that persists to the given file path.
@@ -143,9 +147,29 @@ func (t *Tracker) CheckConnLimit(username string, maxConns int) bool {
 	return u.ActiveConns < maxConns
 }
 
-// Stop stops the background persistence loop.
+// ResetUser zeroes a user's counters for the current month and persists the
+// change. It reports false if the tracker has no usage for username.
+func (t *Tracker) ResetUser(username string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u, ok := t.users[auth.NormalizeUsername(username)]
+	if !ok {
+		return false
+	}
+	u.BytesUp = 0
+	u.BytesDown = 0
+	u.TotalBytes = 0
+	u.LastResetAt = time.Now().Format(time.RFC3339)
+	t.saveToDiskLocked()
+	return true
+}
+
+// Stop stops the background persistence loop and saves usage to disk.
+// It is safe to call more than once; every call saves, so a later call
+// also persists anything recorded since the first.
 func (t *Tracker) Stop() {
-	close(t.stopCh)
+	t.stopOnce.Do(func() { close(t.stopCh) })
 	t.saveToDisk() // final save
 }
 
@@ -158,7 +182,10 @@ func (t *Tracker) GetMonth() string {
 
 // --- internal helpers ---
 
+// getOrCreate returns username's usage. Usernames are case-insensitive, so
+// usage is keyed by auth.NormalizeUsername.
 func (t *Tracker) getOrCreate(username string) *UserUsage {
+	username = auth.NormalizeUsername(username)
 	u, ok := t.users[username]
 	if !ok {
 		u = &UserUsage{
@@ -214,7 +241,8 @@ func (t *Tracker) saveToDiskLocked() {
 		ui.LogStatus("error", "Failed to marshal bandwidth usage: "+err.Error())
 		return
 	}
-	if err := os.WriteFile(t.filePath, data, 0644); err != nil {
+	// Atomic replace so a crash mid-save never truncates the usage file
+	if err := fsutil.WriteFileAtomic(t.filePath, data, 0644); err != nil {
 		ui.LogStatus("error", "Failed to save bandwidth usage: "+err.Error())
 	}
 }
@@ -235,11 +263,20 @@ func (t *Tracker) loadFromDisk() {
 	// If it's the same month, restore data; otherwise, start fresh
 	currentMonth := time.Now().Format("2006-01")
 	if file.Month == currentMonth && file.Users != nil {
-		t.users = file.Users
 		t.month = file.Month
-		// Reset active conns (they don't survive restarts)
-		for _, u := range t.users {
+		for name, u := range file.Users {
+			// Reset active conns (they don't survive restarts)
 			u.ActiveConns = 0
+			// Files written before usernames were normalized may split a
+			// user across spellings; fold them together
+			key := auth.NormalizeUsername(name)
+			if prev, ok := t.users[key]; ok {
+				prev.BytesUp += u.BytesUp
+				prev.BytesDown += u.BytesDown
+				prev.TotalBytes += u.TotalBytes
+				continue
+			}
+			t.users[key] = u
 		}
 		ui.LogStatus("info", fmt.Sprintf("Restored bandwidth usage for %d users (month: %s)", len(t.users), t.month))
 	} else {
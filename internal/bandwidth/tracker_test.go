@@ -0,0 +1,107 @@
+package bandwidth
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStopSavesUsage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bandwidth_usage.json")
+	tracker := NewTracker(path)
+	tracker.RecordBytes("alice", 100, 200)
+	tracker.Stop()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("usage file not written on Stop: %v", err)
+	}
+	var file UsageFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	if u := file.Users["alice"]; u == nil || u.TotalBytes != 300 {
+		t.Fatalf("saved usage for alice = %+v, want 300 total bytes", u)
+	}
+
+	// A second Stop must not panic and still persists late traffic
+	tracker.RecordBytes("alice", 50, 0)
+	tracker.Stop()
+
+	if got := NewTracker(path).GetUsage("alice").TotalBytes; got != 350 {
+		t.Fatalf("restored TotalBytes = %d, want 350", got)
+	}
+}
+
+func TestResetUser(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bandwidth_usage.json")
+	tracker := NewTracker(path)
+	defer tracker.Stop()
+	tracker.RecordBytes("alice", 100, 200)
+	tracker.RecordBytes("bob", 10, 20)
+	tracker.IncrementConns("alice")
+
+	if !tracker.ResetUser("alice") {
+		t.Fatal("ResetUser(alice) = false for a tracked user")
+	}
+	if tracker.ResetUser("carol") {
+		t.Error("ResetUser(carol) = true for an unknown user")
+	}
+
+	if u := tracker.GetUsage("alice"); u.TotalBytes != 0 || u.BytesUp != 0 || u.BytesDown != 0 {
+		t.Errorf("alice after reset = %+v, want zero counters", u)
+	}
+	if got := tracker.GetActiveConns("alice"); got != 1 {
+		t.Errorf("alice active conns = %d after reset, want 1", got)
+	}
+	if got := tracker.GetUsage("bob").TotalBytes; got != 30 {
+		t.Errorf("bob TotalBytes = %d, want 30 untouched", got)
+	}
+
+	// The reset is persisted immediately
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file UsageFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	if u := file.Users["alice"]; u == nil || u.TotalBytes != 0 {
+		t.Errorf("saved usage for alice = %+v, want 0 total bytes", u)
+	}
+}
+
+func TestUsernameCaseSharesUsage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bandwidth_usage.json")
+	tracker := NewTracker(path)
+	tracker.RecordBytes("Alice", 100, 0)
+	tracker.RecordBytes("alice", 0, 200)
+	tracker.IncrementConns("ALICE")
+
+	if got := tracker.GetUsage("alice").TotalBytes; got != 300 {
+		t.Errorf("TotalBytes = %d, want 300 across spellings", got)
+	}
+	if tracker.CheckConnLimit("alice", 1) {
+		t.Error("connection limit ignores a connection opened as ALICE")
+	}
+	if len(tracker.GetAllUsage()) != 1 {
+		t.Errorf("GetAllUsage = %v, want one user", tracker.GetAllUsage())
+	}
+	tracker.Stop()
+
+	// Files from before normalization are folded together on load
+	data, _ := json.Marshal(UsageFile{Month: tracker.GetMonth(), Users: map[string]*UserUsage{
+		"Bob": {BytesUp: 10, TotalBytes: 10},
+		"bob": {BytesDown: 20, TotalBytes: 20},
+	}})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewTracker(path)
+	defer restored.Stop()
+	if u := restored.GetUsage("BOB"); u.TotalBytes != 30 || u.BytesUp != 10 || u.BytesDown != 20 {
+		t.Errorf("restored Bob = %+v, want 10 up, 20 down, 30 total", u)
+	}
+}
@@ -0,0 +1,54 @@
+// Package bufpool provides pooled byte buffers for the relay copy loops,
+// avoiding a fresh 32KB allocation per direction per connection.
+package bufpool
+
+import "sync"
+
+// DefaultSize is the relay buffer size used when none is configured.
+const DefaultSize = 32 * 1024
+
+// Pool hands out fixed-size byte buffers backed by a sync.Pool.
+type Pool struct {
+	size int
+	pool sync.Pool
+}
+
+// New creates a pool of buffers of the given size (DefaultSize if <= 0).
+func New(size int) *Pool {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	p := &Pool{size: size}
+	p.pool.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+// Get returns a buffer of Size bytes. Return it with Put when done.
+func (p *Pool) Get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+// Put returns a buffer to the pool. Buffers of the wrong size are dropped.
+func (p *Pool) Put(buf *[]byte) {
+	if buf == nil || len(*buf) != p.size {
+		return
+	}
+	p.pool.Put(buf)
+}
+
+// Size returns the length of buffers handed out by the pool.
+func (p *Pool) Size() int {
+	return p.size
+}
+
+// Relay is the shared pool used by the proxy, httpproxy and socks5 relays.
+var Relay = New(DefaultSize)
+
+// SetRelaySize replaces the shared relay pool. Call once at startup,
+// before any server starts relaying.
+func SetRelaySize(size int) {
+	Relay = New(size)
+}
@@ -0,0 +1,71 @@
+// Package bytesize renders byte counts for logs and the stats API, so the
+// same count reads the same everywhere.
+package bytesize
+
+import "strconv"
+
+// Base selects the unit multiplier and labels.
+type Base int
+
+const (
+	// Binary uses powers of 1024 labelled KB, MB, GB, TB (the proxy's
+	// historical style)
+	Binary Base = iota
+	// IEC uses powers of 1024 labelled KiB, MiB, GiB, TiB
+	IEC
+	// SI uses powers of 1000 labelled kB, MB, GB, TB
+	SI
+)
+
+// Options controls how Format renders a count.
+type Options struct {
+	Base  Base
+	Space bool // "1.5 MB" rather than "1.5MB"
+}
+
+var labels = map[Base][]string{
+	Binary: {"B", "KB", "MB", "GB", "TB"},
+	IEC:    {"B", "KiB", "MiB", "GiB", "TiB"},
+	SI:     {"B", "kB", "MB", "GB", "TB"},
+}
+
+// Format renders n in the largest unit it reaches, with one decimal above
+// bytes (e.g. "512 B", "1.5 MB"). A value that would round up to the next
+// unit is shown in that unit, so 1023.96 KB prints as "1.0 MB".
+func Format(n int64, opts Options) string {
+	units, ok := labels[opts.Base]
+	if !ok {
+		units = labels[Binary]
+	}
+	step := 1024.0
+	if opts.Base == SI {
+		step = 1000
+	}
+
+	sep := ""
+	if opts.Space {
+		sep = " "
+	}
+
+	v := float64(n)
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	if v < step {
+		return strconv.FormatInt(n, 10) + sep + units[0]
+	}
+
+	i := 0
+	for i < len(units)-1 && roundTenth(v) >= step {
+		v /= step
+		i++
+	}
+	return sign + strconv.FormatFloat(v, 'f', 1, 64) + sep + units[i]
+}
+
+// roundTenth rounds v to one decimal place.
+func roundTenth(v float64) float64 {
+	f, _ := strconv.ParseFloat(strconv.FormatFloat(v, 'f', 1, 64), 64)
+	return f
+}
@@ -0,0 +1,47 @@
+package bytesize
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	const (
+		KiB = int64(1024)
+		MiB = 1024 * KiB
+		GiB = 1024 * MiB
+		TiB = 1024 * GiB
+	)
+	spaced := Options{Space: true}
+
+	for _, tc := range []struct {
+		n    int64
+		opts Options
+		want string
+	}{
+		{0, spaced, "0 B"},
+		{1023, spaced, "1023 B"},
+		{KiB, spaced, "1.0 KB"},
+		{KiB + KiB/2, spaced, "1.5 KB"},
+		{MiB - 1, spaced, "1.0 MB"}, // rounds up into the next unit
+		{MiB, spaced, "1.0 MB"},
+		{GiB - 1, spaced, "1.0 GB"},
+		{5*GiB + GiB/2, spaced, "5.5 GB"},
+		{TiB, spaced, "1.0 TB"},
+		{2048 * TiB, spaced, "2048.0 TB"}, // TB is the largest unit
+		{-1536, spaced, "-1.5 KB"},
+		{-512, spaced, "-512 B"},
+
+		{KiB + KiB/2, Options{}, "1.5KB"},
+		{512, Options{}, "512B"},
+
+		{KiB + KiB/2, Options{Base: IEC, Space: true}, "1.5 KiB"},
+		{3 * GiB, Options{Base: IEC}, "3.0GiB"},
+
+		{999, Options{Base: SI}, "999B"},
+		{1000, Options{Base: SI}, "1.0kB"},
+		{1500000, Options{Base: SI, Space: true}, "1.5 MB"},
+		{1000000000000, Options{Base: SI}, "1.0TB"},
+	} {
+		if got := Format(tc.n, tc.opts); got != tc.want {
+			t.Errorf("Format(%d, %+v) = %q, want %q", tc.n, tc.opts, got, tc.want)
+		}
+	}
+}
@@ -5,41 +5,129 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"strings"
 )
 
 // Config holds all proxy configuration values.
 type Config struct {
-	Listen        string            `json:"listen"`
-	CertFile      string            `json:"cert_file"`
-	KeyFile       string            `json:"key_file"`
-	TimeoutSec    int               `json:"timeout_sec"`
-	MaxConns      int               `json:"max_conns"`
-	MetricsListen string            `json:"metrics_listen"`
-	Hosts         map[string]string `json:"hosts"`
-	
+	Listen         string            `json:"listen"`
+	CertFile       string            `json:"cert_file"`
+	KeyFile        string            `json:"key_file"`
+	IdleTimeoutSec int               `json:"idle_timeout_sec"` // Signal relay closes after this long with no data either way
+	MaxLifetimeSec int               `json:"max_lifetime_sec"` // Signal relay closes this long after it starts, active or not (0 = no limit)
+	MaxConns       int               `json:"max_conns"`
+	MetricsListen  string            `json:"metrics_listen"`
+	Hosts          map[string]string `json:"hosts"`
+
+	// Header rewrite rules for plain HTTP proxying (HTTPS mode)
+	HeaderRules []HeaderRule `json:"header_rules"`
+
+	// TLS overrides for https:// targets of plain HTTP proxying (HTTPS mode)
+	UpstreamTLS []UpstreamTLSRule `json:"upstream_tls"`
+
 	// Environment configuration (loaded from env vars)
 	Env *EnvConfig `json:"-"`
+
+	// Path the config was loaded from
+	Path string `json:"-"`
+
+	// Deprecated: timeout_sec was always an idle timeout; it is read as
+	// idle_timeout_sec when that is not set.
+	TimeoutSec int `json:"timeout_sec"`
+}
+
+// HeaderRule adds, overrides or strips headers on proxied HTTP requests
+// whose target host matches MatchHost (a glob such as "*.example.com").
+type HeaderRule struct {
+	MatchHost       string            `json:"match_host"`
+	SetHeaders      map[string]string `json:"set_headers"`
+	RemoveHeaders   []string          `json:"remove_headers"`
+	ApplyToResponse bool              `json:"apply_to_response"` // Also rewrite the upstream response
+}
+
+// Matches reports whether the rule applies to the given hostname (no port).
+func (r HeaderRule) Matches(host string) bool {
+	if r.MatchHost == "" || r.MatchHost == "*" {
+		return true
+	}
+	ok, err := path.Match(r.MatchHost, strings.ToLower(host))
+	return err == nil && ok
+}
+
+// UpstreamTLSRule overrides the SNI server name and ALPN protocols sent to
+// https:// targets whose host matches MatchHost (a glob such as
+// "*.example.com"). Empty fields keep the defaults.
+type UpstreamTLSRule struct {
+	MatchHost  string   `json:"match_host"`
+	ServerName string   `json:"server_name"`
+	ALPN       []string `json:"alpn"`
+}
+
+// Matches reports whether the rule applies to the given hostname (no port).
+func (r UpstreamTLSRule) Matches(host string) bool {
+	return HeaderRule{MatchHost: r.MatchHost}.Matches(host)
+}
+
+// LookupHost returns the upstream for an SNI hostname. Exact host keys win;
+// otherwise wildcard keys with a single leading "*." (e.g. "*.signal.org")
+// are tried from the most specific suffix outwards. The apex domain itself
+// is not matched by its wildcard.
+func (c *Config) LookupHost(sni string) (string, bool) {
+	host := strings.ToLower(sni)
+	if host == "" {
+		return "", false
+	}
+	if target, ok := c.Hosts[host]; ok {
+		return target, true
+	}
+	for rest := host; ; {
+		i := strings.IndexByte(rest, '.')
+		if i < 0 {
+			return "", false
+		}
+		rest = rest[i+1:]
+		if rest == "" {
+			return "", false
+		}
+		if target, ok := c.Hosts["*."+rest]; ok {
+			return target, true
+		}
+	}
 }
 
 // Load reads configuration from config.json with sensible defaults.
 func Load() *Config {
+	return LoadFrom("config.json")
+}
+
+// LoadFrom reads configuration from the given JSON file with sensible
+// defaults. A missing file leaves the defaults in place.
+func LoadFrom(path string) *Config {
 	cfg := &Config{
 		Listen:        ":8443",
-		TimeoutSec:    300,
 		MaxConns:      1000,
-		MetricsListen: ":9090",
+		MetricsListen: "127.0.0.1:9090", // Loopback only; expose explicitly via METRICS_LISTEN
 		CertFile:      "certs/dev/server.crt",
 		KeyFile:       "certs/dev/server.key",
 		Hosts:         make(map[string]string),
 		Env:           LoadEnv(), // Load environment config
+		Path:          path,
 	}
 
-	if file, err := os.Open("config.json"); err == nil {
+	if file, err := os.Open(path); err == nil {
 		defer file.Close()
 		json.NewDecoder(file).Decode(cfg)
 	}
 
+	// Fall back to the old timeout_sec name, then to 5 minutes
+	if cfg.IdleTimeoutSec == 0 {
+		cfg.IdleTimeoutSec = cfg.TimeoutSec
+	}
+	if cfg.IdleTimeoutSec == 0 {
+		cfg.IdleTimeoutSec = 300
+	}
+
 	// Override with environment variables (for production deployments)
 	if certFile := os.Getenv("CERT_FILE"); certFile != "" {
 		cfg.CertFile = certFile
@@ -58,6 +146,14 @@ func Load() *Config {
 	}
 	cfg.Hosts = cleaned
 
+	// Normalize header rule host globs to lowercase
+	for i := range cfg.HeaderRules {
+		cfg.HeaderRules[i].MatchHost = strings.ToLower(strings.TrimSpace(cfg.HeaderRules[i].MatchHost))
+	}
+	for i := range cfg.UpstreamTLS {
+		cfg.UpstreamTLS[i].MatchHost = strings.ToLower(strings.TrimSpace(cfg.UpstreamTLS[i].MatchHost))
+	}
+
 	return cfg
 }
 
@@ -70,17 +166,26 @@ func (c *Config) Validate() error {
 		errs = append(errs, "listen address is required")
 	}
 
-	// Check certificate files exist
-	if _, err := os.Stat(c.CertFile); os.IsNotExist(err) {
-		errs = append(errs, fmt.Sprintf("certificate file not found: %s", c.CertFile))
-	}
-	if _, err := os.Stat(c.KeyFile); os.IsNotExist(err) {
-		errs = append(errs, fmt.Sprintf("key file not found: %s", c.KeyFile))
+	// Check certificate files exist, unless ACME provides certificates
+	if c.Env != nil && c.Env.ACMEEnabled {
+		if len(c.Env.ACMEDomains) == 0 {
+			errs = append(errs, "ACME_DOMAINS is required when ACME_ENABLED=true")
+		}
+	} else {
+		if _, err := os.Stat(c.CertFile); os.IsNotExist(err) {
+			errs = append(errs, fmt.Sprintf("certificate file not found: %s", c.CertFile))
+		}
+		if _, err := os.Stat(c.KeyFile); os.IsNotExist(err) {
+			errs = append(errs, fmt.Sprintf("key file not found: %s", c.KeyFile))
+		}
 	}
 
 	// Validate numeric values
-	if c.TimeoutSec <= 0 {
-		errs = append(errs, "timeout_sec must be positive")
+	if c.IdleTimeoutSec <= 0 {
+		errs = append(errs, "idle_timeout_sec must be positive")
+	}
+	if c.MaxLifetimeSec < 0 {
+		errs = append(errs, "max_lifetime_sec must not be negative")
 	}
 	if c.MaxConns <= 0 {
 		errs = append(errs, "max_conns must be positive")
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupHost(t *testing.T) {
+	cfg := &Config{Hosts: map[string]string{
+		"chat.signal.org":        "chat.signal.org:443",
+		"*.signal.org":           "wildcard.signal.org:443",
+		"*.cdn.signal.org":       "cdn-wildcard.signal.org:443",
+		"*.cdn-signal.org":       "cdn-signal.org:443",
+		"storage.cdn.signal.org": "storage-exact:443",
+	}}
+
+	tests := []struct {
+		sni    string
+		want   string
+		wantOK bool
+	}{
+		{"chat.signal.org", "chat.signal.org:443", true},        // exact beats wildcard
+		{"CHAT.Signal.ORG", "chat.signal.org:443", true},        // case-insensitive
+		{"storage.signal.org", "wildcard.signal.org:443", true}, // wildcard match
+		{"a.b.signal.org", "wildcard.signal.org:443", true},     // deeper subdomain
+		{"x.cdn.signal.org", "cdn-wildcard.signal.org:443", true},
+		{"storage.cdn.signal.org", "storage-exact:443", true},
+		{"media.cdn-signal.org", "cdn-signal.org:443", true},
+		{"signal.org", "", false}, // apex not covered by its wildcard
+		{"evil.example.com", "", false},
+		{"notsignal.org", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := cfg.LookupHost(tt.sni)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("LookupHost(%q) = %q, %v; want %q, %v", tt.sni, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestValidateACMEReplacesCertFiles(t *testing.T) {
+	cfg := &Config{
+		Listen:         ":443",
+		CertFile:       "/nonexistent/server.crt",
+		KeyFile:        "/nonexistent/server.key",
+		IdleTimeoutSec: 300,
+		MaxConns:       10,
+		Hosts:          map[string]string{"chat.signal.org": "chat.signal.org:443"},
+		Env:            &EnvConfig{ACMEEnabled: true, ACMEDomains: []string{"proxy.example.com"}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate with ACME = %v, want missing cert files ignored", err)
+	}
+
+	cfg.Env.ACMEDomains = nil
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate accepted ACME without domains")
+	}
+
+	cfg.Env.ACMEEnabled = false
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate accepted missing cert files without ACME")
+	}
+}
+
+func TestLoadFromIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want int
+	}{
+		{"default", `{}`, 300},
+		{"idle_timeout_sec", `{"idle_timeout_sec": 60}`, 60},
+		{"legacy timeout_sec", `{"timeout_sec": 120}`, 120},
+		{"new name wins", `{"timeout_sec": 120, "idle_timeout_sec": 60}`, 60},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(path, []byte(tt.json), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if got := LoadFrom(path).IdleTimeoutSec; got != tt.want {
+			t.Errorf("%s: IdleTimeoutSec = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,8 +1,13 @@
 package config
 
 import (
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Environment represents the application environment
@@ -15,16 +20,25 @@ const (
 	Production Environment = "production"
 )
 
+// Rate limit modes for RATE_LIMIT_MODE
+const (
+	// RateLimitPerRequest takes a token for every HTTP request and every SOCKS5 connection
+	RateLimitPerRequest = "per_request"
+	// RateLimitPerConnection takes one token per client connection in both protocols
+	RateLimitPerConnection = "per_connection"
+)
+
 // EnvConfig holds environment-specific configuration
 type EnvConfig struct {
 	// Environment name (development, production)
 	Env Environment
 
 	// Domain settings
-	Domain        string
-	APIDomain     string
-	BaseURL       string
-	AllowedOrigin string
+	Domain           string
+	APIDomain        string
+	BaseURL          string
+	AllowedOrigin    string // CORS origin for the PAC endpoint
+	APIAllowedOrigin string // CORS origin for /api/stats, /api/history and /api/usage
 
 	// Feature flags
 	Debug bool
@@ -37,18 +51,112 @@ type EnvConfig struct {
 	NgrokDomain  string
 
 	// Proxy mode configuration
-	ProxyMode       string // "signal" (default) or "https"
-	HTTPProxyPort   string // HTTP proxy port (default :8080)
-	HTTPProxyTLS    bool   // Enable TLS for HTTP proxy
-	HTTPProxyTLSPort string // HTTPS proxy port (default :8443)
-	SOCKS5Port      string // SOCKS5 proxy port (default :1080)
-	UsersFile       string // Path to users.json
+	ProxyMode             string // "signal" (default) or "https"
+	HTTPProxyPort         string // HTTP proxy listen address(es), comma-separated (default :8080)
+	HTTPProxyTLS          bool   // Enable TLS for HTTP proxy
+	HTTPProxyTLSPort      string // HTTPS proxy port (default :8443)
+	SOCKS5Port            string // SOCKS5 listen address(es), comma-separated (default :1080)
+	SOCKS5BindEnabled     bool   // Allow the SOCKS5 BIND command (active FTP, some P2P)
+	TransparentMode       bool   // Relay plain HTTP redirected by iptables to its original destination (Linux only)
+	UsersFile             string // Path to users.json
+	BandwidthUsageFile    string // Per-user usage JSON (empty = bandwidth_usage.json next to UsersFile)
+	ExpiryWarningDays     int    // Days before expiry that HTTP responses carry X-Proxy-Account-Expires (0 = off)
+	ExpiryFailClosed      bool   // Treat a malformed expires_at as expired rather than never expiring
+	StrictPasswordHashes  bool   // Refuse to load users whose password_hash is not a bcrypt hash
+	ProxyUser             string // Extra user defined in the environment, overriding the users file
+	ProxyPass             string // PROXY_USER's plaintext password (hashed at startup)
+	ProxyPassHash         string // PROXY_USER's bcrypt hash, instead of ProxyPass
+	AuthWebhookURL        string // External service that checks credentials instead of the users file (empty = off)
+	AuthWebhookTimeoutSec int    // Time the webhook has to answer (default 5)
+	AuthWebhookCacheSec   int    // How long a webhook answer is reused (default 60)
+	RateLimitMode         string // What rate_limit_rpm counts: RateLimitPerRequest (default) or RateLimitPerConnection
 
 	// PAC (Proxy Auto-Config) configuration
-	PACEnabled      bool   // Enable PAC endpoint (/proxy.pac)
-	PACToken        string // Optional secret token for PAC access control
-	PACDefaultUser  string // Default username if no user param provided
-	PACRateLimitRPM int    // Rate limit for PAC endpoint (requests per minute)
+	PACEnabled      bool     // Enable PAC endpoint (/proxy.pac)
+	PACToken        string   // Optional secret token for PAC access control
+	PACDefaultUser  string   // Default username if no user param provided
+	PACRateLimitRPM int      // Rate limit for PAC endpoint (requests per minute)
+	PACDirectHosts  []string // Extra hosts/CIDRs the PAC file sends DIRECT
+	TrustedProxies  []string // CIDRs whose X-Forwarded-For/X-Real-IP are believed (empty = none)
+	PACSigningKey   string   // HMAC key for signed, expiring PAC links (empty = disabled)
+
+	// Metrics access
+	MetricsToken          string // Bearer token required on /metrics (empty = no auth)
+	APIAuthToken          string // Token required on the Signal port's /api/* endpoints (empty = public)
+	APIMaxBodyKB          int    // Largest request body the Signal port's API accepts, in KB (default 64)
+	MetricsAnonymizeUsers bool   // Replace usernames in metric labels with a salted hash
+	MetricsUserSalt       string // Pins the hash salt across restarts (empty = random per process)
+	MetricsUserBandwidth  bool   // Export per-user bandwidth usage gauges (two series per user)
+
+	// Shutdown configuration
+	DrainTimeoutSec int // Grace period for active connections on shutdown (default 30)
+
+	// Startup: binding while a replaced instance still holds the port
+	ListenRetry        int // Extra bind attempts while a listen address is in use (default 0)
+	ListenRetryDelayMs int // Wait before the first bind retry in ms, doubled after each (default 500)
+
+	// TLS listener hardening
+	TLSMinVersion      string   // "1.2" (default) or "1.3"
+	TLSCipherSuites    []string // TLS 1.2 cipher suite names, empty = Go defaults
+	CertExpiryWarnDays int      // Warn when the Signal mode certificate expires within this many days (default 14)
+
+	// ACME certificate provisioning (replaces CertFile/KeyFile when enabled)
+	ACMEEnabled      bool     // Obtain certificates automatically
+	ACMEDomains      []string // Domains certificates may be issued for
+	ACMECacheDir     string   // Where issued certificates and the account key are stored
+	ACMEEmail        string   // Contact address registered with the CA (optional)
+	ACMEDirectoryURL string   // ACME directory, empty = Let's Encrypt production
+
+	// Upstream dialing
+	DialTimeoutSec      int  // Upstream dial timeout, 0 = per-mode default (30s HTTP/SOCKS5, 10s Signal)
+	TCPKeepAliveSec     int  // TCP keep-alive period for client and upstream connections (default 30)
+	DisableTCPNoDelay   bool // TCP_NODELAY=false: leave Nagle on for client and upstream connections
+	SocketSndBuf        int  // SO_SNDBUF in bytes on client and upstream connections (0 = OS default)
+	SocketRcvBuf        int  // SO_RCVBUF in bytes on client and upstream connections (0 = OS default)
+	DialRetries         int  // Extra attempts after a refused or timed-out dial (HTTP and SOCKS5, default 0)
+	DialRetryBackoffMs  int  // Wait before the first retry in ms, doubled after each (default 100)
+	HandshakeTimeoutSec int  // Time a client has for the SOCKS5 handshake or HTTP request headers (default 30)
+
+	// Plain HTTP upstream connection pool
+	HTTPPoolMaxIdlePerHost int // Idle keep-alive connections kept per upstream host (default 10)
+	HTTPPoolIdleTimeoutSec int // Seconds an idle pooled connection is kept open (default 90)
+	HTTPRequestTimeoutSec  int // Limit on a whole plain HTTP request, body included (0 = none, default); CONNECT is unbounded
+
+	// Egress restrictions
+	ConnectAllowedPorts []int // Ports CONNECT may target, empty = allow all
+	SOCKS5RestrictPorts bool  // Apply ConnectAllowedPorts to SOCKS5 CONNECT too
+
+	// Stats history persistence
+	StatsHistoryFile       string // JSON file for the 24h chart history (empty = in-memory only)
+	StatsSampleIntervalSec int    // Seconds between history samples (default 3600)
+	StatsRetentionHours    int    // Hours of history kept (default 24)
+	DashboardEnabled       bool   // Serve the embedded dashboard at / in Signal mode
+
+	// Relay tuning
+	RelayBufferSize int // Bytes per pooled relay buffer (default 32KB)
+
+	// Signal mode upstream for SNIs missing from the hosts map ("" = reject them)
+	DefaultUpstream string
+
+	// Signal mode per-SNI usage tracking
+	SNIUsageEnabled bool // Record relayed bytes per SNI hostname (/api/usage)
+	SNIUsageLimitGB int  // Monthly cap per SNI hostname in GB, 0 = unlimited
+
+	// Access log written by every proxy
+	AccessLog       string // File to append one record per connection/request to ("" = off)
+	AccessLogFormat string // "combined" (default) or "json"
+	AccessLogMaxMB  int    // Rotate the access log past this size (default 100)
+
+	// Forwarding headers on plain HTTP requests: "none" (default), "standard" or "strip"
+	HTTPForwardHeaders string
+
+	// Startup banner
+	BannerEnabled bool   // Print the startup banner (default true)
+	Tagline       string // Fixed banner tagline (empty = random pick)
+
+	// Unknown values LoadEnv replaced with a default, and values it could
+	// not parse at all, reported by Validate
+	fallbacks []EnvIssue
 }
 
 // LoadEnv loads environment configuration from environment variables
@@ -73,6 +181,9 @@ func LoadEnv() *EnvConfig {
 			cfg.LogLevel = "info"
 		}
 	default: // Development
+		if cfg.Env != Development {
+			cfg.fallback("APP_ENV", env, string(Development))
+		}
 		cfg.Env = Development // Normalize unknown envs to development
 
 		// Ngrok configuration (development only)
@@ -87,27 +198,162 @@ func LoadEnv() *EnvConfig {
 		}
 
 		cfg.BaseURL = getEnvOrDefault("BASE_URL", "https://"+cfg.Domain)
-		cfg.APIDomain = cfg.Domain
-		cfg.AllowedOrigin = "*"
+		cfg.APIDomain = getEnvOrDefault("API_DOMAIN", cfg.Domain)
+		cfg.AllowedOrigin = getEnvOrDefault("ALLOWED_ORIGIN", "*")
 		cfg.Debug = getEnvOrDefault("DEBUG", "true") == "true"
 		if cfg.LogLevel == "info" {
 			cfg.LogLevel = "debug" // Dev default
 		}
 	}
 
+	// CORS origin for the stats API, defaulting to the API domain itself
+	cfg.APIAllowedOrigin = getEnvOrDefault("API_ALLOWED_ORIGIN", "https://"+cfg.APIDomain)
+
 	// Load proxy mode configuration (applies to both dev and prod)
 	cfg.ProxyMode = strings.ToLower(getEnvOrDefault("PROXY_MODE", "signal"))
 	cfg.HTTPProxyPort = getEnvOrDefault("HTTP_PROXY_PORT", ":8080")
 	cfg.HTTPProxyTLS = getEnvOrDefault("HTTP_PROXY_TLS", "true") == "true"
 	cfg.HTTPProxyTLSPort = getEnvOrDefault("HTTP_PROXY_TLS_PORT", ":8443")
 	cfg.SOCKS5Port = getEnvOrDefault("SOCKS5_PORT", ":1080")
+	cfg.SOCKS5BindEnabled = getEnvOrDefault("SOCKS5_BIND_ENABLED", "false") == "true"
+	cfg.TransparentMode = getEnvOrDefault("TRANSPARENT_MODE", "false") == "true"
 	cfg.UsersFile = getEnvOrDefault("USERS_FILE", "users.json")
+	cfg.BandwidthUsageFile = getEnvOrDefault("BANDWIDTH_USAGE_FILE", "")
+	cfg.ExpiryWarningDays = parseIntOrDefault(getEnvOrDefault("EXPIRY_WARNING_DAYS", "7"), 7)
+	cfg.ExpiryFailClosed = getEnvOrDefault("EXPIRY_FAIL_CLOSED", "false") == "true"
+	cfg.StrictPasswordHashes = getEnvOrDefault("STRICT_PASSWORD_HASHES", "false") == "true"
+	cfg.ProxyUser = getEnvOrDefault("PROXY_USER", "")
+	cfg.ProxyPass = getEnvOrDefault("PROXY_PASS", "")
+	cfg.ProxyPassHash = getEnvOrDefault("PROXY_PASS_HASH", "")
+	cfg.AuthWebhookURL = getEnvOrDefault("AUTH_WEBHOOK_URL", "")
+	cfg.AuthWebhookTimeoutSec = parseIntOrDefault(getEnvOrDefault("AUTH_WEBHOOK_TIMEOUT_SEC", "5"), 5)
+	cfg.AuthWebhookCacheSec = parseIntOrDefault(getEnvOrDefault("AUTH_WEBHOOK_CACHE_SEC", "60"), 60)
+
+	// Load rate limit mode, falling back to per_request for unknown values
+	cfg.RateLimitMode = strings.ToLower(getEnvOrDefault("RATE_LIMIT_MODE", RateLimitPerRequest))
+	if cfg.RateLimitMode != RateLimitPerConnection {
+		if cfg.RateLimitMode != RateLimitPerRequest {
+			cfg.fallback("RATE_LIMIT_MODE", cfg.RateLimitMode, RateLimitPerRequest)
+		}
+		cfg.RateLimitMode = RateLimitPerRequest
+	}
 
 	// Load PAC configuration
 	cfg.PACEnabled = getEnvOrDefault("PAC_ENABLED", "true") == "true"
 	cfg.PACToken = getEnvOrDefault("PAC_TOKEN", "") // Empty = no token required
 	cfg.PACDefaultUser = getEnvOrDefault("PAC_DEFAULT_USER", "")
 	cfg.PACRateLimitRPM = parseIntOrDefault(getEnvOrDefault("PAC_RATE_LIMIT_RPM", "60"), 60)
+	for _, entry := range parseList(getEnvOrDefault("PAC_DIRECT_HOSTS", "")) {
+		if err := ValidateDirectHost(entry); err != nil {
+			cfg.fallbacks = append(cfg.fallbacks, EnvIssue{
+				Var:     "PAC_DIRECT_HOSTS",
+				Value:   entry,
+				Message: err.Error() + ", ignored",
+			})
+			continue
+		}
+		cfg.PACDirectHosts = append(cfg.PACDirectHosts, strings.ToLower(entry))
+	}
+	cfg.TrustedProxies = parseList(getEnvOrDefault("TRUSTED_PROXIES", ""))
+	cfg.PACSigningKey = getEnvOrDefault("PAC_SIGNING_KEY", "")
+
+	// Load metrics access
+	cfg.MetricsToken = getEnvOrDefault("METRICS_TOKEN", "")
+	cfg.APIAuthToken = getEnvOrDefault("API_AUTH_TOKEN", "")
+	cfg.APIMaxBodyKB = parseIntOrDefault(getEnvOrDefault("API_MAX_BODY_KB", "64"), 64)
+	cfg.MetricsAnonymizeUsers = getEnvOrDefault("METRICS_ANONYMIZE_USERS", "false") == "true"
+	cfg.MetricsUserSalt = getEnvOrDefault("METRICS_USER_SALT", "")
+	cfg.MetricsUserBandwidth = getEnvOrDefault("METRICS_USER_BANDWIDTH", "false") == "true"
+
+	// Load shutdown configuration
+	cfg.DrainTimeoutSec = parseIntOrDefault(getEnvOrDefault("DRAIN_TIMEOUT_SEC", "30"), 30)
+	cfg.ListenRetry = parseIntOrDefault(getEnvOrDefault("LISTEN_RETRY", "0"), 0)
+	cfg.ListenRetryDelayMs = parseIntOrDefault(getEnvOrDefault("LISTEN_RETRY_DELAY_MS", "500"), 500)
+
+	// Load TLS listener hardening (validated when the listeners start)
+	cfg.TLSMinVersion = getEnvOrDefault("TLS_MIN_VERSION", "1.2")
+	cfg.TLSCipherSuites = parseList(getEnvOrDefault("TLS_CIPHER_SUITES", ""))
+	cfg.CertExpiryWarnDays = parseIntOrDefault(getEnvOrDefault("CERT_EXPIRY_WARN_DAYS", "14"), 14)
+
+	// Load ACME certificate provisioning
+	cfg.ACMEEnabled = getEnvOrDefault("ACME_ENABLED", "false") == "true"
+	cfg.ACMEDomains = parseList(getEnvOrDefault("ACME_DOMAINS", ""))
+	cfg.ACMECacheDir = getEnvOrDefault("ACME_CACHE_DIR", "certs/acme")
+	cfg.ACMEEmail = getEnvOrDefault("ACME_EMAIL", "")
+	cfg.ACMEDirectoryURL = getEnvOrDefault("ACME_DIRECTORY_URL", "")
+
+	// Load upstream dialing
+	cfg.DialTimeoutSec = parseIntOrDefault(getEnvOrDefault("DIAL_TIMEOUT_SEC", "0"), 0)
+	cfg.TCPKeepAliveSec = parseIntOrDefault(getEnvOrDefault("TCP_KEEPALIVE_SEC", "30"), 30)
+	cfg.DisableTCPNoDelay = getEnvOrDefault("TCP_NODELAY", "true") != "true"
+	cfg.SocketSndBuf = parseIntOrDefault(getEnvOrDefault("SOCKET_SNDBUF", "0"), 0)
+	cfg.SocketRcvBuf = parseIntOrDefault(getEnvOrDefault("SOCKET_RCVBUF", "0"), 0)
+	cfg.DialRetries = parseIntOrDefault(getEnvOrDefault("DIAL_RETRIES", "0"), 0)
+	cfg.DialRetryBackoffMs = parseIntOrDefault(getEnvOrDefault("DIAL_RETRY_BACKOFF_MS", "100"), 100)
+	cfg.HandshakeTimeoutSec = parseIntOrDefault(getEnvOrDefault("HANDSHAKE_TIMEOUT_SEC", "30"), 30)
+
+	// Load plain HTTP upstream pool tuning
+	cfg.HTTPPoolMaxIdlePerHost = parseIntOrDefault(getEnvOrDefault("HTTP_POOL_MAX_IDLE_PER_HOST", "10"), 10)
+	cfg.HTTPPoolIdleTimeoutSec = parseIntOrDefault(getEnvOrDefault("HTTP_POOL_IDLE_TIMEOUT_SEC", "90"), 90)
+	cfg.HTTPRequestTimeoutSec = parseIntOrDefault(getEnvOrDefault("HTTP_REQUEST_TIMEOUT_SEC", "0"), 0)
+
+	// Load egress restrictions
+	allowedPorts := getEnvOrDefault("CONNECT_ALLOWED_PORTS", "")
+	if ports, err := parsePortList(allowedPorts); err != nil {
+		// An empty list allows every port, so a typo must not fail open
+		cfg.fallbacks = append(cfg.fallbacks, EnvIssue{
+			Var:     "CONNECT_ALLOWED_PORTS",
+			Value:   allowedPorts,
+			Message: err.Error(),
+			Fatal:   true,
+		})
+	} else {
+		cfg.ConnectAllowedPorts = ports
+	}
+	cfg.SOCKS5RestrictPorts = getEnvOrDefault("SOCKS5_RESTRICT_PORTS", "false") == "true"
+
+	// Load stats history persistence
+	cfg.StatsHistoryFile = getEnvOrDefault("STATS_HISTORY_FILE", "stats_history.json")
+	cfg.StatsSampleIntervalSec = parseIntOrDefault(getEnvOrDefault("STATS_SAMPLE_INTERVAL_SEC", "3600"), 3600)
+	cfg.StatsRetentionHours = parseIntOrDefault(getEnvOrDefault("STATS_RETENTION_HOURS", "24"), 24)
+	cfg.DashboardEnabled = getEnvOrDefault("DASHBOARD_ENABLED", "false") == "true"
+
+	// Load relay tuning
+	cfg.RelayBufferSize = parseIntOrDefault(getEnvOrDefault("RELAY_BUFFER_SIZE", "32768"), 32768)
+	if cfg.RelayBufferSize < 1024 {
+		cfg.RelayBufferSize = 32768
+	}
+
+	// Load Signal mode fallback upstream (validated by Validate)
+	cfg.DefaultUpstream = getEnvOrDefault("DEFAULT_UPSTREAM", "")
+
+	// Load Signal mode per-SNI usage tracking
+	cfg.SNIUsageEnabled = getEnvOrDefault("SNI_USAGE_ENABLED", "false") == "true"
+	cfg.SNIUsageLimitGB = parseIntOrDefault(getEnvOrDefault("SNI_USAGE_LIMIT_GB", "0"), 0)
+
+	// Load access log settings, falling back to "combined" for unknown formats
+	cfg.AccessLog = getEnvOrDefault("ACCESS_LOG", "")
+	cfg.AccessLogFormat = strings.ToLower(getEnvOrDefault("ACCESS_LOG_FORMAT", "combined"))
+	switch cfg.AccessLogFormat {
+	case "combined", "json":
+	default:
+		cfg.fallback("ACCESS_LOG_FORMAT", cfg.AccessLogFormat, "combined")
+		cfg.AccessLogFormat = "combined"
+	}
+	cfg.AccessLogMaxMB = parseIntOrDefault(getEnvOrDefault("ACCESS_LOG_MAX_MB", "100"), 100)
+
+	// Load forwarding header mode, falling back to "none" for unknown values
+	cfg.HTTPForwardHeaders = strings.ToLower(getEnvOrDefault("HTTP_FORWARD_HEADERS", "none"))
+	switch cfg.HTTPForwardHeaders {
+	case "none", "standard", "strip":
+	default:
+		cfg.fallback("HTTP_FORWARD_HEADERS", cfg.HTTPForwardHeaders, "none")
+		cfg.HTTPForwardHeaders = "none"
+	}
+
+	// Load startup banner settings
+	cfg.BannerEnabled = getEnvOrDefault("BANNER_ENABLED", "true") == "true"
+	cfg.Tagline = getEnvOrDefault("TAGLINE", "")
 
 	return cfg
 }
@@ -122,6 +368,202 @@ func (e *EnvConfig) IsProduction() bool {
 	return e.Env == Production
 }
 
+// IsSignalMode returns true unless PROXY_MODE selects the HTTP/SOCKS5 proxy
+func (e *EnvConfig) IsSignalMode() bool {
+	switch e.ProxyMode {
+	case "https", "http", "general":
+		return false
+	}
+	return true
+}
+
+// DrainTimeout returns how long active connections may run after a shutdown
+// signal before they are forcibly closed. Falls back to 30s when unset.
+func (e *EnvConfig) DrainTimeout() time.Duration {
+	if e == nil || e.DrainTimeoutSec <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(e.DrainTimeoutSec) * time.Second
+}
+
+// AuthWebhookTimeout returns how long AUTH_WEBHOOK_URL has to answer. Falls
+// back to 5s when unset.
+func (e *EnvConfig) AuthWebhookTimeout() time.Duration {
+	if e == nil || e.AuthWebhookTimeoutSec <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(e.AuthWebhookTimeoutSec) * time.Second
+}
+
+// AuthWebhookCacheTTL returns how long a webhook answer is reused. Falls
+// back to 60s when unset.
+func (e *EnvConfig) AuthWebhookCacheTTL() time.Duration {
+	if e == nil || e.AuthWebhookCacheSec <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(e.AuthWebhookCacheSec) * time.Second
+}
+
+// ListenRetryDelay returns the wait before the first bind retry. Falls back
+// to 500ms when unset.
+func (e *EnvConfig) ListenRetryDelay() time.Duration {
+	if e == nil || e.ListenRetryDelayMs <= 0 {
+		return 500 * time.Millisecond
+	}
+	return time.Duration(e.ListenRetryDelayMs) * time.Millisecond
+}
+
+// DialTimeout returns the configured upstream dial timeout, or modeDefault
+// when DIAL_TIMEOUT_SEC is unset.
+func (e *EnvConfig) DialTimeout(modeDefault time.Duration) time.Duration {
+	if e == nil || e.DialTimeoutSec <= 0 {
+		return modeDefault
+	}
+	return time.Duration(e.DialTimeoutSec) * time.Second
+}
+
+// TCPKeepAlive returns the TCP keep-alive period. Falls back to 30s when unset.
+func (e *EnvConfig) TCPKeepAlive() time.Duration {
+	if e == nil || e.TCPKeepAliveSec <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(e.TCPKeepAliveSec) * time.Second
+}
+
+// CertExpiryWarning returns how close to expiry a certificate must be before
+// it is logged. Falls back to 14 days when unset.
+func (e *EnvConfig) CertExpiryWarning() time.Duration {
+	if e == nil || e.CertExpiryWarnDays <= 0 {
+		return 14 * 24 * time.Hour
+	}
+	return time.Duration(e.CertExpiryWarnDays) * 24 * time.Hour
+}
+
+// DialRetryBackoff returns the wait before the first dial retry. Falls back
+// to 100ms when unset.
+func (e *EnvConfig) DialRetryBackoff() time.Duration {
+	if e == nil || e.DialRetryBackoffMs <= 0 {
+		return 100 * time.Millisecond
+	}
+	return time.Duration(e.DialRetryBackoffMs) * time.Millisecond
+}
+
+// HandshakeTimeout returns how long a client may take to complete the
+// SOCKS5 negotiation or send an HTTP request line and headers. Slower
+// clients are dropped so trickled bytes cannot pin connections open. Falls
+// back to 30s when unset.
+func (e *EnvConfig) HandshakeTimeout() time.Duration {
+	if e == nil || e.HandshakeTimeoutSec <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(e.HandshakeTimeoutSec) * time.Second
+}
+
+// AccessLogMaxBytes returns the size past which the access log is rotated.
+// Falls back to 100MB when unset.
+func (e *EnvConfig) AccessLogMaxBytes() int64 {
+	if e == nil || e.AccessLogMaxMB <= 0 {
+		return 100 << 20
+	}
+	return int64(e.AccessLogMaxMB) << 20
+}
+
+// APIMaxBodyBytes returns the largest request body the Signal port's
+// internal API reads. Falls back to 64KB when unset.
+func (e *EnvConfig) APIMaxBodyBytes() int64 {
+	if e == nil || e.APIMaxBodyKB <= 0 {
+		return 64 << 10
+	}
+	return int64(e.APIMaxBodyKB) << 10
+}
+
+// HTTPProxyAddrs returns the HTTP proxy listen addresses from the
+// comma-separated HTTP_PROXY_PORT. Falls back to :8080 when unset.
+func (e *EnvConfig) HTTPProxyAddrs() []string {
+	if addrs := parseList(e.HTTPProxyPort); len(addrs) > 0 {
+		return addrs
+	}
+	return []string{":8080"}
+}
+
+// SOCKS5Addrs returns the SOCKS5 listen addresses from the comma-separated
+// SOCKS5_PORT. Falls back to :1080 when unset.
+func (e *EnvConfig) SOCKS5Addrs() []string {
+	if addrs := parseList(e.SOCKS5Port); len(addrs) > 0 {
+		return addrs
+	}
+	return []string{":1080"}
+}
+
+// MaxIdleConnsPerHost returns how many idle upstream connections the plain
+// HTTP transport keeps per host. Falls back to 10 when unset.
+func (e *EnvConfig) MaxIdleConnsPerHost() int {
+	if e == nil || e.HTTPPoolMaxIdlePerHost <= 0 {
+		return 10
+	}
+	return e.HTTPPoolMaxIdlePerHost
+}
+
+// HTTPPoolIdleTimeout returns how long an idle pooled upstream connection is
+// kept open. Falls back to 90s when unset.
+func (e *EnvConfig) HTTPPoolIdleTimeout() time.Duration {
+	if e == nil || e.HTTPPoolIdleTimeoutSec <= 0 {
+		return 90 * time.Second
+	}
+	return time.Duration(e.HTTPPoolIdleTimeoutSec) * time.Second
+}
+
+// HTTPRequestTimeout returns how long a plain HTTP request may take from
+// forwarding to the last body byte, or 0 for no limit (the default).
+func (e *EnvConfig) HTTPRequestTimeout() time.Duration {
+	if e == nil || e.HTTPRequestTimeoutSec <= 0 {
+		return 0
+	}
+	return time.Duration(e.HTTPRequestTimeoutSec) * time.Second
+}
+
+// APIOrigin returns the validated API_ALLOWED_ORIGIN: "*" or an origin of
+// the form scheme://host[:port] with an http or https scheme.
+func (e *EnvConfig) APIOrigin() (string, error) {
+	origin := e.APIAllowedOrigin
+	if origin == "*" {
+		return origin, nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid API_ALLOWED_ORIGIN %q (want * or scheme://host[:port])", origin)
+	}
+	return origin, nil
+}
+
+// IsPortAllowed reports whether CONNECT may target the given port.
+// An empty ConnectAllowedPorts list allows every port.
+func (e *EnvConfig) IsPortAllowed(port int) bool {
+	if len(e.ConnectAllowedPorts) == 0 {
+		return true
+	}
+	for _, p := range e.ConnectAllowedPorts {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsConnectTarget reports whether the port of a host:port CONNECT
+// target is permitted by the allow-list.
+func (e *EnvConfig) AllowsConnectTarget(hostPort string) bool {
+	if len(e.ConnectAllowedPorts) == 0 {
+		return true
+	}
+	_, portStr, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return false
+	}
+	return e.IsPortAllowed(parseIntOrDefault(portStr, 0))
+}
+
 // String returns the environment name
 func (e Environment) String() string {
 	return string(e)
@@ -151,3 +593,59 @@ func parseIntOrDefault(s string, defaultValue int) int {
 	}
 	return result
 }
+
+// parseList splits a comma-separated list, trimming blanks
+func parseList(s string) []string {
+	var items []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			items = append(items, part)
+		}
+	}
+	return items
+}
+
+// parsePortList parses a comma-separated list of ports (e.g. "443,80,5223"),
+// skipping blank entries. Anything that is not a port from 1 to 65535 is an
+// error.
+func parsePortList(s string) ([]int, error) {
+	var ports []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		port, err := strconv.Atoi(part)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("%q is not a port (1-65535)", part)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// ValidateDirectHost checks a PAC_DIRECT_HOSTS entry is an IPv4 CIDR or a
+// host glob made of letters, digits, '-', '.', '*' and '?'. Anything else
+// could break out of the generated PAC JavaScript string.
+func ValidateDirectHost(entry string) error {
+	entry = strings.TrimSpace(entry)
+	if entry == "" {
+		return fmt.Errorf("empty entry")
+	}
+	if strings.Contains(entry, "/") {
+		ip, _, err := net.ParseCIDR(entry)
+		if err != nil || ip.To4() == nil {
+			return fmt.Errorf("not a valid IPv4 CIDR")
+		}
+		return nil
+	}
+	for _, c := range entry {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '.', c == '*', c == '?':
+		default:
+			return fmt.Errorf("contains invalid character %q", c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePortList(t *testing.T) {
+	got, err := parsePortList(" 443, 80,,5223 ")
+	want := []int{443, 80, 5223}
+	if err != nil || len(got) != len(want) {
+		t.Fatalf("parsePortList = %v, %v; want %v", got, err, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("parsePortList = %v, want %v", got, want)
+		}
+	}
+
+	for _, bad := range []string{"abc", "443;8443", "443,70000", "0"} {
+		if ports, err := parsePortList(bad); err == nil {
+			t.Errorf("parsePortList(%q) = %v, want an error", bad, ports)
+		}
+	}
+}
+
+func TestInvalidConnectAllowedPortsIsFatal(t *testing.T) {
+	t.Setenv("CONNECT_ALLOWED_PORTS", "443;8443")
+	env := LoadEnv()
+	if len(env.ConnectAllowedPorts) != 0 {
+		t.Errorf("ConnectAllowedPorts = %v", env.ConnectAllowedPorts)
+	}
+	if is, ok := issueFor(env.Validate(), "CONNECT_ALLOWED_PORTS"); !ok || !is.Fatal {
+		t.Fatalf("Validate: CONNECT_ALLOWED_PORTS issue = %+v, %v; want fatal", is, ok)
+	}
+}
+
+func TestValidateDirectHost(t *testing.T) {
+	valid := []string{"*.corp.local", "host-1.example.com", "10.0.0.0/8", "192.168.1.?"}
+	invalid := []string{"", "bad host", `a"b`, "10.0.0.0/33", "fd00::/8", "a\\b"}
+
+	for _, entry := range valid {
+		if err := ValidateDirectHost(entry); err != nil {
+			t.Errorf("ValidateDirectHost(%q) = %v, want nil", entry, err)
+		}
+	}
+	for _, entry := range invalid {
+		if err := ValidateDirectHost(entry); err == nil {
+			t.Errorf("ValidateDirectHost(%q) = nil, want error", entry)
+		}
+	}
+}
+
+func TestInvalidPACDirectHostsAreReported(t *testing.T) {
+	t.Setenv("PAC_DIRECT_HOSTS", `*.Corp.local, evil"host, 10.20.0.0/16`)
+	env := LoadEnv()
+	want := []string{"*.corp.local", "10.20.0.0/16"}
+	if len(env.PACDirectHosts) != len(want) || env.PACDirectHosts[0] != want[0] || env.PACDirectHosts[1] != want[1] {
+		t.Errorf("PACDirectHosts = %q, want %q", env.PACDirectHosts, want)
+	}
+	is, ok := issueFor(env.Validate(), "PAC_DIRECT_HOSTS")
+	if !ok || is.Fatal || is.Value != `evil"host` {
+		t.Fatalf("Validate: PAC_DIRECT_HOSTS issue = %+v, %v; want a warning for the invalid entry", is, ok)
+	}
+}
+
+func TestAllowsConnectTarget(t *testing.T) {
+	env := &EnvConfig{ConnectAllowedPorts: []int{443, 5223}}
+	for _, tc := range []struct {
+		target string
+		want   bool
+	}{
+		{"example.com:443", true},
+		{"example.com:5223", true},
+		{"example.com:25", false},
+		{"[::1]:443", true},
+		{"example.com", false},
+	} {
+		if got := env.AllowsConnectTarget(tc.target); got != tc.want {
+			t.Errorf("AllowsConnectTarget(%q) = %v, want %v", tc.target, got, tc.want)
+		}
+	}
+
+	if !(&EnvConfig{}).AllowsConnectTarget("smtp.example.com:25") {
+		t.Error("empty allow-list should allow every port")
+	}
+}
+
+func TestAPIOrigin(t *testing.T) {
+	for _, tc := range []struct {
+		origin string
+		ok     bool
+	}{
+		{"*", true},
+		{"https://dashboard.example.com", true},
+		{"http://localhost:8443", true},
+		{"", false},
+		{"dashboard.example.com", false},
+		{"https://dashboard.example.com/", false},
+		{"https://dashboard.example.com/stats", false},
+		{"ftp://dashboard.example.com", false},
+		{"https://user@dashboard.example.com", false},
+	} {
+		got, err := (&EnvConfig{APIAllowedOrigin: tc.origin}).APIOrigin()
+		if tc.ok && (err != nil || got != tc.origin) {
+			t.Errorf("APIOrigin(%q) = %q, %v; want it accepted", tc.origin, got, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("APIOrigin(%q) accepted, want an error", tc.origin)
+		}
+	}
+}
+
+func TestAPIAllowedOriginDefaultsToAPIDomain(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("API_DOMAIN", "api.example.com")
+	t.Setenv("API_ALLOWED_ORIGIN", "")
+	if got := LoadEnv().APIAllowedOrigin; got != "https://api.example.com" {
+		t.Errorf("APIAllowedOrigin = %q, want https://api.example.com", got)
+	}
+
+	t.Setenv("API_ALLOWED_ORIGIN", "*")
+	if got := LoadEnv().APIAllowedOrigin; got != "*" {
+		t.Errorf("APIAllowedOrigin = %q, want * when configured", got)
+	}
+}
+
+func TestLoadEnvPopulatesProxyFields(t *testing.T) {
+	for k, v := range map[string]string{
+		"APP_ENV":             "production",
+		"DOMAIN":              "proxy.example.com",
+		"API_DOMAIN":          "stats.example.com",
+		"BASE_URL":            "https://base.example.com",
+		"ALLOWED_ORIGIN":      "https://app.example.com",
+		"PROXY_MODE":          "HTTPS",
+		"USERS_FILE":          "/etc/proxy/users.json",
+		"HTTP_PROXY_PORT":     ":3128",
+		"HTTP_PROXY_TLS":      "false",
+		"HTTP_PROXY_TLS_PORT": ":3129",
+		"SOCKS5_PORT":         ":1081",
+		"PAC_ENABLED":         "false",
+		"PAC_TOKEN":           "pac-secret",
+		"PAC_DEFAULT_USER":    "alice",
+		"PAC_RATE_LIMIT_RPM":  "15",
+		"DRAIN_TIMEOUT_SEC":   "5",
+		"TCP_NODELAY":         "false",
+	} {
+		t.Setenv(k, v)
+	}
+
+	env := LoadEnv()
+	for _, tc := range []struct {
+		name      string
+		got, want any
+	}{
+		{"Env", env.Env, Production},
+		{"Domain", env.Domain, "proxy.example.com"},
+		{"APIDomain", env.APIDomain, "stats.example.com"},
+		{"BaseURL", env.BaseURL, "https://base.example.com"},
+		{"AllowedOrigin", env.AllowedOrigin, "https://app.example.com"},
+		{"ProxyMode", env.ProxyMode, "https"},
+		{"UsersFile", env.UsersFile, "/etc/proxy/users.json"},
+		{"HTTPProxyPort", env.HTTPProxyPort, ":3128"},
+		{"HTTPProxyTLS", env.HTTPProxyTLS, false},
+		{"HTTPProxyTLSPort", env.HTTPProxyTLSPort, ":3129"},
+		{"SOCKS5Port", env.SOCKS5Port, ":1081"},
+		{"PACEnabled", env.PACEnabled, false},
+		{"PACToken", env.PACToken, "pac-secret"},
+		{"PACDefaultUser", env.PACDefaultUser, "alice"},
+		{"PACRateLimitRPM", env.PACRateLimitRPM, 15},
+		{"DrainTimeout", env.DrainTimeout(), 5 * time.Second},
+		{"DisableTCPNoDelay", env.DisableTCPNoDelay, true},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s = %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestLoadEnvDevelopmentOverrides(t *testing.T) {
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("NGROK_ENABLED", "false")
+	t.Setenv("DOMAIN", "dev.example.com")
+	t.Setenv("API_DOMAIN", "")
+	t.Setenv("ALLOWED_ORIGIN", "")
+
+	env := LoadEnv()
+	if env.APIDomain != "dev.example.com" || env.AllowedOrigin != "*" {
+		t.Errorf("defaults: APIDomain=%q AllowedOrigin=%q, want DOMAIN and *", env.APIDomain, env.AllowedOrigin)
+	}
+
+	t.Setenv("API_DOMAIN", "api.dev.example.com")
+	t.Setenv("ALLOWED_ORIGIN", "https://app.dev.example.com")
+	env = LoadEnv()
+	if env.APIDomain != "api.dev.example.com" || env.AllowedOrigin != "https://app.dev.example.com" {
+		t.Errorf("overrides: APIDomain=%q AllowedOrigin=%q", env.APIDomain, env.AllowedOrigin)
+	}
+}
+
+func TestSNIUsageIsOptIn(t *testing.T) {
+	t.Setenv("SNI_USAGE_ENABLED", "")
+	if LoadEnv().SNIUsageEnabled {
+		t.Error("SNI usage tracking enabled by default; /api/usage would be public on the proxy port")
+	}
+	t.Setenv("SNI_USAGE_ENABLED", "true")
+	if !LoadEnv().SNIUsageEnabled {
+		t.Error("SNI_USAGE_ENABLED=true did not enable tracking")
+	}
+}
@@ -0,0 +1,296 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// EnvIssue is a problem with one environment variable found by Validate.
+type EnvIssue struct {
+	Var     string // Environment variable, e.g. "PROXY_MODE"
+	Value   string
+	Message string
+	Fatal   bool // Startup must not continue
+}
+
+// EnvSetting is one row of the effective configuration summary.
+type EnvSetting struct {
+	Var   string
+	Value string
+}
+
+// Allowed values for the enum-like variables
+var (
+	proxyModes = []string{"signal", "https", "http", "general"}
+	logLevels  = []string{"debug", "info", "warn", "error"}
+)
+
+// fallback records that LoadEnv replaced an unknown value with used.
+func (e *EnvConfig) fallback(name, value, used string) {
+	e.fallbacks = append(e.fallbacks, EnvIssue{
+		Var:     name,
+		Value:   value,
+		Message: "unknown value, using " + strconv.Quote(used),
+	})
+}
+
+// Validate checks enum values and setting combinations. Unknown values that
+// have a safe default are warnings; a misspelled PROXY_MODE or clashing
+// listen ports are fatal, since starting anyway would serve the wrong
+// protocol or fail to bind.
+func (e *EnvConfig) Validate() []EnvIssue {
+	issues := append([]EnvIssue(nil), e.fallbacks...)
+
+	if !slices.Contains(proxyModes, e.ProxyMode) {
+		issues = append(issues, EnvIssue{
+			Var:     "PROXY_MODE",
+			Value:   e.ProxyMode,
+			Message: "must be one of " + strings.Join(proxyModes, ", "),
+			Fatal:   true,
+		})
+	}
+	if !slices.Contains(logLevels, strings.ToLower(e.LogLevel)) {
+		issues = append(issues, EnvIssue{
+			Var:     "LOG_LEVEL",
+			Value:   e.LogLevel,
+			Message: "must be one of " + strings.Join(logLevels, ", "),
+		})
+	}
+	if _, err := e.APIOrigin(); err != nil {
+		issues = append(issues, EnvIssue{
+			Var:     "API_ALLOWED_ORIGIN",
+			Value:   e.APIAllowedOrigin,
+			Message: "must be * or scheme://host[:port]",
+			Fatal:   true,
+		})
+	}
+
+	if _, err := parseTLSVersion(e.TLSMinVersion); err != nil {
+		issues = append(issues, EnvIssue{
+			Var:     "TLS_MIN_VERSION",
+			Value:   e.TLSMinVersion,
+			Message: "must be 1.2 or 1.3",
+			Fatal:   true,
+		})
+	}
+	if _, err := parseCipherSuites(e.TLSCipherSuites); err != nil {
+		issues = append(issues, EnvIssue{
+			Var:     "TLS_CIPHER_SUITES",
+			Value:   strings.Join(e.TLSCipherSuites, ","),
+			Message: err.Error(),
+			Fatal:   true,
+		})
+	} else if len(e.TLSCipherSuites) > 0 && e.TLSMinVersion == "1.3" {
+		// Go ignores CipherSuites for TLS 1.3, the only version left
+		issues = append(issues, EnvIssue{
+			Var:     "TLS_CIPHER_SUITES",
+			Value:   strings.Join(e.TLSCipherSuites, ","),
+			Message: "ignored with TLS_MIN_VERSION=1.3, whose suites are not configurable",
+		})
+	}
+
+	if e.IsSignalMode() && e.DefaultUpstream != "" {
+		if _, _, err := net.SplitHostPort(e.DefaultUpstream); err != nil {
+			issues = append(issues, EnvIssue{
+				Var:     "DEFAULT_UPSTREAM",
+				Value:   e.DefaultUpstream,
+				Message: "must be host:port",
+				Fatal:   true,
+			})
+		}
+	}
+
+	if !e.IsSignalMode() {
+		httpAddrs, socksAddrs := e.HTTPProxyAddrs(), e.SOCKS5Addrs()
+		if hasDuplicate(httpAddrs) {
+			issues = append(issues, EnvIssue{
+				Var:     "HTTP_PROXY_PORT",
+				Value:   e.HTTPProxyPort,
+				Message: "lists an address twice",
+				Fatal:   true,
+			})
+		}
+		if hasDuplicate(socksAddrs) || overlaps(socksAddrs, httpAddrs) {
+			issues = append(issues, EnvIssue{
+				Var:     "SOCKS5_PORT",
+				Value:   e.SOCKS5Port,
+				Message: "lists an address twice or one used by HTTP_PROXY_PORT",
+				Fatal:   true,
+			})
+		}
+		if e.TransparentMode && runtime.GOOS != "linux" {
+			issues = append(issues, EnvIssue{
+				Var:     "TRANSPARENT_MODE",
+				Value:   "true",
+				Message: "needs SO_ORIGINAL_DST, which only Linux provides",
+				Fatal:   true,
+			})
+		}
+		if e.TransparentMode && !usersFileHasIPWhitelist(e.UsersFile) {
+			issues = append(issues, EnvIssue{
+				Var:     "TRANSPARENT_MODE",
+				Value:   "true",
+				Message: "needs an ip_whitelist in USERS_FILE: intercepted clients send no credentials, so without one the proxy is an open relay",
+				Fatal:   true,
+			})
+		}
+		if e.ProxyUser != "" && e.ProxyPass == "" && e.ProxyPassHash == "" {
+			issues = append(issues, EnvIssue{
+				Var:     "PROXY_USER",
+				Value:   e.ProxyUser,
+				Message: "needs PROXY_PASS or PROXY_PASS_HASH",
+				Fatal:   true,
+			})
+		}
+		if e.ProxyUser == "" && (e.ProxyPass != "" || e.ProxyPassHash != "") {
+			issues = append(issues, EnvIssue{
+				Var:     "PROXY_PASS",
+				Value:   setOrUnset(e.ProxyPass + e.ProxyPassHash),
+				Message: "ignored without PROXY_USER",
+			})
+		}
+		tlsAddr := []string{e.HTTPProxyTLSPort}
+		if e.HTTPProxyTLS && (overlaps(tlsAddr, httpAddrs) || overlaps(tlsAddr, socksAddrs)) {
+			issues = append(issues, EnvIssue{
+				Var:     "HTTP_PROXY_TLS_PORT",
+				Value:   e.HTTPProxyTLSPort,
+				Message: "same as another proxy port",
+				Fatal:   true,
+			})
+		}
+	}
+	return issues
+}
+
+// usersFileHasIPWhitelist reports whether the users file at path, or any
+// *.json fragment if it is a directory, sets a non-empty ip_whitelist.
+// Unreadable files count as having none.
+func usersFileHasIPWhitelist(path string) bool {
+	files := []string{path}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		files, _ = filepath.Glob(filepath.Join(path, "*.json"))
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var cfg struct {
+			IPWhitelist []string `json:"ip_whitelist"`
+		}
+		if json.Unmarshal(data, &cfg) == nil && len(cfg.IPWhitelist) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDuplicate reports whether addrs lists an address more than once.
+func hasDuplicate(addrs []string) bool {
+	for i, a := range addrs {
+		if slices.Contains(addrs[i+1:], a) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlaps reports whether a and b share an address.
+func overlaps(a, b []string) bool {
+	return slices.ContainsFunc(a, func(addr string) bool { return slices.Contains(b, addr) })
+}
+
+// EnvError joins the fatal issues into one error, or returns nil if there
+// are none.
+func EnvError(issues []EnvIssue) error {
+	var errs []string
+	for _, is := range issues {
+		if is.Fatal {
+			errs = append(errs, is.Var+"="+strconv.Quote(is.Value)+": "+is.Message)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.New("environment validation failed:\n  - " + strings.Join(errs, "\n  - "))
+}
+
+// Summary lists the effective settings worth confirming at startup, in a
+// fixed order. Secrets are shown only as set or unset.
+func (e *EnvConfig) Summary() []EnvSetting {
+	rows := []EnvSetting{
+		{"APP_ENV", e.Env.String()},
+		{"PROXY_MODE", e.ProxyMode},
+		{"DOMAIN", e.Domain},
+		{"LOG_LEVEL", e.LogLevel},
+		{"API_ALLOWED_ORIGIN", e.APIAllowedOrigin},
+	}
+	if e.IsDevelopment() {
+		rows = append(rows, EnvSetting{"NGROK_ENABLED", strconv.FormatBool(e.NgrokEnabled)})
+	}
+	if e.IsSignalMode() {
+		rows = append(rows, EnvSetting{"DEFAULT_UPSTREAM", e.DefaultUpstream})
+	}
+	if !e.IsSignalMode() {
+		rows = append(rows,
+			EnvSetting{"HTTP_PROXY_PORT", e.HTTPProxyPort},
+			EnvSetting{"HTTP_PROXY_TLS", strconv.FormatBool(e.HTTPProxyTLS)},
+			EnvSetting{"HTTP_PROXY_TLS_PORT", e.HTTPProxyTLSPort},
+			EnvSetting{"SOCKS5_PORT", e.SOCKS5Port},
+			EnvSetting{"TRANSPARENT_MODE", strconv.FormatBool(e.TransparentMode)},
+			EnvSetting{"USERS_FILE", e.UsersFile},
+			EnvSetting{"RATE_LIMIT_MODE", e.RateLimitMode},
+			EnvSetting{"HTTP_FORWARD_HEADERS", e.HTTPForwardHeaders},
+			EnvSetting{"PAC_ENABLED", strconv.FormatBool(e.PACEnabled)},
+		)
+	}
+	if !e.IsSignalMode() && e.ProxyUser != "" {
+		rows = append(rows, EnvSetting{"PROXY_USER", e.ProxyUser})
+	}
+	if !e.IsSignalMode() && e.AuthWebhookURL != "" {
+		rows = append(rows, EnvSetting{"AUTH_WEBHOOK_URL", RedactURL(e.AuthWebhookURL)})
+	}
+	if e.AccessLog != "" {
+		rows = append(rows, EnvSetting{"ACCESS_LOG", e.AccessLog + " (" + e.AccessLogFormat + ")"})
+	}
+	rows = append(rows,
+		EnvSetting{"METRICS_TOKEN", setOrUnset(e.MetricsToken)},
+		EnvSetting{"API_AUTH_TOKEN", setOrUnset(e.APIAuthToken)},
+		EnvSetting{"ACME_ENABLED", strconv.FormatBool(e.ACMEEnabled)},
+		EnvSetting{"TLS_MIN_VERSION", e.TLSMinVersion},
+	)
+	if len(e.TLSCipherSuites) > 0 {
+		rows = append(rows, EnvSetting{"TLS_CIPHER_SUITES", strings.Join(e.TLSCipherSuites, ",")})
+	}
+	return rows
+}
+
+// setOrUnset hides a secret's value.
+func setOrUnset(secret string) string {
+	if secret == "" {
+		return "unset"
+	}
+	return "set"
+}
+
+// RedactURL strips the userinfo, query and fragment from raw, which may
+// carry credentials, so the URL can be logged. Unparseable values are
+// replaced entirely.
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
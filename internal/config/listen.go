@@ -0,0 +1,142 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// unixScheme prefixes a listen address that names a Unix socket path
+const unixScheme = "unix:"
+
+// IsUnixAddr reports whether addr is a "unix:/path" listen address
+func IsUnixAddr(addr string) bool {
+	return strings.HasPrefix(addr, unixScheme)
+}
+
+// ErrAddrInUse matches, with errors.Is, a Listen or ListenTLS error for an
+// address another process (often another instance) is already bound to.
+var ErrAddrInUse = errors.New("address already in use")
+
+// AddrInUseError is the error Listen and ListenTLS return when addr is
+// already bound. It matches ErrAddrInUse.
+type AddrInUseError struct {
+	Addr string
+	Err  error // The underlying listen error
+}
+
+func (e *AddrInUseError) Error() string { return e.Err.Error() }
+
+func (e *AddrInUseError) Unwrap() error { return e.Err }
+
+func (e *AddrInUseError) Is(target error) bool { return target == ErrAddrInUse }
+
+// listenError returns err as an *AddrInUseError when it says addr is taken.
+func listenError(addr string, err error) error {
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return &AddrInUseError{Addr: addr, Err: err}
+	}
+	return err
+}
+
+// Listen opens a listener for one address from HTTP_PROXY_PORT or
+// SOCKS5_PORT. "unix:/run/proxy.sock" listens on a Unix socket; anything
+// else is a TCP address, bound per ListenNetwork. Go sets SO_REUSEADDR on
+// TCP listeners, so connections of a previous instance lingering in
+// TIME_WAIT do not block the bind; a listener still open elsewhere does.
+//
+// control, if not nil, runs on a TCP socket before it listens, as in
+// net.ListenConfig; accepted connections inherit options such as buffer
+// sizes set there.
+//
+// A socket file left behind by an unclean exit is removed first, unless
+// another process is still accepting on it. Closing the returned listener
+// removes the socket file again.
+func Listen(addr string, control func(network, address string, c syscall.RawConn) error) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixScheme)
+	if !ok {
+		lc := net.ListenConfig{Control: control}
+		ln, err := lc.Listen(context.Background(), ListenNetwork(addr), addr)
+		return ln, listenError(addr, err)
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, &AddrInUseError{Addr: addr, Err: fmt.Errorf("%s is in use by another process", path)}
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	return ln, listenError(addr, err)
+}
+
+// ListenTLS opens a TLS listener on the TCP address addr, bound per
+// ListenNetwork. control is as for Listen.
+func ListenTLS(addr string, tlsConfig *tls.Config, control func(network, address string, c syscall.RawConn) error) (net.Listener, error) {
+	lc := net.ListenConfig{Control: control}
+	ln, err := lc.Listen(context.Background(), ListenNetwork(addr), addr)
+	if err != nil {
+		return nil, listenError(addr, err)
+	}
+	return tls.NewListener(ln, tlsConfig), nil
+}
+
+// ListenNetwork picks the network for a TCP listen address. A bare port
+// (":8080") or hostname binds dual-stack; an IPv4 literal ("0.0.0.0:8080")
+// binds IPv4 only and an IPv6 literal ("[::]:8080") IPv6 only.
+func ListenNetwork(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "tcp"
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "tcp"
+	case ip.To4() != nil:
+		return "tcp4"
+	default:
+		return "tcp6"
+	}
+}
+
+// IsUnixConn reports whether conn was accepted on a Unix socket. Such peers
+// are on the same host and have no IP address to check.
+func IsUnixConn(conn net.Conn) bool {
+	return conn.LocalAddr().Network() == "unix"
+}
+
+// ListenRetry calls listen until it succeeds, fails with an error other
+// than ErrAddrInUse, or has been retried retries times, so a restart can
+// wait out the instance it replaces. The wait starts at delay and doubles
+// after each attempt. Cancelling ctx gives up with the last error.
+func ListenRetry(ctx context.Context, retries int, delay time.Duration, listen func() (net.Listener, error)) (net.Listener, error) {
+	for attempt := 0; ; attempt++ {
+		ln, err := listen()
+		if err == nil || attempt >= retries || !errors.Is(err, ErrAddrInUse) {
+			return ln, err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, err
+		}
+		delay *= 2
+	}
+}
+
+// ListenWithRetry opens listen, retrying up to LISTEN_RETRY times while its
+// address is in use. See ListenRetry.
+func (e *EnvConfig) ListenWithRetry(ctx context.Context, listen func() (net.Listener, error)) (net.Listener, error) {
+	retries := 0
+	if e != nil {
+		retries = e.ListenRetry
+	}
+	return ListenRetry(ctx, retries, e.ListenRetryDelay(), listen)
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestListenReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a crash: the listener goes away but the file stays
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := Listen("unix:"+path, nil)
+	if err != nil {
+		t.Fatalf("Listen over a stale socket: %v", err)
+	}
+	if ln.Addr().Network() != "unix" {
+		t.Errorf("network = %q, want unix", ln.Addr().Network())
+	}
+	ln.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file still present after Close: %v", err)
+	}
+}
+
+func TestListenRefusesSocketInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.sock")
+	first, err := Listen("unix:"+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	if ln, err := Listen("unix:"+path, nil); err == nil {
+		ln.Close()
+		t.Fatal("second Listen on a live socket succeeded")
+	}
+}
+
+func TestListenTCP(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if ln.Addr().Network() != "tcp" {
+		t.Errorf("network = %q, want tcp", ln.Addr().Network())
+	}
+}
+
+func TestListenNetwork(t *testing.T) {
+	for addr, want := range map[string]string{
+		":8080":          "tcp",
+		"localhost:8080": "tcp",
+		"0.0.0.0:8080":   "tcp4",
+		"127.0.0.1:8080": "tcp4",
+		"[::]:8080":      "tcp6",
+		"[::1]:8080":     "tcp6",
+		"8080":           "tcp",
+	} {
+		if got := ListenNetwork(addr); got != want {
+			t.Errorf("ListenNetwork(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestListenIPv6(t *testing.T) {
+	ln, err := Listen("[::1]:0", nil)
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	defer ln.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if ip := ln.Addr().(*net.TCPAddr).IP; ip.To4() != nil {
+		t.Errorf("listener bound %v, want an IPv6 address", ip)
+	}
+}
+
+func TestListenAddrInUse(t *testing.T) {
+	first, err := Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	addr := first.Addr().String()
+
+	_, err = Listen(addr, nil)
+	var inUse *AddrInUseError
+	if !errors.Is(err, ErrAddrInUse) || !errors.As(err, &inUse) || inUse.Addr != addr {
+		t.Fatalf("Listen on a bound port = %v, want an AddrInUseError for %s", err, addr)
+	}
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("error %v lost the underlying EADDRINUSE", err)
+	}
+	noCert := &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return nil, nil }}
+	if _, err := ListenTLS(addr, noCert, nil); !errors.Is(err, ErrAddrInUse) {
+		t.Errorf("ListenTLS on a bound port = %v, want ErrAddrInUse", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "proxy.sock")
+	sock, err := Listen("unix:"+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sock.Close()
+	if _, err := Listen("unix:"+path, nil); !errors.Is(err, ErrAddrInUse) {
+		t.Errorf("Listen on a live socket = %v, want ErrAddrInUse", err)
+	}
+
+	if _, err := Listen("203.0.113.1:0", nil); err == nil || errors.Is(err, ErrAddrInUse) {
+		t.Errorf("Listen on a foreign address = %v, want a different error", err)
+	}
+}
+
+func TestListenRetryWaitsForRelease(t *testing.T) {
+	taken, err := Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := taken.Addr().String()
+	time.AfterFunc(150*time.Millisecond, func() { taken.Close() })
+
+	env := &EnvConfig{ListenRetry: 5, ListenRetryDelayMs: 50}
+	ln, err := env.ListenWithRetry(context.Background(), func() (net.Listener, error) { return Listen(addr, nil) })
+	if err != nil {
+		t.Fatalf("bind after release: %v", err)
+	}
+	ln.Close()
+}
+
+func TestListenRetryGivesUp(t *testing.T) {
+	taken, err := Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	addr := taken.Addr().String()
+
+	attempts := 0
+	listen := func() (net.Listener, error) {
+		attempts++
+		return Listen(addr, nil)
+	}
+	if _, err := ListenRetry(context.Background(), 2, time.Millisecond, listen); !errors.Is(err, ErrAddrInUse) || attempts != 3 {
+		t.Errorf("ListenRetry = %v after %d attempts, want ErrAddrInUse after 3", err, attempts)
+	}
+
+	// Without LISTEN_RETRY there is a single attempt
+	attempts = 0
+	if _, err := (&EnvConfig{}).ListenWithRetry(context.Background(), listen); err == nil || attempts != 1 {
+		t.Errorf("ListenWithRetry = %v after %d attempts, want an error after 1", err, attempts)
+	}
+
+	// Cancelling stops the wait
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts = 0
+	if _, err := ListenRetry(ctx, 5, time.Hour, listen); !errors.Is(err, ErrAddrInUse) || attempts != 1 {
+		t.Errorf("cancelled ListenRetry = %v after %d attempts, want ErrAddrInUse after 1", err, attempts)
+	}
+}
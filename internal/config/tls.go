@@ -0,0 +1,60 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+)
+
+// ServerTLSConfig returns the base tls.Config for the proxy's TLS listeners,
+// applying TLS_MIN_VERSION and TLS_CIPHER_SUITES. Callers add certificates.
+func (e *EnvConfig) ServerTLSConfig() (*tls.Config, error) {
+	minVersion, err := parseTLSVersion(e.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	suites, err := parseCipherSuites(e.TLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{MinVersion: minVersion, CipherSuites: suites}, nil
+}
+
+// parseTLSVersion maps "1.2" or "1.3" to its tls constant. Empty means 1.2.
+func parseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("invalid TLS_MIN_VERSION %q (want 1.2 or 1.3)", v)
+	}
+}
+
+// parseCipherSuites resolves IANA suite names (e.g.
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) to IDs. Only Go's secure TLS 1.2
+// suites are accepted; TLS 1.3 suites are not configurable. Empty keeps the
+// Go defaults.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]*tls.CipherSuite)
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		cs, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure TLS cipher suite %q", name)
+		}
+		if !slices.Contains(cs.SupportedVersions, tls.VersionTLS12) {
+			return nil, fmt.Errorf("TLS cipher suite %q is TLS 1.3 only and cannot be configured", name)
+		}
+		ids = append(ids, cs.ID)
+	}
+	return ids, nil
+}
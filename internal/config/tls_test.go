@@ -0,0 +1,64 @@
+package config
+
+import (
+	"crypto/tls"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestServerTLSConfigMinVersion(t *testing.T) {
+	for _, tc := range []struct {
+		setting string
+		want    uint16
+	}{
+		{"", tls.VersionTLS12},
+		{"1.2", tls.VersionTLS12},
+		{"1.3", tls.VersionTLS13},
+	} {
+		cfg, err := (&EnvConfig{TLSMinVersion: tc.setting}).ServerTLSConfig()
+		if err != nil {
+			t.Fatalf("TLS_MIN_VERSION=%q: %v", tc.setting, err)
+		}
+		if cfg.MinVersion != tc.want {
+			t.Errorf("TLS_MIN_VERSION=%q: MinVersion = %#x, want %#x", tc.setting, cfg.MinVersion, tc.want)
+		}
+	}
+
+	if _, err := (&EnvConfig{TLSMinVersion: "1.1"}).ServerTLSConfig(); err == nil {
+		t.Error("TLS_MIN_VERSION=1.1 accepted")
+	}
+}
+
+func TestServerTLSConfigCipherSuites(t *testing.T) {
+	cfg, err := (&EnvConfig{}).ServerTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CipherSuites != nil {
+		t.Errorf("CipherSuites = %v with none configured, want Go defaults", cfg.CipherSuites)
+	}
+
+	cfg, err = (&EnvConfig{TLSCipherSuites: []string{
+		"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+		"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	}}).ServerTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256}
+	if !slices.Equal(cfg.CipherSuites, want) {
+		t.Errorf("CipherSuites = %v, want %v", cfg.CipherSuites, want)
+	}
+
+	for _, bad := range []string{
+		"TLS_NOT_A_SUITE",
+		"TLS_RSA_WITH_RC4_128_SHA", // insecure
+		"TLS_AES_128_GCM_SHA256",   // TLS 1.3 only
+	} {
+		_, err := (&EnvConfig{TLSCipherSuites: []string{bad}}).ServerTLSConfig()
+		if err == nil || !strings.Contains(err.Error(), bad) {
+			t.Errorf("TLS_CIPHER_SUITES=%s: err = %v, want it rejected by name", bad, err)
+		}
+	}
+}
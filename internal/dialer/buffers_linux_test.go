@@ -0,0 +1,70 @@
+package dialer
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// bufferOption reads a socket-level buffer size (SO_SNDBUF or SO_RCVBUF)
+// back from conn. Linux reports double the requested size to cover its
+// bookkeeping overhead.
+func bufferOption(t *testing.T, conn net.Conn, opt int) int {
+	t.Helper()
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	var serr error
+	raw.Control(func(fd uintptr) {
+		v, serr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, opt)
+	})
+	if serr != nil {
+		t.Fatal(serr)
+	}
+	return v
+}
+
+func TestBuffersApplied(t *testing.T) {
+	const send, recv = 96 << 10, 64 << 10
+
+	lc := net.ListenConfig{Control: BufferControl(send, recv)}
+	ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- c
+	}()
+
+	dialed, err := New(time.Second, 0).WithBuffers(send, recv).DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialed.Close()
+	server := <-accepted
+	defer server.Close()
+
+	for name, conn := range map[string]net.Conn{"dialed": dialed, "accepted": server} {
+		if got := bufferOption(t, conn, syscall.SO_SNDBUF); got != 2*send {
+			t.Errorf("%s SO_SNDBUF = %d, want %d (2 × %d)", name, got, 2*send, send)
+		}
+		if got := bufferOption(t, conn, syscall.SO_RCVBUF); got != 2*recv {
+			t.Errorf("%s SO_RCVBUF = %d, want %d (2 × %d)", name, got, 2*recv, recv)
+		}
+	}
+
+	// Zero sizes leave the socket alone
+	if BufferControl(0, 0) != nil {
+		t.Error("BufferControl(0, 0) returned a Control function")
+	}
+}
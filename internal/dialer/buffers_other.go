@@ -0,0 +1,10 @@
+//go:build !unix
+
+package dialer
+
+import "syscall"
+
+// BufferControl returns nil: socket buffer sizes are only set on Unix.
+func BufferControl(send, recv int) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package dialer
+
+import "syscall"
+
+// BufferControl returns a net.Dialer or net.ListenConfig Control function
+// that sets SO_SNDBUF to send and SO_RCVBUF to recv bytes before the socket
+// connects or listens, so the TCP window scale is negotiated for them.
+// Sockets accepted from such a listener inherit both sizes. Larger buffers
+// let one relay fill a high-latency link. A size <= 0 keeps the OS default,
+// and the kernel may clamp the rest (e.g. to net.core.wmem_max on Linux).
+// It returns nil when both sizes are defaults.
+func BufferControl(send, recv int) func(network, address string, c syscall.RawConn) error {
+	if send <= 0 && recv <= 0 {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		return c.Control(func(fd uintptr) {
+			if send > 0 {
+				syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, send)
+			}
+			if recv > 0 {
+				syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, recv)
+			}
+		})
+	}
+}
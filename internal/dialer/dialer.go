@@ -0,0 +1,125 @@
+// Package dialer provides the upstream dialer shared by every proxy mode, so
+// timeouts, TCP keep-alive, TCP_NODELAY, socket buffers and retries are
+// configured in one place.
+package dialer
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Per-mode dial timeouts used when DIAL_TIMEOUT_SEC is unset.
+const (
+	DefaultTimeout = 30 * time.Second // HTTP CONNECT, plain HTTP and SOCKS5
+	SignalTimeout  = 10 * time.Second // Signal TLS relay
+)
+
+// DefaultKeepAlive is the TCP keep-alive period used when none is configured.
+const DefaultKeepAlive = 30 * time.Second
+
+// Dialer opens upstream TCP connections. Transient failures are retried up
+// to Retries times with exponential backoff, all within Timeout.
+type Dialer struct {
+	Timeout      time.Duration
+	KeepAlive    time.Duration
+	NoDelay      bool // TCP_NODELAY on dialed connections (Nagle off)
+	SendBuffer   int  // SO_SNDBUF in bytes, 0 = OS default
+	RecvBuffer   int  // SO_RCVBUF in bytes, 0 = OS default
+	Retries      int
+	RetryBackoff time.Duration // Wait before the first retry, doubled after each
+}
+
+// New returns a Dialer, substituting DefaultTimeout and DefaultKeepAlive for
+// non-positive values. It sets TCP_NODELAY, as Go does by default, and does
+// not retry until WithRetries is called.
+func New(timeout, keepAlive time.Duration) *Dialer {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if keepAlive <= 0 {
+		keepAlive = DefaultKeepAlive
+	}
+	return &Dialer{Timeout: timeout, KeepAlive: keepAlive, NoDelay: true}
+}
+
+// WithNoDelay sets whether dialed connections use TCP_NODELAY and returns d.
+func (d *Dialer) WithNoDelay(on bool) *Dialer {
+	d.NoDelay = on
+	return d
+}
+
+// WithBuffers sets SO_SNDBUF and SO_RCVBUF for dialed connections and
+// returns d. A size <= 0 keeps the OS default.
+func (d *Dialer) WithBuffers(send, recv int) *Dialer {
+	d.SendBuffer = send
+	d.RecvBuffer = recv
+	return d
+}
+
+// WithRetries sets the retry policy and returns d.
+func (d *Dialer) WithRetries(retries int, backoff time.Duration) *Dialer {
+	d.Retries = retries
+	d.RetryBackoff = backoff
+	return d
+}
+
+// DialContext connects to addr, giving up after Timeout or when ctx is done.
+// Only connection setup is retried; nothing has been sent at that point.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeout)
+	defer cancel()
+
+	nd := &net.Dialer{KeepAlive: d.KeepAlive, Control: BufferControl(d.SendBuffer, d.RecvBuffer)}
+	backoff := d.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		conn, err := nd.DialContext(ctx, network, addr)
+		if err == nil {
+			SetNoDelay(conn, d.NoDelay)
+			return conn, nil
+		}
+		if attempt >= d.Retries || !isTransient(err) {
+			return conn, err
+		}
+
+		// Jitter within the upper half keeps clients from retrying in lockstep
+		wait := backoff/2 + rand.N(backoff/2+1)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// noDelayer is implemented by *net.TCPConn and by wrappers embedding one.
+type noDelayer interface {
+	SetNoDelay(noDelay bool) error
+}
+
+// SetNoDelay turns Nagle's algorithm off (on = true) or on for conn, looking
+// through a *tls.Conn to the socket beneath. Conns without the option, such
+// as Unix sockets, are left alone.
+func SetNoDelay(conn net.Conn, on bool) {
+	if tc, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = tc.NetConn()
+	}
+	if nd, ok := conn.(noDelayer); ok {
+		nd.SetNoDelay(on)
+	}
+}
+
+// isTransient reports whether a failed dial is worth retrying.
+func isTransient(err error) bool {
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ETIMEDOUT) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,148 @@
+package dialer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"signal-proxy/internal/config"
+)
+
+func TestShortTimeoutFailsFast(t *testing.T) {
+	env := &config.EnvConfig{DialTimeoutSec: 1}
+	d := New(env.DialTimeout(DefaultTimeout), env.TCPKeepAlive())
+
+	// TEST-NET-1 (RFC 5737) is never routed, so the SYN goes unanswered
+	start := time.Now()
+	conn, err := d.DialContext(context.Background(), "tcp", "192.0.2.1:81")
+	if err == nil {
+		conn.Close()
+		t.Fatal("dial to a black-hole address succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("dial took %v, want it bounded by the 1s timeout", elapsed)
+	}
+}
+
+func TestPerModeDefaults(t *testing.T) {
+	unset := &config.EnvConfig{}
+	if got := unset.DialTimeout(DefaultTimeout); got != 30*time.Second {
+		t.Errorf("HTTP/SOCKS5 default = %v, want 30s", got)
+	}
+	if got := unset.DialTimeout(SignalTimeout); got != 10*time.Second {
+		t.Errorf("Signal default = %v, want 10s", got)
+	}
+	if got := unset.TCPKeepAlive(); got != 30*time.Second {
+		t.Errorf("keep-alive default = %v, want 30s", got)
+	}
+
+	set := &config.EnvConfig{DialTimeoutSec: 5, TCPKeepAliveSec: 15}
+	if got := set.DialTimeout(SignalTimeout); got != 5*time.Second {
+		t.Errorf("configured timeout = %v, want 5s", got)
+	}
+	d := New(set.DialTimeout(DefaultTimeout), set.TCPKeepAlive())
+	if d.Timeout != 5*time.Second || d.KeepAlive != 15*time.Second {
+		t.Errorf("New = %+v, want 5s timeout and 15s keep-alive", *d)
+	}
+}
+
+func TestRetrySucceedsOnceTargetComesUp(t *testing.T) {
+	// Reserve a port, then leave it closed so the first attempts are refused
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	up := make(chan net.Listener, 1)
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			up <- nil
+			return
+		}
+		up <- ln
+	}()
+
+	d := New(5*time.Second, 0).WithRetries(10, 50*time.Millisecond)
+	conn, err := d.DialContext(context.Background(), "tcp", addr)
+	if ln := <-up; ln != nil {
+		defer ln.Close()
+	} else {
+		t.Skip("could not re-listen on the reserved port")
+	}
+	if err != nil {
+		t.Fatalf("dial with retries failed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestNoRetryFailsOnFirstAttempt(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	if _, err := New(time.Second, 0).DialContext(context.Background(), "tcp", addr); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("err = %v, want connection refused", err)
+	}
+}
+
+func TestRetriesBoundedByTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	d := New(300*time.Millisecond, 0).WithRetries(1000, 20*time.Millisecond)
+	start := time.Now()
+	if _, err := d.DialContext(context.Background(), "tcp", addr); err == nil {
+		t.Fatal("dial to a closed port succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("retries ran for %v, want them capped by the 300ms timeout", elapsed)
+	}
+}
+
+// recordingConn records the last SetNoDelay call, standing in for
+// *net.TCPConn.
+type recordingConn struct {
+	net.Conn
+	noDelay *bool
+}
+
+func (c *recordingConn) SetNoDelay(on bool) error {
+	c.noDelay = &on
+	return nil
+}
+
+func TestSetNoDelay(t *testing.T) {
+	conn := &recordingConn{}
+	SetNoDelay(conn, false)
+	if conn.noDelay == nil || *conn.noDelay {
+		t.Fatalf("SetNoDelay(false) recorded %v", conn.noDelay)
+	}
+
+	// A TLS client connection is looked through to its socket
+	tlsConn := tls.Client(conn, &tls.Config{})
+	SetNoDelay(tlsConn, true)
+	if !*conn.noDelay {
+		t.Error("SetNoDelay on a *tls.Conn did not reach the socket")
+	}
+
+	// Conns without the option are left alone
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	SetNoDelay(a, true)
+}
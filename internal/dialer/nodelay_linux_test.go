@@ -0,0 +1,55 @@
+package dialer
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// noDelayOption reads TCP_NODELAY back from conn's socket.
+func noDelayOption(t *testing.T, conn net.Conn) bool {
+	t.Helper()
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	var serr error
+	raw.Control(func(fd uintptr) {
+		v, serr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	})
+	if serr != nil {
+		t.Fatal(serr)
+	}
+	return v != 0
+}
+
+func TestDialAppliesNoDelay(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	for _, on := range []bool{true, false} {
+		conn, err := New(time.Second, 0).WithNoDelay(on).DialContext(context.Background(), "tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := noDelayOption(t, conn); got != on {
+			t.Errorf("WithNoDelay(%v): socket TCP_NODELAY = %v", on, got)
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,73 @@
+package fsutil
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomicReplaces(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	if err := WriteFileAtomic(path, []byte(`{"v":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(path, []byte(`{"v":2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `{"v":2}` {
+		t.Fatalf("contents = %s, want {\"v\":2}", got)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0644 {
+		t.Errorf("perm = %o, want 644", perm)
+	}
+}
+
+func TestWriteFileAtomicInterruptedKeepsOriginal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users.json")
+	original := []byte(`{"users":[{"username":"alice"}]}`)
+	if err := os.WriteFile(path, original, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash halfway through writing the replacement
+	errCrash := errors.New("interrupted")
+	err := writeFileAtomic(path, 0644, func(f *os.File) error {
+		f.Write([]byte(`{"users":[{"usern`))
+		return errCrash
+	})
+	if !errors.Is(err, errCrash) {
+		t.Fatalf("err = %v, want %v", err, errCrash)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed map[string]any
+	if err := json.Unmarshal(got, &parsed); err != nil {
+		t.Fatalf("original file no longer parses: %v (%s)", err, got)
+	}
+	if string(got) != string(original) {
+		t.Errorf("contents = %s, want %s", got, original)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temp file left behind: %v", entries)
+	}
+}
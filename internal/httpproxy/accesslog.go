@@ -0,0 +1,51 @@
+package httpproxy
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+
+	"signal-proxy/internal/accesslog"
+)
+
+// accessWriter notes a response's status and body size in its access log
+// entry.
+type accessWriter struct {
+	http.ResponseWriter
+	entry *accesslog.Entry
+}
+
+func (w *accessWriter) WriteHeader(code int) {
+	if w.entry.Status == 0 {
+		w.entry.Status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessWriter) Write(b []byte) (int, error) {
+	if w.entry.Status == 0 {
+		w.entry.Status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.entry.BytesDown += int64(n)
+	return n, err
+}
+
+// Hijack lets handleConnect take over the connection through the wrapper.
+func (w *accessWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *accessWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// noteTunnel records a finished CONNECT tunnel, whose 200 and traffic
+// bypass the ResponseWriter.
+func noteTunnel(w http.ResponseWriter, up, down int64) {
+	if aw, ok := w.(*accessWriter); ok {
+		aw.entry.Status = http.StatusOK
+		aw.entry.BytesUp, aw.entry.BytesDown = up, down
+	}
+}
@@ -0,0 +1,86 @@
+package httpproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"signal-proxy/internal/auth"
+	"signal-proxy/internal/config"
+)
+
+// stubAuth is a minimal auth.Authenticator standing in for a custom backend
+// such as LDAP: a fixed set of passwords, and a switch to refuse requests as
+// rate limited.
+type stubAuth struct {
+	passwords   map[string]string
+	rateLimited bool
+}
+
+func (a *stubAuth) ValidateCredentials(username, password string) (*auth.User, bool) {
+	if want, ok := a.passwords[username]; !ok || want != password {
+		return nil, false
+	}
+	return a.GetUser(username), true
+}
+
+func (a *stubAuth) GetUser(username string) *auth.User {
+	if _, ok := a.passwords[username]; !ok {
+		return nil
+	}
+	return &auth.User{Username: username, Role: "user", Enabled: true}
+}
+
+func (a *stubAuth) CheckIPAllowed(string) bool               { return true }
+func (a *stubAuth) IsSuperAdminIP(string) (*auth.User, bool) { return nil, false }
+func (a *stubAuth) CheckRateLimit(string) bool               { return !a.rateLimited }
+func (a *stubAuth) CheckExpiry(string) bool                  { return true }
+func (a *stubAuth) ExpiryTime(string) (time.Time, bool)      { return time.Time{}, false }
+func (a *stubAuth) DaysUntilExpiry(string) (int, bool)       { return 0, false }
+
+func (a *stubAuth) RateLimitState(string) (int, int, time.Duration, bool) {
+	if a.rateLimited {
+		return 60, 0, time.Second, true
+	}
+	return 0, 0, 0, false
+}
+
+func TestCustomAuthenticator(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(origin.Close)
+
+	stub := &stubAuth{passwords: map[string]string{"ldapuser": "from-ldap"}}
+	srv := NewServer(&config.Config{Env: &config.EnvConfig{}}, stub, nil)
+	ts := httptest.NewServer(http.HandlerFunc(srv.handleRequest))
+	t.Cleanup(ts.Close)
+
+	get := func(userinfo string) int {
+		t.Helper()
+		proxyURL, _ := url.Parse("http://" + userinfo + "@" + ts.Listener.Addr().String())
+		client := &http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+			Timeout:   5 * time.Second,
+		}
+		resp, err := client.Get(origin.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := get("ldapuser:from-ldap"); got != http.StatusOK {
+		t.Errorf("valid credentials = %d, want 200", got)
+	}
+	if got := get("ldapuser:wrong"); got != http.StatusProxyAuthRequired {
+		t.Errorf("wrong password = %d, want 407", got)
+	}
+	stub.rateLimited = true
+	if got := get("ldapuser:from-ldap"); got != http.StatusTooManyRequests {
+		t.Errorf("rate limited by the backend = %d, want 429", got)
+	}
+}
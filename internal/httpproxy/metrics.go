@@ -42,10 +42,28 @@ var (
 		Help: "Total proxy errors by type",
 	}, []string{"type"})
 
+	// MetricConnectionsRejected counts requests refused at MaxConns
+	MetricConnectionsRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "httpproxy_connections_rejected_total",
+		Help: "Total HTTP proxy requests rejected due to capacity",
+	})
+
 	// MetricDuration tracks request duration
 	MetricDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    "httpproxy_request_duration_seconds",
 		Help:    "HTTP proxy request duration in seconds",
 		Buckets: []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300},
 	})
+
+	// MetricPoolIdle reports pooled upstream connections waiting for reuse
+	MetricPoolIdle = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "signalproxy_http_pool_idle",
+		Help: "Idle keep-alive connections in the plain HTTP upstream pool",
+	}, func() float64 { return float64(pool.idle()) })
+
+	// MetricPoolActive reports pooled upstream connections serving a request
+	MetricPoolActive = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "signalproxy_http_pool_active",
+		Help: "Plain HTTP upstream connections currently serving a request",
+	}, func() float64 { return float64(pool.active.Load()) })
 )
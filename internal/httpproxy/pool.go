@@ -0,0 +1,69 @@
+package httpproxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+)
+
+// poolStats counts the upstream connections held by the plain HTTP
+// transports. http.Transport keeps its pool private, so connections are
+// counted as they are dialed and closed, and as requests pick them up.
+type poolStats struct {
+	open   atomic.Int64 // Dialed and not yet closed
+	active atomic.Int64 // Currently serving a request
+}
+
+// pool is shared by every transport in the process.
+var pool poolStats
+
+// idle returns the open connections not serving a request.
+func (p *poolStats) idle() int64 {
+	return max(p.open.Load()-p.active.Load(), 0)
+}
+
+// pooledConn removes itself from stats.open when closed.
+type pooledConn struct {
+	net.Conn
+	stats *poolStats
+	once  sync.Once
+}
+
+func (c *pooledConn) Close() error {
+	c.once.Do(func() { c.stats.open.Add(-1) })
+	return c.Conn.Close()
+}
+
+// dial wraps d so the connections it returns are counted as open.
+func (p *poolStats) dial(d func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := d(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		p.open.Add(1)
+		return &pooledConn{Conn: conn, stats: p}, nil
+	}
+}
+
+// trackActive counts the connection req is sent on as active until the
+// returned release func is called.
+func (p *poolStats) trackActive(req *http.Request) (*http.Request, func()) {
+	var got atomic.Bool
+	trace := &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			if got.CompareAndSwap(false, true) {
+				p.active.Add(1)
+			}
+		},
+	}
+	release := func() {
+		if got.CompareAndSwap(true, false) {
+			p.active.Add(-1)
+		}
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), release
+}
@@ -0,0 +1,89 @@
+package httpproxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"signal-proxy/internal/config"
+)
+
+func TestPoolTunablesReachTransport(t *testing.T) {
+	cfg := &config.Config{
+		Env: &config.EnvConfig{HTTPPoolMaxIdlePerHost: 32, HTTPPoolIdleTimeoutSec: 15},
+		UpstreamTLS: []config.UpstreamTLSRule{
+			{MatchHost: "*.example.com", ServerName: "edge.example.net"},
+		},
+	}
+	srv := NewServer(cfg, newTestUserStore(t, "alice", "secret"), nil)
+
+	for name, tr := range map[string]*http.Transport{
+		"default":      srv.transport,
+		"upstream_tls": srv.tlsTransports[0],
+	} {
+		if tr.MaxIdleConnsPerHost != 32 {
+			t.Errorf("%s: MaxIdleConnsPerHost = %d, want 32", name, tr.MaxIdleConnsPerHost)
+		}
+		if tr.IdleConnTimeout != 15*time.Second {
+			t.Errorf("%s: IdleConnTimeout = %v, want 15s", name, tr.IdleConnTimeout)
+		}
+	}
+}
+
+func TestPoolTunablesDefaults(t *testing.T) {
+	srv := NewServer(&config.Config{Env: &config.EnvConfig{}}, newTestUserStore(t, "alice", "secret"), nil)
+	if srv.transport.MaxIdleConnsPerHost != 10 {
+		t.Errorf("MaxIdleConnsPerHost = %d, want 10", srv.transport.MaxIdleConnsPerHost)
+	}
+	if srv.transport.IdleConnTimeout != 90*time.Second {
+		t.Errorf("IdleConnTimeout = %v, want 90s", srv.transport.IdleConnTimeout)
+	}
+	if srv.transport.MaxIdleConns < srv.transport.MaxIdleConnsPerHost {
+		t.Errorf("MaxIdleConns = %d caps the per-host limit", srv.transport.MaxIdleConns)
+	}
+}
+
+func TestPoolCountsConnections(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(origin.Close)
+
+	var stats poolStats
+	tr := &http.Transport{DialContext: stats.dial((&net.Dialer{}).DialContext)}
+	t.Cleanup(tr.CloseIdleConnections)
+
+	req, err := http.NewRequest(http.MethodGet, origin.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, release := stats.trackActive(req)
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stats.active.Load(); got != 1 {
+		t.Errorf("active = %d during the request, want 1", got)
+	}
+	if got := stats.idle(); got != 0 {
+		t.Errorf("idle = %d during the request, want 0", got)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	release()
+
+	if got := stats.active.Load(); got != 0 {
+		t.Errorf("active = %d after release, want 0", got)
+	}
+	if got := stats.idle(); got != 1 {
+		t.Errorf("idle = %d after the request, want 1", got)
+	}
+
+	tr.CloseIdleConnections()
+	if got := stats.idle(); got != 0 {
+		t.Errorf("idle = %d after CloseIdleConnections, want 0", got)
+	}
+}
@@ -0,0 +1,78 @@
+package httpproxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"signal-proxy/internal/config"
+)
+
+// applyHeaderRules applies every rule matching host to h, in config order.
+// When response is true only rules with ApplyToResponse set are applied.
+func applyHeaderRules(rules []config.HeaderRule, host string, h http.Header, response bool) {
+	for _, rule := range rules {
+		if response && !rule.ApplyToResponse {
+			continue
+		}
+		if !rule.Matches(host) {
+			continue
+		}
+		for _, name := range rule.RemoveHeaders {
+			h.Del(name)
+		}
+		for name, value := range rule.SetHeaders {
+			h.Set(name, value)
+		}
+	}
+}
+
+// forwardingHeaders are the inbound headers that reveal the client or
+// upstream proxies, removed in "strip" mode.
+var forwardingHeaders = []string{
+	"Forwarded",
+	"Via",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Forwarded-Port",
+	"X-Real-IP",
+	"X-Client-IP",
+	"Client-IP",
+	"True-Client-IP",
+	"CF-Connecting-IP",
+}
+
+// applyForwardHeaders adds or strips proxy forwarding headers on an outgoing
+// request according to mode ("none", "standard" or "strip").
+func applyForwardHeaders(mode string, h http.Header, remoteAddr, proto string) {
+	switch mode {
+	case "standard":
+		clientIP, _, err := net.SplitHostPort(remoteAddr)
+		if err != nil {
+			clientIP = remoteAddr
+		}
+		if prior := h.Get("X-Forwarded-For"); prior != "" {
+			clientIP = prior + ", " + clientIP
+		}
+		h.Set("X-Forwarded-For", clientIP)
+
+		via := viaProtoVersion(proto) + " signal-proxy"
+		if prior := h.Get("Via"); prior != "" {
+			via = prior + ", " + via
+		}
+		h.Set("Via", via)
+	case "strip":
+		for _, name := range forwardingHeaders {
+			h.Del(name)
+		}
+	}
+}
+
+// viaProtoVersion converts "HTTP/1.1" to the "1.1" form used in Via.
+func viaProtoVersion(proto string) string {
+	if v, ok := strings.CutPrefix(proto, "HTTP/"); ok && v != "" {
+		return v
+	}
+	return "1.1"
+}
@@ -4,74 +4,124 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"signal-proxy/internal/accesslog"
+	"signal-proxy/internal/acmecert"
 	"signal-proxy/internal/auth"
 	"signal-proxy/internal/bandwidth"
+	"signal-proxy/internal/bufpool"
 	"signal-proxy/internal/config"
+	"signal-proxy/internal/dialer"
 	"signal-proxy/internal/pac"
+	"signal-proxy/internal/proxy"
 	"signal-proxy/internal/ui"
 )
 
+// Rate limit headers sent to users with a rate_limit_rpm
+const (
+	headerRateLimitLimit     = "X-RateLimit-Limit"
+	headerRateLimitRemaining = "X-RateLimit-Remaining"
+)
+
 // Server is an HTTP/HTTPS forward proxy with authentication
 type Server struct {
 	Config    *config.Config
-	UserStore *auth.UserStore
+	UserStore auth.Authenticator // *auth.UserStore, or a custom backend
 	Bandwidth *bandwidth.Tracker
 
+	// OnUser, if set, is called with the username of each authenticated
+	// request (the client IP in transparent mode), e.g. for unique-user stats
+	OnUser func(id string)
+
+	// OnCertificate, if set, is called with the TLS certificate loaded for
+	// the HTTPS listener, and with each one ACME serves
+	OnCertificate func(*tls.Certificate)
+
 	httpServer  *http.Server
 	httpsServer *http.Server
-	ln          net.Listener
+	lns         []net.Listener
 	tlsLn       net.Listener
 	wg          sync.WaitGroup
+	connSem     chan struct{} // Caps in-flight requests and tunnels (nil = unlimited)
 	shutdown    chan struct{}
+	drained     chan struct{} // Closed once shutdown has finished draining
+	stopOnce    sync.Once
 
-	// Connection tracking
-	connCount   int
-	connCountMu sync.Mutex
+	// Hijacked CONNECT tunnels are invisible to http.Server.Shutdown,
+	// so they are tracked here for graceful drain
+	tunnels   sync.WaitGroup
+	tunnelsMu sync.Mutex
+	tunnelSet map[net.Conn]net.Conn // client -> target
+
+	// Dialer for CONNECT targets, also used by transport
+	upstream *dialer.Dialer
 
 	// Transport for outgoing HTTP requests (with connection pooling)
 	transport *http.Transport
 
+	// Transports carrying per-host TLS overrides, parallel to Config.UpstreamTLS
+	tlsTransports []*http.Transport
+
 	// PAC handler
 	pacHandler *pac.Handler
 }
 
-// NewServer creates a new HTTP/HTTPS proxy server
-func NewServer(cfg *config.Config, userStore *auth.UserStore, bw *bandwidth.Tracker) *Server {
+// NewServer creates a new HTTP/HTTPS proxy server.
+// cfg.MaxConns caps in-flight requests and tunnels; 0 means unlimited.
+func NewServer(cfg *config.Config, userStore auth.Authenticator, bw *bandwidth.Tracker) *Server {
+	upstream := dialer.New(cfg.Env.DialTimeout(dialer.DefaultTimeout), cfg.Env.TCPKeepAlive()).
+		WithNoDelay(!cfg.Env.DisableTCPNoDelay).
+		WithBuffers(cfg.Env.SocketSndBuf, cfg.Env.SocketRcvBuf).
+		WithRetries(cfg.Env.DialRetries, cfg.Env.DialRetryBackoff())
 	srv := &Server{
 		Config:    cfg,
 		UserStore: userStore,
 		Bandwidth: bw,
 		shutdown:  make(chan struct{}),
+		drained:   make(chan struct{}),
+		tunnelSet: make(map[net.Conn]net.Conn),
+		upstream:  upstream,
 		transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 10,
-			IdleConnTimeout:     90 * time.Second,
+			MaxIdleConns:        max(100, cfg.Env.MaxIdleConnsPerHost()),
+			MaxIdleConnsPerHost: cfg.Env.MaxIdleConnsPerHost(),
+			IdleConnTimeout:     cfg.Env.HTTPPoolIdleTimeout(),
 			DisableKeepAlives:   false,
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
+			DialContext:         pool.dial(upstream.DialContext),
 		},
 	}
 
+	if cfg.MaxConns > 0 {
+		srv.connSem = make(chan struct{}, cfg.MaxConns)
+	}
+
+	for _, rule := range cfg.UpstreamTLS {
+		srv.tlsTransports = append(srv.tlsTransports, upstreamTLSTransport(srv.transport, rule))
+	}
+
 	// Initialize PAC handler if enabled
 	if cfg.Env.PACEnabled {
 		pacConfig := &pac.Config{
-			Enabled:      cfg.Env.PACEnabled,
-			ProxyHost:    cfg.Env.Domain,
-			HTTPPort:     strings.TrimPrefix(cfg.Env.HTTPProxyPort, ":"),
-			SOCKS5Port:   strings.TrimPrefix(cfg.Env.SOCKS5Port, ":"),
-			Token:        cfg.Env.PACToken,
-			DefaultUser:  cfg.Env.PACDefaultUser,
-			RateLimitRPM: cfg.Env.PACRateLimitRPM,
+			Enabled:       cfg.Env.PACEnabled,
+			ProxyHost:     cfg.Env.Domain,
+			HTTPPort:      listenPort(cfg.Env.HTTPProxyAddrs()),
+			SOCKS5Port:    listenPort(cfg.Env.SOCKS5Addrs()),
+			Token:         cfg.Env.PACToken,
+			DefaultUser:   cfg.Env.PACDefaultUser,
+			RateLimitRPM:  cfg.Env.PACRateLimitRPM,
+			AllowedOrigin: cfg.Env.AllowedOrigin,
+			DirectHosts:   cfg.Env.PACDirectHosts,
+			SigningKey:    cfg.Env.PACSigningKey,
+			BaseURL:       cfg.Env.BaseURL,
 		}
 		srv.pacHandler = pac.NewHandler(pacConfig, userStore)
 		ui.LogStatus("info", "PAC endpoint enabled at /proxy.pac")
@@ -85,54 +135,80 @@ func (s *Server) Start(ctx context.Context) error {
 	// Create HTTP handler
 	handler := http.HandlerFunc(s.handleRequest)
 
-	// Start plain HTTP proxy listener
-	httpAddr := s.Config.Env.HTTPProxyPort
-	if httpAddr == "" {
-		httpAddr = ":8080"
-	}
+	// Socket buffer sizes are set on the listeners and inherited by clients
+	ctrl := dialer.BufferControl(s.Config.Env.SocketSndBuf, s.Config.Env.SocketRcvBuf)
 
-	var err error
-	s.ln, err = net.Listen("tcp", httpAddr)
-	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", httpAddr, err)
+	// Start plain HTTP proxy listeners, one per HTTP_PROXY_PORT address
+	for _, addr := range s.Config.Env.HTTPProxyAddrs() {
+		ln, err := s.Config.Env.ListenWithRetry(ctx, func() (net.Listener, error) {
+			return config.Listen(addr, ctrl)
+		})
+		if err != nil {
+			for _, opened := range s.lns {
+				opened.Close()
+			}
+			return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		s.lns = append(s.lns, ln)
+		ui.LogStatus("info", "HTTP Proxy listening on "+addr)
 	}
 
 	s.httpServer = &http.Server{
-		Handler:      handler,
-		ReadTimeout:  0, // Disabled: CONNECT tunnels are long-lived, managed per-handler
-		WriteTimeout: 0, // Disabled: CONNECT tunnels are long-lived, managed per-handler
-		IdleTimeout:  120 * time.Second,
+		Handler:           handler,
+		ConnContext:       s.connContext,
+		ReadHeaderTimeout: s.Config.Env.HandshakeTimeout(), // Request line and headers only, so trickling clients are dropped
+		ReadTimeout:       0,                               // Disabled: CONNECT tunnels are long-lived, managed per-handler
+		WriteTimeout:      0,                               // Disabled: CONNECT tunnels are long-lived, managed per-handler
+		IdleTimeout:       120 * time.Second,
 	}
 
-	ui.LogStatus("info", "HTTP Proxy listening on "+httpAddr)
-
 	// Start HTTPS proxy listener if TLS is configured
-	if s.Config.Env.HTTPProxyTLS && s.Config.CertFile != "" && s.Config.KeyFile != "" {
+	hasCertFiles := s.Config.CertFile != "" && s.Config.KeyFile != ""
+	if s.Config.Env.HTTPProxyTLS && (s.Config.Env.ACMEEnabled || hasCertFiles) {
 		httpsAddr := s.Config.Env.HTTPProxyTLSPort
 		if httpsAddr == "" {
 			httpsAddr = ":8443"
 		}
 
-		cert, err := tls.LoadX509KeyPair(s.Config.CertFile, s.Config.KeyFile)
+		tlsConfig, err := s.Config.Env.ServerTLSConfig()
 		if err != nil {
-			return fmt.Errorf("failed to load TLS cert: %w", err)
+			return err
 		}
-
-		tlsConfig := &tls.Config{
-			Certificates: []tls.Certificate{cert},
-			MinVersion:   tls.VersionTLS12,
+		if s.Config.Env.ACMEEnabled {
+			m, err := acmecert.NewManager(s.Config.Env)
+			if err != nil {
+				return err
+			}
+			tlsConfig.GetCertificate = acmecert.GetCertificate(m, s.Config.Env.ACMEDomains[0], s.OnCertificate)
+			tlsConfig.NextProtos = []string{"http/1.1", acmecert.ALPNProto}
+		} else {
+			cert, err := tls.LoadX509KeyPair(s.Config.CertFile, s.Config.KeyFile)
+			if err != nil {
+				return fmt.Errorf("failed to load TLS cert: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+			if s.OnCertificate != nil {
+				s.OnCertificate(&cert)
+			}
 		}
 
-		s.tlsLn, err = tls.Listen("tcp", httpsAddr, tlsConfig)
+		s.tlsLn, err = s.Config.Env.ListenWithRetry(ctx, func() (net.Listener, error) {
+			return config.ListenTLS(httpsAddr, tlsConfig, ctrl)
+		})
 		if err != nil {
+			for _, opened := range s.lns {
+				opened.Close()
+			}
 			return fmt.Errorf("failed to listen TLS on %s: %w", httpsAddr, err)
 		}
 
 		s.httpsServer = &http.Server{
-			Handler:      handler,
-			ReadTimeout:  0, // Disabled: CONNECT tunnels are long-lived, managed per-handler
-			WriteTimeout: 0, // Disabled: CONNECT tunnels are long-lived, managed per-handler
-			IdleTimeout:  120 * time.Second,
+			Handler:           handler,
+			ConnContext:       s.connContext,
+			ReadHeaderTimeout: s.Config.Env.HandshakeTimeout(), // Request line and headers only, so trickling clients are dropped
+			ReadTimeout:       0,                               // Disabled: CONNECT tunnels are long-lived, managed per-handler
+			WriteTimeout:      0,                               // Disabled: CONNECT tunnels are long-lived, managed per-handler
+			IdleTimeout:       120 * time.Second,
 		}
 
 		ui.LogStatus("info", "HTTPS Proxy listening on "+httpsAddr+" (TLS)")
@@ -150,28 +226,120 @@ func (s *Server) Start(ctx context.Context) error {
 	// Monitor for shutdown
 	go s.watchShutdown(ctx)
 
+	// Serve extra HTTP listeners in the background; Shutdown closes them all
+	for _, ln := range s.lns[1:] {
+		s.wg.Add(1)
+		go func(ln net.Listener) {
+			defer s.wg.Done()
+			if err := s.httpServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+				ui.LogStatus("error", "HTTP proxy error on "+ln.Addr().String()+": "+err.Error())
+			}
+		}(ln)
+	}
+
 	// Start HTTP server (blocking)
-	if err := s.httpServer.Serve(s.ln); err != nil && err != http.ErrServerClosed {
+	if err := s.httpServer.Serve(s.lns[0]); err != nil && err != http.ErrServerClosed {
 		return err
 	}
 
+	// Serve returns as soon as Shutdown begins; wait for the drain to finish
+	<-s.drained
 	s.wg.Wait()
 	return nil
 }
 
 // watchShutdown monitors context for cancellation
 func (s *Server) watchShutdown(ctx context.Context) {
-	<-ctx.Done()
-	close(s.shutdown)
+	select {
+	case <-ctx.Done():
+	case <-s.shutdown:
+		return
+	}
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.Config.Env.DrainTimeout())
 	defer cancel()
+	s.stop(shutdownCtx)
+}
+
+// stop shuts the HTTP servers down and drains tunnels until ctx expires,
+// then closes drained. Only the first call does the work; later ones wait
+// for it to finish.
+func (s *Server) stop(ctx context.Context) {
+	s.stopOnce.Do(func() {
+		close(s.shutdown)
+		defer close(s.drained)
 
-	if s.httpServer != nil {
-		s.httpServer.Shutdown(shutdownCtx)
+		if s.httpServer != nil {
+			s.httpServer.Shutdown(ctx)
+		}
+		if s.httpsServer != nil {
+			s.httpsServer.Shutdown(ctx)
+		}
+		s.drainTunnels(ctx)
+	})
+}
+
+// trackTunnel registers the connections of a hijacked CONNECT tunnel.
+func (s *Server) trackTunnel(client, target net.Conn) {
+	s.tunnelsMu.Lock()
+	s.tunnelSet[client] = target
+	s.tunnelsMu.Unlock()
+}
+
+// untrackTunnel removes a finished CONNECT tunnel.
+func (s *Server) untrackTunnel(client net.Conn) {
+	s.tunnelsMu.Lock()
+	delete(s.tunnelSet, client)
+	s.tunnelsMu.Unlock()
+}
+
+// drainTunnels waits for active CONNECT tunnels to finish, force-closing
+// any that are still running when ctx expires.
+func (s *Server) drainTunnels(ctx context.Context) {
+	s.tunnelsMu.Lock()
+	active := len(s.tunnelSet)
+	s.tunnelsMu.Unlock()
+	if active > 0 {
+		ui.LogStatus("info", fmt.Sprintf("Draining %d active CONNECT tunnels...", active))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.tunnels.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		ui.LogStatus("success", "All HTTP proxy connections drained.")
+	case <-ctx.Done():
+		s.tunnelsMu.Lock()
+		ui.LogStatus("warn", fmt.Sprintf("HTTP proxy drain timeout reached. Forcing close of %d tunnels.", len(s.tunnelSet)))
+		for client, target := range s.tunnelSet {
+			client.Close()
+			target.Close()
+		}
+		s.tunnelsMu.Unlock()
 	}
-	if s.httpsServer != nil {
-		s.httpsServer.Shutdown(shutdownCtx)
+}
+
+// acquireSlot reserves a request slot, reporting false at capacity.
+func (s *Server) acquireSlot() bool {
+	if s.connSem == nil {
+		return true
+	}
+	select {
+	case s.connSem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseSlot frees a slot taken by acquireSlot.
+func (s *Server) releaseSlot() {
+	if s.connSem != nil {
+		<-s.connSem
 	}
 }
 
@@ -183,17 +351,54 @@ func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The connection's ID tags this request's log lines, access log record
+	// and response; pin it in case connContext did not run
+	id := accesslog.IDFrom(r.Context())
+	r = r.WithContext(accesslog.WithID(r.Context(), id))
+	w.Header().Set(accesslog.HeaderRequestID, id)
+
+	// One access log record per request, written once it completes
+	entry := &accesslog.Entry{
+		ID:     id,
+		Time:   time.Now(),
+		Proxy:  "http",
+		Client: auth.RealClientIP(r, r.RemoteAddr),
+		Method: r.Method,
+		Target: r.RequestURI,
+	}
+	w = &accessWriter{ResponseWriter: w, entry: entry}
+	defer func() {
+		entry.Duration = time.Since(entry.Time)
+		accesslog.Log(*entry)
+	}()
+
+	// Reject at capacity rather than spawning unbounded work
+	if !s.acquireSlot() {
+		MetricConnectionsRejected.Inc()
+		logStatus(r, "warn", "HTTP proxy request rejected: at max capacity ("+strconv.Itoa(s.Config.MaxConns)+")")
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.releaseSlot()
+
 	startTime := time.Now()
-	clientIP := r.RemoteAddr
+	clientIP := entry.Client
 
-	// Check IP whitelist
-	if !s.UserStore.CheckIPAllowed(clientIP) {
+	// Check IP whitelist; Unix socket peers are local and have no IP
+	if !fromUnixSocket(r) && !s.UserStore.CheckIPAllowed(clientIP) {
 		MetricAuthFailures.WithLabelValues("ip_blocked").Inc()
-		ui.LogStatus("warn", "IP blocked: "+clientIP)
+		logStatus(r, "warn", "IP blocked: "+clientIP)
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
 
+	// Traffic intercepted in TRANSPARENT_MODE carries no credentials
+	if dst, ok := transparentTarget(r); ok {
+		entry.User = entry.Client
+		s.handleTransparent(w, r, dst, startTime)
+		return
+	}
+
 	// Always require authentication
 	var user *auth.User
 	username, password, ok := parseProxyAuth(r)
@@ -204,51 +409,58 @@ func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	entry.User = username
 	var valid bool
 	user, valid = s.UserStore.ValidateCredentials(username, password)
 	if !valid {
 		MetricAuthFailures.WithLabelValues("invalid_credentials").Inc()
-		ui.LogStatus("warn", "Auth failed for user: "+username+" from "+clientIP)
+		logStatus(r, "warn", "Auth failed for user: "+username+" from "+clientIP)
 		w.Header().Set("Proxy-Authenticate", `Basic realm="Proxy Authentication Required"`)
 		http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
 		return
 	}
+	// Log and account under the stored spelling, whatever case the client sent
+	username = user.Username
+	entry.User = username
 
 	// Determine if this user is a super_admin connecting from a trusted IP
 	isSuperAdmin := false
 	if user.Role == "super_admin" {
 		if _, ok := s.UserStore.IsSuperAdminIP(clientIP); ok {
 			isSuperAdmin = true
-			ui.LogStatus("info", "HTTP super_admin verified: "+username+" from "+clientIP)
+			logStatus(r, "info", "HTTP super_admin verified: "+username+" from "+clientIP)
 		}
 	}
 
 	if !isSuperAdmin {
 		// Check rate limit
-		if !s.UserStore.CheckRateLimit(username) {
-			MetricRateLimited.WithLabelValues(username).Inc()
-			ui.LogStatus("warn", "Rate limited: "+username)
+		allowed := s.checkRateLimit(r, username)
+		retryAfter := s.setRateLimitHeaders(w.Header(), username)
+		if !allowed {
+			MetricRateLimited.WithLabelValues(proxy.UserLabel(username)).Inc()
+			logStatus(r, "warn", "Rate limited: "+username)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
 			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
 			return
 		}
 
 		// Check account expiry
 		if !s.UserStore.CheckExpiry(username) {
-			ui.LogStatus("warn", "Account expired: "+username)
+			logStatus(r, "warn", "Account expired: "+username)
 			http.Error(w, "Account Expired", http.StatusForbidden)
 			return
 		}
 
 		// Check bandwidth allowance
 		if s.Bandwidth != nil && !s.Bandwidth.CheckAllowance(username, user.BandwidthLimitGB) {
-			ui.LogStatus("warn", "Bandwidth exceeded: "+username)
+			logStatus(r, "warn", "Bandwidth exceeded: "+username)
 			http.Error(w, "Bandwidth Limit Exceeded", http.StatusForbidden)
 			return
 		}
 
 		// Check concurrent connection limit
 		if s.Bandwidth != nil && !s.Bandwidth.CheckConnLimit(username, user.MaxConnections) {
-			ui.LogStatus("warn", "Connection limit reached: "+username)
+			logStatus(r, "warn", "Connection limit reached: "+username)
 			http.Error(w, "Connection Limit Reached", http.StatusTooManyRequests)
 			return
 		}
@@ -257,24 +469,158 @@ func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 	// Track connection
 	MetricActiveConns.Inc()
 	defer MetricActiveConns.Dec()
+	s.recordUser(user.Username)
 
 	// Track per-user connection count
 	if s.Bandwidth != nil && user != nil {
 		s.Bandwidth.IncrementConns(user.Username)
 		defer s.Bandwidth.DecrementConns(user.Username)
+
+		// Admins can cut this request or tunnel via /api/connections/kick
+		ctx, release := s.Bandwidth.Kickable(r.Context(), user.Username)
+		defer release()
+		r = r.WithContext(ctx)
+	}
+
+	// Cap re-checked mid-tunnel (super_admin is exempt)
+	limitGB := 0
+	if !isSuperAdmin {
+		limitGB = user.BandwidthLimitGB
+	}
+
+	// Warn clients whose account expires soon
+	if !isSuperAdmin {
+		if expires := s.expiryWarning(user.Username); expires != "" {
+			w.Header().Set(auth.HeaderAccountExpires, expires)
+		}
 	}
 
 	// Handle the request based on method
 	if r.Method == http.MethodConnect {
-		s.handleConnect(w, r, user, startTime)
+		s.handleConnect(w, r, user, limitGB, startTime)
 	} else {
 		s.handleHTTP(w, r, user, startTime)
 	}
 }
 
-// handleConnect handles HTTPS tunneling via CONNECT method
-func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request, user *auth.User, startTime time.Time) {
-	MetricRequests.WithLabelValues(user.Username, "CONNECT").Inc()
+// connRateLimitKey is the context key for a client connection's
+// *connRateLimit.
+type connRateLimitKey struct{}
+
+// connRateLimit records which user has already been charged a rate limit
+// token on a client connection (RATE_LIMIT_MODE=per_connection).
+type connRateLimit struct {
+	mu   sync.Mutex
+	user string
+}
+
+// connContext gives every client connection its own connRateLimit and
+// request ID, applies TCP_NODELAY and, in
+// TRANSPARENT_MODE, records where a redirected connection was headed.
+func (s *Server) connContext(ctx context.Context, conn net.Conn) context.Context {
+	ctx = context.WithValue(ctx, connRateLimitKey{}, &connRateLimit{})
+	ctx = accesslog.WithID(ctx, accesslog.NewID())
+	dialer.SetNoDelay(conn, !s.Config.Env.DisableTCPNoDelay)
+	if s.Config.Env.TransparentMode {
+		ctx = withOriginalDst(ctx, conn)
+	}
+	return ctx
+}
+
+// logStatus logs message tagged with the request ID of r.
+func logStatus(r *http.Request, category, message string) {
+	ui.LogStatus(category, accesslog.Tag(accesslog.IDFrom(r.Context()), message))
+}
+
+// fromUnixSocket reports whether r arrived on a Unix socket listener.
+func fromUnixSocket(r *http.Request) bool {
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && local.Network() == "unix"
+}
+
+// checkRateLimit takes a rate limit token for the request. In per_connection
+// mode only the first allowed request of a user on a client connection is
+// charged, matching SOCKS5, which checks once per connection.
+func (s *Server) checkRateLimit(r *http.Request, username string) bool {
+	charged, _ := r.Context().Value(connRateLimitKey{}).(*connRateLimit)
+	if s.Config.Env.RateLimitMode != config.RateLimitPerConnection || charged == nil {
+		return s.UserStore.CheckRateLimit(username)
+	}
+
+	charged.mu.Lock()
+	defer charged.mu.Unlock()
+	if charged.user == username {
+		return true
+	}
+	if !s.UserStore.CheckRateLimit(username) {
+		return false
+	}
+	charged.user = username
+	return true
+}
+
+// setRateLimitHeaders adds X-RateLimit-Limit and X-RateLimit-Remaining for
+// users with a rate limit, and returns the whole seconds until their next
+// request is allowed (at least 1), for Retry-After.
+func (s *Server) setRateLimitHeaders(h http.Header, username string) int {
+	limit, remaining, retryAfter, ok := s.UserStore.RateLimitState(username)
+	if !ok {
+		return 1
+	}
+	h.Set(headerRateLimitLimit, strconv.Itoa(limit))
+	h.Set(headerRateLimitRemaining, strconv.Itoa(remaining))
+	return max(int(math.Ceil(retryAfter.Seconds())), 1)
+}
+
+// expiryWarning returns the user's expiry time for auth.HeaderAccountExpires
+// if it falls within the EXPIRY_WARNING_DAYS window, or "" otherwise.
+func (s *Server) expiryWarning(username string) string {
+	window := s.Config.Env.ExpiryWarningDays
+	if window <= 0 {
+		return ""
+	}
+	days, ok := s.UserStore.DaysUntilExpiry(username)
+	if !ok || days > window {
+		return ""
+	}
+	expiresAt, _ := s.UserStore.ExpiryTime(username)
+	return expiresAt.UTC().Format(time.RFC3339)
+}
+
+// listenPort returns the port of the first TCP listen address in addrs,
+// such as ":8080" or "127.0.0.1:8080". Unix sockets are skipped because PAC
+// clients cannot reach them.
+func listenPort(addrs []string) string {
+	addr := addrs[0]
+	for _, a := range addrs {
+		if !config.IsUnixAddr(a) {
+			addr = a
+			break
+		}
+	}
+	if _, port, err := net.SplitHostPort(addr); err == nil {
+		return port
+	}
+	return strings.TrimPrefix(addr, ":")
+}
+
+// isTimeout reports whether err is a network timeout, as opposed to e.g. a
+// refused connection.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// handleConnect handles HTTPS tunneling via CONNECT method. Usage is
+// recorded while the tunnel runs and it is cut once the user crosses
+// limitGB (0 = unlimited).
+func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request, user *auth.User, limitGB int, startTime time.Time) {
+	MetricRequests.WithLabelValues(proxy.UserLabel(user.Username), "CONNECT").Inc()
+
+	// Register with the drain group before hijacking, while http.Server
+	// still counts this request as active during Shutdown
+	s.tunnels.Add(1)
+	defer s.tunnels.Done()
 
 	// Get the target host
 	targetHost := r.Host
@@ -282,13 +628,22 @@ func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request, user *aut
 		targetHost = targetHost + ":443"
 	}
 
-	// Connect to target with TCP keep-alive to prevent mobile NAT drops
-	dialer := &net.Dialer{
-		Timeout:   30 * time.Second,
-		KeepAlive: 30 * time.Second,
+	// Enforce the CONNECT port allow-list (blocks e.g. SMTP relaying)
+	if !s.Config.Env.AllowsConnectTarget(targetHost) {
+		MetricErrors.WithLabelValues("port_blocked").Inc()
+		logStatus(r, "warn", "CONNECT port blocked: "+targetHost+" for "+user.Username)
+		http.Error(w, "Forbidden: port not allowed", http.StatusForbidden)
+		return
 	}
-	targetConn, err := dialer.Dial("tcp", targetHost)
+
+	// Connect to target with TCP keep-alive to prevent mobile NAT drops
+	targetConn, err := s.upstream.DialContext(r.Context(), "tcp", targetHost)
 	if err != nil {
+		if isTimeout(err) {
+			MetricErrors.WithLabelValues("dial_timeout").Inc()
+			http.Error(w, "Timed out connecting to target", http.StatusGatewayTimeout)
+			return
+		}
 		MetricErrors.WithLabelValues("dial_failed").Inc()
 		http.Error(w, "Failed to connect to target", http.StatusBadGateway)
 		return
@@ -311,21 +666,37 @@ func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request, user *aut
 	}
 	defer clientConn.Close()
 
+	s.trackTunnel(clientConn, targetConn)
+	defer s.untrackTunnel(clientConn)
+
 	// Enable TCP keep-alive on client side too (if underlying conn is TCP)
 	if tcpConn, ok := clientConn.(*net.TCPConn); ok {
 		tcpConn.SetKeepAlive(true)
-		tcpConn.SetKeepAlivePeriod(30 * time.Second)
+		tcpConn.SetKeepAlivePeriod(s.Config.Env.TCPKeepAlive())
 	}
 
 	// Send 200 Connection Established
-	clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	established := "HTTP/1.1 200 Connection Established\r\n"
+	for _, name := range []string{accesslog.HeaderRequestID, auth.HeaderAccountExpires, headerRateLimitLimit, headerRateLimitRemaining} {
+		if v := w.Header().Get(name); v != "" {
+			established += name + ": " + v + "\r\n"
+		}
+	}
+	clientConn.Write([]byte(established + "\r\n"))
 
-	// Apply optional speed throttle
+	// Meter the client side against the monthly cap
 	var relayClient, relayTarget net.Conn
 	relayClient = clientConn
 	relayTarget = targetConn
+	var quota *bandwidth.QuotaConn
+	if s.Bandwidth != nil {
+		quota = bandwidth.NewQuotaConn(clientConn, s.Bandwidth, user.Username, limitGB)
+		relayClient = quota
+	}
+
+	// Apply optional speed throttle
 	if user.BandwidthSpeedMbps > 0 {
-		relayClient = bandwidth.NewThrottledConn(clientConn, user.BandwidthSpeedMbps).(*bandwidth.ThrottledConn)
+		relayClient = bandwidth.NewThrottledConn(relayClient, user.BandwidthSpeedMbps).(*bandwidth.ThrottledConn)
 		relayTarget = bandwidth.NewThrottledConn(targetConn, user.BandwidthSpeedMbps).(*bandwidth.ThrottledConn)
 	}
 
@@ -335,8 +706,9 @@ func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request, user *aut
 
 	copyBuf := func(dst, src net.Conn, bytes *int64) {
 		defer func() { done <- struct{}{} }()
-		buf := make([]byte, 32*1024) // 32KB buffer for efficient relay
-		n, _ := io.CopyBuffer(dst, src, buf)
+		buf := bufpool.Relay.Get() // Pooled buffer (RELAY_BUFFER_SIZE)
+		defer bufpool.Relay.Put(buf)
+		n, _ := io.CopyBuffer(dst, src, *buf)
 		*bytes = n
 		// Half-close to signal the other side gracefully
 		if tc, ok := dst.(*net.TCPConn); ok {
@@ -344,6 +716,14 @@ func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request, user *aut
 		}
 	}
 
+	// A kick cancels the request context; unblock both directions
+	stopKick := context.AfterFunc(r.Context(), func() {
+		now := time.Now()
+		clientConn.SetDeadline(now)
+		targetConn.SetDeadline(now)
+	})
+	defer stopKick()
+
 	go copyBuf(relayTarget, relayClient, &upBytes)
 	go copyBuf(relayClient, relayTarget, &downBytes)
 
@@ -353,19 +733,24 @@ func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request, user *aut
 
 	// Record metrics
 	duration := time.Since(startTime).Seconds()
-	MetricBytes.WithLabelValues(user.Username, "upstream").Add(float64(upBytes))
-	MetricBytes.WithLabelValues(user.Username, "downstream").Add(float64(downBytes))
+	MetricBytes.WithLabelValues(proxy.UserLabel(user.Username), "upstream").Add(float64(upBytes))
+	MetricBytes.WithLabelValues(proxy.UserLabel(user.Username), "downstream").Add(float64(downBytes))
 	MetricDuration.Observe(duration)
-
-	// Record bandwidth usage for tracking
-	if s.Bandwidth != nil {
-		s.Bandwidth.RecordBytes(user.Username, upBytes, downBytes)
+	noteTunnel(w, upBytes, downBytes)
+
+	// Record the rest of the bandwidth usage
+	if quota != nil {
+		quota.Flush()
+		if quota.Exceeded() {
+			MetricErrors.WithLabelValues("bandwidth_exceeded").Inc()
+			logStatus(r, "warn", "Bandwidth exceeded mid-tunnel: "+user.Username)
+		}
 	}
 }
 
 // handleHTTP handles plain HTTP proxy requests
 func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request, user *auth.User, startTime time.Time) {
-	MetricRequests.WithLabelValues(user.Username, r.Method).Inc()
+	MetricRequests.WithLabelValues(proxy.UserLabel(user.Username), r.Method).Inc()
 
 	// Ensure absolute URL
 	if !r.URL.IsAbs() {
@@ -373,8 +758,24 @@ func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request, user *auth.U
 		return
 	}
 
+	// Optionally bound the whole exchange: with the server's
+	// Read/WriteTimeout off for CONNECT, nothing else limits a stalled
+	// client or target
+	ctx, cancel := r.Context(), context.CancelFunc(func() {})
+	if timeout := s.Config.Env.HTTPRequestTimeout(); timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
 	// Create outgoing request
-	outReq := r.Clone(r.Context())
+	outReq := r.Clone(ctx)
+
+	// Apply configured header rewrites before hop-by-hop stripping, so
+	// rules cannot reintroduce hop-by-hop headers
+	applyHeaderRules(s.Config.HeaderRules, outReq.URL.Hostname(), outReq.Header, false)
+
+	// Add or strip X-Forwarded-For / Via per HTTP_FORWARD_HEADERS
+	applyForwardHeaders(s.Config.Env.HTTPForwardHeaders, outReq.Header, r.RemoteAddr, r.Proto)
 
 	// Remove hop-by-hop headers
 	removeHopByHopHeaders(outReq.Header)
@@ -382,15 +783,21 @@ func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request, user *auth.U
 	// Remove Proxy-Authorization header
 	outReq.Header.Del("Proxy-Authorization")
 
-	// Perform the request
-	resp, err := s.transport.RoundTrip(outReq)
+	// Perform the request, counting its pooled connection as active
+	outReq, release := pool.trackActive(outReq)
+	defer release()
+	resp, err := s.transportFor(outReq.URL.Hostname()).RoundTrip(outReq)
 	if err != nil {
-		MetricErrors.WithLabelValues("request_failed").Inc()
-		http.Error(w, "Failed to reach target", http.StatusBadGateway)
+		failure := classifyUpstreamError(err)
+		MetricErrors.WithLabelValues(failure.label).Inc()
+		logStatus(r, "warn", "Upstream "+outReq.URL.Host+" failed ("+failure.label+"): "+err.Error())
+		http.Error(w, failure.message, failure.status)
 		return
 	}
 	defer resp.Body.Close()
 
+	applyHeaderRules(s.Config.HeaderRules, outReq.URL.Hostname(), resp.Header, true)
+
 	// Copy response headers
 	for k, vv := range resp.Header {
 		for _, v := range vv {
@@ -402,11 +809,17 @@ func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request, user *auth.U
 	w.WriteHeader(resp.StatusCode)
 
 	// Copy response body
-	written, _ := io.Copy(w, resp.Body)
+	buf := bufpool.Relay.Get()
+	defer bufpool.Relay.Put(buf)
+	written, _ := io.CopyBuffer(w, resp.Body, *buf)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		MetricErrors.WithLabelValues("request_timeout").Inc()
+		logStatus(r, "warn", "Request to "+outReq.URL.Host+" cut off after "+s.Config.Env.HTTPRequestTimeout().String())
+	}
 
 	// Record metrics
 	duration := time.Since(startTime).Seconds()
-	MetricBytes.WithLabelValues(user.Username, "downstream").Add(float64(written))
+	MetricBytes.WithLabelValues(proxy.UserLabel(user.Username), "downstream").Add(float64(written))
 	MetricDuration.Observe(duration)
 
 	// Record bandwidth usage for tracking
@@ -460,14 +873,17 @@ func removeHopByHopHeaders(h http.Header) {
 	}
 }
 
-// Shutdown gracefully stops the proxy server
-func (s *Server) Shutdown(ctx context.Context) error {
-	if s.httpServer != nil {
-		s.httpServer.Shutdown(ctx)
-	}
-	if s.httpsServer != nil {
-		s.httpsServer.Shutdown(ctx)
+// recordUser passes id to OnUser, if set.
+func (s *Server) recordUser(id string) {
+	if s.OnUser != nil {
+		s.OnUser(id)
 	}
+}
+
+// Shutdown gracefully stops the proxy server, force-closing any tunnels
+// still active when ctx expires.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stop(ctx)
 	s.wg.Wait()
 	return nil
 }
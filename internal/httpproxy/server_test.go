@@ -0,0 +1,891 @@
+package httpproxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"signal-proxy/internal/accesslog"
+	"signal-proxy/internal/auth"
+	"signal-proxy/internal/bandwidth"
+	"signal-proxy/internal/config"
+)
+
+// newTestUserStore writes a users.json with a single enabled user and loads it.
+func newTestUserStore(t *testing.T, username, password string) *auth.UserStore {
+	t.Helper()
+	return newTestUserStoreWithUser(t, auth.User{Username: username, Role: "user", Enabled: true}, password)
+}
+
+// newTestUserStoreWithUser writes a users.json holding u, with password
+// hashed into it, and loads it.
+func newTestUserStoreWithUser(t *testing.T, u auth.User, password string) *auth.UserStore {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.PasswordHash = string(hash)
+	data, err := json.Marshal(auth.UsersConfig{Users: []auth.User{u}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := auth.NewUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+	return store
+}
+
+// newTestProxy serves the proxy handler on an ephemeral port and returns the
+// server alongside its address.
+func newTestProxy(t *testing.T, env *config.EnvConfig) (*Server, string) {
+	t.Helper()
+	return newTestProxyWithConfig(t, &config.Config{Env: env})
+}
+
+// newTestProxyWithConfig is newTestProxy for callers needing a full Config.
+func newTestProxyWithConfig(t *testing.T, cfg *config.Config) (*Server, string) {
+	t.Helper()
+	srv := NewServer(cfg, newTestUserStore(t, "alice", "secret"), nil)
+	ts := httptest.NewServer(http.HandlerFunc(srv.handleRequest))
+	t.Cleanup(ts.Close)
+	return srv, ts.Listener.Addr().String()
+}
+
+// sendConnect issues an authenticated CONNECT for target and returns the
+// response status along with the (possibly tunnelled) connection.
+func sendConnect(t *testing.T, proxyAddr, target string) (int, net.Conn) {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", proxyAddr, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	creds := base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+	req := "CONNECT " + target + " HTTP/1.1\r\n" +
+		"Host: " + target + "\r\n" +
+		"Proxy-Authorization: Basic " + creds + "\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode, conn
+}
+
+// startTCPTarget listens on an ephemeral port, accepting and holding
+// connections until the test ends.
+func startTCPTarget(t *testing.T) (addr string, port int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { c.Close() })
+		}
+	}()
+	return ln.Addr().String(), ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestConnectAllowedPort(t *testing.T) {
+	target, port := startTCPTarget(t)
+	_, proxyAddr := newTestProxy(t, &config.EnvConfig{ConnectAllowedPorts: []int{443, port}})
+
+	status, _ := sendConnect(t, proxyAddr, target)
+	if status != http.StatusOK {
+		t.Fatalf("CONNECT to allowed port: status %d, want 200", status)
+	}
+}
+
+func TestConnectBlockedPort(t *testing.T) {
+	target, _ := startTCPTarget(t)
+	_, proxyAddr := newTestProxy(t, &config.EnvConfig{ConnectAllowedPorts: []int{443, 80}})
+
+	status, _ := sendConnect(t, proxyAddr, target)
+	if status != http.StatusForbidden {
+		t.Fatalf("CONNECT to blocked port: status %d, want 403", status)
+	}
+}
+
+func TestConnectEmptyAllowListAllowsAll(t *testing.T) {
+	target, _ := startTCPTarget(t)
+	_, proxyAddr := newTestProxy(t, &config.EnvConfig{})
+
+	status, _ := sendConnect(t, proxyAddr, target)
+	if status != http.StatusOK {
+		t.Fatalf("CONNECT with empty allow-list: status %d, want 200", status)
+	}
+}
+
+// proxiedGet fetches target through the proxy as alice with the given
+// request headers.
+func proxiedGet(t *testing.T, proxyAddr, target string, header http.Header) *http.Response {
+	t.Helper()
+	proxyURL, err := url.Parse("http://alice:secret@" + proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = header
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestHeaderRulesRewriteRequest(t *testing.T) {
+	received := make(chan http.Header, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		w.Header().Set("Server", "upstream")
+		w.Header().Set("X-Upstream-Secret", "leak")
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Env: &config.EnvConfig{},
+		HeaderRules: []config.HeaderRule{
+			{
+				MatchHost:     "127.0.0.*",
+				SetHeaders:    map[string]string{"User-Agent": "signal-proxy", "X-Added": "yes", "Connection": "keep-alive"},
+				RemoveHeaders: []string{"X-Debug-Token"},
+			},
+			{
+				MatchHost:  "*.example.com",
+				SetHeaders: map[string]string{"X-Unmatched": "yes"},
+			},
+			{
+				MatchHost:       "127.0.0.1",
+				RemoveHeaders:   []string{"X-Upstream-Secret"},
+				ApplyToResponse: true,
+			},
+		},
+	}
+	_, proxyAddr := newTestProxyWithConfig(t, cfg)
+
+	resp := proxiedGet(t, proxyAddr, upstream.URL+"/path", http.Header{"X-Debug-Token": {"abc"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want 200", resp.StatusCode)
+	}
+
+	var h http.Header
+	select {
+	case h = <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream never received the request")
+	}
+
+	if got := h.Get("User-Agent"); got != "signal-proxy" {
+		t.Errorf("User-Agent = %q, want %q", got, "signal-proxy")
+	}
+	if got := h.Get("X-Added"); got != "yes" {
+		t.Errorf("X-Added = %q, want %q", got, "yes")
+	}
+	if got := h.Get("X-Debug-Token"); got != "" {
+		t.Errorf("X-Debug-Token = %q, want removed", got)
+	}
+	if got := h.Get("X-Unmatched"); got != "" {
+		t.Errorf("X-Unmatched = %q, rule for another host was applied", got)
+	}
+	if got := h.Get("Proxy-Authorization"); got != "" {
+		t.Errorf("Proxy-Authorization leaked upstream: %q", got)
+	}
+	if got := h.Get("Connection"); got != "" {
+		t.Errorf("Connection = %q, hop-by-hop header reintroduced by rule", got)
+	}
+
+	if got := resp.Header.Get("X-Upstream-Secret"); got != "" {
+		t.Errorf("response X-Upstream-Secret = %q, want removed", got)
+	}
+	if got := resp.Header.Get("Server"); got != "upstream" {
+		t.Errorf("response Server = %q, want untouched", got)
+	}
+}
+
+func TestForwardHeaderModes(t *testing.T) {
+	inbound := http.Header{
+		"X-Forwarded-For": {"10.0.0.1"},
+		"X-Real-Ip":       {"10.0.0.1"},
+		"Forwarded":       {"for=10.0.0.1"},
+	}
+
+	tests := []struct {
+		mode    string
+		wantXFF string
+		wantVia string
+		gone    []string
+	}{
+		{mode: "none", wantXFF: "10.0.0.1"},
+		{mode: "standard", wantXFF: "10.0.0.1, 127.0.0.1", wantVia: "1.1 signal-proxy"},
+		{mode: "strip", gone: []string{"X-Real-Ip", "Forwarded"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			received := make(chan http.Header, 1)
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received <- r.Header.Clone()
+			}))
+			defer upstream.Close()
+
+			_, proxyAddr := newTestProxy(t, &config.EnvConfig{HTTPForwardHeaders: tt.mode})
+			proxiedGet(t, proxyAddr, upstream.URL, inbound.Clone())
+
+			h := <-received
+			if got := h.Get("X-Forwarded-For"); got != tt.wantXFF {
+				t.Errorf("X-Forwarded-For = %q, want %q", got, tt.wantXFF)
+			}
+			if got := h.Get("Via"); got != tt.wantVia {
+				t.Errorf("Via = %q, want %q", got, tt.wantVia)
+			}
+			for _, name := range tt.gone {
+				if got := h.Get(name); got != "" {
+					t.Errorf("%s = %q, want removed", name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxConnsRejectsExcessRequests(t *testing.T) {
+	_, proxyAddr := newTestProxyWithConfig(t, &config.Config{MaxConns: 1, Env: &config.EnvConfig{}})
+	target, _ := startTCPTarget(t)
+
+	// An open tunnel holds the only slot
+	if status, _ := sendConnect(t, proxyAddr, target); status != http.StatusOK {
+		t.Fatalf("first CONNECT status = %d, want 200", status)
+	}
+
+	for i := 0; i < 3; i++ {
+		if status, _ := sendConnect(t, proxyAddr, target); status != http.StatusServiceUnavailable {
+			t.Fatalf("CONNECT %d over the limit status = %d, want 503", i+1, status)
+		}
+	}
+}
+
+func TestExpiryWarningHeader(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	target, _ := startTCPTarget(t)
+
+	cases := []struct {
+		name    string
+		expires time.Duration
+		window  int
+		want    bool
+	}{
+		{"inside window", 3 * 24 * time.Hour, 7, true},
+		{"outside window", 30 * 24 * time.Hour, 7, false},
+		{"warning disabled", 3 * 24 * time.Hour, 0, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			expiresAt := time.Now().Add(tc.expires).UTC().Format(time.RFC3339)
+			store := newTestUserStoreWithUser(t, auth.User{
+				Username:  "alice",
+				Role:      "user",
+				Enabled:   true,
+				ExpiresAt: expiresAt,
+			}, "secret")
+			srv := NewServer(&config.Config{Env: &config.EnvConfig{ExpiryWarningDays: tc.window}}, store, nil)
+			ts := httptest.NewServer(http.HandlerFunc(srv.handleRequest))
+			defer ts.Close()
+			proxyAddr := ts.Listener.Addr().String()
+
+			want := ""
+			if tc.want {
+				want = expiresAt
+			}
+			resp := proxiedGet(t, proxyAddr, upstream.URL, http.Header{})
+			if got := resp.Header.Get(auth.HeaderAccountExpires); got != want {
+				t.Errorf("GET %s = %q, want %q", auth.HeaderAccountExpires, got, want)
+			}
+
+			conn, err := net.DialTimeout("tcp", proxyAddr, 2*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			creds := base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+			conn.Write([]byte("CONNECT " + target + " HTTP/1.1\r\nHost: " + target +
+				"\r\nProxy-Authorization: Basic " + creds + "\r\n\r\n"))
+			connectResp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := connectResp.Header.Get(auth.HeaderAccountExpires); got != want {
+				t.Errorf("CONNECT %s = %q, want %q", auth.HeaderAccountExpires, got, want)
+			}
+		})
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	store := newTestUserStoreWithUser(t, auth.User{
+		Username:     "alice",
+		Role:         "user",
+		Enabled:      true,
+		RateLimitRPM: 60, // burst of 10
+	}, "secret")
+	srv := NewServer(&config.Config{Env: &config.EnvConfig{}}, store, nil)
+	ts := httptest.NewServer(http.HandlerFunc(srv.handleRequest))
+	defer ts.Close()
+	proxyAddr := ts.Listener.Addr().String()
+
+	for i := 1; i <= 10; i++ {
+		resp := proxiedGet(t, proxyAddr, upstream.URL, http.Header{})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i, resp.StatusCode)
+		}
+		if got := resp.Header.Get("X-RateLimit-Limit"); got != "60" {
+			t.Errorf("request %d: X-RateLimit-Limit = %q, want 60", i, got)
+		}
+		if got, want := resp.Header.Get("X-RateLimit-Remaining"), strconv.Itoa(10-i); got != want {
+			t.Errorf("request %d: X-RateLimit-Remaining = %q, want %s", i, got, want)
+		}
+	}
+
+	resp := proxiedGet(t, proxyAddr, upstream.URL, http.Header{})
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("request beyond burst: status %d, want 429", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("429 X-RateLimit-Remaining = %q, want 0", got)
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || secs < 1 {
+		t.Errorf("429 Retry-After = %q, want a positive number of seconds", resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestRateLimitModes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	// start serves the proxy with per-connection contexts, as Start does
+	start := func(t *testing.T, mode string) string {
+		store := newTestUserStoreWithUser(t, auth.User{
+			Username:     "alice",
+			Role:         "user",
+			Enabled:      true,
+			RateLimitRPM: 60, // burst of 10
+		}, "secret")
+		srv := NewServer(&config.Config{Env: &config.EnvConfig{RateLimitMode: mode}}, store, nil)
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(srv.handleRequest))
+		ts.Config.ConnContext = srv.connContext
+		ts.Start()
+		t.Cleanup(ts.Close)
+		return ts.Listener.Addr().String()
+	}
+
+	// statuses sends n GETs through one client, reusing its connection
+	// unless keepAlive is false
+	statuses := func(t *testing.T, proxyAddr string, n int, keepAlive bool) []int {
+		proxyURL, _ := url.Parse("http://alice:secret@" + proxyAddr)
+		client := &http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableKeepAlives: !keepAlive},
+			Timeout:   5 * time.Second,
+		}
+		defer client.CloseIdleConnections()
+		var got []int
+		for i := 0; i < n; i++ {
+			resp, err := client.Get(upstream.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			got = append(got, resp.StatusCode)
+		}
+		return got
+	}
+
+	t.Run("per_request", func(t *testing.T) {
+		got := statuses(t, start(t, config.RateLimitPerRequest), 11, true)
+		if got[9] != http.StatusOK || got[10] != http.StatusTooManyRequests {
+			t.Errorf("statuses = %v, want 10 x 200 then 429 on one connection", got)
+		}
+	})
+
+	t.Run("per_connection", func(t *testing.T) {
+		proxyAddr := start(t, config.RateLimitPerConnection)
+		for i, status := range statuses(t, proxyAddr, 20, true) {
+			if status != http.StatusOK {
+				t.Fatalf("request %d on a reused connection: status %d, want 200", i+1, status)
+			}
+		}
+		// The kept-alive connection cost one token; 9 fresh ones remain
+		got := statuses(t, proxyAddr, 10, false)
+		if got[8] != http.StatusOK || got[9] != http.StatusTooManyRequests {
+			t.Errorf("statuses = %v, want 9 x 200 then 429 on new connections", got)
+		}
+	})
+}
+
+func TestRateLimitHeadersOmittedWhenUnlimited(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	_, proxyAddr := newTestProxy(t, &config.EnvConfig{})
+
+	resp := proxiedGet(t, proxyAddr, upstream.URL, http.Header{})
+	if got := resp.Header.Get("X-RateLimit-Limit"); got != "" {
+		t.Errorf("X-RateLimit-Limit = %q for an unlimited user, want none", got)
+	}
+}
+
+func TestDisabledUserDenied(t *testing.T) {
+	store := newTestUserStoreWithUser(t, auth.User{Username: "alice", Role: "user", Enabled: false}, "secret")
+	srv := NewServer(&config.Config{Env: &config.EnvConfig{}}, store, nil)
+	ts := httptest.NewServer(http.HandlerFunc(srv.handleRequest))
+	defer ts.Close()
+	proxyAddr := ts.Listener.Addr().String()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	if resp := proxiedGet(t, proxyAddr, upstream.URL, http.Header{}); resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Errorf("GET as disabled user: status %d, want 407", resp.StatusCode)
+	}
+
+	target, _ := startTCPTarget(t)
+	if status, _ := sendConnect(t, proxyAddr, target); status != http.StatusProxyAuthRequired {
+		t.Errorf("CONNECT as disabled user: status %d, want 407", status)
+	}
+}
+
+// refusedAddr returns an address with nothing listening on it.
+func refusedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestDialFailureStatus(t *testing.T) {
+	t.Run("refused", func(t *testing.T) {
+		_, proxyAddr := newTestProxy(t, &config.EnvConfig{})
+		target := refusedAddr(t)
+		if status, _ := sendConnect(t, proxyAddr, target); status != http.StatusBadGateway {
+			t.Errorf("CONNECT: status %d, want 502", status)
+		}
+		if resp := proxiedGet(t, proxyAddr, "http://"+target+"/", http.Header{}); resp.StatusCode != http.StatusBadGateway {
+			t.Errorf("GET: status %d, want 502", resp.StatusCode)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		srv, proxyAddr := newTestProxy(t, &config.EnvConfig{})
+		srv.upstream.Timeout = time.Nanosecond // every dial times out
+		target, _ := startTCPTarget(t)
+		if status, _ := sendConnect(t, proxyAddr, target); status != http.StatusGatewayTimeout {
+			t.Errorf("CONNECT: status %d, want 504", status)
+		}
+		if resp := proxiedGet(t, proxyAddr, "http://"+target+"/", http.Header{}); resp.StatusCode != http.StatusGatewayTimeout {
+			t.Errorf("GET: status %d, want 504", resp.StatusCode)
+		}
+	})
+}
+
+func TestHTTPRequestTimeout(t *testing.T) {
+	// The target accepts the request but never answers
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(slow.Close)
+	_, proxyAddr := newTestProxy(t, &config.EnvConfig{HTTPRequestTimeoutSec: 1})
+
+	start := time.Now()
+	resp := proxiedGet(t, proxyAddr, slow.URL, http.Header{})
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("GET: status %d, want 504", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("GET took %s, want about 1s", elapsed)
+	}
+
+	// CONNECT tunnels outlive the timeout
+	target, _ := startTCPTarget(t)
+	status, conn := sendConnect(t, proxyAddr, target)
+	if status != http.StatusOK {
+		t.Fatalf("CONNECT: status %d, want 200", status)
+	}
+	defer conn.Close()
+	time.Sleep(1500 * time.Millisecond)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); !isTimeout(err) {
+		t.Errorf("tunnel read after the request timeout = %v, want it still open", err)
+	}
+}
+
+// freePort returns a loopback address that was free a moment ago.
+func freePort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// waitListening blocks until something accepts TCP connections on addr.
+func waitListening(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c, err := net.Dial("tcp", addr)
+		if err == nil {
+			c.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("proxy never listened on %s: %v", addr, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStartWithBandwidthTracker(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello through the proxy"))
+	}))
+	t.Cleanup(origin.Close)
+
+	tracker := bandwidth.NewTracker(filepath.Join(t.TempDir(), "bandwidth_usage.json"))
+	t.Cleanup(tracker.Stop)
+	addr := freePort(t)
+	cfg := &config.Config{Env: &config.EnvConfig{HTTPProxyPort: addr}}
+	srv := NewServer(cfg, newTestUserStore(t, "alice", "secret"), tracker)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Start returned %v", err)
+		}
+	})
+
+	waitListening(t, addr)
+
+	resp := proxiedGet(t, addr, origin.URL, http.Header{})
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "hello through the proxy" {
+		t.Fatalf("GET via proxy = %d %q", resp.StatusCode, body)
+	}
+
+	// The handler records usage after the body is written; give it a moment
+	var usage bandwidth.UserUsage
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if usage = tracker.GetUsage("alice"); usage.BytesDown > 0 && usage.ActiveConns == 0 {
+			break
+		}
+	}
+	if usage.BytesDown != int64(len(body)) {
+		t.Errorf("BytesDown = %d, want %d", usage.BytesDown, len(body))
+	}
+	if usage.ActiveConns != 0 {
+		t.Errorf("ActiveConns = %d after the request finished, want 0", usage.ActiveConns)
+	}
+}
+
+func TestOnUserCalledForAuthenticatedRequests(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(origin.Close)
+
+	srv, addr := newTestProxy(t, &config.EnvConfig{})
+	seen := make(chan string, 1)
+	srv.OnUser = func(id string) { seen <- id }
+
+	resp := proxiedGet(t, addr, origin.URL, http.Header{})
+	resp.Body.Close()
+	select {
+	case id := <-seen:
+		if id != "alice" {
+			t.Errorf("OnUser got %q, want alice", id)
+		}
+	default:
+		t.Error("OnUser not called for an authenticated request")
+	}
+}
+
+func TestStartListensOnEveryPort(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(origin.Close)
+
+	addrs := []string{freePort(t), freePort(t)}
+	cfg := &config.Config{Env: &config.EnvConfig{HTTPProxyPort: strings.Join(addrs, ",")}}
+	srv := NewServer(cfg, newTestUserStore(t, "alice", "secret"), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Start returned %v", err)
+		}
+	})
+
+	for _, addr := range addrs {
+		waitListening(t, addr)
+		resp := proxiedGet(t, addr, origin.URL, http.Header{})
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+			t.Fatalf("GET via %s = %d %q", addr, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestShutdownReturnsStart(t *testing.T) {
+	addr := freePort(t)
+	srv := NewServer(&config.Config{Env: &config.EnvConfig{HTTPProxyPort: addr}}, newTestUserStore(t, "alice", "secret"), nil)
+
+	// The context is never cancelled; Shutdown alone must unblock Start
+	done := make(chan error, 1)
+	go func() { done <- srv.Start(context.Background()) }()
+	waitListening(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start returned %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Start still blocked after Shutdown")
+	}
+}
+
+// trickle writes data to conn one byte every interval until it is done or a
+// write fails.
+func trickle(conn net.Conn, data string, interval time.Duration) {
+	for i := 0; i < len(data); i++ {
+		if _, err := conn.Write([]byte{data[i]}); err != nil {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+func TestTricklingClientDropped(t *testing.T) {
+	addr := freePort(t)
+	cfg := &config.Config{Env: &config.EnvConfig{HTTPProxyPort: addr, HandshakeTimeoutSec: 1}}
+	srv := NewServer(cfg, newTestUserStore(t, "alice", "secret"), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	waitListening(t, addr)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go trickle(conn, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n", 100*time.Millisecond)
+
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); isTimeout(err) {
+		t.Fatal("connection still open after the handshake timeout")
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 3*time.Second {
+		t.Errorf("dropped after %s, want about 1s", elapsed)
+	}
+}
+
+// captureAccessLog sends access log records to a JSON file for the rest of
+// the test and returns a func that waits for n of them and decodes them.
+func captureAccessLog(t *testing.T) func(n int) []accesslog.Entry {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := accesslog.Open(path, accesslog.JSON, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accesslog.SetDefault(l)
+	t.Cleanup(func() {
+		accesslog.SetDefault(nil)
+		l.Close()
+	})
+	return func(n int) []accesslog.Entry {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			l.Flush()
+			data, _ := os.ReadFile(path)
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if len(lines) >= n && lines[0] != "" {
+				entries := make([]accesslog.Entry, len(lines))
+				for i, line := range lines {
+					if err := json.Unmarshal([]byte(line), &entries[i]); err != nil {
+						t.Fatalf("unparseable record %q: %v", line, err)
+					}
+				}
+				return entries
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d access log records, want %d", len(lines), n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestAccessLogRecordsRequests(t *testing.T) {
+	records := captureAccessLog(t)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	t.Cleanup(origin.Close)
+	// A tunnel target that answers one ping and hangs up
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		io.ReadFull(c, make([]byte, 4))
+		c.Write([]byte("pong"))
+		c.Close()
+	}()
+	targetAddr := ln.Addr().String()
+	_, proxyAddr := newTestProxy(t, &config.EnvConfig{})
+
+	resp := proxiedGet(t, proxyAddr, origin.URL+"/page", nil)
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	status, conn := sendConnect(t, proxyAddr, targetAddr)
+	if status != http.StatusOK {
+		t.Fatalf("CONNECT = %d", status)
+	}
+	conn.Write([]byte("ping"))
+	io.ReadFull(conn, make([]byte, 4))
+	conn.Close()
+
+	entries := records(2)
+	get, tunnel := entries[0], entries[1]
+	if get.Method == http.MethodConnect {
+		get, tunnel = tunnel, get
+	}
+	if get.Proxy != "http" || get.Client != "127.0.0.1" || get.User != "alice" ||
+		get.Target != origin.URL+"/page" || get.Status != http.StatusOK || get.BytesDown != 5 {
+		t.Errorf("GET record = %+v", get)
+	}
+	if tunnel.Method != http.MethodConnect || tunnel.User != "alice" || tunnel.Target != targetAddr ||
+		tunnel.Status != http.StatusOK || tunnel.BytesUp != 4 || tunnel.BytesDown != 4 {
+		t.Errorf("CONNECT record = %+v", tunnel)
+	}
+}
+
+func TestRequestIDMatchesAccessLog(t *testing.T) {
+	records := captureAccessLog(t)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	t.Cleanup(origin.Close)
+	srv := NewServer(&config.Config{Env: &config.EnvConfig{}}, newTestUserStore(t, "alice", "secret"), nil)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(srv.handleRequest))
+	ts.Config.ConnContext = srv.connContext
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	resp := proxiedGet(t, ts.Listener.Addr().String(), origin.URL+"/page", nil)
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	id := resp.Header.Get(accesslog.HeaderRequestID)
+	if !regexp.MustCompile(`^[0-9a-f]{8}$`).MatchString(id) {
+		t.Fatalf("%s = %q, want 8 hex characters", accesslog.HeaderRequestID, id)
+	}
+	if got := records(1)[0].ID; got != id {
+		t.Errorf("access log ID = %q, response header = %q", got, id)
+	}
+}
+
+func TestStartAddrInUse(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	cfg := &config.Config{Env: &config.EnvConfig{HTTPProxyPort: taken.Addr().String()}}
+	srv := NewServer(cfg, newTestUserStore(t, "alice", "secret"), nil)
+	if err := srv.Start(context.Background()); !errors.Is(err, config.ErrAddrInUse) {
+		t.Fatalf("Start on a bound port = %v, want ErrAddrInUse", err)
+	}
+}
@@ -0,0 +1,107 @@
+package httpproxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"signal-proxy/internal/auth"
+)
+
+// originalDst returns the address a client connected to before an iptables
+// REDIRECT or TPROXY rule sent it here. It is sockOriginalDst, swapped out
+// in tests.
+var originalDst = sockOriginalDst
+
+// origDstKey is the context key for the original destination of a
+// transparently intercepted client connection.
+type origDstKey struct{}
+
+// Address families in a Linux SO_ORIGINAL_DST reply
+const (
+	afInet  = 2
+	afInet6 = 10
+)
+
+// decodeOriginalDst parses the sockaddr_in or sockaddr_in6 returned by
+// SO_ORIGINAL_DST into host:port. The family is in host byte order, the
+// port in network byte order.
+func decodeOriginalDst(b []byte) (string, error) {
+	if len(b) < 4 {
+		return "", errors.New("original destination too short")
+	}
+	port := int(binary.BigEndian.Uint16(b[2:4]))
+	switch binary.NativeEndian.Uint16(b[0:2]) {
+	case afInet:
+		if len(b) < 8 {
+			return "", errors.New("sockaddr_in too short")
+		}
+		return net.JoinHostPort(net.IP(b[4:8]).String(), strconv.Itoa(port)), nil
+	case afInet6:
+		if len(b) < 24 {
+			return "", errors.New("sockaddr_in6 too short")
+		}
+		return net.JoinHostPort(net.IP(b[8:24]).String(), strconv.Itoa(port)), nil
+	}
+	return "", errors.New("unknown address family in original destination")
+}
+
+// withOriginalDst records conn's original destination in ctx when it was
+// redirected to us. A connection made to the proxy directly reports its own
+// local address, which must not be relayed to.
+func withOriginalDst(ctx context.Context, conn net.Conn) context.Context {
+	dst, err := originalDst(conn)
+	if err != nil || dst == conn.LocalAddr().String() {
+		return ctx
+	}
+	return context.WithValue(ctx, origDstKey{}, dst)
+}
+
+// transparentTarget returns where an intercepted request was originally
+// headed. Requests a client sent to us as a proxy, with an absolute URL or
+// CONNECT, are never transparent.
+func transparentTarget(r *http.Request) (string, bool) {
+	if r.Method == http.MethodConnect || r.URL.IsAbs() {
+		return "", false
+	}
+	dst, ok := r.Context().Value(origDstKey{}).(string)
+	return dst, ok
+}
+
+// ipWhitelister is implemented by authenticators that can tell whether
+// CheckIPAllowed restricts anyone, such as *auth.UserStore.
+type ipWhitelister interface {
+	HasIPWhitelist() bool
+}
+
+// handleTransparent relays a request intercepted by iptables to dst. There
+// is no Proxy-Authorization to check, so the IP whitelist is the only access
+// control, and usage is recorded under the client IP. Without a whitelist
+// (or an authenticator that can report one) it fails closed, since
+// otherwise anyone reaching the port could relay through the proxy.
+func (s *Server) handleTransparent(w http.ResponseWriter, r *http.Request, dst string, startTime time.Time) {
+	if wl, ok := s.UserStore.(ipWhitelister); !ok || !wl.HasIPWhitelist() {
+		MetricAuthFailures.WithLabelValues("ip_blocked").Inc()
+		logStatus(r, "warn", "Transparent request refused: no ip_whitelist configured")
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+
+	MetricActiveConns.Inc()
+	defer MetricActiveConns.Dec()
+	s.recordUser(client)
+
+	// Dial the intercepted address; the Host header still names the site
+	r.URL.Scheme = "http"
+	r.URL.Host = dst
+	s.handleHTTP(w, r, &auth.User{Username: client}, startTime)
+}
@@ -0,0 +1,49 @@
+//go:build linux
+
+package httpproxy
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// soOriginalDst is SO_ORIGINAL_DST from linux/netfilter_ipv4.h; the IPv6
+// option IP6T_SO_ORIGINAL_DST has the same value.
+const soOriginalDst = 80
+
+// sockOriginalDst asks netfilter for conn's pre-NAT destination.
+func sockOriginalDst(conn net.Conn) (string, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return "", errors.New("connection has no socket")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+
+	level := syscall.SOL_IP
+	if tcp, ok := conn.LocalAddr().(*net.TCPAddr); ok && tcp.IP.To4() == nil {
+		level = syscall.SOL_IPV6
+	}
+
+	var buf [syscall.SizeofSockaddrInet6]byte
+	size := uint32(len(buf))
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, uintptr(level), soOriginalDst,
+			uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 0)
+		if errno != 0 {
+			sockErr = errno
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if sockErr != nil {
+		return "", sockErr
+	}
+	return decodeOriginalDst(buf[:size])
+}
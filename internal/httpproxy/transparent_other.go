@@ -0,0 +1,14 @@
+//go:build !linux
+
+package httpproxy
+
+import (
+	"errors"
+	"net"
+)
+
+// sockOriginalDst always fails: SO_ORIGINAL_DST is Linux-only, and Validate
+// refuses TRANSPARENT_MODE elsewhere.
+func sockOriginalDst(net.Conn) (string, error) {
+	return "", errors.New("transparent mode requires Linux")
+}
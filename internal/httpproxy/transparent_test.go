@@ -0,0 +1,148 @@
+package httpproxy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"signal-proxy/internal/auth"
+	"signal-proxy/internal/config"
+)
+
+func TestDecodeOriginalDst(t *testing.T) {
+	in4 := make([]byte, 16)
+	binary.NativeEndian.PutUint16(in4[0:2], afInet)
+	binary.BigEndian.PutUint16(in4[2:4], 80)
+	copy(in4[4:8], net.ParseIP("10.0.0.5").To4())
+
+	in6 := make([]byte, 28)
+	binary.NativeEndian.PutUint16(in6[0:2], afInet6)
+	binary.BigEndian.PutUint16(in6[2:4], 8080)
+	copy(in6[8:24], net.ParseIP("2001:db8::1"))
+
+	tests := []struct {
+		name string
+		raw  []byte
+		want string
+	}{
+		{"ipv4", in4, "10.0.0.5:80"},
+		{"ipv6", in6, "[2001:db8::1]:8080"},
+	}
+	for _, tt := range tests {
+		got, err := decodeOriginalDst(tt.raw)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: decodeOriginalDst = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	if _, err := decodeOriginalDst(in4[:3]); err == nil {
+		t.Error("truncated sockaddr decoded without error")
+	}
+	bad := append([]byte(nil), in4...)
+	binary.NativeEndian.PutUint16(bad[0:2], 99)
+	if _, err := decodeOriginalDst(bad); err == nil {
+		t.Error("unknown family decoded without error")
+	}
+}
+
+// newTransparentProxy serves the proxy in TRANSPARENT_MODE with originalDst
+// faked by lookup. whitelist is the users.json ip_whitelist.
+func newTransparentProxy(t *testing.T, lookup func(net.Conn) (string, error), whitelist ...string) string {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("TRANSPARENT_MODE is Linux-only")
+	}
+	orig := originalDst
+	originalDst = lookup
+	t.Cleanup(func() { originalDst = orig })
+
+	data, err := json.Marshal(auth.UsersConfig{IPWhitelist: whitelist})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := auth.NewUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+
+	srv := NewServer(&config.Config{Env: &config.EnvConfig{TransparentMode: true}}, store, nil)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(srv.handleRequest))
+	ts.Config.ConnContext = srv.connContext
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts.Listener.Addr().String()
+}
+
+func TestTransparentRelaysToOriginalDst(t *testing.T) {
+	var gotHost string
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost = r.Host
+		w.Write([]byte("intercepted " + r.URL.Path))
+	}))
+	t.Cleanup(origin.Close)
+	originAddr := origin.Listener.Addr().String()
+
+	proxyAddr := newTransparentProxy(t, func(net.Conn) (string, error) { return originAddr, nil }, "127.0.0.1")
+
+	// As redirected by iptables: an origin-form request with no credentials
+	req, _ := http.NewRequest("GET", "http://"+proxyAddr+"/page", nil)
+	req.Host = "example.test"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK || string(body) != "intercepted /page" {
+		t.Fatalf("transparent GET = %d %q", resp.StatusCode, body)
+	}
+	if gotHost != "example.test" {
+		t.Errorf("origin saw Host %q, want example.test", gotHost)
+	}
+}
+
+func TestTransparentIgnoresDirectConnections(t *testing.T) {
+	// Without a redirect SO_ORIGINAL_DST reports the proxy's own address
+	proxyAddr := newTransparentProxy(t, func(c net.Conn) (string, error) { return c.LocalAddr().String(), nil }, "127.0.0.1")
+
+	resp, err := http.Get("http://" + proxyAddr + "/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Fatalf("direct origin-form request = %d, want %d", resp.StatusCode, http.StatusProxyAuthRequired)
+	}
+}
+
+func TestTransparentRequiresWhitelist(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request relayed without an ip_whitelist")
+	}))
+	t.Cleanup(origin.Close)
+	originAddr := origin.Listener.Addr().String()
+
+	// An empty ip_whitelist allows every IP, so it must not open a relay
+	proxyAddr := newTransparentProxy(t, func(net.Conn) (string, error) { return originAddr, nil })
+	resp, err := http.Get("http://" + proxyAddr + "/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("transparent GET without ip_whitelist = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,82 @@
+package httpproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"syscall"
+
+	"signal-proxy/internal/config"
+)
+
+// Non-standard statuses, as used by Cloudflare, for TLS trouble upstream
+const (
+	statusTLSHandshakeFailed = 525
+	statusInvalidCertificate = 526
+)
+
+// upstreamFailure describes why forwarding an HTTP request failed
+type upstreamFailure struct {
+	label   string // MetricErrors label
+	status  int
+	message string
+}
+
+// classifyUpstreamError sorts a RoundTrip error into DNS, refused, timeout,
+// TLS certificate and TLS handshake failures, so operators can tell which
+// upstreams misbehave and how.
+func classifyUpstreamError(err error) upstreamFailure {
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var unknownCA x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+
+	switch {
+	case errors.As(err, &dnsErr) && !dnsErr.IsTimeout:
+		return upstreamFailure{"dns_failed", http.StatusBadGateway, "Could not resolve target host"}
+	case isTimeout(err):
+		return upstreamFailure{"request_timeout", http.StatusGatewayTimeout, "Timed out reaching target"}
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return upstreamFailure{"connection_refused", http.StatusBadGateway, "Target refused the connection"}
+	case errors.As(err, &certErr), errors.As(err, &unknownCA), errors.As(err, &hostErr), errors.As(err, &invalidErr):
+		return upstreamFailure{"tls_cert_invalid", statusInvalidCertificate, "Target presented an invalid TLS certificate"}
+	case errors.As(err, &recordErr), errors.As(err, &alertErr):
+		return upstreamFailure{"tls_handshake_failed", statusTLSHandshakeFailed, "TLS handshake with target failed"}
+	}
+	return upstreamFailure{"request_failed", http.StatusBadGateway, "Failed to reach target"}
+}
+
+// upstreamTLSTransport clones base with rule's SNI and ALPN overrides. The
+// clone keeps its own connection pool so overridden handshakes are never
+// reused for other hosts.
+func upstreamTLSTransport(base *http.Transport, rule config.UpstreamTLSRule) *http.Transport {
+	t := base.Clone()
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	if rule.ServerName != "" {
+		t.TLSClientConfig.ServerName = rule.ServerName
+	}
+	if len(rule.ALPN) > 0 {
+		t.TLSClientConfig.NextProtos = slices.Clone(rule.ALPN)
+		t.ForceAttemptHTTP2 = slices.Contains(rule.ALPN, "h2")
+	}
+	return t
+}
+
+// transportFor returns the transport for an https:// target host: the one
+// for the first matching upstream_tls rule, or the shared default.
+func (s *Server) transportFor(host string) *http.Transport {
+	for i, rule := range s.Config.UpstreamTLS {
+		if rule.Matches(host) {
+			return s.tlsTransports[i]
+		}
+	}
+	return s.transport
+}
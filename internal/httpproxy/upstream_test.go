@@ -0,0 +1,184 @@
+package httpproxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"signal-proxy/internal/config"
+)
+
+// rawProxyGet sends an absolute-form GET for target straight to the proxy,
+// so https:// targets go through handleHTTP rather than CONNECT.
+func rawProxyGet(t *testing.T, proxyAddr, target string) int {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", proxyAddr, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	creds := base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+	req := "GET " + target + " HTTP/1.1\r\n" +
+		"Host: proxied\r\n" +
+		"Proxy-Authorization: Basic " + creds + "\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// startGarbageServer accepts connections and answers with bytes that are
+// not a TLS handshake.
+func startGarbageServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+			c.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestUpstreamFailureClasses(t *testing.T) {
+	selfSigned := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(selfSigned.Close)
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"dns", "http://no-such-host.invalid/", http.StatusBadGateway},
+		{"refused", "http://" + refusedAddr(t) + "/", http.StatusBadGateway},
+		{"untrusted certificate", selfSigned.URL + "/", statusInvalidCertificate},
+		{"not TLS", "https://" + startGarbageServer(t) + "/", statusTLSHandshakeFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, proxyAddr := newTestProxy(t, &config.EnvConfig{})
+			if got := rawProxyGet(t, proxyAddr, tt.target); got != tt.status {
+				t.Errorf("status = %d, want %d", got, tt.status)
+			}
+		})
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		srv, proxyAddr := newTestProxy(t, &config.EnvConfig{})
+		srv.upstream.Timeout = time.Nanosecond
+		target, _ := startTCPTarget(t)
+		if got := rawProxyGet(t, proxyAddr, "http://"+target+"/"); got != http.StatusGatewayTimeout {
+			t.Errorf("status = %d, want 504", got)
+		}
+	})
+}
+
+func TestClassifyUpstreamError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"dns", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "x.invalid", IsNotFound: true}}, "dns_failed"},
+		{"dns timeout", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "i/o timeout", Name: "x", IsTimeout: true}}, "request_timeout"},
+		{"refused", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, "connection_refused"},
+		{"deadline", context.DeadlineExceeded, "request_timeout"},
+		{"unknown CA", &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}, "tls_cert_invalid"},
+		{"hostname", x509.HostnameError{Host: "example.org", Certificate: &x509.Certificate{}}, "tls_cert_invalid"},
+		{"record header", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, "tls_handshake_failed"},
+		{"alert", tls.AlertError(40), "tls_handshake_failed"},
+		{"other", errors.New("malformed HTTP response"), "request_failed"},
+	}
+	for _, tt := range tests {
+		if got := classifyUpstreamError(tt.err).label; got != tt.want {
+			t.Errorf("%s: label %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUpstreamTLSOverrides(t *testing.T) {
+	cfg := &config.Config{
+		Env: &config.EnvConfig{},
+		UpstreamTLS: []config.UpstreamTLSRule{
+			{MatchHost: "*.example.com", ServerName: "edge.example.net", ALPN: []string{"h2", "http/1.1"}},
+			{MatchHost: "legacy.test", ALPN: []string{"http/1.1"}},
+		},
+	}
+	srv, _ := newTestProxyWithConfig(t, cfg)
+
+	api := srv.transportFor("api.example.com")
+	if api.TLSClientConfig == nil || api.TLSClientConfig.ServerName != "edge.example.net" {
+		t.Fatalf("api.example.com ServerName = %+v, want edge.example.net", api.TLSClientConfig)
+	}
+	if got := api.TLSClientConfig.NextProtos; len(got) != 2 || got[0] != "h2" || !api.ForceAttemptHTTP2 {
+		t.Errorf("api.example.com ALPN = %v (h2 forced %v)", got, api.ForceAttemptHTTP2)
+	}
+
+	legacy := srv.transportFor("legacy.test")
+	if legacy.TLSClientConfig.ServerName != "" || legacy.ForceAttemptHTTP2 {
+		t.Errorf("legacy.test ServerName %q, h2 forced %v; want defaults", legacy.TLSClientConfig.ServerName, legacy.ForceAttemptHTTP2)
+	}
+
+	if other := srv.transportFor("other.org"); other != srv.transport {
+		t.Error("unmatched host did not get the shared transport")
+	}
+	if srv.transport.TLSClientConfig != nil {
+		t.Error("overrides leaked into the shared transport")
+	}
+}
+
+func TestUpstreamTLSOverrideSendsServerName(t *testing.T) {
+	sni := make(chan string, 1)
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	origin.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			sni <- hello.ServerName
+			return nil, nil
+		},
+	}
+	origin.StartTLS()
+	t.Cleanup(origin.Close)
+
+	cfg := &config.Config{
+		Env:         &config.EnvConfig{},
+		UpstreamTLS: []config.UpstreamTLSRule{{MatchHost: "127.0.0.1", ServerName: "pinned.example"}},
+	}
+	_, proxyAddr := newTestProxyWithConfig(t, cfg)
+
+	// The test CA is untrusted, so the request fails after the ClientHello
+	rawProxyGet(t, proxyAddr, origin.URL+"/")
+	select {
+	case got := <-sni:
+		if got != "pinned.example" {
+			t.Fatalf("upstream saw SNI %q, want pinned.example", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream never received a ClientHello")
+	}
+}
@@ -1,15 +1,21 @@
 package pac
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"signal-proxy/internal/auth"
+	"signal-proxy/internal/config"
 	"signal-proxy/internal/ui"
 )
 
@@ -22,12 +28,16 @@ type Config struct {
 	Token          string // Optional secret token for access control
 	DefaultUser    string // Default username if no user param provided
 	RateLimitRPM   int    // Rate limit for PAC endpoint
+	AllowedOrigin  string // CORS Access-Control-Allow-Origin, "*" if empty
+	DirectHosts    []string // Extra DIRECT rules: host globs ("*.corp.local") or IPv4 CIDRs
+	SigningKey     string   // HMAC key for signed, expiring PAC URLs (empty = disabled)
+	BaseURL        string   // Public base URL used by GenerateSignedPACURL
 }
 
 // Handler creates an HTTP handler for the PAC endpoint
 type Handler struct {
 	config    *Config
-	userStore *auth.UserStore
+	userStore auth.Authenticator
 
 	// Rate limiting
 	rateMu      sync.Mutex
@@ -36,7 +46,7 @@ type Handler struct {
 }
 
 // NewHandler creates a new PAC handler
-func NewHandler(cfg *Config, userStore *auth.UserStore) *Handler {
+func NewHandler(cfg *Config, userStore auth.Authenticator) *Handler {
 	return &Handler{
 		config:     cfg,
 		userStore:  userStore,
@@ -47,7 +57,7 @@ func NewHandler(cfg *Config, userStore *auth.UserStore) *Handler {
 
 // ServeHTTP handles PAC file requests
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	clientIP := getClientIP(r)
+	clientIP := auth.RealClientIP(r, r.RemoteAddr)
 
 	// Rate limiting
 	if h.config.RateLimitRPM > 0 && !h.checkRateLimit(clientIP) {
@@ -56,6 +66,12 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Signed, expiring URLs replace both the token and the plaintext password
+	if r.URL.Query().Has("sig") {
+		h.serveSignedPAC(w, r, clientIP)
+		return
+	}
+
 	// Token-based access control (if configured)
 	if h.config.Token != "" {
 		token := r.URL.Query().Get("token")
@@ -85,7 +101,8 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	password := r.URL.Query().Get("pass")
 	if password == "" {
 		// Generate PAC with placeholder - user must provide password via query param
-		// This is the safest approach since we can't reverse bcrypt hashes
+		// This is the safest approach since we can't reverse bcrypt hashes.
+		// Nothing is verified here, so the account expiry is not disclosed
 		h.sendPACWithPlaceholder(w, username)
 		return
 	}
@@ -99,30 +116,100 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Generate PAC with embedded credentials
+	h.setExpiryHeader(w, username)
 	pac := h.generatePAC(username, password)
 	h.sendPAC(w, pac)
 
 	ui.LogStatus("info", "PAC served for user: "+username+" from "+clientIP)
 }
 
+// serveSignedPAC verifies a ?user=&exp=&sig= link and serves the user's PAC.
+// Only bcrypt hashes are stored, so the PAC names the proxy without embedding
+// a password; the client is prompted for credentials as in placeholder mode.
+func (h *Handler) serveSignedPAC(w http.ResponseWriter, r *http.Request, clientIP string) {
+	q := r.URL.Query()
+	username := strings.ToLower(q.Get("user"))
+	if err := h.verifySignature(username, q.Get("exp"), q.Get("sig")); err != nil {
+		ui.LogStatus("warn", "PAC signed URL rejected from "+clientIP+": "+err.Error())
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user := h.userStore.GetUser(username)
+	if user == nil || !user.Enabled || !h.userStore.CheckExpiry(username) {
+		ui.LogStatus("warn", "PAC signed URL for unknown or inactive user: "+username)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h.setExpiryHeader(w, user.Username)
+	h.sendPACWithPlaceholder(w, user.Username)
+	ui.LogStatus("info", "PAC served (signed) for user: "+user.Username+" from "+clientIP)
+}
+
+// GenerateSignedPACURL returns a PAC link for username that expires after ttl.
+func (h *Handler) GenerateSignedPACURL(username string, ttl time.Duration) (string, error) {
+	return SignedURL(h.config.BaseURL, h.config.SigningKey, username, ttl)
+}
+
+// SignedURL returns a PAC link under baseURL for username, signed with
+// signingKey (PAC_SIGNING_KEY), that expires after ttl. It backs
+// signal-proxy --pac-url.
+func SignedURL(baseURL, signingKey, username string, ttl time.Duration) (string, error) {
+	if signingKey == "" {
+		return "", fmt.Errorf("PAC signing key not configured")
+	}
+	username = strings.ToLower(username)
+	exp := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+
+	q := url.Values{}
+	q.Set("user", username)
+	q.Set("exp", exp)
+	q.Set("sig", sign(signingKey, username, exp))
+	return strings.TrimSuffix(baseURL, "/") + "/proxy.pac?" + q.Encode(), nil
+}
+
+// sign computes the hex HMAC-SHA256 of username and expiry under key.
+func sign(key, username, exp string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(username + "\n" + exp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature checks sig matches and exp (unix seconds) is in the future.
+func (h *Handler) verifySignature(username, exp, sig string) error {
+	if h.config.SigningKey == "" {
+		return fmt.Errorf("signed URLs not enabled")
+	}
+	if username == "" || exp == "" || sig == "" {
+		return fmt.Errorf("missing user, exp or sig")
+	}
+	want := sign(h.config.SigningKey, username, exp)
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return fmt.Errorf("bad signature for user %s", username)
+	}
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expiry")
+	}
+	if time.Now().Unix() >= expUnix {
+		return fmt.Errorf("link expired for user %s", username)
+	}
+	return nil
+}
+
 // generatePAC creates the PAC file content with embedded credentials
 func (h *Handler) generatePAC(username, password string) string {
-	proxyURL := fmt.Sprintf("%s:%s@%s:%s",
-		username, password, h.config.ProxyHost, h.config.HTTPPort)
+	proxyURL := fmt.Sprintf("%s:%s@%s",
+		username, password, h.proxyAddr(h.config.HTTPPort))
 
 	// SOCKS5 proxy URL (for SOCKS-capable clients)
-	socks5URL := fmt.Sprintf("%s:%s@%s:%s",
-		username, password, h.config.ProxyHost, h.config.SOCKS5Port)
+	socks5URL := fmt.Sprintf("%s:%s@%s",
+		username, password, h.proxyAddr(h.config.SOCKS5Port))
 
 	return fmt.Sprintf(`function FindProxyForURL(url, host) {
     // Don't proxy local addresses
-    if (isPlainHostName(host) ||
-        shExpMatch(host, "*.local") ||
-        isInNet(host, "192.168.0.0", "255.255.0.0") ||
-        isInNet(host, "10.0.0.0", "255.0.0.0") ||
-        isInNet(host, "172.16.0.0", "255.240.0.0") ||
-        host == "localhost" ||
-        host == "127.0.0.1") {
+    if (%s) {
         return "DIRECT";
     }
     
@@ -130,7 +217,12 @@ func (h *Handler) generatePAC(username, password string) string {
     // Primary: HTTP/HTTPS proxy, Fallback: SOCKS5
     return "PROXY %s; SOCKS5 %s; DIRECT";
 }
-`, proxyURL, socks5URL)
+`, h.directCondition(), proxyURL, socks5URL)
+}
+
+// proxyAddr joins ProxyHost and port, bracketing an IPv6 ProxyHost.
+func (h *Handler) proxyAddr(port string) string {
+	return net.JoinHostPort(strings.Trim(h.config.ProxyHost, "[]"), port)
 }
 
 // sendPACWithPlaceholder sends a PAC file with placeholders for credentials
@@ -140,24 +232,47 @@ func (h *Handler) sendPACWithPlaceholder(w http.ResponseWriter, username string)
     // Note: This PAC requires authentication. Your browser/system will prompt for password.
     
     // Don't proxy local addresses
-    if (isPlainHostName(host) ||
-        shExpMatch(host, "*.local") ||
-        isInNet(host, "192.168.0.0", "255.255.0.0") ||
-        isInNet(host, "10.0.0.0", "255.0.0.0") ||
-        isInNet(host, "172.16.0.0", "255.240.0.0") ||
-        host == "localhost" ||
-        host == "127.0.0.1") {
+    if (%s) {
         return "DIRECT";
     }
     
     // Route everything else through proxy (credentials required separately)
-    return "PROXY %s:%s; SOCKS5 %s:%s; DIRECT";
+    return "PROXY %s; SOCKS5 %s; DIRECT";
 }
-`, username, h.config.ProxyHost, h.config.HTTPPort, h.config.ProxyHost, h.config.SOCKS5Port)
+`, username, h.directCondition(), h.proxyAddr(h.config.HTTPPort), h.proxyAddr(h.config.SOCKS5Port))
 
 	h.sendPAC(w, pac)
 }
 
+// directCondition renders the JS condition for hosts that bypass the proxy:
+// the built-in private ranges followed by any configured DirectHosts.
+// LoadEnv already reports and drops invalid entries; any that reach here are
+// skipped rather than written into the JavaScript.
+func (h *Handler) directCondition() string {
+	clauses := []string{
+		`isPlainHostName(host)`,
+		`shExpMatch(host, "*.local")`,
+		`isInNet(host, "192.168.0.0", "255.255.0.0")`,
+		`isInNet(host, "10.0.0.0", "255.0.0.0")`,
+		`isInNet(host, "172.16.0.0", "255.240.0.0")`,
+		`host == "localhost"`,
+		`host == "127.0.0.1"`,
+	}
+	for _, entry := range h.config.DirectHosts {
+		if config.ValidateDirectHost(entry) != nil {
+			continue
+		}
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			clauses = append(clauses, fmt.Sprintf(`isInNet(host, "%s", "%s")`,
+				ipNet.IP.String(), net.IP(ipNet.Mask).String()))
+			continue
+		}
+		clauses = append(clauses, fmt.Sprintf(`shExpMatch(host, "%s")`, entry))
+	}
+	return strings.Join(clauses, " ||\n        ")
+}
+
 // sendErrorPAC sends a PAC file that returns DIRECT with an error comment
 func (h *Handler) sendErrorPAC(w http.ResponseWriter, message string) {
 	pac := fmt.Sprintf(`// Error: %s
@@ -169,6 +284,17 @@ function FindProxyForURL(url, host) {
 	h.sendPAC(w, pac)
 }
 
+// setExpiryHeader adds auth.HeaderAccountExpires when username has an
+// account expiry.
+func (h *Handler) setExpiryHeader(w http.ResponseWriter, username string) {
+	if h.userStore == nil {
+		return
+	}
+	if expiresAt, ok := h.userStore.ExpiryTime(username); ok {
+		w.Header().Set(auth.HeaderAccountExpires, expiresAt.UTC().Format(time.RFC3339))
+	}
+}
+
 // sendPAC sends the PAC content with proper headers
 func (h *Handler) sendPAC(w http.ResponseWriter, content string) {
 	// Set proper content type for PAC files
@@ -179,7 +305,11 @@ func (h *Handler) sendPAC(w http.ResponseWriter, content string) {
 	w.Header().Set("Cache-Control", "public, max-age=300")
 
 	// CORS headers for browser compatibility
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	origin := h.config.AllowedOrigin
+	if origin == "" {
+		origin = "*"
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
@@ -211,30 +341,6 @@ func (h *Handler) checkRateLimit(clientIP string) bool {
 	return false
 }
 
-// getClientIP extracts the client IP from the request
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header (for proxied requests)
-	forwarded := r.Header.Get("X-Forwarded-For")
-	if forwarded != "" {
-		// Take the first IP in the chain
-		parts := strings.Split(forwarded, ",")
-		return strings.TrimSpace(parts[0])
-	}
-
-	// Check X-Real-IP header
-	realIP := r.Header.Get("X-Real-IP")
-	if realIP != "" {
-		return realIP
-	}
-
-	// Fall back to RemoteAddr
-	host := r.RemoteAddr
-	if idx := strings.LastIndex(host, ":"); idx != -1 {
-		host = host[:idx]
-	}
-	return host
-}
-
 // GenerateToken creates a random access token (utility function)
 func GenerateToken() (string, error) {
 	bytes := make([]byte, 16)
@@ -0,0 +1,360 @@
+package pac
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"signal-proxy/internal/auth"
+)
+
+func servePAC(t *testing.T, cfg *Config, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	h := NewHandler(cfg, nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestPACAllowedOrigin(t *testing.T) {
+	cfg := &Config{ProxyHost: "proxy.example.com", HTTPPort: "8080", SOCKS5Port: "1080", AllowedOrigin: "https://app.example.com"}
+	rec := servePAC(t, cfg, "/proxy.pac?user=alice")
+
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, "https://app.example.com")
+	}
+}
+
+func TestPACAllowedOriginDefaultsToWildcard(t *testing.T) {
+	rec := servePAC(t, &Config{ProxyHost: "proxy.example.com"}, "/proxy.pac")
+
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, "*")
+	}
+}
+
+// checkPACStructure is a light structural check: outside string literals,
+// braces and parentheses must balance and every string must be closed.
+func checkPACStructure(t *testing.T, js string) {
+	t.Helper()
+	var stack []rune
+	pairs := map[rune]rune{')': '(', '}': '{'}
+	inString, inComment := false, false
+	for i, c := range js {
+		switch {
+		case inComment:
+			if c == '\n' {
+				inComment = false
+			}
+		case inString:
+			if c == '"' {
+				inString = false
+			}
+		case c == '/' && strings.HasPrefix(js[i:], "//"):
+			inComment = true
+		case c == '"':
+			inString = true
+		case c == '(' || c == '{':
+			stack = append(stack, c)
+		case c == ')' || c == '}':
+			if len(stack) == 0 || stack[len(stack)-1] != pairs[c] {
+				t.Fatalf("unbalanced %q at offset %d:\n%s", c, i, js)
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if inString || len(stack) != 0 {
+		t.Fatalf("unterminated string or bracket:\n%s", js)
+	}
+	if !strings.HasPrefix(strings.TrimSpace(js), "function FindProxyForURL(url, host) {") {
+		t.Fatalf("missing FindProxyForURL:\n%s", js)
+	}
+}
+
+func TestPACDirectHosts(t *testing.T) {
+	cfg := &Config{
+		ProxyHost:   "proxy.example.com",
+		HTTPPort:    "8080",
+		SOCKS5Port:  "1080",
+		DirectHosts: []string{"*.Corp.local", "10.20.0.0/16", "intranet.example.com", `evil"); alert(1); ("`, "fd00::/8"},
+	}
+	h := NewHandler(cfg, nil)
+
+	wantClauses := []string{
+		`shExpMatch(host, "*.corp.local")`,
+		`isInNet(host, "10.20.0.0", "255.255.0.0")`,
+		`shExpMatch(host, "intranet.example.com")`,
+		`isInNet(host, "192.168.0.0", "255.255.0.0")`, // built-in bypass kept
+	}
+
+	for name, js := range map[string]string{
+		"credentials": h.generatePAC("alice", "secret"),
+		"placeholder": func() string {
+			rec := httptest.NewRecorder()
+			h.sendPACWithPlaceholder(rec, "alice")
+			return rec.Body.String()
+		}(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			for _, clause := range wantClauses {
+				if !strings.Contains(js, clause) {
+					t.Errorf("PAC missing %s:\n%s", clause, js)
+				}
+			}
+			if strings.Contains(js, "alert") || strings.Contains(js, "fd00") {
+				t.Errorf("invalid DirectHosts entry rendered:\n%s", js)
+			}
+			checkPACStructure(t, js)
+		})
+	}
+}
+
+// newTestUserStore writes a users.json with the given users and loads it.
+func newTestUserStore(t *testing.T, users ...auth.User) *auth.UserStore {
+	t.Helper()
+	data, err := json.Marshal(auth.UsersConfig{Users: users})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := auth.NewUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+	return store
+}
+
+func newSignedHandler(t *testing.T) *Handler {
+	t.Helper()
+	store := newTestUserStore(t,
+		auth.User{Username: "alice", Role: "user", Enabled: true},
+		auth.User{Username: "bob", Role: "user", Enabled: true, ExpiresAt: "2000-01-01T00:00:00Z"},
+	)
+	return NewHandler(&Config{
+		ProxyHost:  "proxy.example.com",
+		HTTPPort:   "8080",
+		SOCKS5Port: "1080",
+		Token:      "legacy-token",
+		SigningKey: "test-signing-key",
+		BaseURL:    "https://proxy.example.com/",
+	}, store)
+}
+
+// serveURL requests a URL (absolute or path) against h.
+func serveURL(h *Handler, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestSignedPACURLValid(t *testing.T) {
+	h := newSignedHandler(t)
+	link, err := h.GenerateSignedPACURL("Alice", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(link, "https://proxy.example.com/proxy.pac?") {
+		t.Fatalf("link = %q, want it under BaseURL", link)
+	}
+	if strings.Contains(link, "pass=") || strings.Contains(link, "token=") {
+		t.Fatalf("link leaks a secret: %q", link)
+	}
+
+	// The signature replaces the token, so no ?token= is needed
+	rec := serveURL(h, link)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "PAC file for user: alice") ||
+		!strings.Contains(body, "PROXY proxy.example.com:8080") {
+		t.Errorf("unexpected PAC body:\n%s", body)
+	}
+}
+
+func TestSignedPACURLExpired(t *testing.T) {
+	h := newSignedHandler(t)
+	link, err := h.GenerateSignedPACURL("alice", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := serveURL(h, link); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expired link: status %d, want 401", rec.Code)
+	}
+}
+
+func TestSignedPACURLTampered(t *testing.T) {
+	h := newSignedHandler(t)
+	link, err := h.GenerateSignedPACURL("alice", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tamper := map[string]func(q url.Values){
+		"user": func(q url.Values) { q.Set("user", "bob") },
+		"exp": func(q url.Values) {
+			q.Set("exp", strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10))
+		},
+		"sig":     func(q url.Values) { q.Set("sig", strings.Repeat("0", 64)) },
+		"missing": func(q url.Values) { q.Set("sig", "") },
+	}
+	for name, mutate := range tamper {
+		t.Run(name, func(t *testing.T) {
+			q := u.Query()
+			mutate(q)
+			if rec := serveURL(h, "/proxy.pac?"+q.Encode()); rec.Code != http.StatusUnauthorized {
+				t.Fatalf("status %d, want 401", rec.Code)
+			}
+		})
+	}
+}
+
+func TestSignedPACURLInactiveUser(t *testing.T) {
+	h := newSignedHandler(t)
+	for _, user := range []string{"bob", "nobody"} {
+		link, err := h.GenerateSignedPACURL(user, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec := serveURL(h, link); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", user, rec.Code)
+		}
+	}
+}
+
+func TestSignedPACURLFallbackToToken(t *testing.T) {
+	h := newSignedHandler(t)
+	if rec := serveURL(h, "/proxy.pac?user=alice"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no token: status %d, want 401", rec.Code)
+	}
+	if rec := serveURL(h, "/proxy.pac?user=alice&token=legacy-token"); rec.Code != http.StatusOK {
+		t.Errorf("legacy token: status %d, want 200", rec.Code)
+	}
+
+	noKey := NewHandler(&Config{ProxyHost: "proxy.example.com"}, nil)
+	if _, err := noKey.GenerateSignedPACURL("alice", time.Hour); err == nil {
+		t.Error("GenerateSignedPACURL without a key should fail")
+	}
+	if rec := serveURL(noKey, "/proxy.pac?user=alice&exp=1&sig=abc"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("signed request without key: status %d, want 401", rec.Code)
+	}
+}
+
+func TestSignedURLServedByHandler(t *testing.T) {
+	// As printed by signal-proxy --pac-url
+	link, err := SignedURL("https://proxy.example.com", "test-signing-key", "alice", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := serveURL(newSignedHandler(t), link); rec.Code != http.StatusOK {
+		t.Errorf("SignedURL link: status %d, want 200", rec.Code)
+	}
+	if _, err := SignedURL("https://proxy.example.com", "", "alice", time.Hour); err == nil {
+		t.Error("SignedURL without a key should fail")
+	}
+}
+
+func TestPACIncludesAccountExpiry(t *testing.T) {
+	hash, err := auth.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newTestUserStore(t,
+		auth.User{Username: "alice", Role: "user", PasswordHash: hash, Enabled: true, ExpiresAt: "2030-06-01T00:00:00Z"},
+		auth.User{Username: "carol", Role: "user", PasswordHash: hash, Enabled: true},
+	)
+	h := NewHandler(&Config{ProxyHost: "proxy.example.com"}, store)
+
+	if got := serveURL(h, "/proxy.pac?user=alice&pass=secret").Header().Get(auth.HeaderAccountExpires); got != "2030-06-01T00:00:00Z" {
+		t.Errorf("%s = %q, want the account expiry", auth.HeaderAccountExpires, got)
+	}
+	if got := serveURL(h, "/proxy.pac?user=carol&pass=secret").Header().Get(auth.HeaderAccountExpires); got != "" {
+		t.Errorf("%s = %q for an account with no expiry, want none", auth.HeaderAccountExpires, got)
+	}
+
+	// Unauthenticated requests must not reveal the expiry, or which
+	// usernames exist
+	for _, target := range []string{"/proxy.pac?user=alice", "/proxy.pac?user=alice&pass=wrong"} {
+		if got := serveURL(h, target).Header().Get(auth.HeaderAccountExpires); got != "" {
+			t.Errorf("%s: %s = %q without valid credentials, want none", target, auth.HeaderAccountExpires, got)
+		}
+	}
+}
+
+func TestPACRateLimitIPv6Clients(t *testing.T) {
+	h := NewHandler(&Config{ProxyHost: "proxy.example.com", RateLimitRPM: 1}, nil)
+	get := func(remote string) int {
+		r := httptest.NewRequest(http.MethodGet, "/proxy.pac", nil)
+		r.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	if code := get("[2001:db8::1]:1000"); code != http.StatusOK {
+		t.Fatalf("first request = %d, want 200", code)
+	}
+	// Same client on a new source port shares the bucket
+	if code := get("[2001:db8::1]:2000"); code != http.StatusTooManyRequests {
+		t.Errorf("second request from the same IPv6 client = %d, want 429", code)
+	}
+	if code := get("[2001:db8::2]:1000"); code != http.StatusOK {
+		t.Errorf("request from another IPv6 client = %d, want 200", code)
+	}
+}
+
+func TestPACBracketsIPv6ProxyHost(t *testing.T) {
+	for _, host := range []string{"2001:db8::10", "[2001:db8::10]"} {
+		rec := servePAC(t, &Config{ProxyHost: host, HTTPPort: "8080", SOCKS5Port: "1080"}, "/proxy.pac?user=alice")
+		if body := rec.Body.String(); !strings.Contains(body, `"PROXY [2001:db8::10]:8080; SOCKS5 [2001:db8::10]:1080; DIRECT"`) {
+			t.Errorf("ProxyHost %q: PAC does not bracket the address:\n%s", host, body)
+		}
+	}
+}
+
+func TestPACRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	auth.SetTrustedProxies([]string{"10.0.0.0/8"})
+	t.Cleanup(func() { auth.SetTrustedProxies(nil) })
+	h := NewHandler(&Config{ProxyHost: "proxy.example.com", RateLimitRPM: 1}, nil)
+	get := func(remote, forwardedFor string) int {
+		r := httptest.NewRequest(http.MethodGet, "/proxy.pac", nil)
+		r.RemoteAddr = remote
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	// An untrusted client cannot dodge its limit by inventing addresses
+	if code := get("203.0.113.7:1000", "198.51.100.1"); code != http.StatusOK {
+		t.Fatalf("first request = %d, want 200", code)
+	}
+	if code := get("203.0.113.7:1001", "198.51.100.2"); code != http.StatusTooManyRequests {
+		t.Errorf("spoofed X-Forwarded-For = %d, want 429", code)
+	}
+
+	// Behind a trusted proxy each forwarded client has its own bucket
+	if code := get("10.0.0.2:1000", "198.51.100.3"); code != http.StatusOK {
+		t.Errorf("first forwarded client = %d, want 200", code)
+	}
+	if code := get("10.0.0.2:1001", "198.51.100.4"); code != http.StatusOK {
+		t.Errorf("second forwarded client = %d, want 200", code)
+	}
+	if code := get("10.0.0.2:1002", "198.51.100.3"); code != http.StatusTooManyRequests {
+		t.Errorf("repeat forwarded client = %d, want 429", code)
+	}
+}
@@ -0,0 +1,469 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"signal-proxy/internal/bandwidth"
+	"signal-proxy/internal/config"
+)
+
+func TestSimpleResponseWriterFlush(t *testing.T) {
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	clientSide.SetDeadline(time.Now().Add(3 * time.Second))
+
+	req, _ := http.NewRequest("GET", "/stream", nil)
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer proxySide.Close()
+		w := newSimpleResponseWriter(proxySide, req)
+		io.WriteString(w, "first chunk\n")
+		w.Flush()
+		<-release // handler is still running
+		io.WriteString(w, "second chunk\n")
+		w.bw.Flush()
+	}()
+
+	br := bufio.NewReader(clientSide)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading flushed chunk: %v", err)
+	}
+	if line != "first chunk\n" {
+		t.Fatalf("got %q before handler returned, want first chunk", line)
+	}
+	close(release)
+	io.Copy(io.Discard, resp.Body)
+	<-done
+}
+
+func TestSimpleResponseWriterClosedConn(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/stats", nil)
+
+	// The failure shows on the first flush and sticks
+	clientSide, proxySide := net.Pipe()
+	clientSide.Close()
+	w := newSimpleResponseWriter(proxySide, req)
+	w.WriteHeader(http.StatusOK)
+	if err := http.NewResponseController(w).Flush(); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("Flush to a closed client = %v, want io.ErrClosedPipe", err)
+	}
+	if _, err := io.WriteString(w, "more"); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Write after a failed flush = %v, want io.ErrClosedPipe", err)
+	}
+
+	// A body larger than the buffer fails in Write itself
+	clientSide, proxySide = net.Pipe()
+	clientSide.Close()
+	w = newSimpleResponseWriter(proxySide, req)
+	if _, err := w.Write(make([]byte, 64<<10)); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("large Write to a closed client = %v, want io.ErrClosedPipe", err)
+	}
+}
+
+func TestHandleInternalAPIClosesUnsizedResponse(t *testing.T) {
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	clientSide.SetDeadline(time.Now().Add(3 * time.Second))
+
+	raw := "GET /missing HTTP/1.1\r\nHost: proxy\r\n\r\n"
+	go func() {
+		defer proxySide.Close()
+		handleInternalAPI(proxySide, "", []byte(raw[:4]), nil, nil)
+	}()
+	// http.ReadRequest sees the peeked bytes followed by the rest
+	go io.WriteString(clientSide, raw[4:])
+
+	br := bufio.NewReader(clientSide)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	// http.Error does not set Content-Length, so the reply must close
+	if !resp.Close {
+		t.Error("unsized body was sent without Connection: close")
+	}
+}
+
+func TestSimpleResponseWriterKeepAliveNeedsContentLength(t *testing.T) {
+	cases := []struct {
+		proto  string
+		length bool
+		close  bool
+		keep   bool
+	}{
+		{"HTTP/1.1", true, false, true},
+		{"HTTP/1.1", false, false, false},
+		{"HTTP/1.1", true, true, false},
+		{"HTTP/1.0", true, false, false},
+	}
+	for _, tc := range cases {
+		raw := "GET /api/stats " + tc.proto + "\r\nHost: proxy\r\n"
+		if tc.close {
+			raw += "Connection: close\r\n"
+		}
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw + "\r\n")))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clientSide, proxySide := net.Pipe()
+		go func() {
+			defer proxySide.Close()
+			w := newSimpleResponseWriter(proxySide, req)
+			if tc.length {
+				w.Header().Set("Content-Length", strconv.Itoa(2))
+			}
+			io.WriteString(w, "ok")
+			w.Flush()
+		}()
+		resp, err := http.ReadResponse(bufio.NewReader(clientSide), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Close == tc.keep {
+			t.Errorf("%s length=%v close=%v: keep-alive = %v, want %v",
+				tc.proto, tc.length, tc.close, !resp.Close, tc.keep)
+		}
+		clientSide.Close()
+	}
+}
+
+func TestInternalAPIPipelinedKeepAlive(t *testing.T) {
+	tracker := bandwidth.NewTracker(filepath.Join(t.TempDir(), "sni_usage.json"))
+	defer tracker.Stop()
+
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	clientSide.SetDeadline(time.Now().Add(3 * time.Second))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handleInternalAPI(proxySide, "", nil, &config.Config{Env: &config.EnvConfig{}}, tracker)
+	}()
+	// Both requests go out before either response is read
+	go io.WriteString(clientSide,
+		"GET /api/stats HTTP/1.1\r\nHost: proxy\r\n\r\n"+
+			"OPTIONS /api/history HTTP/1.1\r\nHost: proxy\r\n\r\n"+
+			"GET /api/usage HTTP/1.1\r\nHost: proxy\r\n\r\n"+
+			"GET /api/history HTTP/1.0\r\nHost: proxy\r\n\r\n")
+
+	br := bufio.NewReader(clientSide)
+	for i, want := range []struct {
+		status int
+		close  bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusNoContent, false},
+		{http.StatusOK, false},
+		{http.StatusOK, true}, // HTTP/1.0 ends the connection
+	} {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("response %d: %v", i+1, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode != want.status || resp.Close != want.close {
+			t.Errorf("response %d = %d close=%v, want %d close=%v", i+1, resp.StatusCode, resp.Close, want.status, want.close)
+		}
+	}
+	<-done
+}
+
+func TestInternalAPIDashboard(t *testing.T) {
+	enabled := &config.Config{Env: &config.EnvConfig{DashboardEnabled: true}}
+
+	for _, p := range []string{"/", "/index.html"} {
+		resp, body := internalAPIGet(t, enabled, nil, p)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s = %d, want 200", p, resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("GET %s Content-Type = %q, want text/html", p, ct)
+		}
+		if !strings.Contains(body, "/api/stats") {
+			t.Errorf("GET %s body does not load /api/stats", p)
+		}
+	}
+
+	if resp, _ := internalAPIGet(t, enabled, nil, "/nope"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /nope = %d, want 404", resp.StatusCode)
+	}
+
+	disabled := &config.Config{Env: &config.EnvConfig{}}
+	if resp, _ := internalAPIGet(t, disabled, nil, "/"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET / with the dashboard disabled = %d, want 404", resp.StatusCode)
+	}
+}
+
+// internalAPIGet sends one GET through handleInternalAPI and returns the
+// response with its body read.
+func internalAPIGet(t *testing.T, cfg *config.Config, bw *bandwidth.Tracker, path string) (*http.Response, string) {
+	t.Helper()
+	return internalAPIGetWithHeaders(t, cfg, bw, path, "")
+}
+
+// internalAPIGetWithHeaders is internalAPIGet with extra raw header lines,
+// each ending in CRLF.
+func internalAPIGetWithHeaders(t *testing.T, cfg *config.Config, bw *bandwidth.Tracker, path, headers string) (*http.Response, string) {
+	t.Helper()
+	return internalAPIRequest(t, cfg, bw, http.MethodGet, path, headers)
+}
+
+// internalAPIRequest is internalAPIGetWithHeaders for any method.
+func internalAPIRequest(t *testing.T, cfg *config.Config, bw *bandwidth.Tracker, method, path, headers string) (*http.Response, string) {
+	t.Helper()
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	clientSide.SetDeadline(time.Now().Add(3 * time.Second))
+
+	raw := method + " " + path + " HTTP/1.1\r\nHost: proxy\r\nConnection: close\r\n" + headers + "\r\n"
+	go func() {
+		defer proxySide.Close()
+		handleInternalAPI(proxySide, "", []byte(raw[:1]), cfg, bw)
+	}()
+	go io.WriteString(clientSide, raw[1:])
+
+	resp, err := http.ReadResponse(bufio.NewReader(clientSide), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestInternalAPIUsageAfterRelay(t *testing.T) {
+	cfg := &config.Config{
+		IdleTimeoutSec: 2,
+		Hosts:          map[string]string{"localhost": startMockSignalServer(t)},
+		Env:            &config.EnvConfig{},
+	}
+	tracker := bandwidth.NewTracker(filepath.Join(t.TempDir(), "sni_usage.json"))
+	defer tracker.Stop()
+
+	clientSide, proxySide := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		HandleConnection(context.Background(), proxySide, cfg, tracker)
+		close(done)
+	}()
+	conn := tls.Client(clientSide, &tls.Config{InsecureSkipVerify: true, ServerName: "localhost"})
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "ping")
+	if _, err := conn.Read(make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	clientSide.Close()
+	<-done
+
+	resp, body := internalAPIGet(t, cfg, tracker, "/api/usage")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/usage = %d, want 200", resp.StatusCode)
+	}
+	var usage bandwidth.UsageResponse
+	if err := json.Unmarshal([]byte(body), &usage); err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := usage.Users["localhost"]
+	if !ok {
+		t.Fatalf("usage = %s, want an entry for localhost", body)
+	}
+	if entry.BytesUp <= 0 || entry.BytesDown <= 0 {
+		t.Errorf("localhost usage = %+v, want bytes in both directions", entry)
+	}
+
+	if resp, _ := internalAPIGet(t, cfg, nil, "/api/usage"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /api/usage without a tracker = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestInternalAPIAuthToken(t *testing.T) {
+	tracker := bandwidth.NewTracker(filepath.Join(t.TempDir(), "sni_usage.json"))
+	defer tracker.Stop()
+
+	open := &config.Config{Env: &config.EnvConfig{DashboardEnabled: true}}
+	for _, p := range []string{"/api/stats", "/api/history", "/api/usage"} {
+		if resp, _ := internalAPIGet(t, open, tracker, p); resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s without API_AUTH_TOKEN = %d, want 200", p, resp.StatusCode)
+		}
+	}
+
+	private := &config.Config{Env: &config.EnvConfig{DashboardEnabled: true, APIAuthToken: "s3cret"}}
+	for _, p := range []string{"/api/stats", "/api/history", "/api/usage"} {
+		resp, _ := internalAPIGet(t, private, tracker, p)
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("GET %s without a token = %d, want 401", p, resp.StatusCode)
+		}
+		if resp, _ := internalAPIGetWithHeaders(t, private, tracker, p, "Authorization: Bearer wrong\r\n"); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("GET %s with a wrong token = %d, want 401", p, resp.StatusCode)
+		}
+		if resp, _ := internalAPIGetWithHeaders(t, private, tracker, p, "Authorization: Bearer s3cret\r\n"); resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s with a bearer token = %d, want 200", p, resp.StatusCode)
+		}
+		if resp, _ := internalAPIGet(t, private, tracker, p+"?token=s3cret"); resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s with a query token = %d, want 200", p, resp.StatusCode)
+		}
+	}
+
+	// The dashboard page itself stays public; it forwards ?token= to the API
+	if resp, _ := internalAPIGet(t, private, tracker, "/"); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET / with API_AUTH_TOKEN = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestInternalAPIPreflight(t *testing.T) {
+	orig := Stats.AllowedOrigin()
+	Stats.SetAllowedOrigin("https://app.example.com")
+	t.Cleanup(func() { Stats.SetAllowedOrigin(orig) })
+	tracker := bandwidth.NewTracker(filepath.Join(t.TempDir(), "sni_usage.json"))
+	defer tracker.Stop()
+
+	// Preflights carry no credentials, so API_AUTH_TOKEN must not block them
+	cfg := &config.Config{Env: &config.EnvConfig{APIAuthToken: "s3cret"}}
+	preflight := "Origin: https://app.example.com\r\nAccess-Control-Request-Method: GET\r\n"
+	for _, p := range []string{"/api/stats", "/api/history", "/api/usage", "/api/future", "/nope"} {
+		resp, _ := internalAPIRequest(t, cfg, tracker, http.MethodOptions, p, preflight)
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("OPTIONS %s = %d, want 204", p, resp.StatusCode)
+		}
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+			t.Errorf("OPTIONS %s Access-Control-Allow-Origin = %q", p, got)
+		}
+		if got := resp.Header.Get("Access-Control-Allow-Methods"); !strings.Contains(got, "GET") {
+			t.Errorf("OPTIONS %s Access-Control-Allow-Methods = %q", p, got)
+		}
+		// Browsers only send the token in a header the preflight allows
+		if got := resp.Header.Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Authorization") {
+			t.Errorf("OPTIONS %s Access-Control-Allow-Headers = %q, want Authorization allowed", p, got)
+		}
+	}
+
+	// A 404 is readable cross-origin too
+	resp, _ := internalAPIGet(t, cfg, nil, "/api/usage")
+	if resp.StatusCode != http.StatusNotFound || resp.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("GET /api/usage without a tracker = %d with origin %q, want 404 with CORS",
+			resp.StatusCode, resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+	resp, _ = internalAPIGet(t, cfg, nil, "/api/future")
+	if resp.StatusCode != http.StatusNotFound || resp.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("GET /api/future = %d with origin %q, want 404 with CORS",
+			resp.StatusCode, resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestInternalAPIRejectsOversizedBody(t *testing.T) {
+	cfg := &config.Config{Env: &config.EnvConfig{APIMaxBodyKB: 1}}
+
+	// The body is never sent: the declared length alone gets a 413
+	resp, _ := internalAPIRequest(t, cfg, nil, http.MethodPost, "/api/stats", "Content-Length: 1048576\r\n")
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST with a 1MB body = %d, want 413", resp.StatusCode)
+	}
+	if !resp.Close {
+		t.Error("connection kept alive with the oversized body unread")
+	}
+
+	resp, _ = internalAPIRequest(t, cfg, nil, http.MethodPost, "/api/stats", "Content-Length: 0\r\n")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("POST with an empty body = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestLooksLikeHTTP(t *testing.T) {
+	for data, want := range map[string]bool{
+		"GET /api/stats HTTP/1.1\r\nHost: x\r\n\r\n": true,
+		"OPTIONS /api/stats HTTP/1.0\n":              true,
+		"G":                                          true,
+		"POS":                                        true,
+		"GET /api/stats":                             true,
+		"GET / SSH-2.0\r\n":                          false,
+		"GETX / HTTP/1.1\r\n":                        false,
+		"SSH-2.0-OpenSSH_9.6\r\n":                    false,
+		"\x00\x01\x02\x03":                           false,
+		"PRI * HTTP/2.0\r\n":                         false,
+	} {
+		if got := looksLikeHTTP([]byte(data)); got != want {
+			t.Errorf("looksLikeHTTP(%q) = %v, want %v", data, got, want)
+		}
+	}
+}
+
+func TestNonTLSConnections(t *testing.T) {
+	cfg := &config.Config{
+		IdleTimeoutSec: 2,
+		Hosts:          map[string]string{"localhost": startMockSignalServer(t)},
+		Env:            &config.EnvConfig{},
+	}
+	// dial runs HandleConnection on one end of a pipe and returns the other
+	dial := func(t *testing.T) net.Conn {
+		clientSide, proxySide := net.Pipe()
+		t.Cleanup(func() { clientSide.Close() })
+		clientSide.SetDeadline(time.Now().Add(3 * time.Second))
+		go HandleConnection(context.Background(), proxySide, cfg, nil)
+		return clientSide
+	}
+
+	t.Run("random bytes", func(t *testing.T) {
+		conn := dial(t)
+		garbage := make([]byte, 64)
+		rand.Read(garbage)
+		garbage[0] = 0x00 // neither a TLS record nor a method
+		if _, err := conn.Write(garbage); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := conn.Read(make([]byte, 1024)); err != io.EOF {
+			t.Fatalf("read %d bytes, %v; want the connection dropped", n, err)
+		}
+	})
+
+	t.Run("HTTP request", func(t *testing.T) {
+		conn := dial(t)
+		go io.WriteString(conn, "GET /api/stats HTTP/1.1\r\nHost: proxy\r\nConnection: close\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET /api/stats = %d, want 200", resp.StatusCode)
+		}
+	})
+
+	t.Run("TLS handshake", func(t *testing.T) {
+		conn := tls.Client(dial(t), &tls.Config{InsecureSkipVerify: true, ServerName: "localhost"})
+		if err := conn.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(conn, "ping")
+		if _, err := conn.Read(make([]byte, 1024)); err != nil {
+			t.Fatalf("relay read: %v", err)
+		}
+	})
+}
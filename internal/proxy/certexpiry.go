@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"signal-proxy/internal/ui"
+)
+
+// certCheckInterval is how often the expiry warning is re-evaluated between
+// reloads.
+const certCheckInterval = 24 * time.Hour
+
+// certNotAfter holds the loaded certificate's expiry as unix seconds
+// (0 = none loaded yet). With ACME it follows the certificate last served.
+var certNotAfter atomic.Int64
+
+// certExpirySeconds backs MetricCertExpirySeconds.
+func certExpirySeconds() float64 {
+	notAfter := certNotAfter.Load()
+	if notAfter == 0 {
+		return 0
+	}
+	return time.Until(time.Unix(notAfter, 0)).Seconds()
+}
+
+// certLeaf returns the parsed leaf certificate of cert.
+func certLeaf(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("certificate chain is empty")
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
+
+// ObserveCertificate records cert's expiry for MetricCertExpirySeconds. It
+// is called with the certificates ACME serves, and by the HTTPS proxy.
+func ObserveCertificate(cert *tls.Certificate) {
+	if cert == nil {
+		return
+	}
+	if leaf, err := certLeaf(cert); err == nil {
+		certNotAfter.Store(leaf.NotAfter.Unix())
+	}
+}
+
+// checkCertExpiry records cert's expiry for the gauge and warns if it falls
+// within the configured threshold. It reports whether it warned.
+func (s *Server) checkCertExpiry(cert *tls.Certificate) bool {
+	leaf, err := certLeaf(cert)
+	if err != nil {
+		ui.LogStatus("warn", "Cannot read certificate expiry: "+err.Error())
+		return false
+	}
+	certNotAfter.Store(leaf.NotAfter.Unix())
+	return s.warnCertExpiry(leaf.NotAfter)
+}
+
+// warnCertExpiry logs a warning if notAfter falls within the configured
+// threshold, and reports whether it did.
+func (s *Server) warnCertExpiry(notAfter time.Time) bool {
+	remaining := time.Until(notAfter)
+	if remaining >= s.Config.Env.CertExpiryWarning() {
+		return false
+	}
+	expires := notAfter.UTC().Format(time.RFC3339)
+	if remaining <= 0 {
+		ui.LogStatus("warn", "TLS certificate expired at "+expires)
+	} else {
+		days := int(math.Ceil(remaining.Hours() / 24))
+		ui.LogStatus("warn", "TLS certificate expires in "+strconv.Itoa(days)+" days ("+expires+")")
+	}
+	return true
+}
+
+// watchCertExpiry re-checks the current certificate daily so a cert nearing
+// expiry keeps warning even without reloads. Under ACME it checks the
+// certificate last served, which warns when renewals keep failing.
+func (s *Server) watchCertExpiry(ctx context.Context) {
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if notAfter := certNotAfter.Load(); notAfter != 0 {
+				s.warnCertExpiry(time.Unix(notAfter, 0))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"signal-proxy/internal/config"
+)
+
+func TestCertExpiryGaugeAndWarning(t *testing.T) {
+	certFile, keyFile := writeCertFiles(t, generateCertExpiring(t, time.Now().Add(3*24*time.Hour)))
+	srv := NewServer(&config.Config{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		MaxConns: 1,
+		Env:      &config.EnvConfig{CertExpiryWarnDays: 14},
+	}, nil)
+	if err := srv.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := certExpirySeconds()
+	if want := (3 * 24 * time.Hour).Seconds(); got > want || got < want-60 {
+		t.Errorf("cert expiry gauge = %.0fs, want about %.0fs", got, want)
+	}
+	if !srv.checkCertExpiry(srv.cert) {
+		t.Error("no warning for a certificate expiring in 3 days")
+	}
+
+	// A long-lived certificate is quiet
+	certFile, keyFile = writeCertFiles(t, generateCertExpiring(t, time.Now().Add(90*24*time.Hour)))
+	srv.Config.CertFile, srv.Config.KeyFile = certFile, keyFile
+	if err := srv.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if srv.checkCertExpiry(srv.cert) {
+		t.Error("warned for a certificate expiring in 90 days")
+	}
+	if got := certExpirySeconds(); got < (89 * 24 * time.Hour).Seconds() {
+		t.Errorf("cert expiry gauge = %.0fs after reload, want about 90 days", got)
+	}
+}
+
+func TestObserveCertificateUpdatesGauge(t *testing.T) {
+	cert := generateCertExpiring(t, time.Now().Add(30*24*time.Hour))
+	ObserveCertificate(&cert)
+	got := certExpirySeconds()
+	if want := (30 * 24 * time.Hour).Seconds(); got > want || got < want-60 {
+		t.Errorf("cert expiry gauge = %.0fs, want about %.0fs", got, want)
+	}
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"embed"
+	"net/http"
+	"strconv"
+)
+
+// dashboardFS holds the static landing page that renders /api/stats and
+// /api/history.
+//
+//go:embed dashboard/index.html
+var dashboardFS embed.FS
+
+// DashboardHandler serves the embedded dashboard at / and /index.html.
+func DashboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := dashboardFS.ReadFile("dashboard/index.html")
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(body)
+	}
+}
@@ -2,15 +2,25 @@ package proxy
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"signal-proxy/internal/auth"
 	"signal-proxy/internal/ui"
+	"signal-proxy/internal/version"
 )
 
 var (
@@ -38,6 +48,13 @@ var (
 		Help: "Total errors by type",
 	}, []string{"type"})
 
+	// MetricAPIConnsTotal counts non-TLS connections served as Stats API
+	// requests (probes that are not HTTP count as non_tls_probe errors)
+	MetricAPIConnsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "signalproxy_api_connections_total",
+		Help: "Total non-TLS connections handled by the Stats API",
+	})
+
 	// MetricConnectionDuration tracks connection duration
 	MetricConnectionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    "signalproxy_connection_duration_seconds",
@@ -50,13 +67,64 @@ var (
 		Name: "signalproxy_connections_rejected_total",
 		Help: "Total connections rejected due to capacity",
 	})
+
+	// MetricCertExpirySeconds reports the time left on the loaded certificate
+	MetricCertExpirySeconds = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "signalproxy_cert_expiry_seconds",
+		Help: "Seconds until the loaded TLS certificate expires",
+	}, certExpirySeconds)
+
+	// MetricBuildInfo is always 1, labelled with the running build
+	MetricBuildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "signalproxy_build_info",
+		Help: "Build information of the running proxy",
+	}, []string{"version", "commit"})
 )
 
+// userLabelSalt is the HMAC key for anonymized user labels (nil = raw usernames).
+var userLabelSalt atomic.Pointer[[]byte]
+
+// SetUserLabelAnonymization makes UserLabel hash usernames with salt.
+// An empty salt picks a random one, so labels are stable only for the
+// lifetime of the process.
+func SetUserLabelAnonymization(enabled bool, salt string) {
+	if !enabled {
+		userLabelSalt.Store(nil)
+		return
+	}
+	key := []byte(salt)
+	if len(key) == 0 {
+		key = make([]byte, 16)
+		rand.Read(key)
+	}
+	userLabelSalt.Store(&key)
+}
+
+// UserLabel returns the metric label value for a username: the normalized
+// username, or a salted hash of it when anonymization is enabled.
+func UserLabel(username string) string {
+	username = auth.NormalizeUsername(username)
+	key := userLabelSalt.Load()
+	if key == nil {
+		return username
+	}
+	return hashUserLabel(*key, username)
+}
+
+// hashUserLabel derives a short, stable pseudonym for username under salt.
+func hashUserLabel(salt []byte, username string) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(username))
+	return "u_" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
 // activeConnsValue is used internally to get the current gauge value for logging
 var activeConnsMu sync.Mutex
 var activeConnsCount int
 
 func init() {
+	MetricBuildInfo.WithLabelValues(version.Version, version.Commit).Set(1)
+
 	// Wrap the gauge to track count for logging purposes
 	origInc := MetricActiveConns.Inc
 	origDec := MetricActiveConns.Dec
@@ -107,17 +175,34 @@ func GetActiveConns() int {
 // MetricsServer wraps the HTTP server for prometheus metrics
 type MetricsServer struct {
 	server *http.Server
+	mux    *http.ServeMux
+	token  string
 }
 
 // NewMetricsServer creates a new metrics server.
 // usageHandler is an optional handler for /api/usage (nil = not registered).
-func NewMetricsServer(addr string, usageHandler http.HandlerFunc) *MetricsServer {
+// When token is set, every endpoint (/metrics, /api/stats, /api/history,
+// /api/usage and admin routes) requires "Authorization: Bearer <token>".
+func NewMetricsServer(addr string, usageHandler http.HandlerFunc, token string) *MetricsServer {
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
-	mux.HandleFunc("/api/stats", StatsHandler)
-	mux.HandleFunc("/api/history", HistoryHandler)
+	mux.Handle("/metrics", requireBearerToken(token, promhttp.Handler()))
+	mux.Handle("/api/stats", requireBearerToken(token, http.HandlerFunc(StatsHandler)))
+	mux.Handle("/api/history", requireBearerToken(token, http.HandlerFunc(HistoryHandler)))
 	if usageHandler != nil {
-		mux.HandleFunc("/api/usage", usageHandler)
+		// Lists every user's usage. CORS preflights carry no Authorization
+		// and get no data, so they skip the token check
+		protected := requireBearerToken(token, usageHandler)
+		mux.HandleFunc("/api/usage", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				usageHandler(w, r)
+				return
+			}
+			protected.ServeHTTP(w, r)
+		})
+	}
+
+	if token == "" && !isLoopbackAddr(addr) {
+		ui.LogStatus("warn", "Metrics on "+addr+" are reachable externally without METRICS_TOKEN")
 	}
 
 	return &MetricsServer{
@@ -125,7 +210,70 @@ func NewMetricsServer(addr string, usageHandler http.HandlerFunc) *MetricsServer
 			Addr:    addr,
 			Handler: mux,
 		},
+		mux:   mux,
+		token: token,
+	}
+}
+
+// HandleAdmin registers an admin endpoint. Like /metrics it requires the
+// metrics bearer token when one is set; h does its own caller checks.
+func (m *MetricsServer) HandleAdmin(pattern string, h http.HandlerFunc) {
+	m.mux.Handle(pattern, requireBearerToken(m.token, h))
+}
+
+// requireBearerToken rejects requests without the bearer token.
+// An empty token leaves the handler unprotected.
+func requireBearerToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			MetricErrorsTotal.WithLabelValues("metrics_unauthorized").Inc()
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAPIToken rejects requests that carry token neither as
+// "Authorization: Bearer <token>" nor as a ?token= query parameter.
+// An empty token leaves the handler public.
+func requireAPIToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	if token == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			got = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			MetricErrorsTotal.WithLabelValues("api_unauthorized").Inc()
+			ui.LogStatus("warn", "API token rejected for "+auth.RealClientIP(r, r.RemoteAddr)+": "+r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isLoopbackAddr reports whether a listen address only binds loopback.
+// An empty host (":9090") binds every interface.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
 	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
 
 // Start begins serving metrics (non-blocking)
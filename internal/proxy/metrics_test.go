@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"signal-proxy/internal/version"
+)
+
+func TestMetricsBearerToken(t *testing.T) {
+	usage := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{"users":{}}`)) }
+	m := NewMetricsServer("127.0.0.1:0", usage, "s3cret")
+	ts := httptest.NewServer(m.server.Handler)
+	defer ts.Close()
+
+	cases := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"wrong", "Bearer nope", http.StatusUnauthorized},
+		{"wrong scheme", "Basic s3cret", http.StatusUnauthorized},
+		{"valid", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tc := range cases {
+		req, _ := http.NewRequest("GET", ts.URL+"/metrics", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, resp.StatusCode, tc.want)
+		}
+		if tc.want == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: 401 without WWW-Authenticate", tc.name)
+		}
+	}
+
+	// The JSON APIs on the same server need the token too
+	for _, path := range []string{"/api/stats", "/api/history", "/api/usage"} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s without token: status = %d, want 401", path, resp.StatusCode)
+		}
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s with token: status = %d, want 200", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestMetricsWithoutToken(t *testing.T) {
+	m := NewMetricsServer("127.0.0.1:0", nil, "")
+	ts := httptest.NewServer(m.server.Handler)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200 when no token is configured", resp.StatusCode)
+	}
+}
+
+func TestIsLoopbackAddr(t *testing.T) {
+	cases := map[string]bool{
+		"127.0.0.1:9090": true,
+		"localhost:9090": true,
+		"[::1]:9090":     true,
+		":9090":          false,
+		"0.0.0.0:9090":   false,
+		"10.0.0.5:9090":  false,
+		"bogus":          false,
+	}
+	for addr, want := range cases {
+		if got := isLoopbackAddr(addr); got != want {
+			t.Errorf("isLoopbackAddr(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestUserLabelAnonymization(t *testing.T) {
+	defer SetUserLabelAnonymization(false, "")
+
+	SetUserLabelAnonymization(false, "")
+	if got := UserLabel("alice"); got != "alice" {
+		t.Fatalf("UserLabel with anonymization off = %q, want alice", got)
+	}
+	if got := UserLabel("Alice"); got != "alice" {
+		t.Fatalf("UserLabel(Alice) = %q, want alice", got)
+	}
+
+	SetUserLabelAnonymization(true, "salt-a")
+	first, again := UserLabel("alice"), UserLabel("alice")
+	if first != again {
+		t.Fatalf("same username hashed to %q then %q", first, again)
+	}
+	if first == "alice" || strings.Contains(first, "alice") {
+		t.Fatalf("label %q leaks the username", first)
+	}
+	if UserLabel("bob") == first {
+		t.Fatal("different usernames share a label")
+	}
+	if UserLabel("Alice") != first {
+		t.Fatal("Alice and alice have different labels")
+	}
+
+	SetUserLabelAnonymization(true, "salt-b")
+	if UserLabel("alice") == first {
+		t.Fatal("label did not change with the salt")
+	}
+
+	// Unpinned salts are random per call (i.e. per process)
+	SetUserLabelAnonymization(true, "")
+	random1 := UserLabel("alice")
+	SetUserLabelAnonymization(true, "")
+	if UserLabel("alice") == random1 {
+		t.Fatal("two random salts produced the same label")
+	}
+}
+
+func TestBuildInfoMetric(t *testing.T) {
+	m := NewMetricsServer("127.0.0.1:0", nil, "")
+	ts := httptest.NewServer(m.server.Handler)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	want := `signalproxy_build_info{commit="` + version.Commit + `",version="` + version.Version + `"} 1`
+	if !strings.Contains(string(body), want) {
+		t.Errorf("/metrics missing %s", want)
+	}
+}
@@ -2,63 +2,55 @@ package proxy
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"math/big"
 	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"signal-proxy/internal/accesslog"
+	"signal-proxy/internal/bandwidth"
 	"signal-proxy/internal/config"
 )
 
 func TestProxyRedirection(t *testing.T) {
 	// 1. Create a mock Signal server
-	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
-		Certificates: []tls.Certificate{generateSelfSignedCert(t)},
-	})
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer ln.Close()
-	mockServerAddr := ln.Addr().String()
-
-	go func() {
-		for {
-			conn, err := ln.Accept()
-			if err != nil {
-				return
-			}
-			go func(c net.Conn) {
-				defer c.Close()
-				buf := make([]byte, 1024)
-				n, _ := c.Read(buf)
-				if n > 0 {
-					c.Write([]byte("MOCK_SIGNAL_RESPONSE: " + string(buf[:n])))
-				}
-			}(conn)
-		}
-	}()
+	mockServerAddr := startMockSignalServer(t)
 
 	// 2. Configure proxy to point to our mock server
+	certFile, keyFile := writeCertFiles(t, generateSelfSignedCert(t))
 	cfg := &config.Config{
-		Listen:        "127.0.0.1:0",
-		TimeoutSec:    2,
-		MaxConns:      10,
-		MetricsListen: ":0",
+		Listen:         "127.0.0.1:0",
+		IdleTimeoutSec: 2,
+		MaxConns:       10,
+		MetricsListen:  ":0",
 		Hosts: map[string]string{
 			"localhost": mockServerAddr,
 		},
-		CertFile: "../../certs/dev/server.crt",
-		KeyFile:  "../../certs/dev/server.key",
+		CertFile: certFile,
+		KeyFile:  keyFile,
 		Env: &config.EnvConfig{
 			Env: config.Development,
 		},
 	}
 
 	// 3. Start the proxy
-	srv := NewServer(cfg)
-	srv.cert = &tls.Certificate{Certificate: [][]byte{generateSelfSignedCert(t).Certificate[0]}} 
-	
+	srv := NewServer(cfg, nil)
+
 	fmt.Println("Starting proxy server...")
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -72,27 +64,32 @@ func TestProxyRedirection(t *testing.T) {
 
 	// Wait for listener to be active
 	time.Sleep(500 * time.Millisecond)
-	if srv.ln == nil {
+	if srv.Addr() == nil {
 		t.Fatal("Server listener not initialized")
 	}
-	proxyAddr := srv.ln.Addr().String()
+	proxyAddr := srv.Addr().String()
 	fmt.Println("Proxy listening on:", proxyAddr)
 
-	// 4. Connect as a client
+	// 4. Connect as a client: outer TLS to the proxy, inner TLS to Signal
 	time.Sleep(100 * time.Millisecond)
 	conf := &tls.Config{
 		InsecureSkipVerify: true,
 		ServerName:         "localhost",
 	}
-	conn, err := tls.Dial("tcp", proxyAddr, conf)
+	outer, err := tls.Dial("tcp", proxyAddr, conf)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer conn.Close()
+	defer outer.Close()
+
+	conn := tls.Client(outer, conf)
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
 
 	payload := "Hello Signal"
 	fmt.Fprint(conn, payload)
-	
+
 	resp := make([]byte, 1024)
 	n, err := conn.Read(resp)
 	if err != nil {
@@ -105,18 +102,414 @@ func TestProxyRedirection(t *testing.T) {
 	}
 }
 
-// Helper to generate a dummy cert for testing purposes if needed
-// Or just use a pre-existing one if available.
-// For this test, I'll assume I need to generate one or use a dummy.
+func TestSNIBandwidthAccounting(t *testing.T) {
+	mockServerAddr := startMockSignalServer(t)
+	cfg := &config.Config{
+		IdleTimeoutSec: 2,
+		Hosts:          map[string]string{"localhost": mockServerAddr},
+		Env:            &config.EnvConfig{},
+	}
+	tracker := bandwidth.NewTracker(filepath.Join(t.TempDir(), "sni_usage.json"))
+	defer tracker.Stop()
+
+	// Relay two sessions so usage must accumulate rather than overwrite
+	for i := 0; i < 2; i++ {
+		clientSide, proxySide := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			HandleConnection(context.Background(), proxySide, cfg, tracker)
+			close(done)
+		}()
+
+		conn := tls.Client(clientSide, &tls.Config{InsecureSkipVerify: true, ServerName: "LocalHost"})
+		if err := conn.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprint(conn, "ping")
+		if _, err := conn.Read(make([]byte, 1024)); err != nil {
+			t.Fatal(err)
+		}
+		if got := tracker.GetActiveConns("localhost"); got != 1 {
+			t.Errorf("active conns during relay = %d, want 1", got)
+		}
+		clientSide.Close()
+
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("HandleConnection did not return")
+		}
+	}
+
+	all := tracker.GetAllUsage()
+	usage, ok := all["localhost"]
+	if !ok || len(all) != 1 {
+		t.Fatalf("usage keys = %v, want only \"localhost\"", all)
+	}
+	if usage.BytesUp <= 0 || usage.BytesDown <= 0 {
+		t.Errorf("usage = %+v, want bytes in both directions", usage)
+	}
+	if usage.TotalBytes != usage.BytesUp+usage.BytesDown {
+		t.Errorf("TotalBytes = %d, want %d", usage.TotalBytes, usage.BytesUp+usage.BytesDown)
+	}
+	if usage.ActiveConns != 0 {
+		t.Errorf("ActiveConns = %d after relays finished, want 0", usage.ActiveConns)
+	}
+}
+
+func TestSNIBandwidthQuotaExceeded(t *testing.T) {
+	cfg := &config.Config{
+		IdleTimeoutSec: 2,
+		Hosts:          map[string]string{"localhost": startMockSignalServer(t)},
+		Env:            &config.EnvConfig{SNIUsageLimitGB: 1},
+	}
+	tracker := bandwidth.NewTracker(filepath.Join(t.TempDir(), "sni_usage.json"))
+	defer tracker.Stop()
+	tracker.RecordBytes("localhost", 1<<30, 0)
+
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	go HandleConnection(context.Background(), proxySide, cfg, tracker)
+
+	conn := tls.Client(clientSide, &tls.Config{InsecureSkipVerify: true, ServerName: "localhost"})
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := conn.Handshake(); err == nil {
+		t.Fatal("handshake succeeded for an SNI over quota")
+	}
+}
+
+func TestUnknownSNIRejectedByDefault(t *testing.T) {
+	cfg := &config.Config{
+		IdleTimeoutSec: 2,
+		Hosts:          map[string]string{"chat.signal.org": startMockSignalServer(t)},
+		Env:            &config.EnvConfig{},
+	}
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	go HandleConnection(context.Background(), proxySide, cfg, nil)
+
+	conn := tls.Client(clientSide, &tls.Config{InsecureSkipVerify: true, ServerName: "localhost"})
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := conn.Handshake(); err == nil {
+		t.Fatal("handshake succeeded for an SNI missing from hosts")
+	}
+}
+
+func TestAccessLogRecordsSignalOutcomes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := accesslog.Open(path, accesslog.JSON, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accesslog.SetDefault(l)
+	t.Cleanup(func() {
+		accesslog.SetDefault(nil)
+		l.Close()
+	})
+
+	cfg := &config.Config{
+		IdleTimeoutSec: 2,
+		Hosts:          map[string]string{"localhost": startMockSignalServer(t)},
+		Env:            &config.EnvConfig{},
+	}
+	connect := func(serverName string) {
+		t.Helper()
+		clientSide, proxySide := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			HandleConnection(context.Background(), proxySide, cfg, nil)
+			close(done)
+		}()
+		conn := tls.Client(clientSide, &tls.Config{InsecureSkipVerify: true, ServerName: serverName})
+		conn.SetDeadline(time.Now().Add(3 * time.Second))
+		if conn.Handshake() == nil {
+			fmt.Fprint(conn, "ping")
+			conn.Read(make([]byte, 1024))
+		}
+		clientSide.Close()
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("HandleConnection did not return")
+		}
+	}
+	connect("localhost")
+	connect("unknown.example.com")
+
+	l.Flush()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d access log records, want 2:\n%s", len(lines), data)
+	}
+	for i, want := range []struct {
+		user   string
+		status int
+	}{{"localhost", http.StatusOK}, {"unknown.example.com", http.StatusForbidden}} {
+		var e accesslog.Entry
+		if err := json.Unmarshal([]byte(lines[i]), &e); err != nil {
+			t.Fatalf("unparseable record %q: %v", lines[i], err)
+		}
+		if e.Proxy != "signal" || e.User != want.user || e.Status != want.status {
+			t.Errorf("record %d = %+v, want user %s and status %d", i, e, want.user, want.status)
+		}
+	}
+}
+
+func TestUnknownSNIRoutedToDefaultUpstream(t *testing.T) {
+	cfg := &config.Config{
+		IdleTimeoutSec: 2,
+		Hosts:          map[string]string{"chat.signal.org": "127.0.0.1:1"},
+		Env:            &config.EnvConfig{DefaultUpstream: startMockSignalServer(t)},
+	}
+	tracker := bandwidth.NewTracker(filepath.Join(t.TempDir(), "sni_usage.json"))
+	defer tracker.Stop()
+
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	done := make(chan struct{})
+	go func() {
+		HandleConnection(context.Background(), proxySide, cfg, tracker)
+		close(done)
+	}()
+
+	conn := tls.Client(clientSide, &tls.Config{InsecureSkipVerify: true, ServerName: "localhost"})
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := conn.Handshake(); err != nil {
+		t.Fatalf("handshake via DEFAULT_UPSTREAM: %v", err)
+	}
+	fmt.Fprint(conn, "ping")
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "MOCK_SIGNAL_RESPONSE: ping" {
+		t.Fatalf("read = %q, %v", buf[:n], err)
+	}
+	clientSide.Close()
+	<-done
+
+	// Unknown SNIs are accounted together, not one key per name
+	if _, ok := tracker.GetAllUsage()[defaultUpstreamKey]; !ok {
+		t.Errorf("usage keys = %v, want %q", tracker.GetAllUsage(), defaultUpstreamKey)
+	}
+}
+
+// startEchoSignalServer runs a TLS server that echoes everything back until
+// the client closes.
+func startEchoSignalServer(t *testing.T) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{generateSelfSignedCert(t)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				io.Copy(c, c)
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// startRelay runs HandleConnection for one TLS client and returns the
+// client side plus a channel closed once the relay ends.
+func startRelay(t *testing.T, cfg *config.Config) (*tls.Conn, <-chan struct{}) {
+	t.Helper()
+	clientSide, proxySide := net.Pipe()
+	t.Cleanup(func() { clientSide.Close() })
+	done := make(chan struct{})
+	go func() {
+		HandleConnection(context.Background(), proxySide, cfg, nil)
+		close(done)
+	}()
+
+	conn := tls.Client(clientSide, &tls.Config{InsecureSkipVerify: true, ServerName: "localhost"})
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	return conn, done
+}
+
+// ping sends one message through the relay and waits for its echo.
+func ping(t *testing.T, conn *tls.Conn) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatalf("read echo: %v", err)
+	}
+}
+
+func TestRelayIdleTimeout(t *testing.T) {
+	cfg := &config.Config{
+		IdleTimeoutSec: 1,
+		Hosts:          map[string]string{"localhost": startEchoSignalServer(t)},
+		Env:            &config.EnvConfig{},
+	}
+	conn, done := startRelay(t, cfg)
+
+	// Traffic more often than the idle timeout keeps the relay open past it
+	for i := 0; i < 5; i++ {
+		time.Sleep(300 * time.Millisecond)
+		ping(t, conn)
+	}
+
+	idleSince := time.Now()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("idle relay was not closed")
+	}
+	if elapsed := time.Since(idleSince); elapsed < 900*time.Millisecond {
+		t.Errorf("relay closed %s after going idle, want about 1s", elapsed)
+	}
+}
+
+func TestRelayMaxLifetime(t *testing.T) {
+	cfg := &config.Config{
+		IdleTimeoutSec: 10,
+		MaxLifetimeSec: 1,
+		Hosts:          map[string]string{"localhost": startEchoSignalServer(t)},
+		Env:            &config.EnvConfig{},
+	}
+	start := time.Now()
+	conn, done := startRelay(t, cfg)
+
+	// Keep the relay busy; the lifetime still ends it
+	go func() {
+		for {
+			conn.SetDeadline(time.Now().Add(time.Second))
+			if _, err := conn.Write([]byte("ping")); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("relay outlived max_lifetime_sec")
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("relay closed after %s, want about 1s", elapsed)
+	}
+}
+
+// startMockSignalServer runs a TLS server that echoes the first read back
+// prefixed with MOCK_SIGNAL_RESPONSE.
+func startMockSignalServer(t *testing.T) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{generateSelfSignedCert(t)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				buf := make([]byte, 1024)
+				n, _ := c.Read(buf)
+				if n > 0 {
+					c.Write([]byte("MOCK_SIGNAL_RESPONSE: " + string(buf[:n])))
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// generateSelfSignedCert creates a throwaway ECDSA certificate for localhost.
 func generateSelfSignedCert(t *testing.T) tls.Certificate {
-    // In a real scenario, we'd use crypto/x509 to generate one.
-    // However, since I can't easily run complex generation here without lots of code,
-    // I will try to read the one from the project if it exists.
-    cert, err := tls.LoadX509KeyPair("../../certs/dev/server.crt", "../../certs/dev/server.key")
-    if err != nil {
-        // Fallback or skip if not found
-        t.Log("Warning: Could not load dev certs for test, using a placeholder")
-        return tls.Certificate{}
-    }
-    return cert
+	t.Helper()
+	return generateCertExpiring(t, time.Now().Add(time.Hour))
+}
+
+// generateCertExpiring is generateSelfSignedCert with a chosen NotAfter.
+func generateCertExpiring(t *testing.T, notAfter time.Time) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writeCertFiles writes cert as PEM files in a temp dir and returns their paths.
+func writeCertFiles(t *testing.T, cert tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "server.crt")
+	keyFile = filepath.Join(dir, "server.key")
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestStartAddrInUse(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	certFile, keyFile := writeCertFiles(t, generateSelfSignedCert(t))
+	cfg := &config.Config{
+		Listen:   taken.Addr().String(),
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		Env:      &config.EnvConfig{Env: config.Development},
+	}
+	if err := NewServer(cfg, nil).Start(context.Background()); !errors.Is(err, config.ErrAddrInUse) {
+		t.Fatalf("Start on a bound port = %v, want ErrAddrInUse", err)
+	}
 }
@@ -9,16 +9,25 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"signal-proxy/internal/accesslog"
+	"signal-proxy/internal/acmecert"
+	"signal-proxy/internal/bandwidth"
+	"signal-proxy/internal/bufpool"
 	"signal-proxy/internal/config"
+	"signal-proxy/internal/dialer"
 	"signal-proxy/internal/ui"
 )
 
 // Server handles TLS connections and proxies them to Signal servers.
 type Server struct {
 	Config   *config.Config
+	// Optional per-SNI usage tracker (nil = disabled)
+	Bandwidth *bandwidth.Tracker
 	ln       net.Listener
 	connSem  chan struct{}  // Semaphore for connection limiting
 	wg       sync.WaitGroup // Tracks active connections for graceful shutdown
@@ -30,16 +39,24 @@ type Server struct {
 }
 
 // NewServer creates a new proxy server with the given configuration.
-func NewServer(cfg *config.Config) *Server {
+// bw may be nil; when set, relayed bytes are recorded per SNI hostname.
+func NewServer(cfg *config.Config, bw *bandwidth.Tracker) *Server {
 	return &Server{
-		Config:   cfg,
+		Config:    cfg,
+		Bandwidth: bw,
 		connSem:  make(chan struct{}, cfg.MaxConns),
 		shutdown: make(chan struct{}),
 	}
 }
 
-// Reload reloads the TLS certificate from disk.
+// Reload reloads the TLS certificate from disk. It is a no-op when ACME
+// manages certificates.
 func (s *Server) Reload() error {
+	if s.Config.Env.ACMEEnabled {
+		ui.LogStatus("info", "Certificates are managed by ACME, nothing to reload")
+		return nil
+	}
+
 	cert, err := tls.LoadX509KeyPair(s.Config.CertFile, s.Config.KeyFile)
 	if err != nil {
 		return err
@@ -50,9 +67,20 @@ func (s *Server) Reload() error {
 	s.mu.Unlock()
 
 	ui.LogStatus("success", "Certificates reloaded from disk")
+	s.checkCertExpiry(&cert)
 	return nil
 }
 
+// Addr returns the listener address, or nil before Start has bound it.
+func (s *Server) Addr() net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
 // getCertificate returns the current certificate for TLS handshakes.
 func (s *Server) getCertificate(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
 	s.mu.RLock()
@@ -63,38 +91,51 @@ func (s *Server) getCertificate(info *tls.ClientHelloInfo) (*tls.Certificate, er
 // Start begins accepting connections. It blocks until shutdown or error.
 // The context is used for graceful shutdown - cancel it to initiate shutdown.
 func (s *Server) Start(ctx context.Context) error {
-	// 1. Initial certificate load
-	if err := s.Reload(); err != nil {
+	// 1. Initial certificate load (ACME obtains one on first handshake instead)
+	getCertificate := s.getCertificate
+	if s.Config.Env.ACMEEnabled {
+		m, err := acmecert.NewManager(s.Config.Env)
+		if err != nil {
+			return err
+		}
+		getCertificate = acmecert.GetCertificate(m, s.Config.Env.ACMEDomains[0], ObserveCertificate)
+		ui.LogStatus("info", "ACME certificates for "+strings.Join(s.Config.Env.ACMEDomains, ", ")+" cached in "+s.Config.Env.ACMECacheDir)
+	} else if err := s.Reload(); err != nil {
 		return err
 	}
 
 	// TLS config for terminating the OUTER TLS connection from Signal app
-	tlsConfig := &tls.Config{
-		GetCertificate: s.getCertificate,
-		MinVersion:     tls.VersionTLS12,
-		NextProtos:     []string{"http/1.1"},
+	tlsConfig, err := s.Config.Env.ServerTLSConfig()
+	if err != nil {
+		return err
+	}
+	tlsConfig.GetCertificate = getCertificate
+	tlsConfig.NextProtos = []string{"http/1.1"}
+	if s.Config.Env.ACMEEnabled {
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, acmecert.ALPNProto)
 	}
 
 	// 2. Start TLS Listener (we terminate the OUTER TLS here)
-	var err error
-	s.ln, err = tls.Listen("tcp", s.Config.Listen, tlsConfig)
+	ln, err := s.Config.Env.ListenWithRetry(ctx, func() (net.Listener, error) {
+		return config.ListenTLS(s.Config.Listen, tlsConfig, dialer.BufferControl(s.Config.Env.SocketSndBuf, s.Config.Env.SocketRcvBuf))
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to listen on %s: %w", s.Config.Listen, err)
 	}
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
 
 	metricsAddr := s.Config.MetricsListen
 	if strings.HasPrefix(metricsAddr, ":") {
 		metricsAddr = "localhost" + metricsAddr
 	}
 	ui.LogStatus("info", "Metrics: http://"+metricsAddr+"/metrics")
-	
-	// Set the global stats tracker configuration
-	Stats.AllowedOrigin = s.Config.Env.AllowedOrigin
-	
 	ui.LogStatus("info", "Stats API: https://" + s.Config.Env.APIDomain + "/api/stats")
 
-	// 3. Monitor for shutdown signal
+	// 3. Monitor for shutdown signal and certificate expiry
 	go s.watchShutdown(ctx)
+	go s.watchCertExpiry(ctx)
 
 	// 4. Accept Loop
 	for {
@@ -120,6 +161,10 @@ func (s *Server) Start(ctx context.Context) error {
 			}
 		}
 
+		dialer.SetNoDelay(conn, !s.Config.Env.DisableTCPNoDelay)
+
+		id := accesslog.NewID()
+
 		// Try to acquire connection slot (non-blocking)
 		select {
 		case s.connSem <- struct{}{}:
@@ -128,12 +173,19 @@ func (s *Server) Start(ctx context.Context) error {
 			go func(c net.Conn) {
 				defer s.wg.Done()
 				defer func() { <-s.connSem }() // Release slot when done
-				HandleConnection(ctx, c, s.Config)
+				HandleConnection(accesslog.WithID(ctx, id), c, s.Config, s.Bandwidth)
 			}(conn)
 		default:
 			// At capacity, reject connection
 			MetricConnectionsRejected.Inc()
-			ui.LogStatus("warn", "Connection rejected: at max capacity ("+itoa(s.Config.MaxConns)+")")
+			ui.LogStatus("warn", accesslog.Tag(id, "Connection rejected: at max capacity ("+strconv.Itoa(s.Config.MaxConns)+")"))
+			accesslog.Log(accesslog.Entry{
+				ID:     id,
+				Time:   time.Now(),
+				Proxy:  "signal",
+				Client: accesslog.ClientHost(conn.RemoteAddr().String()),
+				Status: http.StatusServiceUnavailable,
+			})
 			conn.Close()
 		}
 	}
@@ -149,9 +201,10 @@ func (s *Server) watchShutdown(ctx context.Context) {
 
 // drainConnections waits for active connections to finish (with timeout).
 func (s *Server) drainConnections() error {
+	timeout := s.Config.Env.DrainTimeout()
 	activeConns := GetActiveConns()
 	if activeConns > 0 {
-		ui.LogStatus("info", "Draining "+itoa(activeConns)+" active connections (30s timeout)...")
+		ui.LogStatus("info", "Draining "+strconv.Itoa(activeConns)+" active connections ("+timeout.String()+" timeout)...")
 	}
 
 	// Wait for connections with timeout
@@ -164,40 +217,65 @@ func (s *Server) drainConnections() error {
 	select {
 	case <-done:
 		ui.LogStatus("success", "All connections drained. Goodbye.")
-	case <-time.After(30 * time.Second):
+	case <-time.After(timeout):
 		ui.LogStatus("warn", "Drain timeout reached. Forcing shutdown.")
 	}
 
 	return nil
 }
 
-// itoa is a simple int to string helper
-func itoa(i int) string {
-	if i == 0 {
-		return "0"
-	}
-	result := ""
-	for i > 0 {
-		result = string(rune('0'+i%10)) + result
-		i /= 10
-	}
-	return result
-}
+// maxClientHelloSize caps how much PeekSNI buffers: a full TLS record
+// (16KB payload) plus its 5-byte header.
+const maxClientHelloSize = 5 + 16384
 
 // PeekSNI reads the TLS ClientHello from a raw connection to extract SNI.
 // This is used AFTER outer TLS termination to read the INNER TLS ClientHello.
+// Reads repeat until the whole first TLS record is buffered, so a hello
+// split across TCP segments still parses. Non-TLS data (e.g. an HTTP request
+// for the stats API) is returned after the first read. All bytes read are
+// returned so the caller can forward them; the caller's deadline bounds the wait.
 func PeekSNI(conn net.Conn) (string, []byte, error) {
-	buf := make([]byte, 16384)
-	n, err := conn.Read(buf)
-	if err != nil {
-		return "", nil, err
+	buf := make([]byte, maxClientHelloSize)
+	n := 0
+	for {
+		nr, err := conn.Read(buf[n:])
+		n += nr
+		if err != nil {
+			if n > 0 && err == io.EOF {
+				break
+			}
+			return "", nil, err
+		}
+		if want, ok := tlsRecordLen(buf[:n]); !ok || n >= want {
+			break
+		}
 	}
 	data := buf[:n]
 	sni := extractSNI(data)
 	return sni, data, nil
 }
 
-// extractSNI parses a TLS ClientHello message and extracts the SNI hostname
+// tlsRecordLen returns the total length (header included) of the TLS
+// handshake record starting data, capped at maxClientHelloSize. ok is false
+// when data does not start with a handshake record, meaning no more reads
+// are needed to classify it.
+func tlsRecordLen(data []byte) (int, bool) {
+	if len(data) == 0 || data[0] != 0x16 {
+		return 0, false
+	}
+	if len(data) < 5 {
+		return 5, true
+	}
+	total := 5 + (int(data[3])<<8 | int(data[4]))
+	if total > maxClientHelloSize {
+		total = maxClientHelloSize
+	}
+	return total, true
+}
+
+// extractSNI parses a TLS ClientHello message and extracts the SNI hostname.
+// Every length field is checked against the buffer before it is skipped, so
+// truncated or crafted input yields "" rather than a misparse.
 func extractSNI(data []byte) string {
 	// Minimum TLS record header: 5 bytes
 	if len(data) < 5 {
@@ -237,6 +315,9 @@ func extractSNI(data []byte) string {
 	}
 	sessionIDLen := int(data[pos])
 	pos += 1 + sessionIDLen
+	if pos > len(data) {
+		return ""
+	}
 
 	// Skip cipher suites
 	if len(data) < pos+2 {
@@ -244,6 +325,9 @@ func extractSNI(data []byte) string {
 	}
 	cipherSuitesLen := int(data[pos])<<8 | int(data[pos+1])
 	pos += 2 + cipherSuitesLen
+	if pos > len(data) {
+		return ""
+	}
 
 	// Skip compression methods
 	if len(data) < pos+1 {
@@ -251,6 +335,9 @@ func extractSNI(data []byte) string {
 	}
 	compressionLen := int(data[pos])
 	pos += 1 + compressionLen
+	if pos > len(data) {
+		return ""
+	}
 
 	// Extensions
 	if len(data) < pos+2 {
@@ -261,7 +348,7 @@ func extractSNI(data []byte) string {
 
 	endPos := pos + extensionsLen
 	if endPos > len(data) {
-		endPos = len(data)
+		return ""
 	}
 
 	// Parse extensions to find SNI (type 0x0000)
@@ -269,20 +356,12 @@ func extractSNI(data []byte) string {
 		extType := int(data[pos])<<8 | int(data[pos+1])
 		extLen := int(data[pos+2])<<8 | int(data[pos+3])
 		pos += 4
+		if pos+extLen > endPos {
+			return ""
+		}
 
 		if extType == 0x0000 { // SNI extension
-			if pos+5 > endPos {
-				return ""
-			}
-			// Skip list length (2), check name type (should be 0)
-			if data[pos+2] != 0x00 {
-				return ""
-			}
-			nameLen := int(data[pos+3])<<8 | int(data[pos+4])
-			if pos+5+nameLen > endPos {
-				return ""
-			}
-			return string(data[pos+5 : pos+5+nameLen])
+			return parseServerNameExt(data[pos : pos+extLen])
 		}
 		pos += extLen
 	}
@@ -290,18 +369,74 @@ func extractSNI(data []byte) string {
 	return ""
 }
 
+// maxSNILen is the longest host_name accepted (a DNS name is at most 253).
+const maxSNILen = 255
+
+// parseServerNameExt returns the first host_name entry of a server_name
+// extension body: list length (2), then entries of type (1), length (2), name.
+func parseServerNameExt(ext []byte) string {
+	if len(ext) < 2 {
+		return ""
+	}
+	listLen := int(ext[0])<<8 | int(ext[1])
+	if 2+listLen > len(ext) {
+		return ""
+	}
+	list := ext[2 : 2+listLen]
+
+	for len(list) >= 3 {
+		nameType := list[0]
+		nameLen := int(list[1])<<8 | int(list[2])
+		if 3+nameLen > len(list) {
+			return ""
+		}
+		if nameType == 0x00 { // host_name
+			if nameLen > maxSNILen {
+				return ""
+			}
+			return string(list[3 : 3+nameLen])
+		}
+		list = list[3+nameLen:]
+	}
+	return ""
+}
+
+// defaultUpstreamKey stands in for the SNI in metrics and usage of
+// connections relayed to DEFAULT_UPSTREAM.
+const defaultUpstreamKey = "default_upstream"
+
 // HandleConnection handles the TLS-in-TLS tunnel for Signal.
 // The outer TLS is already terminated by the server listener.
 // We read the inner TLS ClientHello to get the real destination SNI.
-func HandleConnection(ctx context.Context, clientConn net.Conn, cfg *config.Config) {
+// If bw is non-nil, usage is recorded under the SNI hostname. Log lines
+// are tagged with the request ID carried by ctx (accesslog.WithID).
+func HandleConnection(ctx context.Context, clientConn net.Conn, cfg *config.Config, bw *bandwidth.Tracker) {
 	defer clientConn.Close()
+	id := accesslog.IDFrom(ctx)
 
 	// Track metrics
 	MetricActiveConns.Inc()
 	defer MetricActiveConns.Dec()
 
 	startTime := time.Now()
-	timeout := time.Duration(cfg.TimeoutSec) * time.Second
+	idleTimeout := time.Duration(cfg.IdleTimeoutSec) * time.Second
+
+	// One access log record per relay attempt, rejected or not; its status
+	// is the HTTP equivalent of the outcome. API requests and probes are
+	// not relays and set entry to nil.
+	entry := &accesslog.Entry{
+		ID:     id,
+		Time:   startTime,
+		Proxy:  "signal",
+		Client: accesslog.ClientHost(clientConn.RemoteAddr().String()),
+		Status: http.StatusBadRequest,
+	}
+	defer func() {
+		if entry != nil {
+			entry.Duration = time.Since(startTime)
+			accesslog.Log(*entry)
+		}
+	}()
 
 	// Set deadline for reading inner ClientHello
 	clientConn.SetDeadline(time.Now().Add(10 * time.Second))
@@ -311,37 +446,72 @@ func HandleConnection(ctx context.Context, clientConn net.Conn, cfg *config.Conf
 	if err != nil {
 		MetricErrorsTotal.WithLabelValues("peek_failed").Inc()
 		Stats.RecordError()
-		ui.LogStatus("error", "Failed to peek SNI: "+err.Error())
+		ui.LogStatus("error", accesslog.Tag(id, "Failed to peek SNI: "+err.Error()))
 		return
 	}
+	entry.User = sni
 
 	// Lookup destination
-	target, allowed := cfg.Hosts[strings.ToLower(sni)]
+	target, allowed := cfg.LookupHost(sni)
+	// Metric label and usage key; unknown SNIs share one so they cannot
+	// grow either without bound (keys are lowercased to match the host map)
+	usageKey := strings.ToLower(sni)
 	if !allowed || sni == "" {
 		// Differentiate between Signal traffic (Inner TLS) and Stats API traffic (HTTP)
 		// Signal traffic always starts with a TLS handshake (0x16)
 		if len(initialData) > 0 && initialData[0] != 0x16 {
+			// Scanners and garbage are dropped without parsing or logging
+			entry = nil
+			if !looksLikeHTTP(initialData) {
+				MetricErrorsTotal.WithLabelValues("non_tls_probe").Inc()
+				return
+			}
 			// This looks like an HTTP request (browser/landing page)
 			// Handle the Stats API directly on this connection
-			handleInternalAPI(clientConn, initialData)
+			MetricAPIConnsTotal.Inc()
+			handleInternalAPI(clientConn, id, initialData, cfg, bw)
 			return
 		}
 
-		MetricErrorsTotal.WithLabelValues("unauthorized_sni").Inc()
-		Stats.RecordError()
-		ui.LogStatus("error", "Unauthorized SNI: "+sni)
-		return
+		if cfg.Env.DefaultUpstream == "" {
+			MetricErrorsTotal.WithLabelValues("unauthorized_sni").Inc()
+			Stats.RecordError()
+			ui.LogStatus("error", accesslog.Tag(id, "Unauthorized SNI: "+sni))
+			entry.Status = http.StatusForbidden
+			return
+		}
+		ui.LogStatus("warn", accesslog.Tag(id, "Unknown SNI "+strconv.Quote(sni)+" routed to DEFAULT_UPSTREAM "+cfg.Env.DefaultUpstream))
+		target, usageKey = cfg.Env.DefaultUpstream, defaultUpstreamKey
+	}
+	entry.Target = target
+
+	// Per-SNI quota
+	if bw != nil {
+		if !bw.CheckAllowance(usageKey, cfg.Env.SNIUsageLimitGB) {
+			MetricErrorsTotal.WithLabelValues("quota_exceeded").Inc()
+			Stats.RecordError()
+			ui.LogStatus("warn", accesslog.Tag(id, "SNI bandwidth quota exceeded: "+usageKey))
+			entry.Status = http.StatusTooManyRequests
+			return
+		}
+		bw.IncrementConns(usageKey)
+		defer bw.DecrementConns(usageKey)
 	}
 
 	// Connect to Signal server
-	dialer := &net.Dialer{Timeout: 10 * time.Second}
-	upConn, err := dialer.DialContext(ctx, "tcp", target)
+	entry.Status = http.StatusBadGateway
+	upstream := dialer.New(cfg.Env.DialTimeout(dialer.SignalTimeout), cfg.Env.TCPKeepAlive()).
+		WithNoDelay(!cfg.Env.DisableTCPNoDelay).
+		WithBuffers(cfg.Env.SocketSndBuf, cfg.Env.SocketRcvBuf)
+	dialStart := time.Now()
+	upConn, err := upstream.DialContext(ctx, "tcp", target)
 	if err != nil {
 		MetricErrorsTotal.WithLabelValues("dial_failed").Inc()
 		Stats.RecordError()
-		ui.LogStatus("error", "Target unreachable: "+target+" - "+err.Error())
+		ui.LogStatus("error", accesslog.Tag(id, "Target unreachable: "+target+" - "+err.Error()))
 		return
 	}
+	Stats.RecordLatency(time.Since(dialStart))
 	defer upConn.Close()
 
 	// Forward the ClientHello we already read
@@ -352,8 +522,12 @@ func HandleConnection(ctx context.Context, clientConn net.Conn, cfg *config.Conf
 		}
 	}
 
-	MetricRelayTotal.WithLabelValues(sni).Inc()
+	MetricRelayTotal.WithLabelValues(usageKey).Inc()
 	Stats.RecordRelay()
+	entry.Status = http.StatusOK
+	if clientIP, _, err := net.SplitHostPort(clientConn.RemoteAddr().String()); err == nil {
+		Stats.RecordUser(clientIP)
+	}
 
 	// Clear deadlines for relay
 	clientConn.SetDeadline(time.Time{})
@@ -362,14 +536,24 @@ func HandleConnection(ctx context.Context, clientConn net.Conn, cfg *config.Conf
 	// Relay bidirectionally
 	done := make(chan struct{}, 2)
 	var upBytes, downBytes int64
+	// max_lifetime_sec caps the whole relay, however busy it is
+	relayCtx, stopRelay := context.WithCancel(ctx)
+	if cfg.MaxLifetimeSec > 0 {
+		relayCtx, stopRelay = context.WithTimeout(ctx, time.Duration(cfg.MaxLifetimeSec)*time.Second)
+	}
+	defer stopRelay()
 
 	copyData := func(dst, src net.Conn, bytes *int64) {
 		defer func() { done <- struct{}{} }()
-		buf := make([]byte, 32*1024)
+		bufp := bufpool.Relay.Get()
+		defer bufpool.Relay.Put(bufp)
+		buf := *bufp
 		for {
-			src.SetDeadline(time.Now().Add(timeout))
+			// Every read pushes the deadline out, so this only closes an
+			// idle relay
+			src.SetDeadline(time.Now().Add(idleTimeout))
 			select {
-			case <-ctx.Done():
+			case <-relayCtx.Done():
 				return
 			default:
 			}
@@ -392,67 +576,190 @@ func HandleConnection(ctx context.Context, clientConn net.Conn, cfg *config.Conf
 	go copyData(upConn, clientConn, &upBytes)
 	go copyData(clientConn, upConn, &downBytes)
 
+	pending := 2
 	select {
 	case <-done:
-	case <-ctx.Done():
+		pending--
+	case <-relayCtx.Done():
+		if ctx.Err() == nil {
+			ui.LogStatus("info", accesslog.Tag(id, "Relay for "+sni+" reached max_lifetime_sec, closing"))
+		}
+	}
+
+	// Unblock the other direction and wait for it so the totals are final
+	stopRelay()
+	clientConn.SetDeadline(time.Now())
+	upConn.SetDeadline(time.Now())
+	for ; pending > 0; pending-- {
+		<-done
 	}
 
 	// Record metrics
 	duration := time.Since(startTime).Seconds()
 	MetricConnectionDuration.Observe(duration)
-	MetricBytesTotal.WithLabelValues(sni, "upstream").Add(float64(upBytes))
-	MetricBytesTotal.WithLabelValues(sni, "downstream").Add(float64(downBytes))
+	MetricBytesTotal.WithLabelValues(usageKey, "upstream").Add(float64(upBytes))
+	MetricBytesTotal.WithLabelValues(usageKey, "downstream").Add(float64(downBytes))
 	Stats.RecordBytes(upBytes + downBytes)
+	if bw != nil {
+		bw.RecordBytes(usageKey, upBytes, downBytes)
+	}
+
+	ui.LogRelay(id, sni, clientConn.RemoteAddr().String(), upBytes, downBytes)
+	entry.BytesUp, entry.BytesDown = upBytes, downBytes
+}
 
-	ui.LogRelay(sni, clientConn.RemoteAddr().String(), upBytes, downBytes)
+// httpMethods are the request methods looksLikeHTTP accepts, each with the
+// space that ends it.
+var httpMethods = []string{"GET ", "HEAD ", "POST ", "PUT ", "PATCH ", "DELETE ", "OPTIONS "}
+
+// looksLikeHTTP reports whether data, the first bytes of a non-TLS
+// connection, could start an HTTP/1.x request: it begins with a method (or a
+// prefix of one, if the client's first write was short) and, once the request
+// line is complete, that line ends in an HTTP/1 version.
+func looksLikeHTTP(data []byte) bool {
+	method := false
+	for _, m := range httpMethods {
+		n := min(len(data), len(m))
+		if n > 0 && string(data[:n]) == m[:n] {
+			method = true
+			break
+		}
+	}
+	if !method {
+		return false
+	}
+	line, _, complete := bytes.Cut(data, []byte("\n"))
+	if !complete {
+		return true
+	}
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return bytes.HasPrefix(line[bytes.LastIndexByte(line, ' ')+1:], []byte("HTTP/1."))
 }
 
+// apiIdleTimeout bounds how long a kept-alive API connection waits for the
+// next request.
+const apiIdleTimeout = 10 * time.Second
+
 // handleInternalAPI serves the Stats API directly on the hijacked connection.
 // This allows port 443 to be shared between Signal traffic and the web API.
-func handleInternalAPI(conn net.Conn, initialData []byte) {
-	ui.LogStatus("info", "Handling API request from "+conn.RemoteAddr().String())
+// id is the connection's request ID, for log lines.
+func handleInternalAPI(conn net.Conn, id string, initialData []byte, cfg *config.Config, bw *bandwidth.Tracker) {
+	ui.LogStatus("info", accesslog.Tag(id, "Handling API request from "+conn.RemoteAddr().String()))
 	
 	// Create a combined reader that puts back the data we already read
 	reader := io.MultiReader(bytes.NewReader(initialData), conn)
 	br := bufio.NewReader(reader)
 
-	// Read the HTTP request from the connection
-	req, err := http.ReadRequest(br)
-	if err != nil {
-		if err != io.EOF {
-			ui.LogStatus("error", "API ReadRequest error: "+err.Error())
-		}
-		return
+	// API_AUTH_TOKEN makes /api/* private; the dashboard page stays public
+	var apiToken string
+	var env *config.EnvConfig
+	if cfg != nil && cfg.Env != nil {
+		apiToken = cfg.Env.APIAuthToken
+		env = cfg.Env
 	}
-
-	// Create a simple response writer that writes directly to the connection
-	w := &simpleResponseWriter{
-		conn:   conn,
-		header: make(http.Header),
+	// Bodies are capped: this port is shared with untrusted Signal clients
+	maxBody := env.APIMaxBodyBytes()
+	stats := requireAPIToken(apiToken, StatsHandler)
+	history := requireAPIToken(apiToken, HistoryHandler)
+	usage := apiNotFound
+	if bw != nil {
+		usage = requireAPIToken(apiToken, bandwidth.UsageHandler(bw, Stats.AllowedOrigin(), nil))
 	}
 
-	// Route and handle the request
-	switch req.URL.Path {
-	case "/api/stats":
-		StatsHandler(w, req)
-	case "/api/history":
-		HistoryHandler(w, req)
-	default:
-		http.Error(w, "Not Found", http.StatusNotFound)
-	}
+	for {
+		// Read the HTTP request from the connection
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			if err != io.EOF {
+				ui.LogStatus("error", accesslog.Tag(id, "API ReadRequest error: "+err.Error()))
+			}
+			return
+		}
 
-	// Final verification that headers were sent
-	if !w.wroteHeader {
-		w.WriteHeader(http.StatusOK)
+		// Create a simple response writer that writes directly to the connection
+		w := newSimpleResponseWriter(conn, req)
+
+		// Refuse a declared oversized body unread, closing the connection
+		// since the body is still in the way of the next request
+		if req.ContentLength > maxBody {
+			MetricErrorsTotal.WithLabelValues("api_body_too_large").Inc()
+			setCORSHeaders(w)
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			w.Flush()
+			return
+		}
+		req.Body = http.MaxBytesReader(w, req.Body, maxBody)
+
+		// Route and handle the request. Preflights are answered for every
+		// path ahead of API_AUTH_TOKEN, as browsers send them without
+		// credentials
+		switch {
+		case req.Method == http.MethodOptions:
+			setCORSHeaders(w)
+			w.WriteHeader(http.StatusNoContent)
+		case req.URL.Path == "/api/stats":
+			stats(w, req)
+		case req.URL.Path == "/api/history":
+			history(w, req)
+		case req.URL.Path == "/api/usage":
+			usage(w, req)
+		case req.URL.Path == "/" || req.URL.Path == "/index.html":
+			if cfg != nil && cfg.Env != nil && cfg.Env.DashboardEnabled {
+				DashboardHandler(w, req)
+			} else {
+				apiNotFound(w, req)
+			}
+		default:
+			apiNotFound(w, req)
+		}
+
+		// Final verification that headers were sent
+		if !w.wroteHeader {
+			w.WriteHeader(http.StatusOK)
+		}
+		if err := w.FlushError(); err != nil || !w.keepAlive {
+			return
+		}
+
+		// Drain any unread body so the next request starts at a boundary;
+		// a chunked body running past the cap ends the connection instead
+		if _, err := io.Copy(io.Discard, req.Body); err != nil {
+			return
+		}
+		req.Body.Close()
+		conn.SetDeadline(time.Now().Add(apiIdleTimeout))
 	}
 }
 
+// apiNotFound answers unknown internal API paths with 404, carrying the same
+// CORS headers as the API so browsers can read the error.
+func apiNotFound(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+	http.Error(w, "Not Found", http.StatusNotFound)
+}
+
 // simpleResponseWriter implements http.ResponseWriter for our hijacked connection.
+// Output is buffered; Flush pushes it to the connection immediately. Once a
+// write to the connection fails (e.g. the client went away), every later
+// Write returns that error, so handlers can stop early.
 type simpleResponseWriter struct {
 	conn        net.Conn
+	bw          *bufio.Writer
+	req         *http.Request
 	header      http.Header
 	wroteHeader bool
+	keepAlive   bool
 	status      int
+	err         error // First failed write to conn
+}
+
+func newSimpleResponseWriter(conn net.Conn, req *http.Request) *simpleResponseWriter {
+	return &simpleResponseWriter{
+		conn:   conn,
+		bw:     bufio.NewWriter(conn),
+		req:    req,
+		header: make(http.Header),
+	}
 }
 
 func (w *simpleResponseWriter) Header() http.Header {
@@ -463,7 +770,34 @@ func (w *simpleResponseWriter) Write(b []byte) (int, error) {
 	if !w.wroteHeader {
 		w.WriteHeader(http.StatusOK)
 	}
-	return w.conn.Write(b)
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.bw.Write(b)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// Flush implements http.Flusher, sending headers and any buffered body.
+func (w *simpleResponseWriter) Flush() {
+	w.FlushError()
+}
+
+// FlushError is Flush returning the write error, which
+// http.ResponseController.Flush passes on to handlers.
+func (w *simpleResponseWriter) FlushError() error {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.bw.Flush(); err != nil {
+		w.err = err
+	}
+	return w.err
 }
 
 func (w *simpleResponseWriter) WriteHeader(status int) {
@@ -473,19 +807,33 @@ func (w *simpleResponseWriter) WriteHeader(status int) {
 	w.wroteHeader = true
 	w.status = status
 
+	// Keep the connection open only when the body is length-delimited
+	// (or absent, as for 204 and 304); otherwise the client relies on close
+	// to find the end of the body. HTTP/1.0 clients always get close
+	bodyless := status == http.StatusNoContent || status == http.StatusNotModified
+	w.keepAlive = w.req != nil && w.req.ProtoAtLeast(1, 1) && !w.req.Close &&
+		(w.header.Get("Content-Length") != "" || bodyless)
+
 	// Write HTTP/1.1 response line
-	fmt.Fprintf(w.conn, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	fmt.Fprintf(w.bw, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
 	
 	// Write headers
 	w.header.Set("Date", time.Now().Format(http.TimeFormat))
-	w.header.Set("Connection", "close") // Force close for simplicity
+	if w.keepAlive {
+		w.header.Set("Connection", "keep-alive")
+	} else {
+		w.header.Set("Connection", "close")
+	}
 	
 	for k, vv := range w.header {
 		for _, v := range vv {
-			fmt.Fprintf(w.conn, "%s: %s\r\n", k, v)
+			fmt.Fprintf(w.bw, "%s: %s\r\n", k, v)
 		}
 	}
 	
-	// End of headers
-	fmt.Fprintf(w.conn, "\r\n")
+	// End of headers. The buffer's error is sticky, so this reports any
+	// flush of the header block that failed
+	if _, err := w.bw.WriteString("\r\n"); err != nil {
+		w.err = err
+	}
 }
@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// captureClientHello returns the first TLS record a crypto/tls client sends
+// for serverName.
+func captureClientHello(t testing.TB, serverName string) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+		client.Close()
+	}()
+
+	server.SetDeadline(time.Now().Add(2 * time.Second))
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(server, header); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, int(header[3])<<8|int(header[4]))
+	if _, err := io.ReadFull(server, body); err != nil {
+		t.Fatal(err)
+	}
+	return append(header, body...)
+}
+
+// peekSplit writes hello to PeekSNI in two chunks split at offset.
+func peekSplit(t *testing.T, hello []byte, offset int) (string, []byte) {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		client.Write(hello[:offset])
+		time.Sleep(20 * time.Millisecond) // let PeekSNI consume the first chunk
+		client.Write(hello[offset:])
+	}()
+
+	server.SetDeadline(time.Now().Add(2 * time.Second))
+	sni, data, err := PeekSNI(server)
+	if err != nil {
+		t.Fatalf("PeekSNI: %v", err)
+	}
+	return sni, data
+}
+
+func TestPeekSNISplitClientHello(t *testing.T) {
+	hello := captureClientHello(t, "chat.signal.org")
+
+	for _, offset := range []int{3, 5, 40, len(hello) - 1} {
+		sni, data := peekSplit(t, hello, offset)
+		if sni != "chat.signal.org" {
+			t.Errorf("split at %d: SNI = %q, want chat.signal.org", offset, sni)
+		}
+		if string(data) != string(hello) {
+			t.Errorf("split at %d: returned %d bytes, want the full %d-byte hello", offset, len(data), len(hello))
+		}
+	}
+}
+
+func TestPeekSNINonTLSReturnsAfterFirstRead(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	req := "GET /api/stats HTTP/1.1\r\n"
+	go client.Write([]byte(req))
+
+	server.SetDeadline(time.Now().Add(2 * time.Second))
+	sni, data, err := PeekSNI(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sni != "" || string(data) != req {
+		t.Fatalf("PeekSNI = %q, %q; want no SNI and the request bytes", sni, data)
+	}
+}
+
+func TestPeekSNITruncatedHelloHitsDeadline(t *testing.T) {
+	hello := captureClientHello(t, "chat.signal.org")
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go client.Write(hello[:len(hello)/2])
+
+	server.SetDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := PeekSNI(server); err == nil {
+		t.Fatal("expected deadline error for a hello that never completes")
+	}
+}
+
+// buildClientHello assembles a minimal ClientHello record with cipherCount
+// cipher suites and an SNI extension for serverName (omitted if empty).
+func buildClientHello(serverName string, cipherCount int) []byte {
+	u16 := func(n int) []byte { return []byte{byte(n >> 8), byte(n)} }
+
+	var exts []byte
+	if serverName != "" {
+		entry := append([]byte{0x00}, u16(len(serverName))...)
+		entry = append(entry, serverName...)
+		sni := append(u16(len(entry)), entry...)
+		exts = append(exts, 0x00, 0x00) // server_name
+		exts = append(exts, u16(len(sni))...)
+		exts = append(exts, sni...)
+	}
+
+	body := []byte{0x03, 0x03}               // client_version
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, 0x00)                // session_id
+	body = append(body, u16(cipherCount*2)...)
+	for i := 0; i < cipherCount; i++ {
+		body = append(body, 0x13, 0x01)
+	}
+	body = append(body, 0x01, 0x00) // compression: null
+	body = append(body, u16(len(exts))...)
+	body = append(body, exts...)
+
+	hs := append([]byte{0x01, 0x00}, u16(len(body))...)
+	hs = append(hs, body...)
+	record := append([]byte{0x16, 0x03, 0x01}, u16(len(hs))...)
+	return append(record, hs...)
+}
+
+func TestExtractSNIRealAndLargeHellos(t *testing.T) {
+	if got := extractSNI(captureClientHello(t, "chat.signal.org")); got != "chat.signal.org" {
+		t.Errorf("crypto/tls hello: SNI = %q", got)
+	}
+	// ~8KB of cipher suites, well past the size of one typical TCP segment
+	if got := extractSNI(buildClientHello("cdn.signal.org", 4000)); got != "cdn.signal.org" {
+		t.Errorf("large hello: SNI = %q", got)
+	}
+	if got := extractSNI(buildClientHello("", 2)); got != "" {
+		t.Errorf("hello without SNI: got %q", got)
+	}
+}
+
+func TestExtractSNIMalformedLengths(t *testing.T) {
+	hello := buildClientHello("chat.signal.org", 2)
+	// Offsets within buildClientHello's layout
+	const (
+		sessionIDOff = 5 + 4 + 34
+		cipherLenOff = sessionIDOff + 1
+	)
+
+	mutate := func(f func(b []byte)) []byte {
+		b := append([]byte(nil), hello...)
+		f(b)
+		return b
+	}
+	cases := map[string][]byte{
+		"oversized cipher list": mutate(func(b []byte) { b[cipherLenOff], b[cipherLenOff+1] = 0xff, 0xff }),
+		"oversized session id":  mutate(func(b []byte) { b[sessionIDOff] = 0xff }),
+		"oversized extensions":  mutate(func(b []byte) { b[len(b)-len("chat.signal.org")-11] = 0xff }),
+		"oversized name":        mutate(func(b []byte) { b[len(b)-len("chat.signal.org")-2] = 0xff }),
+	}
+	for name, data := range cases {
+		if got := extractSNI(data); got != "" {
+			t.Errorf("%s: SNI = %q, want empty", name, got)
+		}
+	}
+
+	// Every truncation must be rejected cleanly, never panic
+	for n := 0; n < len(hello); n++ {
+		if got := extractSNI(hello[:n]); got != "" {
+			t.Errorf("truncated to %d bytes: SNI = %q, want empty", n, got)
+		}
+	}
+}
+
+func FuzzExtractSNI(f *testing.F) {
+	// testdata/fuzz/FuzzExtractSNI holds a crypto/tls ClientHello for
+	// chat.signal.org; add generated shapes alongside it
+	f.Add(captureClientHello(f, "storage.signal.org"))
+	f.Add(buildClientHello("cdn.signal.org", 64))
+	f.Add(buildClientHello("", 1))
+	f.Add([]byte{0x16, 0x03, 0x01, 0x00, 0x00})
+	f.Add([]byte("GET / HTTP/1.1\r\n\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sni := extractSNI(data)
+		if len(sni) > maxSNILen || len(sni) > len(data) {
+			t.Fatalf("SNI of %d bytes from %d bytes of input", len(sni), len(data))
+		}
+		if sni != "" && data[0] != 0x16 {
+			t.Fatalf("SNI %q extracted from a non-handshake record", sni)
+		}
+	})
+}
+
+func TestExtractSNIRejectsOverlongName(t *testing.T) {
+	long := make([]byte, maxSNILen+1)
+	for i := range long {
+		long[i] = 'a'
+	}
+	if got := extractSNI(buildClientHello(string(long), 1)); got != "" {
+		t.Fatalf("accepted a %d-byte SNI", len(got))
+	}
+}
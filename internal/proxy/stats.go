@@ -4,9 +4,22 @@ import (
 	"encoding/json"
 	"math"
 	"net/http"
+	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"signal-proxy/internal/bytesize"
+	"signal-proxy/internal/fsutil"
+	"signal-proxy/internal/ui"
+	"signal-proxy/internal/version"
+)
+
+// Default history sampling: one sample per hour, kept for 24 hours.
+const (
+	defaultSampleInterval   = time.Hour
+	defaultHistoryRetention = 24 * time.Hour
 )
 
 // StatsTracker tracks server statistics for the landing page API
@@ -20,27 +33,75 @@ type StatsTracker struct {
 	bytesWindow    []int64
 	bytesWindowMu  sync.Mutex
 	
-	// History for 24h chart (hourly samples)
+	// Per-second relay/error counts for the rolling success rate
+	relayRing  countRing
+	errorRing  countRing
+	lastRelays int64
+	lastErrors int64
+	successMu  sync.Mutex
+
+	// Distinct users (usernames, or client IPs in Signal mode) by last seen
+	users   map[string]time.Time
+	usersMu sync.Mutex
+
+	// History for the chart (hourly samples by default, see SetSampling)
 	history        []HistorySample
 	historyMu      sync.RWMutex
-	AllowedOrigin  string
+	historyFile    string // Persisted across restarts when set
+
+	// History sampling, guarded by historyMu
+	sampleInterval  time.Duration      // Time between history samples
+	maxSamples      int                // Samples kept (retention / interval)
+	lastSampleBytes int64              // totalBytes at the previous sample
+	intervalCh      chan time.Duration // Resets the sample ticker
+
+	// Settings changed after startup, guarded by mu
+	mu            sync.RWMutex
+	allowedOrigin string // CORS origin for the stats API (API_ALLOWED_ORIGIN), empty = no CORS header
+	latencyMs     float64 // Moving average of upstream connect times (0 = none yet)
 }
 
 // HistorySample represents a single data point for historical charts
 type HistorySample struct {
-	Time    string `json:"time"`
-	Users   int64  `json:"users"`
-	Traffic int64  `json:"traffic"`
+	Time      string `json:"time"`      // "15:04", local time, as shown on the chart
+	Timestamp string `json:"timestamp"` // RFC3339, UTC; used to drop stale samples on load
+	Users     int64  `json:"users"`     // Distinct users seen in the 24h before the sample
+	Relays    int64  `json:"relays"`    // Lifetime relayed connections (users before it counted users)
+	Traffic   int64  `json:"traffic"`
+}
+
+// successWindowSeconds is the span of the rolling success rate (5 minutes).
+const successWindowSeconds = 300
+
+// countRing is a fixed-size ring of per-second counts with a running sum.
+type countRing struct {
+	slots [successWindowSeconds]int64
+	next  int
+	sum   int64
 }
 
+// push records the count for the latest second, evicting the oldest.
+func (r *countRing) push(n int64) {
+	r.sum += n - r.slots[r.next]
+	r.slots[r.next] = n
+	r.next = (r.next + 1) % len(r.slots)
+}
+
+// uniqueUserWindow is how recently a user must have been seen to count
+// towards totalUsers.
+const uniqueUserWindow = 24 * time.Hour
+
 // StatsResponse is the JSON response for /api/stats
 type StatsResponse struct {
-	TotalUsers        int64   `json:"totalUsers"`
+	TotalUsers        int64   `json:"totalUsers"`  // Distinct users seen in the last 24h
+	TotalRelays       int64   `json:"totalRelays"` // Lifetime relayed connections (totalUsers before it counted users)
 	ActiveConnections int     `json:"activeConnections"`
 	UptimeSeconds     int64   `json:"uptimeSeconds"`
 	DataThroughput    string  `json:"dataThroughput"`
-	Latency           int     `json:"latency"`
-	SuccessRate       float64 `json:"successRate"`
+	Latency           int     `json:"latency"` // Average upstream connect time in ms
+	SuccessRate       float64 `json:"successRate"`       // Lifetime
+	RecentSuccessRate float64 `json:"recentSuccessRate"` // Last 5 minutes
+	Version           string  `json:"version"`
 }
 
 // Global stats tracker instance
@@ -48,6 +109,7 @@ var Stats = &StatsTracker{
 	startTime:   time.Now(),
 	bytesWindow: make([]int64, 0, 60),
 	history:     make([]HistorySample, 0, 24),
+	intervalCh:  make(chan time.Duration, 1),
 }
 
 func init() {
@@ -58,9 +120,9 @@ func init() {
 // backgroundUpdater runs every second to update rolling metrics
 func (s *StatsTracker) backgroundUpdater() {
 	secondTicker := time.NewTicker(1 * time.Second)
-	hourTicker := time.NewTicker(1 * time.Hour)
+	sampleTicker := time.NewTicker(defaultSampleInterval)
 	defer secondTicker.Stop()
-	defer hourTicker.Stop()
+	defer sampleTicker.Stop()
 
 	var lastBytes int64
 
@@ -79,27 +141,207 @@ func (s *StatsTracker) backgroundUpdater() {
 			}
 			s.bytesWindowMu.Unlock()
 
-		case <-hourTicker.C:
-			// Record hourly sample for history
-			s.historyMu.Lock()
-			s.history = append(s.history, HistorySample{
-				Time:    time.Now().Format("15:04"),
-				Users:   s.totalRelays.Load(),
-				Traffic: s.totalBytes.Load(),
-			})
-			if len(s.history) > 24 {
-				s.history = s.history[1:]
+			s.sampleSuccessWindow()
+
+		case interval := <-s.intervalCh:
+			sampleTicker.Reset(interval)
+
+		case <-sampleTicker.C:
+			s.recordHistorySample(time.Now())
+			if err := s.SaveHistory(); err != nil {
+				ui.LogStatus("warn", "Failed to save stats history: "+err.Error())
 			}
-			s.historyMu.Unlock()
 		}
 	}
 }
 
+// SetSampling sets how often history samples are taken and how long they
+// are kept. Non-positive values select the defaults (1h and 24h).
+func (s *StatsTracker) SetSampling(interval, retention time.Duration) {
+	if interval <= 0 {
+		interval = defaultSampleInterval
+	}
+	if retention <= 0 {
+		retention = defaultHistoryRetention
+	}
+	maxSamples := int(retention / interval)
+	if maxSamples < 1 {
+		maxSamples = 1
+	}
+
+	s.historyMu.Lock()
+	s.sampleInterval = interval
+	s.maxSamples = maxSamples
+	if len(s.history) > maxSamples {
+		s.history = s.history[len(s.history)-maxSamples:]
+	}
+	s.historyMu.Unlock()
+
+	// Replace any pending reset so the latest interval wins
+	if s.intervalCh != nil {
+		select {
+		case <-s.intervalCh:
+		default:
+		}
+		s.intervalCh <- interval
+	}
+}
+
+// historyLimit returns the number of samples to keep. Caller holds historyMu.
+func (s *StatsTracker) historyLimit() int {
+	if s.maxSamples > 0 {
+		return s.maxSamples
+	}
+	return int(defaultHistoryRetention / defaultSampleInterval)
+}
+
+// historyWindow returns how far back samples are kept. Caller holds
+// historyMu.
+func (s *StatsTracker) historyWindow() time.Duration {
+	interval := s.sampleInterval
+	if interval <= 0 {
+		interval = defaultSampleInterval
+	}
+	return time.Duration(s.historyLimit()) * interval
+}
+
+// recordHistorySample appends a sample holding the traffic since the
+// previous sample, trimming to the retention window.
+func (s *StatsTracker) recordHistorySample(now time.Time) {
+	users := s.UniqueUsers()
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	totalBytes := s.totalBytes.Load()
+	s.history = append(s.history, HistorySample{
+		Time:      now.Format("15:04"),
+		Timestamp: now.UTC().Format(time.RFC3339),
+		Users:     users,
+		Relays:    s.totalRelays.Load(),
+		Traffic:   totalBytes - s.lastSampleBytes,
+	})
+	s.lastSampleBytes = totalBytes
+	if limit := s.historyLimit(); len(s.history) > limit {
+		s.history = s.history[len(s.history)-limit:]
+	}
+}
+
+// LoadHistory restores history samples from path and remembers path for
+// SaveHistory. Samples older than the retention window, or without a full
+// timestamp (files from before they were dated), are dropped. A missing file
+// is not an error.
+func (s *StatsTracker) LoadHistory(path string) error {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	s.historyFile = path
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var saved []HistorySample
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-s.historyWindow())
+	samples := saved[:0]
+	for _, sample := range saved {
+		if at, err := time.Parse(time.RFC3339, sample.Timestamp); err == nil && at.After(cutoff) {
+			samples = append(samples, sample)
+		}
+	}
+	if limit := s.historyLimit(); len(samples) > limit {
+		samples = samples[len(samples)-limit:]
+	}
+	s.history = samples
+	return nil
+}
+
+// FlushHistory records a last sample for the traffic since the previous
+// one and saves the history, so a shutdown between samples loses nothing.
+func (s *StatsTracker) FlushHistory() error {
+	s.recordHistorySample(time.Now())
+	return s.SaveHistory()
+}
+
+// SaveHistory writes the history samples to the file given to LoadHistory.
+// It is a no-op when persistence is not configured.
+func (s *StatsTracker) SaveHistory() error {
+	s.historyMu.RLock()
+	path := s.historyFile
+	data, err := json.Marshal(s.history)
+	s.historyMu.RUnlock()
+
+	if path == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(path, data, 0644)
+}
+
 // RecordRelay records a successful relay connection
 func (s *StatsTracker) RecordRelay() {
 	s.totalRelays.Add(1)
 }
 
+// latencyWeight is how much each new connect time moves the latency average.
+const latencyWeight = 0.2
+
+// RecordLatency folds the time taken to connect to an upstream server into
+// the latency reported by /api/stats.
+func (s *StatsTracker) RecordLatency(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.latencyMs == 0 {
+		s.latencyMs = ms
+		return
+	}
+	s.latencyMs += latencyWeight * (ms - s.latencyMs)
+}
+
+// RecordUser marks a user as active. In Signal mode, which has no
+// accounts, callers pass the client IP instead.
+func (s *StatsTracker) RecordUser(id string) {
+	s.recordUserAt(id, time.Now())
+}
+
+func (s *StatsTracker) recordUserAt(id string, now time.Time) {
+	if id == "" {
+		return
+	}
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	if s.users == nil {
+		s.users = make(map[string]time.Time)
+	}
+	s.users[id] = now
+}
+
+// UniqueUsers returns how many distinct users were seen within the window,
+// pruning entries that have aged out.
+func (s *StatsTracker) UniqueUsers() int64 {
+	cutoff := time.Now().Add(-uniqueUserWindow)
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	for id, seen := range s.users {
+		if seen.Before(cutoff) {
+			delete(s.users, id)
+		}
+	}
+	return int64(len(s.users))
+}
+
 // RecordBytes records bytes transferred
 func (s *StatsTracker) RecordBytes(n int64) {
 	s.totalBytes.Add(n)
@@ -125,31 +367,81 @@ func (s *StatsTracker) GetThroughput() string {
 	}
 	avg := total / int64(len(s.bytesWindow))
 
-	return formatBytes(avg) + "/s"
+	return bytesize.Format(avg, bytesize.Options{Space: true}) + "/s"
 }
 
 // GetSuccessRate calculates the success rate percentage
 func (s *StatsTracker) GetSuccessRate() float64 {
-	relays := s.totalRelays.Load()
-	errors := s.totalErrors.Load()
-	
+	return successRate(s.totalRelays.Load(), s.totalErrors.Load())
+}
+
+// successRate returns relays as a percentage of relays and errors, rounded
+// to one decimal; 100 when there were neither.
+func successRate(relays, errors int64) float64 {
 	total := relays + errors
 	if total == 0 {
 		return 100.0
 	}
-	
 	return math.Round(float64(relays)/float64(total)*100.0*10) / 10
 }
 
-// GetStats returns the current stats for the API
+// sampleSuccessWindow pushes the relays and errors seen since the previous
+// call into the rolling window. Called once per second.
+func (s *StatsTracker) sampleSuccessWindow() {
+	relays := s.totalRelays.Load()
+	errors := s.totalErrors.Load()
+
+	s.successMu.Lock()
+	defer s.successMu.Unlock()
+	s.relayRing.push(relays - s.lastRelays)
+	s.errorRing.push(errors - s.lastErrors)
+	s.lastRelays = relays
+	s.lastErrors = errors
+}
+
+// GetRecentSuccessRate calculates the success rate over the last 5 minutes
+func (s *StatsTracker) GetRecentSuccessRate() float64 {
+	s.successMu.Lock()
+	relays := s.relayRing.sum
+	errors := s.errorRing.sum
+	s.successMu.Unlock()
+
+	return successRate(relays, errors)
+}
+
+// SetAllowedOrigin sets the CORS origin sent by the stats API
+// (API_ALLOWED_ORIGIN); empty sends no CORS headers.
+func (s *StatsTracker) SetAllowedOrigin(origin string) {
+	s.mu.Lock()
+	s.allowedOrigin = origin
+	s.mu.Unlock()
+}
+
+// AllowedOrigin returns the origin set by SetAllowedOrigin.
+func (s *StatsTracker) AllowedOrigin() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.allowedOrigin
+}
+
+// GetStats returns the current stats for the API. The snapshot is built
+// under mu, and relays and errors are read once, so totalRelays and
+// successRate describe the same moment even while relays are being recorded.
 func (s *StatsTracker) GetStats() StatsResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	relays, errors := s.totalRelays.Load(), s.totalErrors.Load()
+
 	return StatsResponse{
-		TotalUsers:        s.totalRelays.Load(),
+		TotalUsers:        s.UniqueUsers(),
+		TotalRelays:       relays,
 		ActiveConnections: GetActiveConns(),
 		UptimeSeconds:     int64(time.Since(s.startTime).Seconds()),
 		DataThroughput:    s.GetThroughput(),
-		Latency:           18, // TODO: Implement actual latency tracking
-		SuccessRate:       s.GetSuccessRate(),
+		Latency:           int(math.Round(s.latencyMs)),
+		SuccessRate:       successRate(relays, errors),
+		RecentSuccessRate: s.GetRecentSuccessRate(),
+		Version:           version.Version,
 	}
 }
 
@@ -164,41 +456,21 @@ func (s *StatsTracker) GetHistory() []HistorySample {
 	return result
 }
 
-// formatBytes converts bytes to human readable format
-func formatBytes(bytes int64) string {
-	const (
-		KB = 1024
-		MB = KB * 1024
-		GB = MB * 1024
-	)
-
-	switch {
-	case bytes >= GB:
-		return formatFloat(float64(bytes)/float64(GB)) + " GB"
-	case bytes >= MB:
-		return formatFloat(float64(bytes)/float64(MB)) + " MB"
-	case bytes >= KB:
-		return formatFloat(float64(bytes)/float64(KB)) + " KB"
-	default:
-		return itoa(int(bytes)) + " B"
-	}
-}
-
-// formatFloat formats a float with 1 decimal place
-func formatFloat(f float64) string {
-	intPart := int(f)
-	decPart := int((f - float64(intPart)) * 10)
-	if decPart < 0 {
-		decPart = -decPart
+// setCORSHeaders allows Stats.AllowedOrigin() to call the stats API. Nothing
+// is sent while it is unset, rather than a blank Access-Control-Allow-Origin.
+func setCORSHeaders(w http.ResponseWriter) {
+	origin := Stats.AllowedOrigin()
+	if origin == "" {
+		return
 	}
-	return itoa(intPart) + "." + itoa(decPart)
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
 }
 
 // StatsHandler handles /api/stats requests
 func StatsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", Stats.AllowedOrigin)
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	setCORSHeaders(w)
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method == "OPTIONS" {
@@ -206,15 +478,12 @@ func StatsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	stats := Stats.GetStats()
-	json.NewEncoder(w).Encode(stats)
+	writeJSON(w, Stats.GetStats())
 }
 
 // HistoryHandler handles /api/history requests
 func HistoryHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", Stats.AllowedOrigin)
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	setCORSHeaders(w)
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method == "OPTIONS" {
@@ -229,7 +498,20 @@ func HistoryHandler(w http.ResponseWriter, r *http.Request) {
 		history = generateInitialHistory()
 	}
 	
-	json.NewEncoder(w).Encode(history)
+	writeJSON(w, history)
+}
+
+// writeJSON sends v as the JSON body with a Content-Length, so the internal
+// API can keep the connection alive for the dashboard's next request.
+func writeJSON(w http.ResponseWriter, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
 }
 
 // generateInitialHistory creates initial history data for new deployments
@@ -240,9 +522,11 @@ func generateInitialHistory() []HistorySample {
 	for i := 0; i < 24; i++ {
 		t := now.Add(time.Duration(i-23) * time.Hour)
 		history[i] = HistorySample{
-			Time:    t.Format("15:04"),
-			Users:   0,
-			Traffic: 0,
+			Time:      t.Format("15:04"),
+			Timestamp: t.UTC().Format(time.RFC3339),
+			Users:     0,
+			Relays:    0,
+			Traffic:   0,
 		}
 	}
 	
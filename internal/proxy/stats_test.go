@@ -0,0 +1,321 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"signal-proxy/internal/version"
+)
+
+// newTestStats returns a tracker with no background updater.
+func newTestStats() *StatsTracker {
+	return &StatsTracker{startTime: time.Now()}
+}
+
+func TestStatsHistoryPersistsAcrossRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats_history.json")
+
+	before := newTestStats()
+	if err := before.LoadHistory(path); err != nil {
+		t.Fatalf("LoadHistory on missing file: %v", err)
+	}
+	base := time.Now().Truncate(time.Hour).Add(-29 * time.Hour)
+	for i := 0; i < 30; i++ {
+		before.RecordBytes(100)
+		before.recordHistorySample(base.Add(time.Duration(i) * time.Hour))
+	}
+	if err := before.SaveHistory(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulated restart: a fresh tracker loads the saved file
+	after := newTestStats()
+	if err := after.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+
+	got, want := after.GetHistory(), before.GetHistory()
+	if len(got) != 24 {
+		t.Fatalf("restored %d samples, want 24", len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if want := base.Add(6 * time.Hour).UTC().Format(time.RFC3339); got[0].Timestamp != want {
+		t.Errorf("oldest sample at %s, want %s after trimming", got[0].Timestamp, want)
+	}
+}
+
+func TestStatsHistoryDropsStaleSamplesOnLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats_history.json")
+	now := time.Now().UTC()
+	saved := []HistorySample{
+		{Time: "13:00", Traffic: 1}, // undated, from an older release
+		{Time: "13:00", Timestamp: now.Add(-48 * time.Hour).Format(time.RFC3339), Traffic: 2}, // a previous day
+		{Time: "13:00", Timestamp: now.Add(-time.Hour).Format(time.RFC3339), Traffic: 3},      // within the window
+	}
+	data, _ := json.Marshal(saved)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestStats()
+	if err := s.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.GetHistory(); len(got) != 1 || got[0].Traffic != 3 {
+		t.Fatalf("restored %+v, want only the sample from the last 24h", got)
+	}
+}
+
+func TestStatsFlushHistoryRecordsLastSample(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats_history.json")
+	s := newTestStats()
+	if err := s.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	s.RecordBytes(500)
+	if err := s.FlushHistory(); err != nil {
+		t.Fatal(err)
+	}
+
+	after := newTestStats()
+	if err := after.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := after.GetHistory(); len(got) != 1 || got[0].Traffic != 500 {
+		t.Fatalf("restored %+v, want the shutdown sample with 500 bytes", got)
+	}
+}
+
+func TestStatsHistoryTimeFormat(t *testing.T) {
+	s := newTestStats()
+	at := time.Date(2026, 1, 1, 9, 5, 0, 0, time.Local)
+	s.recordHistorySample(at)
+
+	got := s.GetHistory()[0]
+	if got.Time != "09:05" {
+		t.Errorf("time = %q, want %q as before timestamps were added", got.Time, "09:05")
+	}
+	if want := at.UTC().Format(time.RFC3339); got.Timestamp != want {
+		t.Errorf("timestamp = %q, want %q", got.Timestamp, want)
+	}
+}
+
+func TestStatsHistoryWithoutFile(t *testing.T) {
+	s := newTestStats()
+	s.recordHistorySample(time.Now())
+	if err := s.SaveHistory(); err != nil {
+		t.Fatalf("SaveHistory without a path should be a no-op: %v", err)
+	}
+}
+
+func TestStatsHistorySampleHoldsIntervalTraffic(t *testing.T) {
+	s := newTestStats()
+	now := time.Now()
+
+	s.RecordBytes(1000)
+	s.recordHistorySample(now)
+	s.RecordBytes(250)
+	s.recordHistorySample(now.Add(5 * time.Minute))
+	s.recordHistorySample(now.Add(10 * time.Minute)) // idle interval
+
+	history := s.GetHistory()
+	want := []int64{1000, 250, 0}
+	if len(history) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(history), len(want))
+	}
+	for i, w := range want {
+		if history[i].Traffic != w {
+			t.Errorf("sample %d traffic = %d, want %d (interval, not lifetime total)", i, history[i].Traffic, w)
+		}
+	}
+}
+
+func TestStatsSetSamplingRetention(t *testing.T) {
+	s := newTestStats()
+	s.SetSampling(5*time.Minute, time.Hour)
+
+	now := time.Now()
+	for i := 0; i < 20; i++ {
+		s.recordHistorySample(now.Add(time.Duration(i) * 5 * time.Minute))
+	}
+	if got := len(s.GetHistory()); got != 12 {
+		t.Fatalf("kept %d samples, want 12 (1h at 5m)", got)
+	}
+
+	// Shrinking retention trims existing samples immediately
+	s.SetSampling(10*time.Minute, 30*time.Minute)
+	if got := len(s.GetHistory()); got != 3 {
+		t.Fatalf("kept %d samples after shrink, want 3", got)
+	}
+}
+
+func TestStatsUniqueUsers(t *testing.T) {
+	s := newTestStats()
+	for _, name := range []string{"alice", "bob", "alice", "carol", "bob", "alice"} {
+		s.RecordUser(name)
+		s.RecordRelay()
+	}
+	s.RecordUser("") // ignored
+	s.recordUserAt("dave", time.Now().Add(-uniqueUserWindow-time.Minute))
+
+	stats := s.GetStats()
+	if stats.TotalUsers != 3 {
+		t.Errorf("TotalUsers = %d, want 3 distinct recent users", stats.TotalUsers)
+	}
+	if stats.TotalRelays != 6 {
+		t.Errorf("TotalRelays = %d, want 6", stats.TotalRelays)
+	}
+
+	s.recordHistorySample(time.Now())
+	if got := s.GetHistory()[0]; got.Users != 3 || got.Relays != 6 {
+		t.Errorf("history sample users=%d relays=%d, want 3 and 6", got.Users, got.Relays)
+	}
+}
+
+func TestStatsRecentSuccessRateDropsOnOutage(t *testing.T) {
+	s := newTestStats()
+
+	// A long healthy history...
+	for i := 0; i < 1000; i++ {
+		s.RecordRelay()
+	}
+	s.sampleSuccessWindow()
+	// ...that has aged out of the 5-minute window
+	for i := 0; i < successWindowSeconds; i++ {
+		s.sampleSuccessWindow()
+	}
+
+	// Current outage: half of recent attempts fail
+	for i := 0; i < 5; i++ {
+		s.RecordRelay()
+		s.RecordError()
+	}
+	s.sampleSuccessWindow()
+
+	stats := s.GetStats()
+	if stats.RecentSuccessRate != 50 {
+		t.Errorf("RecentSuccessRate = %v, want 50", stats.RecentSuccessRate)
+	}
+	if stats.SuccessRate < 99 {
+		t.Errorf("SuccessRate = %v, want lifetime rate to stay above 99", stats.SuccessRate)
+	}
+}
+
+func TestStatsResponseIncludesVersion(t *testing.T) {
+	defer func(v string) { version.Version = v }(version.Version)
+	version.Version = "v9.9.9-test"
+
+	rec := httptest.NewRecorder()
+	StatsHandler(rec, httptest.NewRequest("GET", "/api/stats", nil))
+
+	var resp StatsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Version != "v9.9.9-test" {
+		t.Errorf("version = %q, want v9.9.9-test", resp.Version)
+	}
+}
+
+func TestStatsCORSReflectsConfiguredOrigin(t *testing.T) {
+	defer Stats.SetAllowedOrigin(Stats.AllowedOrigin())
+
+	Stats.SetAllowedOrigin("https://dashboard.example.com")
+	for name, h := range map[string]http.HandlerFunc{"stats": StatsHandler, "history": HistoryHandler} {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest("GET", "/api/"+name, nil))
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://dashboard.example.com" {
+			t.Errorf("%s Access-Control-Allow-Origin = %q, want the configured origin", name, got)
+		}
+	}
+
+	Stats.SetAllowedOrigin("")
+	rec := httptest.NewRecorder()
+	StatsHandler(rec, httptest.NewRequest("GET", "/api/stats", nil))
+	if _, ok := rec.Header()["Access-Control-Allow-Origin"]; ok {
+		t.Error("unset origin sent an Access-Control-Allow-Origin header")
+	}
+}
+
+func TestStatsLatencyAverage(t *testing.T) {
+	s := newTestStats()
+	if got := s.GetStats().Latency; got != 0 {
+		t.Errorf("Latency = %d before any connection, want 0", got)
+	}
+	s.RecordLatency(50 * time.Millisecond)
+	if got := s.GetStats().Latency; got != 50 {
+		t.Errorf("Latency = %d after the first connection, want 50", got)
+	}
+	// One slow connect moves the average without taking it over
+	s.RecordLatency(150 * time.Millisecond)
+	if got := s.GetStats().Latency; got != 70 {
+		t.Errorf("Latency = %d after a 150ms connect, want 70", got)
+	}
+}
+
+// TestStatsConcurrentAccess is meant for go test -race: readers take
+// snapshots while relays are recorded and settings change.
+func TestStatsConcurrentAccess(t *testing.T) {
+	defer Stats.SetAllowedOrigin(Stats.AllowedOrigin())
+	s := newTestStats()
+
+	stop := make(chan struct{})
+	var writers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				s.RecordRelay()
+				s.RecordLatency(20 * time.Millisecond)
+				s.RecordBytes(512)
+				s.RecordUser("alice")
+			}
+		}()
+	}
+	writers.Add(1)
+	go func() {
+		defer writers.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			s.sampleSuccessWindow()
+			s.recordHistorySample(time.Now())
+			Stats.SetAllowedOrigin([]string{"https://a.example.com", ""}[i%2])
+		}
+	}()
+
+	var last int64
+	for i := 0; i < 2000; i++ {
+		stats := s.GetStats()
+		if stats.TotalRelays < last {
+			t.Fatalf("TotalRelays went back from %d to %d", last, stats.TotalRelays)
+		}
+		last = stats.TotalRelays
+		if stats.SuccessRate != 100 {
+			t.Fatalf("SuccessRate = %v with no errors recorded", stats.SuccessRate)
+		}
+		s.GetHistory()
+		StatsHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/stats", nil))
+	}
+	close(stop)
+	writers.Wait()
+}
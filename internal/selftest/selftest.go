@@ -0,0 +1,221 @@
+// Package selftest checks a deployment's configuration without binding any
+// listeners, for use in CI and pre-deploy hooks.
+package selftest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"signal-proxy/internal/auth"
+	"signal-proxy/internal/config"
+	"signal-proxy/internal/ui"
+)
+
+// DefaultDialTimeout bounds each upstream reachability check.
+const DefaultDialTimeout = 5 * time.Second
+
+// Result is the outcome of a single check.
+type Result struct {
+	Check  string
+	Target string
+	OK     bool
+	Detail string
+}
+
+// Run performs the checks that apply to cfg's proxy mode: config
+// validation, certificates, users.json and upstream reachability.
+func Run(cfg *config.Config) []Result {
+	var results []Result
+
+	signalMode := cfg.Env == nil || cfg.Env.IsSignalMode()
+	if signalMode {
+		// Validate covers the Signal relay (listen address, certs, hosts)
+		res := Result{Check: "config", Target: cfg.Path, OK: true, Detail: "valid"}
+		if err := cfg.Validate(); err != nil {
+			res.OK = false
+			res.Detail = strings.ReplaceAll(strings.TrimPrefix(err.Error(), "config validation failed:\n  - "), "\n  - ", "; ")
+		}
+		results = append(results, res)
+	}
+
+	if signalMode || cfg.Env.HTTPProxyTLS {
+		if cfg.Env != nil && cfg.Env.ACMEEnabled {
+			results = append(results, CheckACME(cfg.Env))
+		} else {
+			results = append(results, CheckCertificate(cfg.CertFile, cfg.KeyFile, time.Now()))
+		}
+	}
+
+	// users.json is required in HTTPS/SOCKS5 mode and checked in Signal
+	// mode only if present
+	if cfg.Env != nil {
+		if _, err := os.Stat(cfg.Env.UsersFile); err == nil || !signalMode {
+			results = append(results, CheckUsers(cfg.Env.UsersFile))
+		}
+	}
+
+	if signalMode {
+		results = append(results, CheckTargets(cfg.Hosts, DefaultDialTimeout)...)
+	}
+	return results
+}
+
+// CheckCertificate verifies that the key pair loads and the leaf
+// certificate is currently valid.
+func CheckCertificate(certFile, keyFile string, now time.Time) Result {
+	res := Result{Check: "certificate", Target: certFile}
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		res.Detail = err.Error()
+		return res
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		res.Detail = err.Error()
+		return res
+	}
+	switch {
+	case now.Before(leaf.NotBefore):
+		res.Detail = "not valid until " + leaf.NotBefore.Format(time.RFC3339)
+	case now.After(leaf.NotAfter):
+		res.Detail = "expired " + leaf.NotAfter.Format(time.RFC3339)
+	default:
+		res.OK = true
+		res.Detail = "expires " + leaf.NotAfter.Format("2006-01-02")
+	}
+	return res
+}
+
+// CheckACME verifies that ACME has domains to issue for and that its cache
+// directory is writable. It does not contact the CA, and it leaves the
+// filesystem as it found it: a missing cache directory is not created, the
+// nearest existing parent is checked instead.
+func CheckACME(env *config.EnvConfig) Result {
+	res := Result{Check: "certificate", Target: "acme:" + env.ACMECacheDir}
+	if len(env.ACMEDomains) == 0 {
+		res.Detail = "ACME_DOMAINS is empty"
+		return res
+	}
+	dir := filepath.Clean(env.ACMECacheDir)
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				res.Detail = dir + " is not a directory"
+				return res
+			}
+			break
+		}
+		parent := filepath.Dir(dir)
+		if !os.IsNotExist(err) || parent == dir {
+			res.Detail = err.Error()
+			return res
+		}
+		dir = parent
+	}
+	probe, err := os.CreateTemp(dir, ".selftest-*")
+	if err != nil {
+		res.Detail = "cache not writable: " + err.Error()
+		return res
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	res.OK = true
+	res.Detail = "managed by ACME for " + strings.Join(env.ACMEDomains, ", ")
+	return res
+}
+
+// CheckUsers loads the users file the same way the proxy does.
+func CheckUsers(path string) Result {
+	res := Result{Check: "users", Target: path}
+	store, err := auth.NewUserStore(path)
+	if err != nil {
+		res.Detail = err.Error()
+		return res
+	}
+	defer store.Close()
+	res.OK = true
+	res.Detail = strconv.Itoa(store.GetUserCount()) + " enabled users"
+	return res
+}
+
+// CheckTargets dials every upstream in hosts concurrently. Results are
+// sorted by SNI hostname.
+func CheckTargets(hosts map[string]string, timeout time.Duration) []Result {
+	names := make([]string, 0, len(hosts))
+	for sni := range hosts {
+		names = append(names, sni)
+	}
+	sort.Strings(names)
+
+	results := make([]Result, len(names))
+	var wg sync.WaitGroup
+	for i, sni := range names {
+		wg.Add(1)
+		go func(i int, sni string) {
+			defer wg.Done()
+			results[i] = CheckTarget(sni, hosts[sni], timeout)
+		}(i, sni)
+	}
+	wg.Wait()
+	return results
+}
+
+// CheckTarget opens (and immediately closes) a TCP connection to target.
+func CheckTarget(sni, target string, timeout time.Duration) Result {
+	res := Result{Check: "upstream " + sni, Target: target}
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", target, timeout)
+	if err != nil {
+		res.Detail = err.Error()
+		return res
+	}
+	conn.Close()
+	res.OK = true
+	res.Detail = strconv.FormatInt(time.Since(start).Milliseconds(), 10) + "ms"
+	return res
+}
+
+// Failed reports whether any check did not pass.
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if !r.OK {
+			return true
+		}
+	}
+	return false
+}
+
+// Render formats results as a pass/fail table.
+func Render(results []Result) string {
+	rows := make([]map[string]string, 0, len(results))
+	for _, r := range results {
+		status := ui.Success("PASS")
+		if !r.OK {
+			status = ui.Error("FAIL")
+		}
+		rows = append(rows, map[string]string{
+			"status": status,
+			"check":  r.Check,
+			"target": r.Target,
+			"detail": r.Detail,
+		})
+	}
+	return ui.RenderTable(ui.RenderTableOptions{
+		Columns: []ui.TableColumn{
+			{Key: "status", Header: "Status"},
+			{Key: "check", Header: "Check"},
+			{Key: "target", Header: "Target"},
+			{Key: "detail", Header: "Detail"},
+		},
+		Rows: rows,
+	})
+}
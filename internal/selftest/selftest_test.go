@@ -0,0 +1,113 @@
+package selftest
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"signal-proxy/internal/config"
+)
+
+// stubListener accepts and drops connections until the test ends.
+func stubListener(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// closedAddr returns an address with nothing listening on it.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestCheckTargets(t *testing.T) {
+	up := stubListener(t)
+	down := closedAddr(t)
+
+	results := CheckTargets(map[string]string{
+		"chat.signal.org":    up,
+		"cdn.signal.org":     down,
+		"storage.signal.org": up,
+	}, time.Second)
+
+	want := []struct {
+		check string
+		ok    bool
+	}{
+		{"upstream cdn.signal.org", false},
+		{"upstream chat.signal.org", true},
+		{"upstream storage.signal.org", true},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, w := range want {
+		r := results[i]
+		if r.Check != w.check || r.OK != w.ok {
+			t.Errorf("result %d = %+v, want %s ok=%v", i, r, w.check, w.ok)
+		}
+		if r.Detail == "" {
+			t.Errorf("result %d has no detail", i)
+		}
+	}
+	if !Failed(results) {
+		t.Error("Failed = false with an unreachable upstream")
+	}
+	if Failed(results[1:]) {
+		t.Error("Failed = true with only reachable upstreams")
+	}
+}
+
+func TestCheckTargetTimeout(t *testing.T) {
+	// 192.0.2.0/24 (TEST-NET-1) is unroutable, so the dial must time out
+	start := time.Now()
+	r := CheckTarget("example", "192.0.2.1:443", 200*time.Millisecond)
+	if r.OK {
+		t.Fatal("dial to TEST-NET-1 succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("check took %s, want it bounded by the timeout", elapsed)
+	}
+}
+
+func TestCheckCertificateMissing(t *testing.T) {
+	r := CheckCertificate("/nonexistent/server.crt", "/nonexistent/server.key", time.Now())
+	if r.OK {
+		t.Fatal("missing certificate passed")
+	}
+}
+
+func TestCheckACME(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "acme")
+	if r := CheckACME(&config.EnvConfig{ACMECacheDir: dir, ACMEDomains: []string{"proxy.example.com"}}); !r.OK {
+		t.Errorf("CheckACME = %+v, want OK with a writable cache dir", r)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("CheckACME created the cache dir: %v", err)
+	}
+	if r := CheckACME(&config.EnvConfig{ACMECacheDir: dir}); r.OK {
+		t.Error("CheckACME passed without ACME_DOMAINS")
+	}
+}
@@ -0,0 +1,38 @@
+package socks5
+
+import (
+	"net"
+
+	"signal-proxy/internal/accesslog"
+	"signal-proxy/internal/ui"
+)
+
+// loggedConn is a client connection carrying its access log entry, so the
+// reply sent on it and the bytes relayed over it can be recorded.
+type loggedConn struct {
+	net.Conn
+	entry *accesslog.Entry
+}
+
+// logStatus logs message tagged with the request ID of conn's access log
+// entry.
+func logStatus(conn net.Conn, category, message string) {
+	var id string
+	if lc, ok := conn.(*loggedConn); ok {
+		id = lc.entry.ID
+	}
+	ui.LogStatus(category, accesslog.Tag(id, message))
+}
+
+// commandName names a SOCKS5 command for the access log.
+func commandName(cmd byte) string {
+	switch cmd {
+	case CmdConnect:
+		return "CONNECT"
+	case CmdBind:
+		return "BIND"
+	case CmdUDP:
+		return "UDP_ASSOCIATE"
+	}
+	return "UNKNOWN"
+}
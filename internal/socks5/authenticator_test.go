@@ -0,0 +1,69 @@
+package socks5
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"signal-proxy/internal/auth"
+	"signal-proxy/internal/config"
+)
+
+// stubAuth is a minimal auth.Authenticator standing in for a custom backend
+// such as a database: a fixed set of passwords and no other restrictions.
+type stubAuth struct {
+	passwords map[string]string
+
+	mu     sync.Mutex
+	checks []string // Usernames charged by CheckRateLimit
+}
+
+func (a *stubAuth) ValidateCredentials(username, password string) (*auth.User, bool) {
+	if want, ok := a.passwords[username]; !ok || want != password {
+		return nil, false
+	}
+	return a.GetUser(username), true
+}
+
+func (a *stubAuth) GetUser(username string) *auth.User {
+	if _, ok := a.passwords[username]; !ok {
+		return nil
+	}
+	return &auth.User{Username: username, Role: "user", Enabled: true}
+}
+
+func (a *stubAuth) CheckIPAllowed(string) bool                            { return true }
+func (a *stubAuth) IsSuperAdminIP(string) (*auth.User, bool)              { return nil, false }
+func (a *stubAuth) CheckExpiry(string) bool                               { return true }
+func (a *stubAuth) ExpiryTime(string) (time.Time, bool)                   { return time.Time{}, false }
+func (a *stubAuth) DaysUntilExpiry(string) (int, bool)                    { return 0, false }
+func (a *stubAuth) RateLimitState(string) (int, int, time.Duration, bool) { return 0, 0, 0, false }
+
+func (a *stubAuth) CheckRateLimit(username string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.checks = append(a.checks, username)
+	return true
+}
+
+func TestCustomAuthenticator(t *testing.T) {
+	stub := &stubAuth{passwords: map[string]string{"dbuser": "from-db"}}
+	_, addr, _, _ := startTestServer(t, &config.EnvConfig{}, stub)
+	target := startDelayedEchoTarget(t, 0, "pong")
+
+	conn := dialSOCKS5(t, addr, "dbuser", "from-db", target)
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("relay with a custom authenticator = %q, %v", buf, err)
+	}
+	conn.Close()
+
+	stub.mu.Lock()
+	checks := stub.checks
+	stub.mu.Unlock()
+	if len(checks) != 1 || checks[0] != "dbuser" {
+		t.Errorf("CheckRateLimit calls = %v, want one for dbuser", checks)
+	}
+}
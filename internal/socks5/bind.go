@@ -0,0 +1,140 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"signal-proxy/internal/auth"
+)
+
+// bindAcceptTimeout bounds how long a BIND waits for the expected peer.
+const bindAcceptTimeout = 2 * time.Minute
+
+// handleBind serves a SOCKS5 BIND (RFC 1928 §4). It listens on an ephemeral
+// port, sends the first reply with the bound address, waits for the expected
+// peer and sends the second reply with the peer's address before relaying.
+// The peer must be a host this user already has an open CONNECT to.
+func (s *Server) handleBind(ctx context.Context, conn net.Conn, username string, user *auth.User, limitGB int, peerAddr string, startTime time.Time) {
+	peerHost, _, err := net.SplitHostPort(peerAddr)
+	if err != nil {
+		s.sendReply(conn, ReplyGeneralFailure, nil)
+		return
+	}
+	peerIPs := s.associatedIPs(username, peerHost)
+	if len(peerIPs) == 0 {
+		s.sendReply(conn, ReplyConnectionNotAllowed, nil)
+		MetricErrors.WithLabelValues("bind_not_associated").Inc()
+		logStatus(conn, "warn", "SOCKS5 BIND without a CONNECT to "+peerHost+" for "+username)
+		return
+	}
+
+	// Listen on the interface the client reached us on; a Unix socket
+	// client has none to offer the peer
+	local, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		s.sendReply(conn, ReplyCmdNotSupported, nil)
+		return
+	}
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: local.IP})
+	if err != nil {
+		s.sendReply(conn, ReplyGeneralFailure, nil)
+		MetricErrors.WithLabelValues("bind_failed").Inc()
+		return
+	}
+	defer ln.Close()
+
+	// First reply: where the peer should connect
+	s.sendReply(conn, ReplySucceeded, ln.Addr().(*net.TCPAddr))
+	conn.SetDeadline(time.Time{})
+
+	// Closing the listener unblocks Accept on forced shutdown
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ln.Close()
+		case <-stop:
+		}
+	}()
+
+	ln.SetDeadline(time.Now().Add(bindAcceptTimeout))
+	var peerConn *net.TCPConn
+	for peerConn == nil {
+		c, err := ln.AcceptTCP()
+		if err != nil {
+			s.sendReply(conn, ReplyTTLExpired, nil)
+			MetricErrors.WithLabelValues("bind_timeout").Inc()
+			return
+		}
+		remote := c.RemoteAddr().(*net.TCPAddr)
+		if !containsIP(peerIPs, remote.IP) {
+			// Not the host the client asked for; keep waiting
+			logStatus(conn, "warn", "SOCKS5 BIND rejected unexpected peer "+remote.String()+" for "+username)
+			c.Close()
+			continue
+		}
+		peerConn = c
+	}
+	defer peerConn.Close()
+
+	// Second reply: who connected
+	s.sendReply(conn, ReplySucceeded, peerConn.RemoteAddr().(*net.TCPAddr))
+	logStatus(conn, "info", "SOCKS5 BIND "+username+" ← "+peerConn.RemoteAddr().String())
+
+	s.relayAndRecord(ctx, conn, peerConn, username, user, limitGB, startTime)
+}
+
+// trackAssociation records an open CONNECT from username to remote and
+// returns a func that removes it.
+func (s *Server) trackAssociation(username string, remote net.Addr) func() {
+	tcpAddr, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return func() {}
+	}
+	key := username + "|" + tcpAddr.IP.String()
+
+	s.assocMu.Lock()
+	s.assocs[key]++
+	s.assocMu.Unlock()
+
+	return func() {
+		s.assocMu.Lock()
+		if s.assocs[key]--; s.assocs[key] <= 0 {
+			delete(s.assocs, key)
+		}
+		s.assocMu.Unlock()
+	}
+}
+
+// associatedIPs returns the addresses of host that username has an open
+// CONNECT to. Domain names are resolved first.
+func (s *Server) associatedIPs(username, host string) []net.IP {
+	var candidates []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		candidates = []net.IP{ip}
+	} else if addrs, err := net.LookupIP(host); err == nil {
+		candidates = addrs
+	}
+
+	s.assocMu.Lock()
+	defer s.assocMu.Unlock()
+	var ips []net.IP
+	for _, ip := range candidates {
+		if s.assocs[username+"|"+ip.String()] > 0 {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// containsIP reports whether ip is in ips.
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, candidate := range ips {
+		if candidate.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
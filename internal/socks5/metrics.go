@@ -42,6 +42,12 @@ var (
 		Help: "Total SOCKS5 errors by type",
 	}, []string{"type"})
 
+	// MetricConnectionsRejected counts connections refused at MaxConns
+	MetricConnectionsRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_connections_rejected_total",
+		Help: "Total SOCKS5 connections rejected due to capacity",
+	})
+
 	// MetricDuration tracks connection duration
 	MetricDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    "socks5_connection_duration_seconds",
@@ -7,12 +7,17 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
+	"signal-proxy/internal/accesslog"
 	"signal-proxy/internal/auth"
 	"signal-proxy/internal/bandwidth"
+	"signal-proxy/internal/bufpool"
 	"signal-proxy/internal/config"
+	"signal-proxy/internal/dialer"
+	"signal-proxy/internal/proxy"
 	"signal-proxy/internal/ui"
 )
 
@@ -53,43 +58,117 @@ const (
 // Server is a SOCKS5 proxy server with authentication
 type Server struct {
 	Config    *config.Config
-	UserStore *auth.UserStore
+	UserStore auth.Authenticator // *auth.UserStore, or a custom backend
 	Bandwidth *bandwidth.Tracker
 
-	ln       net.Listener
-	wg       sync.WaitGroup
-	shutdown chan struct{}
+	// OnUser, if set, is called with the username of each authenticated
+	// connection, e.g. for unique-user stats
+	OnUser func(id string)
+
+	lns          []net.Listener
+	connSem      chan struct{}  // Semaphore for connection limiting (nil = unlimited)
+	refuseSem    chan struct{}  // Bounds refusals in flight at capacity
+	wg           sync.WaitGroup // Tracks active connections for graceful shutdown
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+
+	// Active client connections, force-closed if the drain timeout expires
+	connsMu sync.Mutex
+	conns   map[net.Conn]struct{}
+
+	// Dialer for CONNECT targets
+	upstream *dialer.Dialer
+
+	// Open CONNECT relays per user and target IP, consulted by BIND
+	assocMu sync.Mutex
+	assocs  map[string]int
+
+	// forceClose cancels the context handed to connection handlers. It is
+	// only called once the drain timeout expires, so a shutdown signal
+	// alone does not interrupt in-flight relays.
+	forceClose context.CancelFunc
 }
 
-// NewServer creates a new SOCKS5 proxy server
-func NewServer(cfg *config.Config, userStore *auth.UserStore, bw *bandwidth.Tracker) *Server {
-	return &Server{
+// NewServer creates a new SOCKS5 proxy server.
+// cfg.MaxConns caps concurrent connections; 0 means unlimited.
+func NewServer(cfg *config.Config, userStore auth.Authenticator, bw *bandwidth.Tracker) *Server {
+	upstream := dialer.New(cfg.Env.DialTimeout(dialer.DefaultTimeout), cfg.Env.TCPKeepAlive()).
+		WithNoDelay(!cfg.Env.DisableTCPNoDelay).
+		WithBuffers(cfg.Env.SocketSndBuf, cfg.Env.SocketRcvBuf).
+		WithRetries(cfg.Env.DialRetries, cfg.Env.DialRetryBackoff())
+	s := &Server{
 		Config:    cfg,
 		UserStore: userStore,
 		Bandwidth: bw,
 		shutdown:  make(chan struct{}),
+		refuseSem: make(chan struct{}, maxRefusals),
+		conns:     make(map[net.Conn]struct{}),
+		assocs:    make(map[string]int),
+		upstream:  upstream,
 	}
+	if cfg.MaxConns > 0 {
+		s.connSem = make(chan struct{}, cfg.MaxConns)
+	}
+	return s
 }
 
-// Start begins accepting SOCKS5 connections
+// Start begins accepting SOCKS5 connections on every SOCKS5_PORT address
 func (s *Server) Start(ctx context.Context) error {
-	addr := s.Config.Env.SOCKS5Port
-	if addr == "" {
-		addr = ":1080"
+	var lns []net.Listener
+	for _, addr := range s.Config.Env.SOCKS5Addrs() {
+		ln, err := s.Config.Env.ListenWithRetry(ctx, func() (net.Listener, error) {
+			return config.Listen(addr, dialer.BufferControl(s.Config.Env.SocketSndBuf, s.Config.Env.SocketRcvBuf))
+		})
+		if err != nil {
+			for _, opened := range lns {
+				opened.Close()
+			}
+			return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		lns = append(lns, ln)
+		ui.LogStatus("info", "SOCKS5 Proxy listening on "+addr)
 	}
 
-	var err error
-	s.ln, err = net.Listen("tcp", addr)
-	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", addr, err)
-	}
+	return s.Serve(ctx, lns...)
+}
 
-	ui.LogStatus("info", "SOCKS5 Proxy listening on "+addr)
+// Serve accepts SOCKS5 connections on every listener in lns. It blocks
+// until shutdown or error. Cancelling the context stops accepting on all
+// of them and drains active relays; an accept error on one stops the rest.
+func (s *Server) Serve(ctx context.Context, lns ...net.Listener) error {
+	s.lns = lns
+
+	// Relays outlive the shutdown signal until the drain timeout expires
+	connCtx, forceClose := context.WithCancel(context.WithoutCancel(ctx))
+	defer forceClose()
+	s.connsMu.Lock()
+	s.forceClose = forceClose
+	s.connsMu.Unlock()
 
 	// Monitor for shutdown
 	go s.watchShutdown(ctx)
 
-	// Accept loop
+	errCh := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func(ln net.Listener) { errCh <- s.acceptLoop(connCtx, ln) }(ln)
+	}
+
+	var firstErr error
+	for range lns {
+		if err := <-errCh; err != nil && firstErr == nil {
+			firstErr = err
+			s.stopAccepting()
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return s.drainConnections()
+}
+
+// acceptLoop serves connections from ln until shutdown, returning nil, or
+// until Accept fails for another reason.
+func (s *Server) acceptLoop(ctx context.Context, ln net.Listener) error {
 	for {
 		select {
 		case <-s.shutdown:
@@ -97,7 +176,7 @@ func (s *Server) Start(ctx context.Context) error {
 		default:
 		}
 
-		conn, err := s.ln.Accept()
+		conn, err := ln.Accept()
 		if err != nil {
 			select {
 			case <-s.shutdown:
@@ -110,23 +189,129 @@ func (s *Server) Start(ctx context.Context) error {
 			}
 		}
 
+		dialer.SetNoDelay(conn, !s.Config.Env.DisableTCPNoDelay)
+
+		id := accesslog.NewID()
+
+		// Try to acquire connection slot (non-blocking)
+		if !s.acquireSlot() {
+			MetricConnectionsRejected.Inc()
+			ui.LogStatus("warn", accesslog.Tag(id, "SOCKS5 connection rejected: at max capacity ("+strconv.Itoa(s.Config.MaxConns)+")"))
+			s.refuse(conn)
+			continue
+		}
+
 		s.wg.Add(1)
+		s.trackConn(conn)
 		go func(c net.Conn) {
 			defer s.wg.Done()
-			s.handleConnection(ctx, c)
+			defer s.releaseSlot()
+			defer s.untrackConn(c)
+			s.handleConnection(accesslog.WithID(ctx, id), c)
 		}(conn)
 	}
 }
 
+// acquireSlot reserves a connection slot, reporting false at capacity.
+func (s *Server) acquireSlot() bool {
+	if s.connSem == nil {
+		return true
+	}
+	select {
+	case s.connSem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseSlot frees a slot taken by acquireSlot.
+func (s *Server) releaseSlot() {
+	if s.connSem != nil {
+		<-s.connSem
+	}
+}
+
 // watchShutdown monitors context for cancellation
 func (s *Server) watchShutdown(ctx context.Context) {
-	<-ctx.Done()
-	close(s.shutdown)
-	if s.ln != nil {
-		s.ln.Close()
+	select {
+	case <-ctx.Done():
+		ui.LogStatus("warn", "SOCKS5 shutdown signal received...")
+		s.stopAccepting()
+	case <-s.shutdown:
 	}
 }
 
+// stopAccepting signals the accept loops to exit and closes the listeners.
+// Safe to call more than once.
+func (s *Server) stopAccepting() {
+	s.shutdownOnce.Do(func() {
+		close(s.shutdown)
+		for _, ln := range s.lns {
+			ln.Close()
+		}
+	})
+}
+
+// trackConn registers an active client connection.
+func (s *Server) trackConn(c net.Conn) {
+	s.connsMu.Lock()
+	s.conns[c] = struct{}{}
+	s.connsMu.Unlock()
+}
+
+// untrackConn removes a finished client connection.
+func (s *Server) untrackConn(c net.Conn) {
+	s.connsMu.Lock()
+	delete(s.conns, c)
+	s.connsMu.Unlock()
+}
+
+// activeConnCount returns the number of client connections being served.
+func (s *Server) activeConnCount() int {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	return len(s.conns)
+}
+
+// closeAllConns cancels in-flight relays and force-closes every tracked
+// client connection.
+func (s *Server) closeAllConns() {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if s.forceClose != nil {
+		s.forceClose()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// drainConnections waits for active relays to finish, force-closing any
+// that are still running once the configured grace timeout expires.
+func (s *Server) drainConnections() error {
+	timeout := s.Config.Env.DrainTimeout()
+	if active := s.activeConnCount(); active > 0 {
+		ui.LogStatus("info", fmt.Sprintf("Draining %d active SOCKS5 connections (%s timeout)...", active, timeout))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		ui.LogStatus("success", "All SOCKS5 connections drained.")
+	case <-time.After(timeout):
+		ui.LogStatus("warn", fmt.Sprintf("SOCKS5 drain timeout reached. Forcing close of %d connections.", s.activeConnCount()))
+		s.closeAllConns()
+	}
+
+	return nil
+}
+
 // handleConnection processes a SOCKS5 connection
 func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 	defer conn.Close()
@@ -134,43 +319,66 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 	startTime := time.Now()
 	clientIP := conn.RemoteAddr().String()
 
-	// Check IP whitelist
-	if !s.UserStore.CheckIPAllowed(clientIP) {
+	// One access log record per connection; sendReply and relayAndRecord
+	// fill it in through the wrapped conn
+	entry := &accesslog.Entry{
+		ID:     accesslog.IDFrom(ctx),
+		Time:   startTime,
+		Proxy:  "socks5",
+		Client: accesslog.ClientHost(clientIP),
+		Status: int(ReplyGeneralFailure),
+	}
+	conn = &loggedConn{Conn: conn, entry: entry}
+	defer func() {
+		entry.Duration = time.Since(startTime)
+		accesslog.Log(*entry)
+	}()
+
+	// Check IP whitelist; Unix socket peers are local and have no IP
+	if !config.IsUnixConn(conn) && !s.UserStore.CheckIPAllowed(clientIP) {
 		MetricAuthFailures.WithLabelValues("ip_blocked").Inc()
-		ui.LogStatus("warn", "SOCKS5 IP blocked: "+clientIP)
+		logStatus(conn, "warn", "SOCKS5 IP blocked: "+clientIP)
 		return
 	}
 
 	MetricActiveConns.Inc()
 	defer MetricActiveConns.Dec()
 
-	// Set initial timeout
-	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	// Bound the handshake; relayAndRecord clears this once tunnelling starts
+	conn.SetDeadline(time.Now().Add(s.Config.Env.HandshakeTimeout()))
 
-	// Always require username/password authentication
+	// Trusted super_admin IPs may skip username/password; everyone else
+	// must authenticate
 	var username string
 	var err error
-	username, err = s.handleMethodNegotiation(conn)
+	if admin, ok := s.UserStore.IsSuperAdminIP(clientIP); ok {
+		username, err = s.handleMethodNegotiationNoAuth(conn, admin.Username)
+	} else {
+		username, err = s.handleMethodNegotiation(conn)
+	}
 	if err != nil {
-		ui.LogStatus("error", "SOCKS5 method negotiation failed: "+err.Error())
+		logStatus(conn, "error", "SOCKS5 method negotiation failed: "+err.Error())
 		return
 	}
 
+	entry.User = username
+
 	// Determine if this user is a super_admin connecting from a trusted IP
 	isSuperAdmin := false
 	user := s.UserStore.GetUser(username)
 	if user != nil && user.Role == "super_admin" {
 		if _, ok := s.UserStore.IsSuperAdminIP(clientIP); ok {
 			isSuperAdmin = true
-			ui.LogStatus("info", "SOCKS5 super_admin verified: "+username+" from "+clientIP)
+			logStatus(conn, "info", "SOCKS5 super_admin verified: "+username+" from "+clientIP)
 		}
 	}
 
 	if !isSuperAdmin {
-		// Check rate limit
+		// Check rate limit: one token per connection in every RATE_LIMIT_MODE
 		if !s.UserStore.CheckRateLimit(username) {
-			MetricRateLimited.WithLabelValues(username).Inc()
-			ui.LogStatus("warn", "SOCKS5 rate limited: "+username)
+			MetricRateLimited.WithLabelValues(proxy.UserLabel(username)).Inc()
+			logStatus(conn, "warn", "SOCKS5 rate limited: "+username)
+			s.rejectRequest(conn)
 			return
 		}
 	}
@@ -179,38 +387,70 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 	if !isSuperAdmin && user != nil {
 		// Check account expiry
 		if !s.UserStore.CheckExpiry(username) {
-			ui.LogStatus("warn", "SOCKS5 account expired: "+username)
+			logStatus(conn, "warn", "SOCKS5 account expired: "+username)
+			s.rejectRequest(conn)
 			return
 		}
 
 		// Check bandwidth allowance
 		if s.Bandwidth != nil && !s.Bandwidth.CheckAllowance(username, user.BandwidthLimitGB) {
-			ui.LogStatus("warn", "SOCKS5 bandwidth exceeded: "+username)
+			logStatus(conn, "warn", "SOCKS5 bandwidth exceeded: "+username)
+			s.rejectRequest(conn)
 			return
 		}
 
 		// Check concurrent connection limit
 		if s.Bandwidth != nil && !s.Bandwidth.CheckConnLimit(username, user.MaxConnections) {
-			ui.LogStatus("warn", "SOCKS5 connection limit reached: "+username)
+			logStatus(conn, "warn", "SOCKS5 connection limit reached: "+username)
+			s.rejectRequest(conn)
 			return
 		}
 	}
 
+	if s.OnUser != nil {
+		s.OnUser(username)
+	}
+
+	// Cap re-checked mid-transfer (super_admin is exempt)
+	limitGB := 0
+	if !isSuperAdmin && user != nil {
+		limitGB = user.BandwidthLimitGB
+	}
+
 	// Track per-user connection count
 	if s.Bandwidth != nil {
 		s.Bandwidth.IncrementConns(username)
 		defer s.Bandwidth.DecrementConns(username)
+
+		// Admins can cut this connection via /api/connections/kick
+		var release func()
+		ctx, release = s.Bandwidth.Kickable(ctx, username)
+		defer release()
 	}
 
 	// Step 2: Handle request
-	targetAddr, err := s.handleRequest(conn)
+	cmd, targetAddr, err := s.handleRequest(conn)
 	if err != nil {
-		ui.LogStatus("error", "SOCKS5 request failed: "+err.Error())
+		logStatus(conn, "error", "SOCKS5 request failed: "+err.Error())
+		return
+	}
+	entry.Method, entry.Target = commandName(cmd), targetAddr
+
+	if cmd == CmdBind {
+		s.handleBind(ctx, conn, username, user, limitGB, targetAddr, startTime)
+		return
+	}
+
+	// Optionally enforce the CONNECT port allow-list
+	if s.Config.Env.SOCKS5RestrictPorts && !s.Config.Env.AllowsConnectTarget(targetAddr) {
+		s.sendReply(conn, ReplyConnectionNotAllowed, nil)
+		MetricErrors.WithLabelValues("port_blocked").Inc()
+		logStatus(conn, "warn", "SOCKS5 port blocked: "+targetAddr+" for "+username)
 		return
 	}
 
 	// Step 3: Connect to target
-	targetConn, err := net.DialTimeout("tcp", targetAddr, 30*time.Second)
+	targetConn, err := s.upstream.DialContext(ctx, "tcp", targetAddr)
 	if err != nil {
 		s.sendReply(conn, ReplyHostUnreachable, nil)
 		MetricErrors.WithLabelValues("dial_failed").Inc()
@@ -218,54 +458,113 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 	}
 	defer targetConn.Close()
 
+	// Let this user BIND for the target while the relay is open
+	release := s.trackAssociation(username, targetConn.RemoteAddr())
+	defer release()
+
 	// Send success reply
 	localAddr := targetConn.LocalAddr().(*net.TCPAddr)
 	s.sendReply(conn, ReplySucceeded, localAddr)
 
-	MetricConnections.WithLabelValues(username).Inc()
+	s.relayAndRecord(ctx, conn, targetConn, username, user, limitGB, startTime)
+}
+
+// relayAndRecord relays an established tunnel and records its metrics and
+// bandwidth usage. Usage is recorded as it flows, and the tunnel is cut once
+// the user crosses limitGB (0 = unlimited).
+func (s *Server) relayAndRecord(ctx context.Context, conn, targetConn net.Conn, username string, user *auth.User, limitGB int, startTime time.Time) {
+	MetricConnections.WithLabelValues(proxy.UserLabel(username)).Inc()
 
 	// Clear deadlines for relay
 	conn.SetDeadline(time.Time{})
 	targetConn.SetDeadline(time.Time{})
 
-	// Apply optional speed throttle
+	// Meter the client side against the monthly cap
 	var relayClient, relayTarget net.Conn
 	relayClient = conn
 	relayTarget = targetConn
+	var quota *bandwidth.QuotaConn
+	if s.Bandwidth != nil {
+		quota = bandwidth.NewQuotaConn(conn, s.Bandwidth, username, limitGB)
+		relayClient = quota
+	}
+
+	// Apply optional speed throttle
 	if user != nil && user.BandwidthSpeedMbps > 0 {
-		relayClient = bandwidth.NewThrottledConn(conn, user.BandwidthSpeedMbps).(*bandwidth.ThrottledConn)
+		relayClient = bandwidth.NewThrottledConn(relayClient, user.BandwidthSpeedMbps).(*bandwidth.ThrottledConn)
 		relayTarget = bandwidth.NewThrottledConn(targetConn, user.BandwidthSpeedMbps).(*bandwidth.ThrottledConn)
 	}
 
 	// Relay data bidirectionally
-	var upBytes, downBytes int64
+	upBytes, downBytes := relay(ctx, relayClient, relayTarget)
+	if lc, ok := conn.(*loggedConn); ok {
+		lc.entry.BytesUp, lc.entry.BytesDown = upBytes, downBytes
+	}
+
+	// Record metrics
+	duration := time.Since(startTime).Seconds()
+	MetricBytes.WithLabelValues(proxy.UserLabel(username), "upstream").Add(float64(upBytes))
+	MetricBytes.WithLabelValues(proxy.UserLabel(username), "downstream").Add(float64(downBytes))
+	MetricDuration.Observe(duration)
+
+	// Record the rest of the bandwidth usage
+	if quota != nil {
+		quota.Flush()
+		if quota.Exceeded() {
+			MetricErrors.WithLabelValues("bandwidth_exceeded").Inc()
+			logStatus(conn, "warn", "SOCKS5 bandwidth exceeded mid-transfer: "+username)
+		}
+	}
+}
+
+// relay copies data between client and target until either direction
+// finishes or ctx is cancelled. It returns once both copy goroutines have
+// exited, with the bytes sent upstream and downstream.
+func relay(ctx context.Context, client, target net.Conn) (up, down int64) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	done := make(chan struct{}, 2)
 
 	go func() {
-		n, _ := io.Copy(relayTarget, relayClient)
-		upBytes = n
+		up = copyWithContext(ctx, target, client)
 		done <- struct{}{}
 	}()
 
 	go func() {
-		n, _ := io.Copy(relayClient, relayTarget)
-		downBytes = n
+		down = copyWithContext(ctx, client, target)
 		done <- struct{}{}
 	}()
 
-	// Wait for either direction to finish
+	// Once either direction finishes, stop the other one too
+	<-done
+	cancel()
 	<-done
 
-	// Record metrics
-	duration := time.Since(startTime).Seconds()
-	MetricBytes.WithLabelValues(username, "upstream").Add(float64(upBytes))
-	MetricBytes.WithLabelValues(username, "downstream").Add(float64(downBytes))
-	MetricDuration.Observe(duration)
+	return up, down
+}
 
-	// Record bandwidth usage for tracking
-	if s.Bandwidth != nil {
-		s.Bandwidth.RecordBytes(username, upBytes, downBytes)
-	}
+// copyWithContext copies src to dst until EOF, error or ctx cancellation.
+// When ctx is done a watcher sets immediate deadlines on both connections,
+// unblocking a Read or Write that is waiting on an idle peer.
+func copyWithContext(ctx context.Context, dst, src net.Conn) int64 {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			now := time.Now()
+			src.SetDeadline(now)
+			dst.SetDeadline(now)
+		case <-stop:
+		}
+	}()
+
+	buf := bufpool.Relay.Get()
+	defer bufpool.Relay.Put(buf)
+	n, _ := io.CopyBuffer(dst, src, *buf)
+	return n
 }
 
 // handleMethodNegotiation handles SOCKS5 method selection and authentication
@@ -308,8 +607,10 @@ func (s *Server) handleMethodNegotiation(conn net.Conn) (string, error) {
 	return s.authenticateUser(conn)
 }
 
-// handleMethodNegotiationNoAuth handles SOCKS5 method negotiation accepting no-auth
-func (s *Server) handleMethodNegotiationNoAuth(conn net.Conn) (string, error) {
+// handleMethodNegotiationNoAuth handles SOCKS5 method negotiation for a
+// trusted super_admin IP. No-auth is preferred and maps the client to
+// superAdmin; clients that only offer username/password still authenticate.
+func (s *Server) handleMethodNegotiationNoAuth(conn net.Conn, superAdmin string) (string, error) {
 	// Read version and number of methods
 	buf := make([]byte, 2)
 	if _, err := io.ReadFull(conn, buf); err != nil {
@@ -326,9 +627,29 @@ func (s *Server) handleMethodNegotiationNoAuth(conn net.Conn) (string, error) {
 		return "", err
 	}
 
-	// Accept no-auth method
-	conn.Write([]byte{Version5, MethodNoAuth})
-	return "", nil // username will be set from the super_admin user
+	hasNoAuth, hasUserPass := false, false
+	for _, method := range methods {
+		switch method {
+		case MethodNoAuth:
+			hasNoAuth = true
+		case MethodUserPass:
+			hasUserPass = true
+		}
+	}
+
+	switch {
+	case hasNoAuth:
+		// Accept no-auth method
+		conn.Write([]byte{Version5, MethodNoAuth})
+		return superAdmin, nil
+	case hasUserPass:
+		conn.Write([]byte{Version5, MethodUserPass})
+		return s.authenticateUser(conn)
+	default:
+		conn.Write([]byte{Version5, MethodNoAcceptable})
+		MetricAuthFailures.WithLabelValues("no_auth_method").Inc()
+		return "", errors.New("no acceptable auth method")
+	}
 }
 
 // authenticateUser handles username/password authentication (RFC 1929)
@@ -363,38 +684,40 @@ func (s *Server) authenticateUser(conn net.Conn) (string, error) {
 	}
 
 	// Validate credentials
-	_, valid := s.UserStore.ValidateCredentials(string(username), string(password))
+	user, valid := s.UserStore.ValidateCredentials(string(username), string(password))
 	if !valid {
 		conn.Write([]byte{UserPassVersion, 0x01}) // Auth failure
 		MetricAuthFailures.WithLabelValues("invalid_credentials").Inc()
-		ui.LogStatus("warn", "SOCKS5 auth failed for: "+string(username))
+		logStatus(conn, "warn", "SOCKS5 auth failed for: "+string(username))
 		return "", errors.New("authentication failed")
 	}
 
 	// Auth success
 	conn.Write([]byte{UserPassVersion, 0x00})
-	return string(username), nil
+	// The stored spelling, whatever case the client sent
+	return user.Username, nil
 }
 
-// handleRequest handles SOCKS5 request
-func (s *Server) handleRequest(conn net.Conn) (string, error) {
+// handleRequest handles SOCKS5 request, returning the command and its
+// destination address
+func (s *Server) handleRequest(conn net.Conn) (byte, string, error) {
 	// Read request header: VER, CMD, RSV, ATYP
 	buf := make([]byte, 4)
 	if _, err := io.ReadFull(conn, buf); err != nil {
-		return "", err
+		return 0, "", err
 	}
 
 	if buf[0] != Version5 {
-		return "", errors.New("unsupported version")
+		return 0, "", errors.New("unsupported version")
 	}
 
 	cmd := buf[1]
 	addrType := buf[3]
 
-	// We only support CONNECT
-	if cmd != CmdConnect {
+	// We support CONNECT, and BIND only when enabled
+	if cmd != CmdConnect && !(cmd == CmdBind && s.Config.Env.SOCKS5BindEnabled) {
 		s.sendReply(conn, ReplyCmdNotSupported, nil)
-		return "", errors.New("unsupported command")
+		return 0, "", errors.New("unsupported command")
 	}
 
 	// Parse destination address
@@ -403,70 +726,138 @@ func (s *Server) handleRequest(conn net.Conn) (string, error) {
 	case AddrTypeIPv4:
 		addr := make([]byte, 4)
 		if _, err := io.ReadFull(conn, addr); err != nil {
-			return "", err
+			return 0, "", err
 		}
 		host = net.IP(addr).String()
 
 	case AddrTypeDomain:
 		// Read domain length
 		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
-			return "", err
+			return 0, "", err
 		}
 		domainLen := int(buf[0])
 		domain := make([]byte, domainLen)
 		if _, err := io.ReadFull(conn, domain); err != nil {
-			return "", err
+			return 0, "", err
 		}
 		host = string(domain)
 
 	case AddrTypeIPv6:
 		addr := make([]byte, 16)
 		if _, err := io.ReadFull(conn, addr); err != nil {
-			return "", err
+			return 0, "", err
 		}
 		host = net.IP(addr).String()
 
 	default:
 		s.sendReply(conn, ReplyAddrTypeNotSupported, nil)
-		return "", errors.New("unsupported address type")
+		return 0, "", errors.New("unsupported address type")
 	}
 
 	// Read port
 	portBuf := make([]byte, 2)
 	if _, err := io.ReadFull(conn, portBuf); err != nil {
-		return "", err
+		return 0, "", err
 	}
 	port := binary.BigEndian.Uint16(portBuf)
 
-	return fmt.Sprintf("%s:%d", host, port), nil
+	return cmd, net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+// refuseTimeout bounds how long a connection refused at MaxConns may take to
+// send its greeting.
+const refuseTimeout = 2 * time.Second
+
+// maxRefusals caps refusals answered at once. Beyond it a connection over
+// capacity is closed straight away, so a flood cannot pile up goroutines.
+const maxRefusals = 16
+
+// refuse turns away a connection accepted at capacity: politely with
+// refuseAtCapacity while fewer than maxRefusals are in flight, otherwise by
+// closing it. Refusals in flight are tracked by wg.
+func (s *Server) refuse(conn net.Conn) {
+	select {
+	case s.refuseSem <- struct{}{}:
+	default:
+		conn.Close()
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() { <-s.refuseSem }()
+		refuseAtCapacity(conn)
+	}()
+}
+
+// refuseAtCapacity answers a connection turned away at MaxConns with "no
+// acceptable methods" (0xFF) once its greeting is in, the only refusal a
+// client can receive before authenticating, rather than a bare close. It
+// holds no slot and gives up after refuseTimeout.
+func refuseAtCapacity(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(refuseTimeout))
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil || buf[0] != Version5 {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, buf[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{Version5, MethodNoAcceptable})
+}
+
+// rejectRequest reads the client's request and answers it with
+// ReplyConnectionNotAllowed, so a refused user gets a definite reply code
+// rather than a dropped connection.
+func (s *Server) rejectRequest(conn net.Conn) {
+	if _, _, err := s.handleRequest(conn); err != nil {
+		return
+	}
+	s.sendReply(conn, ReplyConnectionNotAllowed, nil)
 }
 
-// sendReply sends a SOCKS5 reply
+// sendReply sends a SOCKS5 reply. BND.ADDR is IPv4 unless addr is an IPv6
+// address; a nil addr sends 0.0.0.0:0.
 func (s *Server) sendReply(conn net.Conn, reply byte, addr *net.TCPAddr) {
+	if lc, ok := conn.(*loggedConn); ok {
+		lc.entry.Status = int(reply)
+	}
+
 	// Build reply: VER, REP, RSV, ATYP, BND.ADDR, BND.PORT
-	resp := make([]byte, 10)
-	resp[0] = Version5
-	resp[1] = reply
-	resp[2] = 0x00 // Reserved
-	resp[3] = AddrTypeIPv4
-
-	if addr != nil {
-		ip := addr.IP.To4()
-		if ip != nil {
-			copy(resp[4:8], ip)
-		}
-		binary.BigEndian.PutUint16(resp[8:10], uint16(addr.Port))
+	resp := []byte{Version5, reply, 0x00}
+	var port int
+	switch {
+	case addr == nil:
+		resp = append(resp, AddrTypeIPv4, 0, 0, 0, 0)
+	case addr.IP.To4() != nil:
+		resp = append(append(resp, AddrTypeIPv4), addr.IP.To4()...)
+		port = addr.Port
+	default:
+		resp = append(append(resp, AddrTypeIPv6), addr.IP.To16()...)
+		port = addr.Port
 	}
+	resp = binary.BigEndian.AppendUint16(resp, uint16(port))
 
 	conn.Write(resp)
 }
 
-// Shutdown gracefully stops the SOCKS5 server
+// Shutdown gracefully stops the SOCKS5 server, force-closing any
+// connections still active when ctx expires.
 func (s *Server) Shutdown(ctx context.Context) error {
-	close(s.shutdown)
-	if s.ln != nil {
-		s.ln.Close()
+	s.stopAccepting()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.closeAllConns()
 	}
-	s.wg.Wait()
 	return nil
 }
@@ -0,0 +1,1063 @@
+package socks5
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"signal-proxy/internal/accesslog"
+	"signal-proxy/internal/auth"
+	"signal-proxy/internal/bandwidth"
+	"signal-proxy/internal/config"
+)
+
+// newTestUserStore writes a users.json with a single enabled user and loads it.
+func newTestUserStore(t *testing.T, username, password string) *auth.UserStore {
+	t.Helper()
+	return newTestUserStoreWithConfig(t, auth.UsersConfig{
+		Users: []auth.User{{
+			Username:     username,
+			Role:         "user",
+			PasswordHash: mustHash(t, password),
+			Enabled:      true,
+		}},
+	})
+}
+
+// newTestUserStoreWithConfig writes cfg as users.json and loads it.
+func newTestUserStoreWithConfig(t *testing.T, cfg auth.UsersConfig) *auth.UserStore {
+	t.Helper()
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := auth.NewUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+	return store
+}
+
+// mustHash returns a low-cost bcrypt hash of password.
+func mustHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+// startTestServer serves SOCKS5 on an ephemeral port. The returned channel
+// receives Serve's result once the server has fully shut down.
+func startTestServer(t *testing.T, env *config.EnvConfig, store auth.Authenticator) (*Server, string, context.CancelFunc, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(&config.Config{Env: env}, store, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Serve(ctx, ln) }()
+	t.Cleanup(cancel)
+	return srv, ln.Addr().String(), cancel, errCh
+}
+
+// dialSOCKS5 performs the user/pass handshake and a CONNECT to target,
+// returning the established tunnel.
+func dialSOCKS5(t *testing.T, proxyAddr, username, password, target string) net.Conn {
+	t.Helper()
+	reply, conn := socks5Connect(t, proxyAddr, username, password, target)
+	if reply != ReplySucceeded {
+		t.Fatalf("CONNECT reply = %#x, want success", reply)
+	}
+	return conn
+}
+
+// connectReply performs a CONNECT to target and returns only the reply code.
+func connectReply(t *testing.T, proxyAddr, username, password, target string) byte {
+	t.Helper()
+	reply, conn := socks5Connect(t, proxyAddr, username, password, target)
+	conn.Close()
+	return reply
+}
+
+// socks5Connect performs the user/pass handshake and a CONNECT to target,
+// returning the reply code and the connection.
+func socks5Connect(t *testing.T, proxyAddr, username, password, target string) (byte, net.Conn) {
+	t.Helper()
+	conn := socks5Auth(t, proxyAddr, username, password)
+	resp := socks5Request(t, conn, CmdConnect, target)
+	conn.SetDeadline(time.Time{})
+	return resp[1], conn
+}
+
+// socks5Auth dials the proxy (a TCP address or "unix:/path") and completes
+// user/pass authentication.
+func socks5Auth(t *testing.T, proxyAddr, username, password string) net.Conn {
+	t.Helper()
+	network := "tcp"
+	if path, ok := strings.CutPrefix(proxyAddr, "unix:"); ok {
+		network, proxyAddr = "unix", path
+	}
+	conn, err := net.DialTimeout(network, proxyAddr, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte{Version5, 1, MethodUserPass})
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != MethodUserPass {
+		t.Fatalf("method negotiation failed: %v %v", reply, err)
+	}
+
+	authReq := []byte{UserPassVersion, byte(len(username))}
+	authReq = append(authReq, username...)
+	authReq = append(authReq, byte(len(password)))
+	authReq = append(authReq, password...)
+	conn.Write(authReq)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 0x00 {
+		t.Fatalf("authentication failed: %v %v", reply, err)
+	}
+	return conn
+}
+
+// socks5Request sends a cmd request for an IPv4 target and returns the
+// 10-byte reply.
+func socks5Request(t *testing.T, conn net.Conn, cmd byte, target string) []byte {
+	t.Helper()
+	host, portStr, _ := net.SplitHostPort(target)
+	port, _ := strconv.Atoi(portStr)
+	req := []byte{Version5, cmd, 0x00, AddrTypeIPv4}
+	req = append(req, net.ParseIP(host).To4()...)
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	conn.Write(req)
+	return readReply(t, conn)
+}
+
+// readReply reads one 10-byte (IPv4) SOCKS5 reply.
+func readReply(t *testing.T, conn net.Conn) []byte {
+	t.Helper()
+	resp := make([]byte, 10)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		t.Fatalf("reading reply: %v", err)
+	}
+	return resp
+}
+
+// replyAddr decodes BND.ADDR and BND.PORT from an IPv4 reply.
+func replyAddr(resp []byte) string {
+	port := binary.BigEndian.Uint16(resp[8:10])
+	return net.JoinHostPort(net.IP(resp[4:8]).String(), strconv.Itoa(int(port)))
+}
+
+// startDelayedEchoTarget accepts one connection, reads a request, waits for
+// delay and then writes back reply.
+func startDelayedEchoTarget(t *testing.T, delay time.Duration, reply string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 64)
+		c.Read(buf)
+		time.Sleep(delay)
+		c.Write([]byte(reply))
+	}()
+	return ln.Addr().String()
+}
+
+func TestDrainAllowsRelayToFinish(t *testing.T) {
+	store := newTestUserStore(t, "alice", "secret")
+	target := startDelayedEchoTarget(t, 300*time.Millisecond, "pong")
+	_, addr, cancel, errCh := startTestServer(t, &config.EnvConfig{DrainTimeoutSec: 5}, store)
+
+	conn := dialSOCKS5(t, addr, "alice", "secret", target)
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+
+	// Begin shutdown while the target is still preparing its reply
+	cancel()
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("relay was cut off during drain: %v", err)
+	}
+	if string(buf) != "pong" {
+		t.Fatalf("got %q, want %q", buf, "pong")
+	}
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Serve returned error: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Serve did not return after relay finished")
+	}
+}
+
+func TestDrainForcesCloseAfterTimeout(t *testing.T) {
+	store := newTestUserStore(t, "alice", "secret")
+	target := startDelayedEchoTarget(t, time.Hour, "never")
+	_, addr, cancel, errCh := startTestServer(t, &config.EnvConfig{DrainTimeoutSec: 1}, store)
+
+	conn := dialSOCKS5(t, addr, "alice", "secret", target)
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+
+	start := time.Now()
+	cancel()
+
+	select {
+	case <-errCh:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Serve did not return after drain timeout")
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("Serve returned after %s, before the grace window elapsed", elapsed)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected tunnel to be closed after drain timeout")
+	}
+}
+
+func TestRelayStopsOnContextCancel(t *testing.T) {
+	clientConn, clientPeer := net.Pipe()
+	targetConn, targetPeer := net.Pipe()
+	defer clientPeer.Close()
+	defer targetPeer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	returned := make(chan struct{})
+	go func() {
+		relay(ctx, clientConn, targetConn)
+		close(returned)
+	}()
+
+	// Move some data so the relay is mid-transfer, then leave both peers idle
+	go clientPeer.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(targetPeer, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("relay goroutines did not return after context cancellation")
+	}
+}
+
+func TestConnectBlockedPortWhenRestricted(t *testing.T) {
+	store := newTestUserStore(t, "alice", "secret")
+	target := startDelayedEchoTarget(t, 0, "pong")
+	env := &config.EnvConfig{ConnectAllowedPorts: []int{443}, SOCKS5RestrictPorts: true}
+	_, addr, _, _ := startTestServer(t, env, store)
+
+	reply := connectReply(t, addr, "alice", "secret", target)
+	if reply != ReplyConnectionNotAllowed {
+		t.Fatalf("CONNECT reply = %#x, want %#x", reply, ReplyConnectionNotAllowed)
+	}
+}
+
+func TestConnectAllowListIgnoredWhenNotRestricted(t *testing.T) {
+	store := newTestUserStore(t, "alice", "secret")
+	target := startDelayedEchoTarget(t, 0, "pong")
+	env := &config.EnvConfig{ConnectAllowedPorts: []int{443}}
+	_, addr, _, _ := startTestServer(t, env, store)
+
+	conn := dialSOCKS5(t, addr, "alice", "secret", target)
+	conn.Close()
+}
+
+func TestServeMultipleListeners(t *testing.T) {
+	store := newTestUserStore(t, "alice", "secret")
+	target := startDelayedEchoTarget(t, 0, "pong")
+	var lns []net.Listener
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lns = append(lns, ln)
+	}
+	srv := NewServer(&config.Config{Env: &config.EnvConfig{}}, store, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Serve(ctx, lns...) }()
+
+	for _, ln := range lns {
+		conn := dialSOCKS5(t, ln.Addr().String(), "alice", "secret", target)
+		conn.Close()
+	}
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Serve returned error: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Serve did not return after cancel")
+	}
+}
+
+func TestUnixSocketListener(t *testing.T) {
+	// Loopback is not whitelisted; Unix socket peers skip the IP check
+	store := newTestUserStoreWithConfig(t, auth.UsersConfig{
+		IPWhitelist: []string{"10.0.0.0/8"},
+		Users: []auth.User{{
+			Username:     "alice",
+			Role:         "user",
+			PasswordHash: mustHash(t, "secret"),
+			Enabled:      true,
+		}},
+	})
+	target := startDelayedEchoTarget(t, 0, "pong")
+	path := filepath.Join(t.TempDir(), "socks.sock")
+	srv := NewServer(&config.Config{Env: &config.EnvConfig{SOCKS5Port: "unix:" + path, SOCKS5BindEnabled: true}}, store, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Start(ctx) }()
+	t.Cleanup(cancel)
+
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("socket file never appeared")
+		}
+	}
+
+	conn := dialSOCKS5(t, "unix:"+path, "alice", "secret", target)
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("relay over unix socket = %q, %v", buf, err)
+	}
+
+	conn.Close()
+
+	// BIND has no local interface to listen on for a Unix socket client
+	control := dialSOCKS5(t, "unix:"+path, "alice", "secret", startDelayedEchoTarget(t, time.Minute, ""))
+	bind := socks5Auth(t, "unix:"+path, "alice", "secret")
+	if reply := socks5Request(t, bind, CmdBind, "127.0.0.1:0"); reply[1] != ReplyCmdNotSupported {
+		t.Errorf("BIND over unix socket reply = %#x, want %#x", reply[1], ReplyCmdNotSupported)
+	}
+	bind.Close()
+	control.Close()
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Start returned error: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Start did not return after cancel")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file still present after shutdown: %v", err)
+	}
+}
+
+func TestTricklingClientDropped(t *testing.T) {
+	store := newTestUserStore(t, "alice", "secret")
+	_, addr, _, _ := startTestServer(t, &config.EnvConfig{HandshakeTimeoutSec: 1}, store)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Offer one method, then trickle the user/pass request a byte at a time
+	conn.Write([]byte{Version5, 1, MethodUserPass})
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	go func() {
+		for _, b := range []byte{UserPassVersion, 5, 'a', 'l', 'i', 'c', 'e', 6} {
+			if _, err := conn.Write([]byte{b}); err != nil {
+				return
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); isTimeout(err) {
+		t.Fatal("connection still open after the handshake timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("dropped after %s, want about 1s", elapsed)
+	}
+}
+
+func TestBindTwoReplyHandshake(t *testing.T) {
+	store := newTestUserStore(t, "alice", "secret")
+	_, proxyAddr, _, _ := startTestServer(t, &config.EnvConfig{SOCKS5BindEnabled: true}, store)
+
+	// The control connection (e.g. FTP) that BIND is associated with
+	control := startDelayedEchoTarget(t, time.Minute, "")
+	controlConn := dialSOCKS5(t, proxyAddr, "alice", "secret", control)
+	defer controlConn.Close()
+
+	conn := socks5Auth(t, proxyAddr, "alice", "secret")
+	defer conn.Close()
+	first := socks5Request(t, conn, CmdBind, "127.0.0.1:0")
+	if first[1] != ReplySucceeded {
+		t.Fatalf("first BIND reply = %#x, want success", first[1])
+	}
+	bound := replyAddr(first)
+
+	// The server on the control connection's host connects back
+	peer, err := net.DialTimeout("tcp", bound, 2*time.Second)
+	if err != nil {
+		t.Fatalf("dialing bound address %s: %v", bound, err)
+	}
+	defer peer.Close()
+
+	second := readReply(t, conn)
+	if second[1] != ReplySucceeded {
+		t.Fatalf("second BIND reply = %#x, want success", second[1])
+	}
+	if got, want := replyAddr(second), peer.LocalAddr().String(); got != want {
+		t.Errorf("second reply peer = %s, want %s", got, want)
+	}
+
+	// Data now flows between the peer and the client
+	peer.Write([]byte("220 ready"))
+	buf := make([]byte, 9)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "220 ready" {
+		t.Fatalf("client read %q, %v", buf, err)
+	}
+	conn.Write([]byte("PORT"))
+	peer.SetDeadline(time.Now().Add(2 * time.Second))
+	buf = make([]byte, 4)
+	if _, err := io.ReadFull(peer, buf); err != nil || string(buf) != "PORT" {
+		t.Fatalf("peer read %q, %v", buf, err)
+	}
+}
+
+func TestBindRequiresConnectAssociation(t *testing.T) {
+	store := newTestUserStore(t, "alice", "secret")
+	_, proxyAddr, _, _ := startTestServer(t, &config.EnvConfig{SOCKS5BindEnabled: true}, store)
+
+	conn := socks5Auth(t, proxyAddr, "alice", "secret")
+	defer conn.Close()
+	if reply := socks5Request(t, conn, CmdBind, "127.0.0.1:0"); reply[1] != ReplyConnectionNotAllowed {
+		t.Fatalf("BIND without CONNECT reply = %#x, want %#x", reply[1], ReplyConnectionNotAllowed)
+	}
+}
+
+func TestBindDisabledByDefault(t *testing.T) {
+	store := newTestUserStore(t, "alice", "secret")
+	_, proxyAddr, _, _ := startTestServer(t, &config.EnvConfig{}, store)
+
+	conn := socks5Auth(t, proxyAddr, "alice", "secret")
+	defer conn.Close()
+	if reply := socks5Request(t, conn, CmdBind, "127.0.0.1:0"); reply[1] != ReplyCmdNotSupported {
+		t.Fatalf("BIND reply = %#x, want %#x", reply[1], ReplyCmdNotSupported)
+	}
+}
+
+// superAdminStore has a super_admin trusted from trustedCIDR and a regular user.
+func superAdminStore(t *testing.T, trustedCIDR string) *auth.UserStore {
+	t.Helper()
+	return newTestUserStoreWithConfig(t, auth.UsersConfig{
+		SuperAdminIPs: []string{trustedCIDR},
+		Users: []auth.User{
+			{Username: "root", Role: "super_admin", PasswordHash: mustHash(t, "rootpw"), Enabled: true},
+			{Username: "alice", Role: "user", PasswordHash: mustHash(t, "secret"), Enabled: true},
+		},
+	})
+}
+
+// negotiate sends a method-selection message and returns the chosen method.
+func negotiate(t *testing.T, proxyAddr string, methods ...byte) (byte, net.Conn) {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", proxyAddr, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write(append([]byte{Version5, byte(len(methods))}, methods...))
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("method negotiation: %v", err)
+	}
+	return reply[1], conn
+}
+
+func TestNoAuthForTrustedSuperAdminIP(t *testing.T) {
+	store := superAdminStore(t, "127.0.0.1/32")
+	_, proxyAddr, _, _ := startTestServer(t, &config.EnvConfig{}, store)
+	target := startDelayedEchoTarget(t, 0, "pong")
+
+	method, conn := negotiate(t, proxyAddr, MethodNoAuth, MethodUserPass)
+	defer conn.Close()
+	if method != MethodNoAuth {
+		t.Fatalf("trusted IP offered method %#x, want no-auth", method)
+	}
+	if reply := socks5Request(t, conn, CmdConnect, target); reply[1] != ReplySucceeded {
+		t.Fatalf("CONNECT reply = %#x, want success", reply[1])
+	}
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("relay read %q, %v", buf, err)
+	}
+}
+
+func TestTrustedIPCanStillUseUserPass(t *testing.T) {
+	store := superAdminStore(t, "127.0.0.1/32")
+	_, proxyAddr, _, _ := startTestServer(t, &config.EnvConfig{}, store)
+
+	if reply := connectReply(t, proxyAddr, "alice", "secret", startDelayedEchoTarget(t, 0, "")); reply != ReplySucceeded {
+		t.Fatalf("user/pass CONNECT from trusted IP = %#x, want success", reply)
+	}
+}
+
+func TestUntrustedIPForcedToUserPass(t *testing.T) {
+	store := superAdminStore(t, "10.0.0.0/8")
+	_, proxyAddr, _, _ := startTestServer(t, &config.EnvConfig{}, store)
+
+	method, conn := negotiate(t, proxyAddr, MethodNoAuth)
+	conn.Close()
+	if method != MethodNoAcceptable {
+		t.Fatalf("untrusted no-auth offer got method %#x, want %#x", method, MethodNoAcceptable)
+	}
+
+	method, conn = negotiate(t, proxyAddr, MethodNoAuth, MethodUserPass)
+	conn.Close()
+	if method != MethodUserPass {
+		t.Fatalf("untrusted IP got method %#x, want user/pass", method)
+	}
+}
+
+func TestMaxConnsRejectsExcessConnections(t *testing.T) {
+	store := newTestUserStore(t, "alice", "secret")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(&config.Config{MaxConns: 2, Env: &config.EnvConfig{}}, store, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.Serve(ctx, ln)
+	proxyAddr := ln.Addr().String()
+
+	// Fill every slot with an open tunnel
+	target := startDelayedEchoTarget(t, time.Minute, "")
+	var held []net.Conn
+	for i := 0; i < 2; i++ {
+		held = append(held, dialSOCKS5(t, proxyAddr, "alice", "secret", target))
+	}
+
+	// Excess connections are refused during negotiation, without a slot
+	for i := 0; i < 3; i++ {
+		conn, err := net.DialTimeout("tcp", proxyAddr, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte{Version5, 1, MethodUserPass})
+		resp := make([]byte, 2)
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			t.Fatalf("connection %d over the limit: no refusal: %v", i+1, err)
+		}
+		if resp[0] != Version5 || resp[1] != MethodNoAcceptable {
+			t.Fatalf("connection %d over the limit got %x, want %x", i+1, resp, []byte{Version5, MethodNoAcceptable})
+		}
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Fatalf("connection %d over the limit stayed open after the refusal", i+1)
+		}
+		conn.Close()
+	}
+	if n := srv.activeConnCount(); n != 2 {
+		t.Errorf("active connections = %d after refusals, want 2", n)
+	}
+
+	// Freeing a slot admits new connections again
+	held[0].Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.activeConnCount() >= 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("slot was not released after the tunnel closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	dialSOCKS5(t, proxyAddr, "alice", "secret", startDelayedEchoTarget(t, 0, "")).Close()
+	held[1].Close()
+}
+
+func TestMaxConnsClosesWhenRefusalsSaturated(t *testing.T) {
+	store := newTestUserStore(t, "alice", "secret")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(&config.Config{MaxConns: 1, Env: &config.EnvConfig{}}, store, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.Serve(ctx, ln)
+	proxyAddr := ln.Addr().String()
+
+	held := dialSOCKS5(t, proxyAddr, "alice", "secret", startDelayedEchoTarget(t, time.Minute, ""))
+	defer held.Close()
+
+	// With every refusal slot busy, excess connections are closed without
+	// waiting for a greeting
+	for i := 0; i < maxRefusals; i++ {
+		srv.refuseSem <- struct{}{}
+	}
+	conn, err := net.DialTimeout("tcp", proxyAddr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if n, err := conn.Read(make([]byte, 2)); err == nil {
+		t.Fatalf("saturated refusals: read %d bytes, want the connection closed", n)
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("saturated refusals: connection held open")
+	}
+}
+
+func TestBandwidthCapCutsTunnelMidTransfer(t *testing.T) {
+	store := storeWithUser(t, func(u *auth.User) { u.BandwidthLimitGB = 1 })
+	tracker := bandwidth.NewTracker(filepath.Join(t.TempDir(), "bandwidth_usage.json"))
+	defer tracker.Stop()
+	const limit = int64(1) << 30
+	tracker.RecordBytes("alice", limit-1<<20, 0) // 1MB left this month
+
+	// Target streams far more than the remaining allowance
+	const streamSize = 16 << 20
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	go func() {
+		c, err := tl.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.CopyN(c, zeroReader{}, streamSize)
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(&config.Config{Env: &config.EnvConfig{}}, store, tracker)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.Serve(ctx, ln)
+
+	conn := dialSOCKS5(t, ln.Addr().String(), "alice", "secret", tl.Addr().String())
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	received, _ := io.Copy(io.Discard, conn)
+	if received >= streamSize {
+		t.Fatalf("received the full %d bytes, want the tunnel cut near the cap", received)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.activeConnCount() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("tunnel was not torn down")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	total := tracker.GetUsage("alice").TotalBytes
+	if total < limit || total > limit+2*quotaSlack {
+		t.Errorf("recorded %d bytes, want within %d of the %d cap", total, 2*quotaSlack, limit)
+	}
+}
+
+// quotaSlack is roughly how far a tunnel may run past the cap before the
+// next check: one check interval plus one relay buffer.
+const quotaSlack = 256<<10 + 32<<10
+
+// zeroReader is an endless source of zero bytes.
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	clear(b)
+	return len(b), nil
+}
+
+// startTrackedServer serves SOCKS5 with a bandwidth tracker and returns the
+// proxy address.
+func startTrackedServer(t *testing.T, store *auth.UserStore, tracker *bandwidth.Tracker) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(&config.Config{Env: &config.EnvConfig{}}, store, tracker)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go srv.Serve(ctx, ln)
+	return ln.Addr().String()
+}
+
+// storeWithUser loads a store holding a single user "alice" with password
+// "secret", after applying setup to it.
+func storeWithUser(t *testing.T, setup func(u *auth.User)) *auth.UserStore {
+	t.Helper()
+	u := auth.User{Username: "alice", Role: "user", PasswordHash: mustHash(t, "secret"), Enabled: true}
+	setup(&u)
+	return newTestUserStoreWithConfig(t, auth.UsersConfig{Users: []auth.User{u}})
+}
+
+func TestRejectionsSendConnectionNotAllowed(t *testing.T) {
+	target := startDelayedEchoTarget(t, time.Minute, "")
+
+	t.Run("expired", func(t *testing.T) {
+		store := storeWithUser(t, func(u *auth.User) {
+			u.ExpiresAt = time.Now().Add(-time.Hour).Format(time.RFC3339)
+		})
+		_, proxyAddr, _, _ := startTestServer(t, &config.EnvConfig{}, store)
+		if reply := connectReply(t, proxyAddr, "alice", "secret", target); reply != ReplyConnectionNotAllowed {
+			t.Errorf("reply = %#x, want %#x", reply, ReplyConnectionNotAllowed)
+		}
+	})
+
+	// Each SOCKS5 connection takes one token in either RATE_LIMIT_MODE
+	for _, mode := range []string{config.RateLimitPerRequest, config.RateLimitPerConnection} {
+		t.Run("rate limited "+mode, func(t *testing.T) {
+			store := storeWithUser(t, func(u *auth.User) { u.RateLimitRPM = 1 })
+			_, proxyAddr, _, _ := startTestServer(t, &config.EnvConfig{RateLimitMode: mode}, store)
+			// The bucket allows a burst of 10 before refusing
+			for i := 0; i < 10; i++ {
+				if reply := connectReply(t, proxyAddr, "alice", "secret", target); reply != ReplySucceeded {
+					t.Fatalf("connection %d: reply = %#x, want success", i+1, reply)
+				}
+			}
+			if reply := connectReply(t, proxyAddr, "alice", "secret", target); reply != ReplyConnectionNotAllowed {
+				t.Errorf("connection beyond burst: reply = %#x, want %#x", reply, ReplyConnectionNotAllowed)
+			}
+		})
+	}
+
+	t.Run("bandwidth exceeded", func(t *testing.T) {
+		store := storeWithUser(t, func(u *auth.User) { u.BandwidthLimitGB = 1 })
+		tracker := bandwidth.NewTracker(filepath.Join(t.TempDir(), "bandwidth_usage.json"))
+		defer tracker.Stop()
+		tracker.RecordBytes("alice", 1<<30, 0)
+		proxyAddr := startTrackedServer(t, store, tracker)
+		if reply := connectReply(t, proxyAddr, "alice", "secret", target); reply != ReplyConnectionNotAllowed {
+			t.Errorf("reply = %#x, want %#x", reply, ReplyConnectionNotAllowed)
+		}
+	})
+
+	t.Run("connection limit", func(t *testing.T) {
+		store := storeWithUser(t, func(u *auth.User) { u.MaxConnections = 1 })
+		tracker := bandwidth.NewTracker(filepath.Join(t.TempDir(), "bandwidth_usage.json"))
+		defer tracker.Stop()
+		proxyAddr := startTrackedServer(t, store, tracker)
+		held := dialSOCKS5(t, proxyAddr, "alice", "secret", target)
+		defer held.Close()
+		if reply := connectReply(t, proxyAddr, "alice", "secret", target); reply != ReplyConnectionNotAllowed {
+			t.Errorf("reply = %#x, want %#x", reply, ReplyConnectionNotAllowed)
+		}
+	})
+}
+
+func TestDisabledUserDenied(t *testing.T) {
+	store := newTestUserStoreWithConfig(t, auth.UsersConfig{
+		Users: []auth.User{
+			{Username: "root", Role: "super_admin", PasswordHash: mustHash(t, "pw"), Enabled: false},
+			{Username: "alice", Role: "user", PasswordHash: mustHash(t, "secret"), Enabled: false},
+		},
+		SuperAdminIPs: []string{"127.0.0.1/32"},
+	})
+	_, proxyAddr, _, _ := startTestServer(t, &config.EnvConfig{}, store)
+
+	// A disabled super_admin gets no no-auth bypass from a trusted IP
+	method, conn := negotiate(t, proxyAddr, MethodNoAuth, MethodUserPass)
+	conn.Close()
+	if method == MethodNoAuth {
+		t.Error("disabled super_admin was offered no-auth")
+	}
+
+	for _, creds := range [][2]string{{"alice", "secret"}, {"root", "pw"}} {
+		method, conn := negotiate(t, proxyAddr, MethodUserPass)
+		if method != MethodUserPass {
+			t.Fatalf("method = %#x, want user/pass", method)
+		}
+		authReq := []byte{UserPassVersion, byte(len(creds[0]))}
+		authReq = append(authReq, creds[0]...)
+		authReq = append(authReq, byte(len(creds[1])))
+		authReq = append(authReq, creds[1]...)
+		conn.Write(authReq)
+		status := make([]byte, 2)
+		if _, err := io.ReadFull(conn, status); err == nil && status[1] == 0x00 {
+			t.Errorf("disabled user %s authenticated", creds[0])
+		}
+		conn.Close()
+	}
+}
+
+func TestConcurrentRelayTotalsStable(t *testing.T) {
+	const clients, payload = 16, 64 << 10
+
+	// Echo every connection back until the client closes
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	go func() {
+		for {
+			c, err := tl.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	store := newTestUserStore(t, "alice", "secret")
+	tracker := bandwidth.NewTracker(filepath.Join(t.TempDir(), "bandwidth_usage.json"))
+	defer tracker.Stop()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(&config.Config{Env: &config.EnvConfig{}}, store, tracker)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.Serve(ctx, ln)
+
+	errs := make(chan error, clients)
+	for i := 0; i < clients; i++ {
+		conn := dialSOCKS5(t, ln.Addr().String(), "alice", "secret", tl.Addr().String())
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			go conn.Write(make([]byte, payload))
+			_, err := io.ReadFull(conn, make([]byte, payload))
+			errs <- err
+		}()
+	}
+	for i := 0; i < clients; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("echo through the relay: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.activeConnCount() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("relays did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	usage := tracker.GetUsage("alice")
+	if want := int64(clients * payload); usage.BytesUp != want || usage.BytesDown != want {
+		t.Errorf("recorded up=%d down=%d, want %d each", usage.BytesUp, usage.BytesDown, want)
+	}
+}
+
+func TestKickClosesUserRelays(t *testing.T) {
+	// Target accepts and then stays silent, so only a kick ends the relay
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	go func() {
+		for {
+			c, err := tl.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	tracker := bandwidth.NewTracker(filepath.Join(t.TempDir(), "bandwidth_usage.json"))
+	defer tracker.Stop()
+	proxyAddr := startTrackedServer(t, newTestUserStore(t, "alice", "secret"), tracker)
+
+	conns := []net.Conn{
+		dialSOCKS5(t, proxyAddr, "alice", "secret", tl.Addr().String()),
+		dialSOCKS5(t, proxyAddr, "alice", "secret", tl.Addr().String()),
+	}
+	for _, c := range conns {
+		defer c.Close()
+	}
+
+	if got := tracker.Kick("alice"); got != 2 {
+		t.Fatalf("Kick(alice) = %d, want 2", got)
+	}
+	for i, c := range conns {
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := c.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+			t.Fatalf("conn %d: read after kick = %v, want the proxy to close it", i, err)
+		}
+	}
+
+	// The handlers have returned once their connection counts are released
+	deadline := time.Now().Add(2 * time.Second)
+	for tracker.GetActiveConns("alice") != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("ActiveConns = %d after kick, want 0", tracker.GetActiveConns("alice"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// isTimeout reports whether err is a network timeout.
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// captureAccessLog sends access log records to a JSON file for the rest of
+// the test and returns a func that waits for n of them and decodes them.
+func captureAccessLog(t *testing.T) func(n int) []accesslog.Entry {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := accesslog.Open(path, accesslog.JSON, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accesslog.SetDefault(l)
+	t.Cleanup(func() {
+		accesslog.SetDefault(nil)
+		l.Close()
+	})
+	return func(n int) []accesslog.Entry {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			l.Flush()
+			data, _ := os.ReadFile(path)
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if len(lines) >= n && lines[0] != "" {
+				entries := make([]accesslog.Entry, len(lines))
+				for i, line := range lines {
+					if err := json.Unmarshal([]byte(line), &entries[i]); err != nil {
+						t.Fatalf("unparseable record %q: %v", line, err)
+					}
+				}
+				return entries
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d access log records, want %d", len(lines), n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestAccessLogRecordsConnections(t *testing.T) {
+	records := captureAccessLog(t)
+	store := newTestUserStore(t, "alice", "secret")
+	target := startDelayedEchoTarget(t, 0, "pong")
+	env := &config.EnvConfig{ConnectAllowedPorts: []int{443}, SOCKS5RestrictPorts: true}
+	_, addr, _, _ := startTestServer(t, env, store)
+
+	if reply := connectReply(t, addr, "alice", "secret", target); reply != ReplyConnectionNotAllowed {
+		t.Fatalf("CONNECT reply = %#x", reply)
+	}
+	got := records(1)[0]
+	if got.Proxy != "socks5" || got.Client != "127.0.0.1" || got.User != "alice" ||
+		got.Method != "CONNECT" || got.Target != target || got.Status != int(ReplyConnectionNotAllowed) {
+		t.Errorf("refused CONNECT record = %+v", got)
+	}
+
+	env.SOCKS5RestrictPorts = false
+	conn := dialSOCKS5(t, addr, "alice", "secret", target)
+	conn.Write([]byte("ping"))
+	io.ReadFull(conn, make([]byte, 4))
+	conn.Close()
+
+	got = records(2)[1]
+	if got.Status != int(ReplySucceeded) || got.BytesUp != 4 || got.BytesDown != 4 {
+		t.Errorf("relayed CONNECT record = %+v", got)
+	}
+}
+
+func TestSendReplyAddressFamilies(t *testing.T) {
+	s := &Server{}
+	for _, tc := range []struct {
+		name string
+		addr *net.TCPAddr
+		want []byte
+	}{
+		{"nil", nil, []byte{Version5, ReplyGeneralFailure, 0, AddrTypeIPv4, 0, 0, 0, 0, 0, 0}},
+		{"ipv4", &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1080},
+			[]byte{Version5, ReplyGeneralFailure, 0, AddrTypeIPv4, 192, 0, 2, 1, 0x04, 0x38}},
+		{"ipv6", &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1080},
+			append(append([]byte{Version5, ReplyGeneralFailure, 0, AddrTypeIPv6}, net.ParseIP("2001:db8::1")...), 0x04, 0x38)},
+	} {
+		client, server := net.Pipe()
+		go func() {
+			s.sendReply(server, ReplyGeneralFailure, tc.addr)
+			server.Close()
+		}()
+		got, _ := io.ReadAll(client)
+		client.Close()
+		if string(got) != string(tc.want) {
+			t.Errorf("%s: reply = %x, want %x", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestStartAddrInUse(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	cfg := &config.Config{Env: &config.EnvConfig{SOCKS5Port: taken.Addr().String()}}
+	srv := NewServer(cfg, newTestUserStore(t, "alice", "secret"), nil)
+	if err := srv.Start(context.Background()); !errors.Is(err, config.ErrAddrInUse) {
+		t.Fatalf("Start on a bound port = %v, want ErrAddrInUse", err)
+	}
+}
@@ -2,7 +2,11 @@ package ui
 
 import (
 	"regexp"
+	"strings"
+	"unicode"
 	"unicode/utf8"
+
+	"golang.org/x/text/width"
 )
 
 // ANSI escape code patterns
@@ -27,28 +31,101 @@ func StripAnsi(input string) string {
 }
 
 // VisibleWidth returns the display width of a string, ignoring ANSI codes
-// This counts runes, not bytes, for proper Unicode support
+// Wide and fullwidth East Asian runes (CJK, most emoji) take two columns
 func VisibleWidth(input string) int {
-	stripped := StripAnsi(input)
-	return utf8.RuneCountInString(stripped)
+	w := 0
+	for _, r := range StripAnsi(input) {
+		w += runeWidth(r)
+	}
+	return w
+}
+
+// runeWidth returns how many terminal columns r occupies
+func runeWidth(r rune) int {
+	switch {
+	case r == zeroWidthJoiner, unicode.Is(unicode.Mn, r), unicode.Is(unicode.Me, r), unicode.Is(unicode.Cf, r):
+		return 0 // combining marks, variation selectors, ZWJ
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
 }
 
-// TruncateVisible truncates a string to a maximum visible width
-// Preserves ANSI codes but counts only visible characters
+// zeroWidthJoiner glues emoji sequences together
+const zeroWidthJoiner = '\u200d'
+
+// TruncateVisible truncates a string to a maximum visible width, ending it
+// with "..." when there is room. Escape codes before the cut are kept so the
+// text stays styled, and a reset closes any style left open.
 func TruncateVisible(input string, maxWidth int) string {
-	stripped := StripAnsi(input)
-	if utf8.RuneCountInString(stripped) <= maxWidth {
+	if VisibleWidth(input) <= maxWidth {
 		return input
 	}
+	if maxWidth <= 0 {
+		return ""
+	}
 
-	// Simple approach: strip, truncate, return plain
-	runes := []rune(stripped)
-	if len(runes) > maxWidth-3 {
-		return string(runes[:maxWidth-3]) + "..."
+	ellipsis := "..."
+	if maxWidth < len(ellipsis) {
+		ellipsis = ""
 	}
-	return string(runes[:maxWidth])
+	budget := maxWidth - len(ellipsis)
+
+	var b strings.Builder
+	styled, linked := false, false
+	// text writes the plain run s until the budget runs out, reporting
+	// whether all of it fit
+	text := func(s string) bool {
+		for s != "" {
+			r, size := utf8.DecodeRuneInString(s)
+			w := runeWidth(r)
+			if w > budget {
+				return false
+			}
+			budget -= w
+			b.WriteString(s[:size])
+			s = s[size:]
+		}
+		return true
+	}
+
+	// Escape codes are copied through without counting them
+	pos, fits := 0, true
+	for _, loc := range allAnsiPattern.FindAllStringIndex(input, -1) {
+		if fits = text(input[pos:loc[0]]); !fits {
+			break
+		}
+		code := input[loc[0]:loc[1]]
+		b.WriteString(code)
+		if strings.HasPrefix(code, "\x1b]8;;") {
+			linked = code != osc8Close
+		} else {
+			styled = code != sgrReset && code != "\x1b[m"
+		}
+		pos = loc[1]
+	}
+	if fits {
+		text(input[pos:])
+	}
+
+	b.WriteString(ellipsis)
+	if linked {
+		b.WriteString(osc8Close)
+	}
+	if styled {
+		b.WriteString(sgrReset)
+	}
+	return b.String()
 }
 
+// Codes that end a style or a hyperlink
+const (
+	sgrReset  = "\x1b[0m"
+	osc8Close = "\x1b]8;;\x1b\\"
+)
+
 // PadRight pads a string to a minimum visible width (right-aligned content)
 func PadRight(input string, width int) string {
 	visible := VisibleWidth(input)
@@ -0,0 +1,72 @@
+package ui
+
+import "testing"
+
+func TestVisibleWidth(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"alice", 5},
+		{"\x1b[31malice\x1b[0m", 5},
+		{"山田", 4},
+		{"bob山田", 7},
+		{"ｆｕｌｌ", 8}, // fullwidth Latin
+		{"🎄 tree", 7},
+		{"e\u0301", 1}, // e + combining acute
+		{"👍🏽", 4},      // emoji + skin tone modifier
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := VisibleWidth(tt.in); got != tt.want {
+			t.Errorf("VisibleWidth(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPadWideStrings(t *testing.T) {
+	if got := PadRight("山田", 6); got != "山田  " {
+		t.Errorf("PadRight = %q", got)
+	}
+	if got := PadLeft("山田", 6); got != "  山田" {
+		t.Errorf("PadLeft = %q", got)
+	}
+	if got := PadCenter("山", 6); got != "  山  " {
+		t.Errorf("PadCenter = %q", got)
+	}
+}
+
+func TestTruncateVisible(t *testing.T) {
+	red, reset := "\x1b[31m", "\x1b[0m"
+	tests := []struct {
+		name string
+		in   string
+		max  int
+		want string
+	}{
+		{"fits", "hello", 5, "hello"},
+		{"one over", "hello!", 5, "he..."},
+		{"plain", "example.org", 8, "examp..."},
+		{"colored", red + "example.org" + reset, 8, red + "examp..." + reset},
+		{"style opened mid-string", "ab" + red + "cdefgh" + reset, 6, "ab" + red + "c..." + reset},
+		{"colored fits", red + "short" + reset, 5, red + "short" + reset},
+		{"zero", "hello", 0, ""},
+		{"negative", "hello", -2, ""},
+		{"one", "hello", 1, "h"},
+		{"two", red + "hello" + reset, 2, red + "he" + reset},
+		{"three", "hello", 3, "..."},
+		{"wide rune not split", "山田太郎", 6, "山..."},
+		{"link closed", "\x1b]8;;https://x.test\x1b\\linktext\x1b]8;;\x1b\\", 6, "\x1b]8;;https://x.test\x1b\\lin...\x1b]8;;\x1b\\"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TruncateVisible(tt.in, tt.max)
+			if got != tt.want {
+				t.Fatalf("TruncateVisible(%q, %d) = %q, want %q", tt.in, tt.max, got, tt.want)
+			}
+			if tt.max >= 0 && VisibleWidth(got) > tt.max {
+				t.Fatalf("result width %d exceeds %d", VisibleWidth(got), tt.max)
+			}
+		})
+	}
+}
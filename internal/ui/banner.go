@@ -8,147 +8,86 @@ import (
 	"github.com/fatih/color"
 )
 
-// ASCII art banner for Signal Proxy
-var asciiBanner = []string{
-	"░██████╗██╗░██████╗░███╗░░██╗░█████╗░██╗░░░░░",
-	"██╔════╝██║██╔════╝░████╗░██║██╔══██╗██║░░░░░",
-	"╚█████╗░██║██║░░██╗░██╔██╗██║███████║██║░░░░░",
-	"░╚═══██╗██║██║░░╚██╗██║╚████║██╔══██║██║░░░░░",
-	"██████╔╝██║╚██████╔╝██║░╚███║██║░░██║███████╗",
-	"╚═════╝░╚═╝░╚═════╝░╚═╝░░╚══╝╚═╝░░╚═╝╚══════╝",
-}
-
-// Simpler fallback banner
-var simpleBanner = []string{
-	"███████╗██╗ ██████╗ ███╗   ██╗ █████╗ ██╗     ",
-	"██╔════╝██║██╔════╝ ████╗  ██║██╔══██╗██║     ",
-	"███████╗██║██║  ███╗██╔██╗ ██║███████║██║     ",
-	"╚════██║██║██║   ██║██║╚██╗██║██╔══██║██║     ",
-	"███████║██║╚██████╔╝██║ ╚████║██║  ██║███████╗",
-	"╚══════╝╚═╝ ╚═════╝ ╚═╝  ╚═══╝╚═╝  ╚═╝╚══════╝",
-}
-
 var bannerEmitted = false
 
-// FormatBannerArt returns the ASCII banner with gradient coloring
-func FormatBannerArt() string {
-	rich := IsRich()
-	if !rich {
-		return strings.Join(simpleBanner, "\n")
-	}
-
-	// Color styles
-	accent := color.New(color.FgHiRed, color.Bold)
-	accentDim := color.New(color.FgRed)
+// Banner settings from BANNER_ENABLED and TAGLINE
+var (
+	bannerDisabled = false
+	pinnedTagline  = ""
+)
 
-	var lines []string
-	for _, line := range simpleBanner {
-		var coloredLine strings.Builder
-		for _, ch := range line {
-			switch ch {
-			case '█', '╗', '╔', '╚', '╝', '║':
-				coloredLine.WriteString(accent.Sprint(string(ch)))
-			case '░', '═', '╣', '╠':
-				coloredLine.WriteString(accentDim.Sprint(string(ch)))
-			default:
-				coloredLine.WriteString(Muted("%c", ch))
-			}
-		}
-		lines = append(lines, coloredLine.String())
-	}
-	return strings.Join(lines, "\n")
+// ConfigureBanner applies the operator's banner settings. A disabled banner
+// prints nothing at all; a non-empty tagline replaces the random pick.
+func ConfigureBanner(enabled bool, tagline string) {
+	bannerDisabled = !enabled
+	pinnedTagline = strings.TrimSpace(tagline)
 }
 
-// FormatBannerLine returns the version/tagline line
-func FormatBannerLine(version, tagline string) string {
-	rich := IsRich()
-	title := "◆ SIGNAL PROXY"
-
-	if rich {
-		return fmt.Sprintf("%s %s %s %s",
-			Heading(title),
-			Info(version),
-			Muted("—"),
-			AccentDim(tagline))
+// bannerTagline returns the pinned tagline, or a random one
+func bannerTagline() string {
+	if pinnedTagline != "" {
+		return pinnedTagline
 	}
-	return fmt.Sprintf("%s %s — %s", title, version, tagline)
+	return PickTagline()
 }
 
-// EmitBanner displays the banner once, respecting TTY and flags
-func EmitBanner(version, tagline string) {
-	if bannerEmitted {
+// Banner displays the boxed startup banner once. An empty tagline uses the
+// configured one. Nothing is printed when the banner is disabled, the output
+// is not Interactive, or --version was passed.
+func Banner(version, tagline string) {
+	if bannerEmitted || bannerDisabled {
 		return
 	}
-	if !isTTY() {
+	if !Interactive() {
 		return
 	}
-	// Skip for --json or --version flags
 	for _, arg := range os.Args {
-		if arg == "--json" || arg == "--version" || arg == "-v" {
+		if arg == "--version" || arg == "-v" {
 			return
 		}
 	}
-
-	fmt.Println()
-	fmt.Println(FormatBannerArt())
-	fmt.Println()
-	fmt.Println(FormatBannerLine(version, tagline))
-	fmt.Println()
-	bannerEmitted = true
-}
-
-// EmitSimpleBanner displays a simpler boxed banner (current style)
-func EmitSimpleBanner(version, tagline string) {
-	if bannerEmitted {
-		return
-	}
-	if !isTTY() {
-		return
+	if tagline == "" {
+		tagline = bannerTagline()
 	}
 
-	fmt.Println()
+	var b strings.Builder
+	b.WriteString("\n")
 
 	// Product badge
-	badge := color.New(color.BgMagenta, color.FgWhite, color.Bold).Sprint(" ◆ SIGNAL ")
-	ver := Muted(version)
+	badge := " ◆ SIGNAL "
+	if IsRich() {
+		badge = color.New(color.BgMagenta, color.FgWhite, color.Bold).Sprint(badge)
+	}
+	ver := Muted("%s", version)
 
 	// Top border
-	topBorder := Muted(boxTopLeft + strings.Repeat(boxHorizontal, 60) + boxTopRight)
-	fmt.Println(topBorder)
+	topBorder := Muted("%s", boxTopLeft+strings.Repeat(boxHorizontal, 60)+boxTopRight)
+	b.WriteString(topBorder + "\n")
 
 	// Title line
 	titleLine := fmt.Sprintf("%s  %s %s  %s",
 		Muted(boxVertical),
 		badge,
 		ver,
-		Muted(strings.Repeat(" ", 36)+boxVertical))
-	fmt.Println(titleLine)
+		Muted("%s", strings.Repeat(" ", 36)+boxVertical))
+	b.WriteString(titleLine + "\n")
 
-	// Subtitle
-	subtitle := Subtle(tagline)
+	// Subtitle; a long pinned tagline just pushes the border out
+	subtitle := Subtle("%s", tagline)
 	subtitleLine := fmt.Sprintf("%s  %s%s",
 		Muted(boxVertical),
 		subtitle,
-		Muted(strings.Repeat(" ", 60-2-len(tagline))+boxVertical))
-	fmt.Println(subtitleLine)
+		Muted("%s", spaces(60-2-VisibleWidth(tagline))+boxVertical))
+	b.WriteString(subtitleLine + "\n")
 
 	// Bottom border
-	bottomBorder := Muted(boxBottomLeft + strings.Repeat(boxHorizontal, 60) + boxBottomRight)
-	fmt.Println(bottomBorder)
-	fmt.Println()
+	bottomBorder := Muted("%s", boxBottomLeft+strings.Repeat(boxHorizontal, 60)+boxBottomRight)
+	b.WriteString(bottomBorder + "\n\n")
+	emit(b.String())
 
 	bannerEmitted = true
 }
 
-// isTTY checks if stdout is a terminal
-func isTTY() bool {
-	fi, err := os.Stdout.Stat()
-	if err != nil {
-		return false
-	}
-	return (fi.Mode() & os.ModeCharDevice) != 0
-}
-
 // ResetBanner allows banner to be shown again (for testing)
 func ResetBanner() {
 	bannerEmitted = false
@@ -0,0 +1,71 @@
+package ui
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// captureBanner routes banner output to a buffer as if stdout were an
+// interactive terminal and restores the banner state when the test ends.
+func captureBanner(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	buf := captureInteractive(t)
+	ResetBanner()
+	t.Cleanup(func() {
+		ConfigureBanner(true, "")
+		ResetBanner()
+	})
+	return buf
+}
+
+func TestBannerDisabledPrintsNothing(t *testing.T) {
+	buf := captureBanner(t)
+	ConfigureBanner(false, "")
+
+	Banner("v9.9.9", "")
+	Banner("v9.9.9", "tagline")
+
+	if buf.Len() != 0 {
+		t.Fatalf("disabled banner wrote %q", buf.String())
+	}
+}
+
+func TestBannerPinnedTagline(t *testing.T) {
+	buf := captureBanner(t)
+	ConfigureBanner(true, "  Acme Corp egress proxy  ")
+
+	Banner("v9.9.9", "")
+
+	if !strings.Contains(StripAnsi(buf.String()), "Acme Corp egress proxy") {
+		t.Fatalf("banner missing pinned tagline:\n%s", buf.String())
+	}
+}
+
+func TestBannerLongTaglineDoesNotPanic(t *testing.T) {
+	buf := captureBanner(t)
+	ConfigureBanner(true, strings.Repeat("x", 80))
+
+	Banner("v9.9.9", "")
+
+	if !strings.Contains(buf.String(), strings.Repeat("x", 80)) {
+		t.Fatal("banner missing long tagline")
+	}
+}
+
+func TestBannerUsesInjectedVersion(t *testing.T) {
+	buf := captureBanner(t)
+
+	Banner("v7.3.1-rc2", "Pinned for the test")
+
+	out := StripAnsi(buf.String())
+	if !strings.Contains(out, "v7.3.1-rc2") {
+		t.Fatalf("banner missing injected version:\n%s", out)
+	}
+	if strings.Contains(out, "v1.0.0") {
+		t.Fatalf("banner carries a hardcoded version:\n%s", out)
+	}
+	if !strings.Contains(out, "Pinned for the test") {
+		t.Fatalf("banner missing explicit tagline:\n%s", out)
+	}
+}
@@ -66,7 +66,7 @@ func FormatDocsLink(path, label string) string {
 func FormatURLWithStyle(label, url string) string {
 	link := FormatTerminalLink(label, url)
 	if IsRich() {
-		return Secondary(link)
+		return Secondary("%s", link)
 	}
 	return link
 }
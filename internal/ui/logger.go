@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"signal-proxy/internal/bytesize"
 )
 
 // Box-drawing characters for UI elements
@@ -18,25 +20,16 @@ const (
 	boxTeeLeft     = "┤"
 )
 
-// VERSION is the application version
-const VERSION = "v1.0.0"
-
-// PrintBanner displays the CLI header with version and tagline
-func PrintBanner() {
-	tagline := PickTagline()
-	EmitSimpleBanner(VERSION, tagline)
-}
-
 // LogThinking displays a processing message with spinner icon
 func LogThinking(message string) {
-	ts := Muted(time.Now().Format("15:04:05"))
+	ts := Muted("%s", time.Now().Format("15:04:05"))
 	spinner := Primary("◐")
-	fmt.Printf("%s  %s  %s\n", ts, spinner, Subtle(message))
+	emitf("%s  %s  %s\n", ts, spinner, Subtle("%s", message))
 }
 
 // LogStatus displays a status message with semantic styling
 func LogStatus(category, message string) {
-	ts := Muted(time.Now().Format("15:04:05"))
+	ts := Muted("%s", time.Now().Format("15:04:05"))
 
 	var icon string
 	var styledMsg string
@@ -44,78 +37,76 @@ func LogStatus(category, message string) {
 	switch category {
 	case "success":
 		icon = Success("✓")
-		styledMsg = Success(message)
+		styledMsg = Success("%s", message)
 	case "error":
 		icon = Error("✗")
-		styledMsg = Error(message)
+		styledMsg = Error("%s", message)
 	case "warning", "warn":
 		icon = Warn("⚠")
-		styledMsg = Warn(message)
+		styledMsg = Warn("%s", message)
 	case "info":
 		icon = Info("ℹ")
-		styledMsg = Subtle(message)
+		styledMsg = Subtle("%s", message)
 	default:
 		icon = Muted("●")
-		styledMsg = Subtle(message)
+		styledMsg = Subtle("%s", message)
 	}
 
-	fmt.Printf("%s  %s  %s\n", ts, icon, styledMsg)
+	emitf("%s  %s  %s\n", ts, icon, styledMsg)
 }
 
 // LogSection creates a styled section header
 func LogSection(title string) {
-	fmt.Println()
 	header := fmt.Sprintf("%s %s %s",
 		Muted("──"),
-		Heading(title),
-		Muted(strings.Repeat("─", 50-len(title))))
-	fmt.Println(header)
+		Heading("%s", title),
+		Muted("%s", strings.Repeat("─", 50-len(title))))
+	emit("\n" + header + "\n")
 }
 
 // LogGroup starts a grouped block of messages
 func LogGroup(title string) {
-	fmt.Println()
 	top := fmt.Sprintf("%s%s %s %s%s",
 		Muted(boxTopLeft),
-		Muted(strings.Repeat(boxHorizontal, 2)),
-		Primary(title),
-		Muted(strings.Repeat(boxHorizontal, 50-len(title))),
+		Muted("%s", strings.Repeat(boxHorizontal, 2)),
+		Primary("%s", title),
+		Muted("%s", strings.Repeat(boxHorizontal, 50-len(title))),
 		Muted(boxTopRight))
-	fmt.Println(top)
+	emit("\n" + top + "\n")
 }
 
 // LogGroupEnd closes a grouped block
 func LogGroupEnd() {
-	bottom := Muted(boxBottomLeft + strings.Repeat(boxHorizontal, 56) + boxBottomRight)
-	fmt.Println(bottom)
-	fmt.Println()
+	bottom := Muted("%s", boxBottomLeft+strings.Repeat(boxHorizontal, 56)+boxBottomRight)
+	emit(bottom + "\n\n")
 }
 
 // LogGroupItem logs an item within a group
 func LogGroupItem(label, value string) {
 	line := fmt.Sprintf("%s  %s %s",
 		Muted(boxVertical),
-		Muted(label+":"),
-		Secondary(value))
-	fmt.Println(line)
+		Muted("%s", label+":"),
+		Secondary("%s", value))
+	emit(line + "\n")
 }
 
-// LogRelay displays relay connection info
-func LogRelay(sni, clientIP string, up, down int64) {
-	ts := Muted(time.Now().Format("15:04:05"))
+// LogRelay displays relay connection info, tagged with its request ID
+func LogRelay(id, sni, clientIP string, up, down int64) {
+	ts := Muted("%s", time.Now().Format("15:04:05"))
 
-	fmt.Printf("%s  %s  %s  %s  %s %s  %s %s\n",
+	emitf("%s  %s  %s  %s  %s  %s %s  %s %s\n",
 		ts,
 		Success("→"),
-		Secondary(fmt.Sprintf("%-28s", sni)),
-		Muted(fmt.Sprintf("%-16s", clientIP)),
-		Muted("↑"), Subtle(fmt.Sprintf("%-8s", formatBytes(up))),
-		Muted("↓"), Subtle(fmt.Sprintf("%-8s", formatBytes(down))))
+		Muted("%-8s", id),
+		Secondary("%s", PadRight(TruncateVisible(sni, 28), 28)),
+		Muted("%s", fmt.Sprintf("%-16s", clientIP)),
+		Muted("↑"), Subtle("%s", fmt.Sprintf("%-8s", bytesize.Format(up, bytesize.Options{}))),
+		Muted("↓"), Subtle("%s", fmt.Sprintf("%-8s", bytesize.Format(down, bytesize.Options{}))))
 }
 
 // LogConnection shows a connection event
 func LogConnection(event, target string) {
-	ts := Muted(time.Now().Format("15:04:05"))
+	ts := Muted("%s", time.Now().Format("15:04:05"))
 
 	var icon string
 	switch event {
@@ -127,49 +118,28 @@ func LogConnection(event, target string) {
 		icon = Muted("●")
 	}
 
-	fmt.Printf("%s  %s  %s\n", ts, icon, Secondary(target))
+	emitf("%s  %s  %s\n", ts, icon, Secondary("%s", target))
 }
 
 // LogMetric displays a metric value
 func LogMetric(name string, value interface{}, unit string) {
-	ts := Muted(time.Now().Format("15:04:05"))
-	fmt.Printf("%s  %s  %s: %s %s\n",
+	ts := Muted("%s", time.Now().Format("15:04:05"))
+	emitf("%s  %s  %s: %s %s\n",
 		ts,
 		Muted("◈"),
-		Subtle(name),
-		AccentBright(fmt.Sprintf("%v", value)),
-		Muted(unit))
-}
-
-// formatBytes converts bytes to human-readable format
-func formatBytes(b int64) string {
-	const (
-		KB = 1024
-		MB = KB * 1024
-		GB = MB * 1024
-	)
-
-	switch {
-	case b < KB:
-		return fmt.Sprintf("%dB", b)
-	case b < MB:
-		return fmt.Sprintf("%.1fKB", float64(b)/KB)
-	case b < GB:
-		return fmt.Sprintf("%.1fMB", float64(b)/MB)
-	default:
-		return fmt.Sprintf("%.1fGB", float64(b)/GB)
-	}
+		Subtle("%s", name),
+		AccentBright("%s", fmt.Sprintf("%v", value)),
+		Muted("%s", unit))
 }
 
 // PrintSeparator prints a horizontal separator
 func PrintSeparator() {
-	fmt.Println(Muted("  " + strings.Repeat("─", 56)))
+	emit(Muted("%s", "  "+strings.Repeat("─", 56)) + "\n")
 }
 
 // PrintFooter displays a footer message
 func PrintFooter(message string) {
-	fmt.Println()
-	fmt.Printf("  %s %s\n", Muted("▸"), Muted(message))
+	emitf("\n  %s %s\n", Muted("▸"), Muted("%s", message))
 }
 
 // FormatError returns a rich error message with context
@@ -182,18 +152,17 @@ func FormatError(err error, solutions ...string) string {
 	if len(solutions) > 0 {
 		lines = append(lines, Muted("Possible solutions:"))
 		for _, s := range solutions {
-			lines = append(lines, Muted("  • "+s))
+			lines = append(lines, Muted("%s", "  • "+s))
 		}
 		lines = append(lines, "")
 	}
 
-	lines = append(lines, Muted("Docs: "+FormatDocsLink("/troubleshooting", "signal.org/docs")))
+	lines = append(lines, Muted("%s", "Docs: "+FormatDocsLink("/troubleshooting", "signal.org/docs")))
 
 	return strings.Join(lines, "\n")
 }
 
 // LogGracefulShutdown logs the shutdown message
 func LogGracefulShutdown() {
-	fmt.Println()
-	fmt.Printf("  %s %s\n", Muted("✋"), Muted("Cancelled"))
+	emitf("\n  %s %s\n", Muted("✋"), Muted("Cancelled"))
 }
@@ -11,7 +11,18 @@ func Note(message string, title string) {
 	wrapped := WrapNoteMessage(message, 80)
 	lines := strings.Split(wrapped, "\n")
 
-	fmt.Println()
+	// Plain lines when boxes would end up in captured output
+	if !Interactive() {
+		if title != "" {
+			emit(title + ": " + strings.Join(lines, "\n") + "\n")
+		} else {
+			emit(wrapped + "\n")
+		}
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("\n")
 
 	// Calculate box width
 	maxWidth := 0
@@ -27,17 +38,17 @@ func Note(message string, title string) {
 	if title != "" {
 		styledTitle := title
 		if IsRich() {
-			styledTitle = Heading(title)
+			styledTitle = Heading("%s", title)
 		}
 		top := fmt.Sprintf("%s%s %s %s%s",
 			Muted(boxTopLeft),
-			Muted(strings.Repeat(boxHorizontal, 2)),
+			Muted("%s", strings.Repeat(boxHorizontal, 2)),
 			styledTitle,
-			Muted(strings.Repeat(boxHorizontal, boxWidth-4-VisibleWidth(title))),
+			Muted("%s", strings.Repeat(boxHorizontal, boxWidth-4-VisibleWidth(title))),
 			Muted(boxTopRight))
-		fmt.Println(top)
+		b.WriteString(top + "\n")
 	} else {
-		fmt.Println(Muted(boxTopLeft + strings.Repeat(boxHorizontal, boxWidth) + boxTopRight))
+		b.WriteString(Muted("%s", boxTopLeft+strings.Repeat(boxHorizontal, boxWidth)+boxTopRight) + "\n")
 	}
 
 	// Content lines
@@ -46,7 +57,7 @@ func Note(message string, title string) {
 		if padding < 0 {
 			padding = 0
 		}
-		fmt.Printf("%s %s%s %s\n",
+		fmt.Fprintf(&b, "%s %s%s %s\n",
 			Muted(boxVertical),
 			line,
 			spaces(padding),
@@ -54,8 +65,8 @@ func Note(message string, title string) {
 	}
 
 	// Bottom border
-	fmt.Println(Muted(boxBottomLeft + strings.Repeat(boxHorizontal, boxWidth) + boxBottomRight))
-	fmt.Println()
+	b.WriteString(Muted("%s", boxBottomLeft+strings.Repeat(boxHorizontal, boxWidth)+boxBottomRight) + "\n\n")
+	emit(b.String())
 }
 
 // WrapNoteMessage wraps text to fit within terminal width
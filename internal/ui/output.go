@@ -0,0 +1,84 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// All terminal writes go through emit or drawProgress so a spinner redraw
+// and a log line never interleave mid-line.
+var (
+	outputMu     sync.Mutex
+	stdout       io.Writer = os.Stdout
+	stderr       io.Writer = os.Stderr
+	progressLine bool      // a progress line is drawn on stderr without a newline
+)
+
+// clearLine returns the cursor to column 0 and erases the line
+const clearLine = "\r\033[K"
+
+// emit writes s to stdout, first clearing any progress line so the two do
+// not share a row. The next spinner tick redraws it below.
+func emit(s string) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if progressLine {
+		io.WriteString(stderr, clearLine)
+		progressLine = false
+	}
+	io.WriteString(stdout, s)
+}
+
+// emitf is emit with formatting
+func emitf(format string, a ...interface{}) {
+	emit(fmt.Sprintf(format, a...))
+}
+
+// drawProgress replaces the progress line on stderr. An empty s clears it.
+func drawProgress(s string) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	io.WriteString(stderr, clearLine+s)
+	progressLine = s != ""
+}
+
+// stdoutIsTTY is swapped out by tests
+var stdoutIsTTY = isTTY
+
+// jsonMode is set by SetJSONMode (--json)
+var jsonMode atomic.Bool
+
+// SetJSONMode turns off the banner, spinners and boxed notes for output
+// read by machines, as requested with --json.
+func SetJSONMode(enabled bool) {
+	jsonMode.Store(enabled)
+}
+
+// Interactive reports whether the banner, spinners and boxed notes may be
+// drawn: stdout is a terminal, JSON mode is off, and neither CI, NO_COLOR
+// nor TERM=dumb asks for plain output.
+func Interactive() bool {
+	if !stdoutIsTTY() || jsonMode.Load() {
+		return false
+	}
+	if ci := strings.ToLower(strings.TrimSpace(os.Getenv("CI"))); ci != "" && ci != "0" && ci != "false" {
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return true
+}
+
+// isTTY checks if stdout is a terminal
+func isTTY() bool {
+	fi, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return (fi.Mode() & os.ModeCharDevice) != 0
+}
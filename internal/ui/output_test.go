@@ -0,0 +1,88 @@
+package ui
+
+import (
+	"bytes"
+	"testing"
+)
+
+// captureInteractive routes stdout and stderr to a buffer as if they were an
+// interactive terminal, clearing the env vars that force plain output.
+func captureInteractive(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prevOut, prevErr, prevTTY := stdout, stderr, stdoutIsTTY
+	stdout, stderr, stdoutIsTTY = &buf, &buf, func() bool { return true }
+	SetJSONMode(false)
+	for _, key := range []string{"CI", "NO_COLOR", "TERM"} {
+		t.Setenv(key, "")
+	}
+	t.Cleanup(func() {
+		stdout, stderr, stdoutIsTTY = prevOut, prevErr, prevTTY
+		SetJSONMode(false)
+	})
+	return &buf
+}
+
+func TestInteractive(t *testing.T) {
+	captureInteractive(t)
+	if !Interactive() {
+		t.Fatal("Interactive() = false on a plain TTY")
+	}
+
+	tests := []struct {
+		name  string
+		setup func()
+	}{
+		{"json mode", func() { SetJSONMode(true) }},
+		{"CI=true", func() { t.Setenv("CI", "true") }},
+		{"CI=1", func() { t.Setenv("CI", "1") }},
+		{"NO_COLOR", func() { t.Setenv("NO_COLOR", "1") }},
+		{"dumb terminal", func() { t.Setenv("TERM", "dumb") }},
+		{"not a TTY", func() { stdoutIsTTY = func() bool { return false } }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureInteractive(t)
+			tt.setup()
+			if Interactive() {
+				t.Fatal("Interactive() = true")
+			}
+		})
+	}
+}
+
+func TestProgressIsNoopWhenNotInteractive(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		setup func()
+	}{
+		{"json", func() { SetJSONMode(true) }},
+		{"CI", func() { t.Setenv("CI", "true") }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureInteractive(t)
+			tt.setup()
+
+			p := CreateProgress("Working", 10)
+			if _, ok := p.(*noopProgress); !ok {
+				t.Fatalf("CreateProgress returned %T, want a no-op", p)
+			}
+			p.Tick(5)
+			p.Done()
+			if buf.Len() != 0 {
+				t.Fatalf("no-op progress wrote %q", buf.String())
+			}
+		})
+	}
+}
+
+func TestNoteIsPlainWhenNotInteractive(t *testing.T) {
+	buf := captureInteractive(t)
+	t.Setenv("CI", "true")
+
+	Note("disk almost full", "Warning")
+
+	if got := buf.String(); got != "Warning: disk almost full\n" {
+		t.Fatalf("Note wrote %q", got)
+	}
+}
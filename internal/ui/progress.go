@@ -2,7 +2,6 @@ package ui
 
 import (
 	"fmt"
-	"os"
 	"sync"
 	"time"
 )
@@ -33,11 +32,16 @@ type progressReporter struct {
 
 // CreateProgress creates a new progress reporter
 func CreateProgress(label string, total int) ProgressReporter {
-	if !isTTY() {
-		// Return no-op for non-TTY
+	if !Interactive() {
+		// Return no-op for non-TTY, JSON and CI output
 		return &noopProgress{}
 	}
 
+	return startProgress(label, total)
+}
+
+// startProgress creates a progress reporter and starts its spinner
+func startProgress(label string, total int) *progressReporter {
 	p := &progressReporter{
 		label:    label,
 		total:    total,
@@ -72,37 +76,40 @@ func (p *progressReporter) animate() {
 }
 
 func (p *progressReporter) render() {
-	// Clear line
-	fmt.Fprint(os.Stderr, "\r\033[K")
-
 	spinner := spinnerFrames[p.frame]
 	if IsRich() {
-		spinner = Primary(spinner)
+		spinner = Primary("%s", spinner)
 	}
 
 	if p.total > 0 {
 		bar := renderProgressBar(p.percent, 20)
-		fmt.Fprintf(os.Stderr, "  %s %s %s %d%%",
+		drawProgress(fmt.Sprintf("  %s %s %s %d%%",
 			spinner,
-			Subtle(p.label),
+			Subtle("%s", p.label),
 			bar,
-			p.percent)
+			p.percent))
 	} else {
-		fmt.Fprintf(os.Stderr, "  %s %s",
+		drawProgress(fmt.Sprintf("  %s %s",
 			spinner,
-			Subtle(p.label))
+			Subtle("%s", p.label)))
 	}
 }
 
 func (p *progressReporter) SetLabel(label string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.done {
+		return
+	}
 	p.label = label
 }
 
 func (p *progressReporter) SetPercent(percent int) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.done {
+		return
+	}
 	if percent < 0 {
 		percent = 0
 	}
@@ -115,7 +122,7 @@ func (p *progressReporter) SetPercent(percent int) {
 func (p *progressReporter) Tick(delta int) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.total <= 0 {
+	if p.done || p.total <= 0 {
 		return
 	}
 	p.current += delta
@@ -133,8 +140,7 @@ func (p *progressReporter) Done() {
 	}
 	p.done = true
 	close(p.stopChan)
-	// Clear line
-	fmt.Fprint(os.Stderr, "\r\033[K")
+	drawProgress("")
 }
 
 // renderProgressBar creates a simple progress bar
@@ -154,7 +160,7 @@ func renderProgressBar(percent, width int) string {
 	}
 
 	if IsRich() {
-		return Accent(bar)
+		return Accent("%s", bar)
 	}
 	return bar
 }
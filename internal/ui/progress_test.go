@@ -0,0 +1,70 @@
+package ui
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgressAndLogsDoNotInterleave(t *testing.T) {
+	var buf bytes.Buffer
+	prevOut, prevErr := stdout, stderr
+	stdout, stderr = &buf, &buf
+	defer func() { stdout, stderr = prevOut, prevErr }()
+
+	p := startProgress("Downloading", 100)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				LogStatus("info", "worker "+strconv.Itoa(g)+" line "+strconv.Itoa(i))
+				p.Tick(1)
+				time.Sleep(2 * time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+	p.Done()
+
+	// Setters after Done are ignored
+	p.SetPercent(5)
+	p.SetLabel("changed")
+	p.mu.Lock()
+	mutated := p.percent == 5 || p.label == "changed"
+	p.mu.Unlock()
+	if mutated {
+		t.Error("progress mutated after Done")
+	}
+
+	outputMu.Lock()
+	out, drawn := buf.String(), progressLine
+	outputMu.Unlock()
+
+	// Every log line must be intact after any cleared spinner text
+	logged := 0
+	for _, chunk := range strings.Split(out, "\n") {
+		if i := strings.LastIndex(chunk, clearLine); i >= 0 {
+			chunk = chunk[i+len(clearLine):]
+		}
+		line := StripAnsi(chunk)
+		if line == "" || strings.HasPrefix(line, "  ") {
+			continue // trailing spinner frame
+		}
+		if !strings.Contains(line, "ℹ  worker ") || strings.Contains(line, "Downloading") {
+			t.Fatalf("corrupted log line %q", line)
+		}
+		logged++
+	}
+	if logged != 200 {
+		t.Fatalf("found %d intact log lines, want 200", logged)
+	}
+	if drawn {
+		t.Error("progress line still marked as drawn after Done")
+	}
+}
@@ -2,6 +2,7 @@ package ui
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -182,22 +183,44 @@ func RenderTable(opts RenderTableOptions) string {
 	return strings.Join(lines, "\n") + "\n"
 }
 
-// RenderSimpleTable renders a simple key-value table
+// KeyValue is one row of a key-value table
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// RenderSimpleTable renders a simple key-value table, sorted by key so the
+// output is the same on every run
 func RenderSimpleTable(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	rows := make([]KeyValue, len(keys))
+	for i, k := range keys {
+		rows[i] = KeyValue{Key: k, Value: data[k]}
+	}
+	return RenderKeyValues(rows)
+}
+
+// RenderKeyValues renders a key-value table in the order given
+func RenderKeyValues(rows []KeyValue) string {
 	var lines []string
 
 	// Find max key width
 	maxKey := 0
-	for k := range data {
-		if len(k) > maxKey {
-			maxKey = len(k)
+	for _, row := range rows {
+		if w := VisibleWidth(row.Key); w > maxKey {
+			maxKey = w
 		}
 	}
 
-	for k, v := range data {
+	for _, row := range rows {
 		line := fmt.Sprintf("  %s  %s",
-			Muted(PadRight(k+":", maxKey+1)),
-			Subtle(v))
+			Muted("%s", PadRight(row.Key+":", maxKey+1)),
+			Subtle("%s", row.Value))
 		lines = append(lines, line)
 	}
 
@@ -0,0 +1,83 @@
+package ui
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRenderTableAlignsWideCharacters(t *testing.T) {
+	out := RenderTable(RenderTableOptions{
+		Columns: []TableColumn{
+			{Key: "user", Header: "User"},
+			{Key: "traffic", Header: "Traffic", Align: AlignRight},
+		},
+		Rows: []map[string]string{
+			{"user": "alice", "traffic": "1.2GB"},
+			{"user": "山田太郎", "traffic": "300MB"},
+			{"user": "🎉party", "traffic": "4KB"},
+		},
+	})
+
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	want := VisibleWidth(lines[0])
+	for i, line := range lines {
+		if got := VisibleWidth(line); got != want {
+			t.Errorf("line %d width %d, want %d:\n%s", i, got, want, out)
+		}
+	}
+
+	// Column separators sit at the same display columns on every row
+	wantSeps := separatorColumns(lines[1])
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "│") {
+			continue
+		}
+		if got := separatorColumns(line); !slices.Equal(got, wantSeps) {
+			t.Errorf("line %d separators at %v, want %v:\n%s", i, got, wantSeps, out)
+		}
+	}
+}
+
+// separatorColumns returns the display columns of each │ in line
+func separatorColumns(line string) []int {
+	var cols []int
+	col := 0
+	for _, r := range line {
+		if r == '│' {
+			cols = append(cols, col)
+		}
+		col += runeWidth(r)
+	}
+	return cols
+}
+
+func TestRenderSimpleTableIsSorted(t *testing.T) {
+	data := map[string]string{
+		"zeta": "1", "alpha": "2", "mode": "3", "listen": "4", "beta": "5",
+	}
+	first := RenderSimpleTable(data)
+	for i := 0; i < 20; i++ {
+		if got := RenderSimpleTable(data); got != first {
+			t.Fatalf("run %d differs:\n%s\nvs\n%s", i, got, first)
+		}
+	}
+
+	var keys []string
+	for _, line := range strings.Split(StripAnsi(first), "\n") {
+		keys = append(keys, strings.TrimSuffix(strings.Fields(line)[0], ":"))
+	}
+	if want := []string{"alpha", "beta", "listen", "mode", "zeta"}; !slices.Equal(keys, want) {
+		t.Fatalf("row order %v, want %v", keys, want)
+	}
+}
+
+func TestRenderKeyValuesKeepsOrder(t *testing.T) {
+	out := StripAnsi(RenderKeyValues([]KeyValue{
+		{"Mode", "https"}, {"Listen", ":8443"}, {"Admin", "off"},
+	}))
+	want := "  Mode:    https\n  Listen:  :8443\n  Admin:   off"
+	if out != want {
+		t.Fatalf("got\n%q\nwant\n%q", out, want)
+	}
+}
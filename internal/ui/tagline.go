@@ -1,8 +1,9 @@
 package ui
 
 import (
-	"math/rand"
+	"math/rand/v2"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -47,9 +48,30 @@ type taglineRule struct {
 	tagline string
 }
 
+// taglineRand is the shared generator behind PickTagline
+var (
+	taglineMu   sync.Mutex
+	taglineRand = rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), 0))
+)
+
+// SeedTaglines reseeds the generator behind PickTagline so tests and demos
+// get a reproducible sequence.
+func SeedTaglines(seed uint64) {
+	taglineMu.Lock()
+	defer taglineMu.Unlock()
+	taglineRand = rand.New(rand.NewPCG(seed, 0))
+}
+
 // PickTagline returns a random tagline, considering holidays
 func PickTagline() string {
-	now := time.Now()
+	taglineMu.Lock()
+	defer taglineMu.Unlock()
+	return PickTaglineFrom(taglineRand, time.Now())
+}
+
+// PickTaglineFrom picks a tagline for the given day using r. Holiday
+// taglines win on their date; otherwise r chooses from the pool.
+func PickTaglineFrom(r *rand.Rand, now time.Time) string {
 	month := int(now.Month())
 	day := now.Day()
 
@@ -66,10 +88,7 @@ func PickTagline() string {
 	if len(taglines) == 0 {
 		return defaultTagline
 	}
-
-	// Use current time for seed variation
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	return taglines[r.Intn(len(taglines))]
+	return taglines[r.IntN(len(taglines))]
 }
 
 // GetAllTaglines returns all available taglines (for testing/display)
@@ -89,5 +108,5 @@ func FormatTagline(tagline string) string {
 		strings.HasPrefix(tagline, "🎉") {
 		return tagline // Keep emojis as-is
 	}
-	return AccentDim(tagline)
+	return AccentDim("%s", tagline)
 }
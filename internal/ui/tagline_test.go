@@ -0,0 +1,55 @@
+package ui
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestPickTaglineFromIsStableForSeed(t *testing.T) {
+	day := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	pick := func(seed uint64) []string {
+		r := rand.New(rand.NewPCG(seed, 0))
+		out := make([]string, 5)
+		for i := range out {
+			out[i] = PickTaglineFrom(r, day)
+		}
+		return out
+	}
+
+	first, again := pick(42), pick(42)
+	for i := range first {
+		if first[i] != again[i] {
+			t.Fatalf("pick %d with the same seed: %q vs %q", i, first[i], again[i])
+		}
+	}
+}
+
+func TestSeedTaglinesReproducesPickTagline(t *testing.T) {
+	// Holiday dates would override the pool; skip rather than flake on them
+	for _, rules := range holidayTaglines {
+		for _, rule := range rules {
+			if now := time.Now(); int(now.Month()) == rule.month && now.Day() == rule.day {
+				t.Skip("today is a holiday tagline date")
+			}
+		}
+	}
+	defer SeedTaglines(uint64(time.Now().UnixNano()))
+
+	SeedTaglines(7)
+	first := []string{PickTagline(), PickTagline(), PickTagline()}
+	SeedTaglines(7)
+	for i, want := range first {
+		if got := PickTagline(); got != want {
+			t.Fatalf("pick %d after reseed = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestHolidayTaglineOverridesPool(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 0))
+	christmas := time.Date(2026, 12, 25, 9, 0, 0, 0, time.UTC)
+	if got := PickTaglineFrom(r, christmas); got != "🎄 Ho ho ho—relaying holiday cheer!" {
+		t.Fatalf("Christmas tagline = %q", got)
+	}
+}
@@ -0,0 +1,10 @@
+// Package version holds build metadata, set at link time:
+//
+//	go build -ldflags "-X signal-proxy/internal/version.Version=v1.2.0 -X signal-proxy/internal/version.Commit=abc1234" ./cmd/proxy
+package version
+
+// Version is the release version ("dev" for local builds).
+var Version = "dev"
+
+// Commit is the git commit the binary was built from.
+var Commit = "unknown"
@@ -17,6 +17,11 @@ cd "$PROJECT_ROOT"
 # Build directory
 BUILD_DIR="${PROJECT_ROOT}/build"
 
+# Version metadata stamped into the binary
+VERSION="${VERSION:-$(git describe --tags --always --dirty 2>/dev/null || echo dev)}"
+COMMIT="${COMMIT:-$(git rev-parse --short HEAD 2>/dev/null || echo unknown)}"
+LDFLAGS="-s -w -X signal-proxy/internal/version.Version=${VERSION} -X signal-proxy/internal/version.Commit=${COMMIT}"
+
 # Help Text
 show_help() {
     echo ""
@@ -49,7 +54,7 @@ build_for_platform() {
     local start_time=$(get_timestamp)
     
     # Set environment and build
-    if GOOS="$target_os" GOARCH="$target_arch" go build -ldflags="-s -w" -o "$output_path" ./cmd/proxy 2>&1; then
+    if GOOS="$target_os" GOARCH="$target_arch" go build -ldflags="$LDFLAGS" -o "$output_path" ./cmd/proxy 2>&1; then
         local end_time=$(get_timestamp)
         local elapsed=$(format_elapsed "$start_time" "$end_time")
         local size=$(get_file_size "$output_path")
@@ -11,6 +11,8 @@ import (
 	"strings"
 	"time"
 
+	"signal-proxy/internal/fsutil"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -28,11 +30,21 @@ type User struct {
 	ExpiresAt          string `json:"expires_at,omitempty"`
 }
 
+// Plan holds the default limits of a plan in users.json
+type Plan struct {
+	RateLimitRPM       int `json:"rate_limit_rpm,omitempty"`
+	BandwidthLimitGB   int `json:"bandwidth_limit_gb,omitempty"`
+	BandwidthSpeedMbps int `json:"bandwidth_speed_mbps,omitempty"`
+	MaxConnections     int `json:"max_connections,omitempty"`
+}
+
 // UsersConfig holds all user configuration
 type UsersConfig struct {
 	Users         []User   `json:"users"`
 	IPWhitelist   []string `json:"ip_whitelist"`
 	SuperAdminIPs []string `json:"super_admin_ips,omitempty"`
+
+	Plans map[string]Plan `json:"plans,omitempty"`
 }
 
 var reader = bufio.NewReader(os.Stdin)
@@ -388,7 +400,8 @@ func saveConfig(path string, cfg *UsersConfig) {
 		return
 	}
 
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	// Atomic, so a crash never leaves a truncated users.json
+	if err := fsutil.WriteFileAtomic(path, data, 0644); err != nil {
 		fmt.Println("Error writing file:", err)
 	}
 }